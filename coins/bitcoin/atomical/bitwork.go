@@ -0,0 +1,160 @@
+package atomical
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrInvalidBitwork  = errors.New("invalid bitwork")
+	ErrBitworkNotFound = errors.New("bitwork not found")
+)
+
+const (
+	// mined sequences keep the relative lock time disabled and signal rbf
+	minBitworkSequence = uint32(wire.SequenceLockTimeDisabled)
+	maxBitworkSequence = uint32(0xfffffffd)
+	// workers look at the context every so many hashes
+	bitworkCheckInterval = 1 << 12
+)
+
+// Bitwork is a txid proof of work such as "0000" or "7b4.8". The txid must
+// start with Prefix, and when Ext is set the hex digit after it must be at
+// least Ext.
+type Bitwork struct {
+	Prefix string
+	Ext    int
+}
+
+// ParseBitwork parses the bitworkc and bitworkr strings of the mint args.
+func ParseBitwork(s string) (*Bitwork, error) {
+	prefix, ext, hasExt := strings.Cut(s, ".")
+	b := &Bitwork{Prefix: prefix}
+	if len(prefix) == 0 || len(prefix) > chainhash.MaxHashStringSize {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidBitwork, s)
+	}
+	for _, c := range prefix {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidBitwork, s)
+		}
+	}
+	if hasExt {
+		n, err := strconv.Atoi(ext)
+		if err != nil || n < 1 || n > 15 || len(prefix) == chainhash.MaxHashStringSize {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidBitwork, s)
+		}
+		b.Ext = n
+	}
+	return b, nil
+}
+
+func (b *Bitwork) String() string {
+	if b.Ext == 0 {
+		return b.Prefix
+	}
+	return fmt.Sprintf("%s.%d", b.Prefix, b.Ext)
+}
+
+// Match reports whether the txid hash meets the bitwork. Digits are read in
+// the byte reversed order txids are displayed in.
+func (b *Bitwork) Match(hash *chainhash.Hash) bool {
+	digit := func(i int) byte {
+		v := hash[chainhash.HashSize-1-i/2]
+		if i%2 == 0 {
+			return v >> 4
+		}
+		return v & 0x0f
+	}
+	for i := 0; i < len(b.Prefix); i++ {
+		c := b.Prefix[i]
+		var v byte
+		if c <= '9' {
+			v = c - '0'
+		} else {
+			v = c - 'a' + 10
+		}
+		if digit(i) != v {
+			return false
+		}
+	}
+	return b.Ext == 0 || int(digit(len(b.Prefix))) >= b.Ext
+}
+
+// MineBitwork searches the sequence of input index for a txid meeting the
+// bitwork and sets it. The search is split across workers goroutines, all of
+// the cpus when it is 0, and stops when ctx is done. The tx must not be
+// signed yet, except for witnesses which do not change the txid.
+func MineBitwork(ctx context.Context, tx *wire.MsgTx, index int, bitwork *Bitwork, workers int) error {
+	if index < 0 || index >= len(tx.TxIn) {
+		return fmt.Errorf("%w: input %d", ErrInvalidBitwork, index)
+	}
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	var buf bytes.Buffer
+	if err := tx.SerializeNoWitness(&buf); err != nil {
+		return err
+	}
+	offset := sequenceOffset(tx, index)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan uint32, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(start uint32) {
+			defer wg.Done()
+			raw := append([]byte{}, buf.Bytes()...)
+			for seq, n := start, 0; seq >= start && seq <= maxBitworkSequence; seq, n = seq+uint32(workers), n+1 {
+				if n%bitworkCheckInterval == 0 && ctx.Err() != nil {
+					return
+				}
+				binary.LittleEndian.PutUint32(raw[offset:], seq)
+				hash := chainhash.DoubleHashH(raw)
+				if bitwork.Match(&hash) {
+					found <- seq
+					cancel()
+					return
+				}
+			}
+		}(minBitworkSequence + uint32(w))
+	}
+	wg.Wait()
+
+	select {
+	case seq := <-found:
+		tx.TxIn[index].Sequence = seq
+		return nil
+	default:
+	}
+	if ctx.Err() != nil {
+		// the parent context, the search itself only cancels when found
+		return ctx.Err()
+	}
+	return fmt.Errorf("%w: %s", ErrBitworkNotFound, bitwork)
+}
+
+// sequenceOffset returns where the sequence of input index starts in the
+// serialization without witness.
+func sequenceOffset(tx *wire.MsgTx, index int) int {
+	offset := 4 + wire.VarIntSerializeSize(uint64(len(tx.TxIn)))
+	for i, in := range tx.TxIn {
+		// outpoint, then the signature script
+		offset += chainhash.HashSize + 4 + wire.VarIntSerializeSize(uint64(len(in.SignatureScript))) + len(in.SignatureScript)
+		if i == index {
+			break
+		}
+		offset += 4
+	}
+	return offset
+}
@@ -0,0 +1,61 @@
+package atomical
+
+import (
+	"context"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestParseBitwork(t *testing.T) {
+	b, err := ParseBitwork("7b4.8")
+	require.NoError(t, err)
+	assert.Equal(t, &Bitwork{Prefix: "7b4", Ext: 8}, b)
+	assert.Equal(t, "7b4.8", b.String())
+
+	hash, err := chainhash.NewHashFromStr("7b49aa0000000000000000000000000000000000000000000000000000000000")
+	require.NoError(t, err)
+	assert.True(t, b.Match(hash))
+	for _, s := range []string{"7b4", "7b49", "7b4.9", "7b49aa"} {
+		b, err := ParseBitwork(s)
+		require.NoError(t, err)
+		assert.True(t, b.Match(hash), s)
+	}
+	for _, s := range []string{"7b5", "7b4.10", "7b49ab"} {
+		b, err := ParseBitwork(s)
+		require.NoError(t, err)
+		assert.False(t, b.Match(hash), s)
+	}
+
+	for _, s := range []string{"", "7B4", "7b4.0", "7b4.16", "7b4.", "xyz", strings.Repeat("0", 64) + ".1", strings.Repeat("0", 65)} {
+		_, err := ParseBitwork(s)
+		assert.ErrorIs(t, err, ErrInvalidBitwork, s)
+	}
+}
+
+func TestMineBitwork(t *testing.T) {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, []byte{0x51}, nil))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 2}, nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+
+	b, err := ParseBitwork("ab.4")
+	require.NoError(t, err)
+	for _, index := range []int{0, 1} {
+		require.NoError(t, MineBitwork(context.Background(), tx, index, b, 4))
+		hash := tx.TxHash()
+		assert.True(t, strings.HasPrefix(hash.String(), "ab"), hash.String())
+		assert.True(t, b.Match(&hash))
+		assert.True(t, tx.TxIn[index].Sequence >= wire.SequenceLockTimeDisabled)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b, err = ParseBitwork(strings.Repeat("0", 40))
+	require.NoError(t, err)
+	assert.ErrorIs(t, MineBitwork(ctx, tx, 0, b, 0), context.Canceled)
+	assert.ErrorIs(t, MineBitwork(context.Background(), tx, 2, b, 0), ErrInvalidBitwork)
+}
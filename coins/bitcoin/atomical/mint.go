@@ -0,0 +1,361 @@
+package atomical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"math/rand"
+	"time"
+)
+
+var (
+	ErrInvalidMintRequest  = errors.New("invalid atomicals mint request")
+	ErrMintInsufficientFee = errors.New("insufficient balance")
+)
+
+// maxMintNonce bounds the random args nonce like the reference client.
+const maxMintNonce = 10000000
+
+// AtomicalMintRequest mints an ARC-20 (OpDMT with Ticker), a realm (OpNFT
+// with Realm), a container (OpNFT with Container) or a plain NFT with Files.
+// The reveal tx sends RevealOutValue to RevealAddr, for OpDMT it must be the
+// mint_amount of the ticker.
+type AtomicalMintRequest struct {
+	Op        string                 `json:"op"`
+	Ticker    string                 `json:"ticker,omitempty"`
+	Realm     string                 `json:"realm,omitempty"`
+	Container string                 `json:"container,omitempty"`
+	Args      map[string]interface{} `json:"args,omitempty"`
+	Files     []*AtomicalFile        `json:"files,omitempty"`
+	// BitworkC and BitworkR are the proofs of work of the commit and reveal
+	// txids, such as "0000" or "7b4.8".
+	BitworkC string `json:"bitworkc,omitempty"`
+	BitworkR string `json:"bitworkr,omitempty"`
+	// Time and Nonce go in the args, when they are 0 the current time and
+	// a random nonce are set on the request.
+	Time  int64 `json:"time,omitempty"`
+	Nonce int64 `json:"nonce,omitempty"`
+
+	CommitTxPrevOutputList PrevOutputs `json:"commitTxPrevOutputList"`
+	CommitFeeRate          int64       `json:"commitFeeRate"`
+	RevealFeeRate          int64       `json:"revealFeeRate"`
+	RevealAddr             string      `json:"revealAddr"`
+	RevealOutValue         int64       `json:"revealOutValue"`
+	ChangeAddress          string      `json:"changeAddress"`
+	MinChangeValue         int64       `json:"minChangeValue"`
+	// Workers mine the bitwork, all of the cpus when it is 0.
+	Workers int `json:"workers,omitempty"`
+}
+
+// args returns Args with the time, nonce, bitwork and the name requested.
+func (r *AtomicalMintRequest) args() (map[string]interface{}, error) {
+	args := make(map[string]interface{}, len(r.Args)+5)
+	for k, v := range r.Args {
+		args[k] = v
+	}
+	args["time"] = r.Time
+	args["nonce"] = r.Nonce
+	for name, bitwork := range map[string]string{"bitworkc": r.BitworkC, "bitworkr": r.BitworkR} {
+		if bitwork == "" {
+			continue
+		}
+		if _, err := ParseBitwork(bitwork); err != nil {
+			return nil, err
+		}
+		args[name] = bitwork
+	}
+	switch r.Op {
+	case OpDMT:
+		if r.Ticker == "" || r.Realm != "" || r.Container != "" {
+			return nil, fmt.Errorf("%w: dmt needs a ticker only", ErrInvalidMintRequest)
+		}
+		args["mint_ticker"] = r.Ticker
+	case OpNFT:
+		if r.Ticker != "" || r.Realm != "" && r.Container != "" {
+			return nil, fmt.Errorf("%w: nft takes a realm or a container", ErrInvalidMintRequest)
+		}
+		if r.Realm != "" {
+			args["request_realm"] = r.Realm
+		}
+		if r.Container != "" {
+			args["request_container"] = r.Container
+		}
+	default:
+		if !atomicalOps[r.Op] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAtomicalOp, r.Op)
+		}
+	}
+	return args, nil
+}
+
+type AtomicalMintTool struct {
+	Network                   *chaincfg.Params
+	CommitTxPrevOutputFetcher *txscript.MultiPrevOutFetcher
+	CommitTxPrivateKeyList    []*btcec.PrivateKey
+	RevealTxPrevOutputFetcher *txscript.MultiPrevOutFetcher
+	RevealPrivateKey          *btcec.PrivateKey
+	RevealScript              []byte
+	ControlBlockWitness       []byte
+	CommitTxAddress           string
+	CommitTx                  *wire.MsgTx
+	RevealTx                  *wire.MsgTx
+	MustCommitTxFee           int64
+	MustRevealTxFee           int64
+}
+
+// NewAtomicalMintTool builds and signs the commit and reveal txs, mining
+// their bitwork by the sequence of the first input until ctx is done.
+func NewAtomicalMintTool(ctx context.Context, network *chaincfg.Params, request *AtomicalMintRequest) (*AtomicalMintTool, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if len(request.CommitTxPrevOutputList) == 0 || request.RevealOutValue <= 0 {
+		return nil, ErrInvalidMintRequest
+	}
+	var commitTxPrivateKeyList []*btcec.PrivateKey
+	for _, v := range request.CommitTxPrevOutputList {
+		privateKeyWif, err := btcutil.DecodeWIF(v.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		commitTxPrivateKeyList = append(commitTxPrivateKeyList, privateKeyWif.PrivKey)
+	}
+	tool := &AtomicalMintTool{
+		Network:                   network,
+		CommitTxPrevOutputFetcher: txscript.NewMultiPrevOutFetcher(nil),
+		CommitTxPrivateKeyList:    commitTxPrivateKeyList,
+		RevealTxPrevOutputFetcher: txscript.NewMultiPrevOutFetcher(nil),
+		RevealPrivateKey:          commitTxPrivateKeyList[0],
+	}
+	return tool, tool.initTool(ctx, request)
+}
+
+func (tool *AtomicalMintTool) initTool(ctx context.Context, request *AtomicalMintRequest) error {
+	minChangeValue := bitcoin.DefaultMinChangeValue
+	if request.MinChangeValue > 0 {
+		minChangeValue = request.MinChangeValue
+	}
+	if request.Time == 0 {
+		request.Time = time.Now().Unix()
+	}
+	if request.Nonce == 0 {
+		request.Nonce = rand.Int63n(maxMintNonce) + 1
+	}
+	args, err := request.args()
+	if err != nil {
+		return err
+	}
+	payload, err := BuildAtomicalPayload(args, request.Files)
+	if err != nil {
+		return err
+	}
+	if err := tool.buildRevealScript(request.Op, payload); err != nil {
+		return err
+	}
+	revealPrevOutputValue, err := tool.buildEmptyRevealTx(request)
+	if err != nil {
+		return err
+	}
+	if err := tool.buildCommitTx(request, revealPrevOutputValue, minChangeValue); err != nil {
+		return err
+	}
+	if request.BitworkC != "" {
+		if err := tool.mine(ctx, tool.CommitTx, request.BitworkC, request.Workers); err != nil {
+			return err
+		}
+	}
+	if err := bitcoin.Sign(tool.CommitTx, tool.CommitTxPrivateKeyList, tool.CommitTxPrevOutputFetcher); err != nil {
+		return err
+	}
+	commitHash := tool.CommitTx.TxHash()
+	tool.RevealTx.TxIn[0].PreviousOutPoint.Hash = commitHash
+	tool.RevealTxPrevOutputFetcher.AddPrevOut(tool.RevealTx.TxIn[0].PreviousOutPoint, tool.CommitTx.TxOut[0])
+	if request.BitworkR != "" {
+		if err := tool.mine(ctx, tool.RevealTx, request.BitworkR, request.Workers); err != nil {
+			return err
+		}
+	}
+	return tool.signRevealTx()
+}
+
+// buildRevealScript commits to the envelope script as the only leaf of the
+// reveal key.
+func (tool *AtomicalMintTool) buildRevealScript(op string, payload []byte) error {
+	pubKey := tool.RevealPrivateKey.PubKey()
+	script, err := AtomicalEnvelopeScript(schnorr.SerializePubKey(pubKey), op, payload)
+	if err != nil {
+		return err
+	}
+	tree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(script))
+	controlBlock := tree.LeafMerkleProofs[0].ToControlBlock(pubKey)
+	controlBlockWitness, err := controlBlock.ToBytes()
+	if err != nil {
+		return err
+	}
+	rootHash := tree.RootNode.TapHash()
+	addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(pubKey, rootHash[:])), tool.Network)
+	if err != nil {
+		return err
+	}
+	tool.RevealScript = script
+	tool.ControlBlockWitness = controlBlockWitness
+	tool.CommitTxAddress = addr.EncodeAddress()
+	return nil
+}
+
+// buildEmptyRevealTx returns the commit output value, the reveal output and
+// the reveal fee with an empty signature.
+func (tool *AtomicalMintTool) buildEmptyRevealTx(request *AtomicalMintRequest) (int64, error) {
+	pkScript, err := bitcoin.AddrToPkScript(request.RevealAddr, tool.Network)
+	if err != nil {
+		return 0, err
+	}
+	tx := wire.NewMsgTx(bitcoin.DefaultTxVersion)
+	in := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+	in.Sequence = bitcoin.DefaultSequenceNum
+	tx.AddTxIn(in)
+	tx.AddTxOut(wire.NewTxOut(request.RevealOutValue, pkScript))
+
+	estimateTx := tx.Copy()
+	estimateTx.TxIn[0].Witness = wire.TxWitness{make([]byte, 64), tool.RevealScript, tool.ControlBlockWitness}
+	fee := bitcoin.GetTxVirtualSize(btcutil.NewTx(estimateTx)) * request.RevealFeeRate
+	tool.RevealTx = tx
+	tool.MustRevealTxFee = fee
+	return request.RevealOutValue + fee, nil
+}
+
+func (tool *AtomicalMintTool) buildCommitTx(request *AtomicalMintRequest, revealPrevOutputValue, minChangeValue int64) error {
+	totalSenderAmount := btcutil.Amount(0)
+	tx := wire.NewMsgTx(bitcoin.DefaultTxVersion)
+	changePkScript, err := bitcoin.AddrToPkScript(request.ChangeAddress, tool.Network)
+	if err != nil {
+		return err
+	}
+	for _, prevOutput := range request.CommitTxPrevOutputList {
+		txHash, err := chainhash.NewHashFromStr(prevOutput.TxId)
+		if err != nil {
+			return err
+		}
+		outPoint := wire.NewOutPoint(txHash, prevOutput.VOut)
+		pkScript, err := bitcoin.AddrToPkScript(prevOutput.Address, tool.Network)
+		if err != nil {
+			return err
+		}
+		// legacy signatures are part of the txid, so are signed after mining
+		if request.BitworkC != "" && !txscript.IsWitnessProgram(pkScript) {
+			return fmt.Errorf("%w: bitworkc needs segwit inputs", ErrInvalidMintRequest)
+		}
+		tool.CommitTxPrevOutputFetcher.AddPrevOut(*outPoint, wire.NewTxOut(prevOutput.Amount, pkScript))
+		in := wire.NewTxIn(outPoint, nil, nil)
+		in.Sequence = bitcoin.DefaultSequenceNum
+		tx.AddTxIn(in)
+		totalSenderAmount += btcutil.Amount(prevOutput.Amount)
+	}
+	commitPkScript, err := bitcoin.AddrToPkScript(tool.CommitTxAddress, tool.Network)
+	if err != nil {
+		return err
+	}
+	tx.AddTxOut(wire.NewTxOut(revealPrevOutputValue, commitPkScript))
+	tx.AddTxOut(wire.NewTxOut(0, changePkScript))
+
+	txForEstimate := tx.Copy()
+	if err := bitcoin.Sign(txForEstimate, tool.CommitTxPrivateKeyList, tool.CommitTxPrevOutputFetcher); err != nil {
+		return err
+	}
+	fee := btcutil.Amount(bitcoin.GetTxVirtualSize(btcutil.NewTx(txForEstimate))) * btcutil.Amount(request.CommitFeeRate)
+	changeAmount := totalSenderAmount - btcutil.Amount(revealPrevOutputValue) - fee
+	if int64(changeAmount) >= minChangeValue {
+		tx.TxOut[1].Value = int64(changeAmount)
+	} else {
+		tx.TxOut = tx.TxOut[:1]
+		txForEstimate.TxOut = txForEstimate.TxOut[:1]
+		feeWithoutChange := btcutil.Amount(bitcoin.GetTxVirtualSize(btcutil.NewTx(txForEstimate))) * btcutil.Amount(request.CommitFeeRate)
+		if totalSenderAmount-btcutil.Amount(revealPrevOutputValue)-feeWithoutChange < 0 {
+			tool.MustCommitTxFee = int64(fee)
+			return ErrMintInsufficientFee
+		}
+	}
+	tool.CommitTx = tx
+	return nil
+}
+
+func (tool *AtomicalMintTool) mine(ctx context.Context, tx *wire.MsgTx, bitwork string, workers int) error {
+	b, err := ParseBitwork(bitwork)
+	if err != nil {
+		return err
+	}
+	return MineBitwork(ctx, tx, 0, b, workers)
+}
+
+func (tool *AtomicalMintTool) signRevealTx() error {
+	sigHash, err := txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(tool.RevealTx, tool.RevealTxPrevOutputFetcher),
+		txscript.SigHashDefault, tool.RevealTx, 0, tool.RevealTxPrevOutputFetcher, txscript.NewBaseTapLeaf(tool.RevealScript))
+	if err != nil {
+		return err
+	}
+	signature, err := schnorr.Sign(tool.RevealPrivateKey, sigHash)
+	if err != nil {
+		return err
+	}
+	tool.RevealTx.TxIn[0].Witness = wire.TxWitness{signature.Serialize(), tool.RevealScript, tool.ControlBlockWitness}
+	return nil
+}
+
+func (tool *AtomicalMintTool) CalculateFee() (int64, []int64) {
+	commitTxFee := int64(0)
+	for _, in := range tool.CommitTx.TxIn {
+		commitTxFee += tool.CommitTxPrevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
+	}
+	for _, out := range tool.CommitTx.TxOut {
+		commitTxFee -= out.Value
+	}
+	revealTxFee := tool.CommitTx.TxOut[0].Value
+	for _, out := range tool.RevealTx.TxOut {
+		revealTxFee -= out.Value
+	}
+	return commitTxFee, []int64{revealTxFee}
+}
+
+// AtomicalMint returns the signed commit and reveal txs. Without enough
+// balance only the fees are returned, like bitcoin.Inscribe.
+func AtomicalMint(ctx context.Context, network *chaincfg.Params, request *AtomicalMintRequest) (*bitcoin.InscribeTxs, error) {
+	tool, err := NewAtomicalMintTool(ctx, network, request)
+	if errors.Is(err, ErrMintInsufficientFee) {
+		return &bitcoin.InscribeTxs{
+			CommitTx:     "",
+			RevealTxs:    []string{},
+			CommitTxFee:  tool.MustCommitTxFee,
+			RevealTxFees: []int64{tool.MustRevealTxFee},
+			CommitAddrs:  []string{tool.CommitTxAddress},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	commitTx, err := bitcoin.GetTxHex(tool.CommitTx)
+	if err != nil {
+		return nil, err
+	}
+	revealTx, err := bitcoin.GetTxHex(tool.RevealTx)
+	if err != nil {
+		return nil, err
+	}
+	commitTxFee, revealTxFees := tool.CalculateFee()
+
+	return &bitcoin.InscribeTxs{
+		CommitTx:     commitTx,
+		RevealTxs:    []string{revealTx},
+		CommitTxFee:  commitTxFee,
+		RevealTxFees: revealTxFees,
+		CommitAddrs:  []string{tool.CommitTxAddress},
+	}, nil
+}
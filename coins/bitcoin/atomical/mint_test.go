@@ -0,0 +1,134 @@
+package atomical
+
+import (
+	"context"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func atomicalMintTestRequest(op string) *AtomicalMintRequest {
+	return &AtomicalMintRequest{
+		Op:    op,
+		Time:  1700000000,
+		Nonce: 42,
+		CommitTxPrevOutputList: PrevOutputs{{
+			TxId:       "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+			VOut:       0,
+			Amount:     100000,
+			Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+		CommitFeeRate:  2,
+		RevealFeeRate:  2,
+		RevealAddr:     "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		RevealOutValue: 1000,
+		ChangeAddress:  "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}
+}
+
+func TestAtomicalMint(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	dmt := atomicalMintTestRequest(OpDMT)
+	dmt.Ticker = "atom"
+	dmt.BitworkC = "ab.8"
+	dmt.BitworkR = "c"
+	realm := atomicalMintTestRequest(OpNFT)
+	realm.Realm = "okx"
+	nft := atomicalMintTestRequest(OpNFT)
+	nft.Files = []*AtomicalFile{{Name: "image.png", ContentType: "image/png", Data: []byte(strings.Repeat("png", 400))}}
+
+	for _, request := range []*AtomicalMintRequest{dmt, realm, nft} {
+		txs, err := AtomicalMint(context.Background(), network, request)
+		require.NoError(t, err)
+		require.Equal(t, 1, len(txs.RevealTxs))
+		commitTx, err := bitcoin.NewTxFromHex(txs.CommitTx)
+		require.NoError(t, err)
+		revealTx, err := bitcoin.NewTxFromHex(txs.RevealTxs[0])
+		require.NoError(t, err)
+		if request.BitworkC != "" {
+			b, err := ParseBitwork(request.BitworkC)
+			require.NoError(t, err)
+			hash := commitTx.TxHash()
+			assert.True(t, b.Match(&hash))
+			hash = revealTx.TxHash()
+			assert.True(t, strings.HasPrefix(hash.String(), request.BitworkR))
+		}
+
+		res, err := bitcoin.VerifyTransaction(txs.CommitTx, bitcoin.PrevOutputs{{TxId: request.CommitTxPrevOutputList[0].TxId,
+			Amount: 100000, Address: request.CommitTxPrevOutputList[0].Address}}, network)
+		require.NoError(t, err)
+		assert.NoError(t, res.Err())
+		res, err = bitcoin.VerifyTransaction(txs.RevealTxs[0], bitcoin.PrevOutputs{{TxId: commitTx.TxHash().String(),
+			Amount: commitTx.TxOut[0].Value, Address: txs.CommitAddrs[0]}}, network)
+		require.NoError(t, err)
+		assert.NoError(t, res.Err())
+		assert.Equal(t, int64(1000), revealTx.TxOut[0].Value)
+		assert.Equal(t, commitTx.TxOut[0].Value-1000, txs.RevealTxFees[0])
+		assert.Equal(t, 100000-commitTx.TxOut[0].Value-commitTx.TxOut[1].Value, txs.CommitTxFee)
+
+		op, err := DecodeAtomicalOperationFromHex(txs.RevealTxs[0])
+		require.NoError(t, err)
+		assert.Equal(t, request.Op, op.Op)
+		args := op.Args()
+		assert.Equal(t, uint64(1700000000), args["time"])
+		assert.Equal(t, uint64(42), args["nonce"])
+		switch request {
+		case dmt:
+			assert.Equal(t, "atom", args["mint_ticker"])
+			assert.Equal(t, "ab.8", args["bitworkc"])
+			assert.Equal(t, "c", args["bitworkr"])
+		case realm:
+			assert.Equal(t, "okx", args["request_realm"])
+		case nft:
+			assert.Equal(t, map[string]interface{}{"$ct": "image/png", "$d": nft.Files[0].Data}, op.Payload["image.png"])
+		}
+	}
+}
+
+func TestAtomicalMintErrors(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	request := atomicalMintTestRequest(OpDMT)
+	_, err := AtomicalMint(context.Background(), network, request)
+	assert.ErrorIs(t, err, ErrInvalidMintRequest)
+
+	request = atomicalMintTestRequest(OpNFT)
+	request.Realm, request.Container = "okx", "box"
+	_, err = AtomicalMint(context.Background(), network, request)
+	assert.ErrorIs(t, err, ErrInvalidMintRequest)
+
+	request = atomicalMintTestRequest(OpNFT)
+	request.BitworkC = "zz"
+	_, err = AtomicalMint(context.Background(), network, request)
+	assert.ErrorIs(t, err, ErrInvalidBitwork)
+
+	// legacy signatures would change the mined commit txid
+	request = atomicalMintTestRequest(OpNFT)
+	request.BitworkC = "0"
+	segwit, err := btcutil.DecodeAddress(request.CommitTxPrevOutputList[0].Address, network)
+	require.NoError(t, err)
+	legacy, err := btcutil.NewAddressPubKeyHash(segwit.ScriptAddress(), network)
+	require.NoError(t, err)
+	request.CommitTxPrevOutputList[0].Address = legacy.EncodeAddress()
+	_, err = AtomicalMint(context.Background(), network, request)
+	assert.ErrorIs(t, err, ErrInvalidMintRequest)
+
+	request = atomicalMintTestRequest(OpNFT)
+	request.BitworkC = "00000000000000000000"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = AtomicalMint(ctx, network, request)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	request = atomicalMintTestRequest(OpNFT)
+	request.CommitTxPrevOutputList[0].Amount = 1500
+	txs, err := AtomicalMint(context.Background(), network, request)
+	require.NoError(t, err)
+	assert.Empty(t, txs.CommitTx)
+	assert.True(t, txs.CommitTxFee > 0)
+	assert.True(t, txs.RevealTxFees[0] > 0)
+}
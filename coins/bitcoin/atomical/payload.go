@@ -0,0 +1,198 @@
+package atomical
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+)
+
+// AtomicalsProtocol marks the envelope: OP_0 OP_IF "atom" <op> <payload> OP_ENDIF.
+const AtomicalsProtocol = "atom"
+
+// Operations of the Atomicals protocol.
+const (
+	OpNFT         = "nft"
+	OpFT          = "ft"
+	OpDFT         = "dft"
+	OpDMT         = "dmt"
+	OpMod         = "mod"
+	OpEvt         = "evt"
+	OpDat         = "dat"
+	OpSeal        = "sl"
+	OpSplat       = "x"
+	OpSplit       = "y"
+	OpCustomColor = "z"
+)
+
+var atomicalOps = map[string]bool{
+	OpNFT: true, OpFT: true, OpDFT: true, OpDMT: true, OpMod: true, OpEvt: true,
+	OpDat: true, OpSeal: true, OpSplat: true, OpSplit: true, OpCustomColor: true,
+}
+
+var (
+	ErrInvalidAtomicalOp      = errors.New("invalid atomicals operation")
+	ErrInvalidAtomicalPayload = errors.New("invalid atomicals payload")
+	ErrNoAtomicalOperation    = errors.New("no atomicals operation")
+)
+
+// AtomicalFile is a file of the payload. With a content type it is stored as
+// {"$ct": <type>, "$d": <data>}, otherwise as the raw data.
+type AtomicalFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// AtomicalOperation is the operation a reveal tx carries. Payload is the
+// decoded CBOR map, the mint parameters are under "args".
+type AtomicalOperation struct {
+	Op         string                 `json:"op"`
+	InputIndex int                    `json:"inputIndex"`
+	Payload    map[string]interface{} `json:"payload"`
+}
+
+// Args returns the "args" map of the payload, nil without one.
+func (o *AtomicalOperation) Args() map[string]interface{} {
+	args, _ := o.Payload["args"].(map[string]interface{})
+	return args
+}
+
+// BuildAtomicalPayload encodes the payload map {"args": args, <name>: <file>}.
+func BuildAtomicalPayload(args map[string]interface{}, files []*AtomicalFile) ([]byte, error) {
+	payload := make(map[string]interface{}, len(files)+1)
+	if args != nil {
+		payload["args"] = args
+	}
+	for _, f := range files {
+		if f == nil || f.Name == "" || f.Name == "args" {
+			return nil, fmt.Errorf("%w: file name", ErrInvalidAtomicalPayload)
+		}
+		if _, ok := payload[f.Name]; ok {
+			return nil, fmt.Errorf("%w: duplicate file %s", ErrInvalidAtomicalPayload, f.Name)
+		}
+		if f.ContentType == "" {
+			payload[f.Name] = f.Data
+		} else {
+			payload[f.Name] = map[string]interface{}{"$ct": f.ContentType, "$d": f.Data}
+		}
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidAtomicalPayload)
+	}
+	return bitcoin.EncodeCBOR(payload)
+}
+
+// AtomicalEnvelopeScript returns <pubkey> OP_CHECKSIG OP_0 OP_IF "atom" <op>
+// <payload> OP_ENDIF with the payload split into pushes of at most 520 bytes.
+func AtomicalEnvelopeScript(xOnlyPubKey []byte, op string, payload []byte) ([]byte, error) {
+	if !atomicalOps[op] {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAtomicalOp, op)
+	}
+	script, err := txscript.NewScriptBuilder().
+		AddData(xOnlyPubKey).
+		AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_0).
+		AddOp(txscript.OP_IF).
+		AddData([]byte(AtomicalsProtocol)).
+		AddData([]byte(op)).
+		Script()
+	if err != nil {
+		return nil, err
+	}
+	// pushed one by one, txscript.MaxScriptSize 10000 does not apply to tapscripts
+	for len(payload) > 0 {
+		n := len(payload)
+		if n > txscript.MaxScriptElementSize {
+			n = txscript.MaxScriptElementSize
+		}
+		push, err := txscript.NewScriptBuilder().AddData(payload[:n]).Script()
+		if err != nil {
+			return nil, err
+		}
+		script = append(script, push...)
+		payload = payload[n:]
+	}
+	return append(script, txscript.OP_ENDIF), nil
+}
+
+func DecodeAtomicalOperationFromHex(txHex string) (*AtomicalOperation, error) {
+	tx, err := bitcoin.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeAtomicalOperation(tx)
+}
+
+// DecodeAtomicalOperation returns the operation of the first input whose
+// script path witness carries one, as the indexer does.
+func DecodeAtomicalOperation(tx *wire.MsgTx) (*AtomicalOperation, error) {
+	for i, in := range tx.TxIn {
+		tapscript := bitcoin.WitnessTapscript(in.Witness)
+		if tapscript == nil {
+			continue
+		}
+		op, err := DecodeAtomicalTapscript(tapscript)
+		if errors.Is(err, ErrNoAtomicalOperation) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		op.InputIndex = i
+		return op, nil
+	}
+	return nil, ErrNoAtomicalOperation
+}
+
+// DecodeAtomicalTapscript parses the first OP_0 OP_IF "atom" envelope of a
+// tapscript.
+func DecodeAtomicalTapscript(tapscript []byte) (*AtomicalOperation, error) {
+	tokenizer := txscript.MakeScriptTokenizer(0, tapscript)
+	for tokenizer.Next() {
+		if tokenizer.Opcode() != txscript.OP_0 {
+			continue
+		}
+		next := tokenizer
+		if !next.Next() || next.Opcode() != txscript.OP_IF ||
+			!next.Next() || !bytes.Equal(next.Data(), []byte(AtomicalsProtocol)) {
+			continue
+		}
+		if !next.Next() || next.Opcode() > txscript.OP_PUSHDATA4 {
+			return nil, fmt.Errorf("%w: missing", ErrInvalidAtomicalOp)
+		}
+		op := string(next.Data())
+		if !atomicalOps[op] {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidAtomicalOp, op)
+		}
+		var payload []byte
+		for next.Next() {
+			if next.Opcode() == txscript.OP_ENDIF {
+				return decodeAtomicalPayload(op, payload)
+			}
+			if next.Opcode() > txscript.OP_PUSHDATA4 {
+				break
+			}
+			payload = append(payload, next.Data()...)
+		}
+		return nil, fmt.Errorf("%w: unterminated envelope", ErrInvalidAtomicalPayload)
+	}
+	if tokenizer.Err() != nil {
+		return nil, tokenizer.Err()
+	}
+	return nil, ErrNoAtomicalOperation
+}
+
+func decodeAtomicalPayload(op string, payload []byte) (*AtomicalOperation, error) {
+	v, err := bitcoin.DecodeCBOR(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAtomicalPayload, err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: not a map", ErrInvalidAtomicalPayload)
+	}
+	return &AtomicalOperation{Op: op, Payload: m}, nil
+}
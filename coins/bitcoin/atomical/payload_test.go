@@ -0,0 +1,88 @@
+package atomical
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestAtomicalEnvelopeScript(t *testing.T) {
+	pubKey, _ := hex.DecodeString("57bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f")
+	payload, err := BuildAtomicalPayload(map[string]interface{}{"mint_ticker": "atom", "nonce": 1, "time": 1700000000}, nil)
+	require.NoError(t, err)
+	script, err := AtomicalEnvelopeScript(pubKey, OpDMT, payload)
+	require.NoError(t, err)
+	assert.Equal(t, "20"+hex.EncodeToString(pubKey)+"ac0063"+"0461746f6d"+"03646d74", hex.EncodeToString(script[:45]))
+	assert.Equal(t, byte(txscript.OP_ENDIF), script[len(script)-1])
+
+	op, err := DecodeAtomicalTapscript(script)
+	require.NoError(t, err)
+	assert.Equal(t, OpDMT, op.Op)
+	assert.Equal(t, map[string]interface{}{"mint_ticker": "atom", "nonce": uint64(1), "time": uint64(1700000000)}, op.Args())
+
+	// a file larger than a push is split into 520 byte chunks
+	image := bytes.Repeat([]byte{0x89}, 1200)
+	payload, err = BuildAtomicalPayload(map[string]interface{}{"request_realm": "okx"},
+		[]*AtomicalFile{{Name: "image.png", ContentType: "image/png", Data: image}, {Name: "raw", Data: []byte{1}}})
+	require.NoError(t, err)
+	script, err = AtomicalEnvelopeScript(pubKey, OpNFT, payload)
+	require.NoError(t, err)
+	var pushes int
+	tokenizer := txscript.MakeScriptTokenizer(0, script[45:])
+	for tokenizer.Next() && tokenizer.Opcode() != txscript.OP_ENDIF {
+		assert.True(t, len(tokenizer.Data()) <= txscript.MaxScriptElementSize)
+		pushes++
+	}
+	assert.Equal(t, 3, pushes)
+	op, err = DecodeAtomicalTapscript(script)
+	require.NoError(t, err)
+	assert.Equal(t, OpNFT, op.Op)
+	assert.Equal(t, map[string]interface{}{"$ct": "image/png", "$d": image}, op.Payload["image.png"])
+	assert.Equal(t, []byte{1}, op.Payload["raw"])
+	assert.Equal(t, "okx", op.Args()["request_realm"])
+
+	_, err = AtomicalEnvelopeScript(pubKey, "mint", payload)
+	assert.ErrorIs(t, err, ErrInvalidAtomicalOp)
+	_, err = BuildAtomicalPayload(nil, []*AtomicalFile{{Name: "args"}})
+	assert.ErrorIs(t, err, ErrInvalidAtomicalPayload)
+	_, err = BuildAtomicalPayload(nil, nil)
+	assert.ErrorIs(t, err, ErrInvalidAtomicalPayload)
+}
+
+func TestDecodeAtomicalOperation(t *testing.T) {
+	pubKey := bytes.Repeat([]byte{2}, 32)
+	payload, err := BuildAtomicalPayload(map[string]interface{}{"request_container": "box"}, nil)
+	require.NoError(t, err)
+	script, err := AtomicalEnvelopeScript(pubKey, OpNFT, payload)
+	require.NoError(t, err)
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{make([]byte, 64)}))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, wire.TxWitness{make([]byte, 64), script, make([]byte, 33), {txscript.TaprootAnnexTag}}))
+	op, err := DecodeAtomicalOperation(tx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, op.InputIndex)
+	assert.Equal(t, "box", op.Args()["request_container"])
+
+	tx.TxIn = tx.TxIn[:1]
+	_, err = DecodeAtomicalOperation(tx)
+	assert.ErrorIs(t, err, ErrNoAtomicalOperation)
+
+	// the payload must be a cbor map
+	bad, err := AtomicalEnvelopeScript(pubKey, OpNFT, []byte{0x01})
+	require.NoError(t, err)
+	_, err = DecodeAtomicalTapscript(bad)
+	assert.ErrorIs(t, err, ErrInvalidAtomicalPayload)
+	_, err = DecodeAtomicalTapscript(script[:len(script)-1])
+	assert.ErrorIs(t, err, ErrInvalidAtomicalPayload)
+	// an ord envelope is not an atomicals one
+	ord, err := txscript.NewScriptBuilder().AddData(pubKey).AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_0).AddOp(txscript.OP_IF).AddData([]byte("ord")).AddOp(txscript.OP_ENDIF).Script()
+	require.NoError(t, err)
+	_, err = DecodeAtomicalTapscript(ord)
+	assert.ErrorIs(t, err, ErrNoAtomicalOperation)
+}
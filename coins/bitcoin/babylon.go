@@ -0,0 +1,599 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"sort"
+)
+
+// Babylon BTC staking locks bitcoin in a taproot output whose key path is
+// disabled by the NUMS key and whose leaves are:
+//
+//	timelock:  <staker> OP_CHECKSIGVERIFY <stakingTime> OP_CHECKSEQUENCEVERIFY
+//	unbonding: <staker> OP_CHECKSIGVERIFY <covenant quorum multi_a>
+//	slashing:  <staker> OP_CHECKSIGVERIFY <1 of finality providers multi_a, verify> <covenant quorum multi_a>
+//
+// The unbonding transaction moves the stake to an output with the timelock
+// leaf of unbondingTime and the same slashing leaf. The staker pre-signs the
+// slashing transactions of both outputs, which pay the slashing rate to the
+// slashing script and the rest to an output timelocked by unbondingTime.
+
+var (
+	ErrBabylonParams    = errors.New("invalid babylon staking parameters")
+	ErrBabylonSignature = errors.New("babylon input has no signature for the spending path")
+	ErrBabylonOutput    = errors.New("babylon output cannot be spent this way")
+)
+
+// BabylonOutput is an output a Babylon staking creates.
+type BabylonOutput int
+
+const (
+	BabylonStakingOutput BabylonOutput = iota
+	BabylonUnbondingOutput
+	// BabylonSlashingChangeOutput is the part of a slashed stake returned to
+	// the staker.
+	BabylonSlashingChangeOutput
+)
+
+// BabylonStaking is a stake of StakerPubKey delegated to finality providers
+// and guarded by the covenant committee, keys are compressed or x-only hex.
+type BabylonStaking struct {
+	StakerPubKey            string   `json:"stakerPubKey"`
+	FinalityProviderPubKeys []string `json:"finalityProviderPubKeys"`
+	CovenantPubKeys         []string `json:"covenantPubKeys"`
+	CovenantQuorum          int      `json:"covenantQuorum"`
+	// StakingTime and UnbondingTime are relative timelocks in blocks.
+	StakingTime   uint16 `json:"stakingTime"`
+	UnbondingTime uint16 `json:"unbondingTime"`
+
+	stakerKey    []byte
+	fpKeys       [][]byte
+	covenantKeys [][]byte
+}
+
+// BabylonSlashing are the slashing parameters of the Babylon chain.
+type BabylonSlashing struct {
+	// PkScript is the hex script the slashed amount is paid to.
+	PkScript string `json:"pkScript"`
+	// Rate is the slashed share of the stake in hundredths, Babylon allows
+	// two decimal places.
+	Rate int64 `json:"rate"`
+	Fee  int64 `json:"fee"`
+}
+
+// BabylonUtxo is an output of a Babylon staking, unbonding or slashing
+// transaction.
+type BabylonUtxo struct {
+	Output BabylonOutput `json:"output"`
+	TxId   string        `json:"txId"`
+	VOut   uint32        `json:"vOut"`
+	Amount int64         `json:"amount"`
+}
+
+func NewBabylonStaking(stakerPubKey string, fpPubKeys, covenantPubKeys []string, covenantQuorum int,
+	stakingTime, unbondingTime uint16) (*BabylonStaking, error) {
+	b := &BabylonStaking{
+		StakerPubKey:            stakerPubKey,
+		FinalityProviderPubKeys: fpPubKeys,
+		CovenantPubKeys:         covenantPubKeys,
+		CovenantQuorum:          covenantQuorum,
+		StakingTime:             stakingTime,
+		UnbondingTime:           unbondingTime,
+	}
+	if len(fpPubKeys) == 0 || len(covenantPubKeys) == 0 {
+		return nil, fmt.Errorf("%w: no finality provider or covenant key", ErrBabylonParams)
+	}
+	if covenantQuorum < 1 || covenantQuorum > len(covenantPubKeys) {
+		return nil, fmt.Errorf("%w: covenant quorum %d of %d", ErrBabylonParams, covenantQuorum, len(covenantPubKeys))
+	}
+	if stakingTime == 0 || unbondingTime == 0 {
+		return nil, fmt.Errorf("%w: zero timelock", ErrBabylonParams)
+	}
+	seen := make(map[string]bool)
+	parse := func(pubKeys []string) ([][]byte, error) {
+		keys := make([][]byte, 0, len(pubKeys))
+		for _, pubKey := range pubKeys {
+			key, err := parseXOnlyPubKey(pubKey)
+			if err != nil {
+				return nil, err
+			}
+			if seen[string(key)] {
+				return nil, fmt.Errorf("%w: duplicate key %s", ErrBabylonParams, pubKey)
+			}
+			seen[string(key)] = true
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return bytes.Compare(keys[i], keys[j]) < 0
+		})
+		return keys, nil
+	}
+	stakerKey, err := parse([]string{stakerPubKey})
+	if err != nil {
+		return nil, err
+	}
+	b.stakerKey = stakerKey[0]
+	if b.fpKeys, err = parse(fpPubKeys); err != nil {
+		return nil, err
+	}
+	if b.covenantKeys, err = parse(covenantPubKeys); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// parseXOnlyPubKey parses a compressed or x-only hex key to its x-only form.
+func parseXOnlyPubKey(pubKey string) ([]byte, error) {
+	b, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	var key *btcec.PublicKey
+	if len(b) == schnorr.PubKeyBytesLen {
+		key, err = schnorr.ParsePubKey(b)
+	} else {
+		key, err = btcec.ParsePubKey(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.SerializePubKey(key), nil
+}
+
+// babylonSigScript is the single key check of keys, or the threshold of
+// keys multi_a, ending in a verify when verify is set.
+func babylonSigScript(keys [][]byte, threshold int, verify bool) ([]byte, error) {
+	if len(keys) == 1 {
+		op := byte(txscript.OP_CHECKSIG)
+		if verify {
+			op = txscript.OP_CHECKSIGVERIFY
+		}
+		return txscript.NewScriptBuilder().AddData(keys[0]).AddOp(op).Script()
+	}
+	script, err := multiAScript(threshold, keys)
+	if err != nil {
+		return nil, err
+	}
+	if verify {
+		script[len(script)-1] = txscript.OP_NUMEQUALVERIFY
+	}
+	return script, nil
+}
+
+// TimelockLeaf is the leaf the staker spends alone once the output is
+// lockTime blocks old.
+func (b *BabylonStaking) TimelockLeaf(lockTime uint16) ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddData(b.stakerKey).AddOp(txscript.OP_CHECKSIGVERIFY).
+		AddInt64(int64(lockTime)).AddOp(txscript.OP_CHECKSEQUENCEVERIFY).
+		Script()
+}
+
+// UnbondingLeaf is the leaf the staker and the covenant quorum spend to unbond early.
+func (b *BabylonStaking) UnbondingLeaf() ([]byte, error) {
+	staker, err := babylonSigScript([][]byte{b.stakerKey}, 1, true)
+	if err != nil {
+		return nil, err
+	}
+	covenant, err := babylonSigScript(b.covenantKeys, b.CovenantQuorum, false)
+	if err != nil {
+		return nil, err
+	}
+	return append(staker, covenant...), nil
+}
+
+// SlashingLeaf is the leaf the staker, a finality provider and the covenant
+// quorum spend to slash the stake.
+func (b *BabylonStaking) SlashingLeaf() ([]byte, error) {
+	staker, err := babylonSigScript([][]byte{b.stakerKey}, 1, true)
+	if err != nil {
+		return nil, err
+	}
+	fp, err := babylonSigScript(b.fpKeys, 1, true)
+	if err != nil {
+		return nil, err
+	}
+	covenant, err := babylonSigScript(b.covenantKeys, b.CovenantQuorum, false)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(staker, fp...), covenant...), nil
+}
+
+// TaprootTree returns the tree of output under the NUMS key.
+func (b *BabylonStaking) TaprootTree(output BabylonOutput) (*TaprootScriptTree, error) {
+	var scripts [][]byte
+	switch output {
+	case BabylonStakingOutput:
+		timelock, err := b.TimelockLeaf(b.StakingTime)
+		if err != nil {
+			return nil, err
+		}
+		unbonding, err := b.UnbondingLeaf()
+		if err != nil {
+			return nil, err
+		}
+		slashing, err := b.SlashingLeaf()
+		if err != nil {
+			return nil, err
+		}
+		scripts = [][]byte{timelock, unbonding, slashing}
+	case BabylonUnbondingOutput:
+		timelock, err := b.TimelockLeaf(b.UnbondingTime)
+		if err != nil {
+			return nil, err
+		}
+		slashing, err := b.SlashingLeaf()
+		if err != nil {
+			return nil, err
+		}
+		scripts = [][]byte{timelock, slashing}
+	case BabylonSlashingChangeOutput:
+		timelock, err := b.TimelockLeaf(b.UnbondingTime)
+		if err != nil {
+			return nil, err
+		}
+		scripts = [][]byte{timelock}
+	default:
+		return nil, fmt.Errorf("%w: unknown output %d", ErrBabylonOutput, output)
+	}
+	// leaves of equal weight pair up in order, the shape Babylon builds with
+	// txscript.AssembleTaprootScriptTree
+	leaves := make([]*TapLeafSpec, 0, len(scripts))
+	for _, script := range scripts {
+		leaves = append(leaves, &TapLeafSpec{Script: hex.EncodeToString(script)})
+	}
+	return NewTaprootScriptTree(TaprootNUMSKey, leaves)
+}
+
+func (b *BabylonStaking) Address(output BabylonOutput, network *chaincfg.Params) (string, error) {
+	tree, err := b.TaprootTree(output)
+	if err != nil {
+		return "", err
+	}
+	return tree.Address(network)
+}
+
+// newBabylonSpendPsbt creates the PSBT spending utxo through leafScript with
+// sequence, the outputs are left to the caller.
+func newBabylonSpendPsbt(b *BabylonStaking, utxo *BabylonUtxo, leafScript []byte, sequence uint32, network *chaincfg.Params) (*PsbtPacket, error) {
+	tree, err := b.TaprootTree(utxo.Output)
+	if err != nil {
+		return nil, err
+	}
+	leaf := -1
+	for k, l := range tree.Leaves {
+		if bytes.Equal(l.Script, leafScript) {
+			leaf = k
+		}
+	}
+	if leaf < 0 {
+		return nil, fmt.Errorf("%w: output %d has no such leaf", ErrBabylonOutput, utxo.Output)
+	}
+	addr, err := tree.Address(network)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := NewPsbtPacket(0, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	in := &TxInput{TxId: utxo.TxId, VOut: utxo.VOut, Amount: utxo.Amount, Address: addr}
+	if err := packet.AddInput(in); err != nil {
+		return nil, err
+	}
+	packet.Packet.UnsignedTx.TxIn[0].Sequence = sequence
+	if err := packet.UpdateInput(0, in, network); err != nil {
+		return nil, err
+	}
+	if err := tree.AddLeafToPsbtInput(packet.Packet, 0, leaf); err != nil {
+		return nil, err
+	}
+	packet.Packet.Inputs[0].TaprootInternalKey = schnorr.SerializePubKey(tree.InternalKey)
+	packet.Packet.Inputs[0].TaprootMerkleRoot = tree.MerkleRoot()
+	return packet, nil
+}
+
+// NewBabylonUnbondingPsbt creates the unbonding transaction of the staking
+// output utxo, paying it less fee to the unbonding output. Babylon only
+// accepts it final and without a lock time.
+func NewBabylonUnbondingPsbt(b *BabylonStaking, utxo *BabylonUtxo, fee int64, network *chaincfg.Params) (*PsbtPacket, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if utxo.Output != BabylonStakingOutput {
+		return nil, fmt.Errorf("%w: only the staking output unbonds", ErrBabylonOutput)
+	}
+	leafScript, err := b.UnbondingLeaf()
+	if err != nil {
+		return nil, err
+	}
+	packet, err := newBabylonSpendPsbt(b, utxo, leafScript, wire.MaxTxInSequenceNum, network)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := b.Address(BabylonUnbondingOutput, network)
+	if err != nil {
+		return nil, err
+	}
+	if err := addBabylonOutput(packet, addr, utxo.Amount-fee, network); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// NewBabylonSlashingPsbt creates the slashing transaction of the staking or
+// unbonding output utxo: the slashing rate of its amount goes to the slashing
+// script and the rest, less the slashing fee, to the slashing change output.
+func NewBabylonSlashingPsbt(b *BabylonStaking, utxo *BabylonUtxo, slashing *BabylonSlashing, network *chaincfg.Params) (*PsbtPacket, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if utxo.Output == BabylonSlashingChangeOutput {
+		return nil, fmt.Errorf("%w: slashing change cannot be slashed", ErrBabylonOutput)
+	}
+	if slashing.Rate <= 0 || slashing.Rate >= 100 {
+		return nil, fmt.Errorf("%w: slashing rate %d", ErrBabylonParams, slashing.Rate)
+	}
+	slashingScript, err := hex.DecodeString(slashing.PkScript)
+	if err != nil {
+		return nil, err
+	}
+	leafScript, err := b.SlashingLeaf()
+	if err != nil {
+		return nil, err
+	}
+	packet, err := newBabylonSpendPsbt(b, utxo, leafScript, wire.MaxTxInSequenceNum, network)
+	if err != nil {
+		return nil, err
+	}
+	slashed := utxo.Amount * slashing.Rate / 100
+	packet.Packet.UnsignedTx.AddTxOut(wire.NewTxOut(slashed, slashingScript))
+	packet.Packet.Outputs = append(packet.Packet.Outputs, psbt.POutput{})
+	addr, err := b.Address(BabylonSlashingChangeOutput, network)
+	if err != nil {
+		return nil, err
+	}
+	if err := addBabylonOutput(packet, addr, utxo.Amount-slashed-slashing.Fee, network); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// NewBabylonWithdrawPsbt creates the transaction spending utxo to address
+// through its timelock leaf once it is old enough, less fee.
+func NewBabylonWithdrawPsbt(b *BabylonStaking, utxo *BabylonUtxo, address string, fee int64, network *chaincfg.Params) (*PsbtPacket, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	lockTime := b.UnbondingTime
+	if utxo.Output == BabylonStakingOutput {
+		lockTime = b.StakingTime
+	}
+	leafScript, err := b.TimelockLeaf(lockTime)
+	if err != nil {
+		return nil, err
+	}
+	packet, err := newBabylonSpendPsbt(b, utxo, leafScript, BlocksSequence(lockTime), network)
+	if err != nil {
+		return nil, err
+	}
+	if err := addBabylonOutput(packet, address, utxo.Amount-fee, network); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+func addBabylonOutput(packet *PsbtPacket, address string, amount int64, network *chaincfg.Params) error {
+	pkScript, err := AddrToPkScript(address, network)
+	if err != nil {
+		return err
+	}
+	if amount < RelayPolicyFor(network).DustThreshold(pkScript) {
+		return ErrDustOutput
+	}
+	return packet.AddOutput(&TxOutput{Address: address, Amount: amount}, network)
+}
+
+// babylonLeaf returns the single leaf of input i and its sighash.
+func babylonLeaf(p *PsbtPacket, i int) (*psbt.TaprootTapLeafScript, []byte, error) {
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return nil, nil, ErrPsbtInputIndex
+	}
+	in := &p.Packet.Inputs[i]
+	if len(in.TaprootLeafScript) != 1 {
+		return nil, nil, fmt.Errorf("%w: input %d has %d tap leaves", ErrBabylonOutput, i, len(in.TaprootLeafScript))
+	}
+	leaf := in.TaprootLeafScript[0]
+	if err := checkPsbtUtxos(p.Packet); err != nil {
+		return nil, nil, err
+	}
+	fetcher := psbtPrevOutFetcher(p.Packet)
+	sigHashes := txscript.NewTxSigHashes(p.Packet.UnsignedTx, fetcher)
+	hash, err := txscript.CalcTapscriptSignaturehash(sigHashes, txscript.SigHashDefault, p.Packet.UnsignedTx, i, fetcher,
+		txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script))
+	if err != nil {
+		return nil, nil, err
+	}
+	return leaf, hash, nil
+}
+
+// AddBabylonSignature adds the signature of pubKey, such as that of a
+// covenant member, to the leaf of input i once it is checked against the
+// transaction.
+func AddBabylonSignature(p *PsbtPacket, i int, pubKey, signature string) error {
+	leaf, hash, err := babylonLeaf(p, i)
+	if err != nil {
+		return err
+	}
+	xOnly, err := parseXOnlyPubKey(pubKey)
+	if err != nil {
+		return err
+	}
+	sigBytes, err := hex.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return err
+	}
+	key, err := schnorr.ParsePubKey(xOnly)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(hash, key) {
+		return fmt.Errorf("invalid signature of %s on input %d", pubKey, i)
+	}
+	in := &p.Packet.Inputs[i]
+	leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+	for _, s := range in.TaprootScriptSpendSig {
+		if bytes.Equal(s.XOnlyPubKey, xOnly) && bytes.Equal(s.LeafHash, leafHash[:]) {
+			return nil
+		}
+	}
+	in.TaprootScriptSpendSig = append(in.TaprootScriptSpendSig, &psbt.TaprootScriptSpendSig{
+		XOnlyPubKey: xOnly,
+		LeafHash:    leafHash[:],
+		Signature:   sigBytes,
+		SigHash:     txscript.SigHashDefault,
+	})
+	return nil
+}
+
+// BabylonSignature returns the hex signature of pubKey on the leaf of input
+// i, such as the staker's slashing pre-signature Babylon asks for.
+func BabylonSignature(p *PsbtPacket, i int, pubKey string) (string, error) {
+	leaf, _, err := babylonLeaf(p, i)
+	if err != nil {
+		return "", err
+	}
+	xOnly, err := parseXOnlyPubKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+	for _, s := range p.Packet.Inputs[i].TaprootScriptSpendSig {
+		if bytes.Equal(s.XOnlyPubKey, xOnly) && bytes.Equal(s.LeafHash, leafHash[:]) {
+			return hex.EncodeToString(s.Signature), nil
+		}
+	}
+	return "", ErrBabylonSignature
+}
+
+// babylonMultiWitness returns the witness items of the threshold of keys
+// check, keys that do not sign get an empty item.
+func babylonMultiWitness(keys [][]byte, threshold int, sig func(key []byte) []byte) ([][]byte, error) {
+	items := make([][]byte, len(keys))
+	count := 0
+	for j, key := range keys {
+		if count == threshold {
+			break
+		}
+		// the first key checks the top of the stack
+		if s := sig(key); s != nil {
+			items[len(keys)-1-j] = s
+			count++
+		}
+	}
+	if count < threshold {
+		return nil, fmt.Errorf("%w: %d of %d signatures", ErrBabylonSignature, count, threshold)
+	}
+	return items, nil
+}
+
+// FinalizeBabylonInput builds the final witness of input i from the
+// signatures on its leaf: the staker's, and for the unbonding and slashing
+// leaves those of the covenant quorum and of a finality provider.
+func FinalizeBabylonInput(p *PsbtPacket, i int, b *BabylonStaking) error {
+	leaf, _, err := babylonLeaf(p, i)
+	if err != nil {
+		return err
+	}
+	in := &p.Packet.Inputs[i]
+	leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+	sig := func(key []byte) []byte {
+		for _, s := range in.TaprootScriptSpendSig {
+			if bytes.Equal(s.XOnlyPubKey, key) && bytes.Equal(s.LeafHash, leafHash[:]) {
+				if s.SigHash != txscript.SigHashDefault {
+					return append(append([]byte{}, s.Signature...), byte(s.SigHash))
+				}
+				return s.Signature
+			}
+		}
+		return nil
+	}
+	unbonding, err := b.UnbondingLeaf()
+	if err != nil {
+		return err
+	}
+	slashing, err := b.SlashingLeaf()
+	if err != nil {
+		return err
+	}
+
+	var witness [][]byte
+	switch {
+	case bytes.Equal(leaf.Script, unbonding):
+		if witness, err = babylonMultiWitness(b.covenantKeys, b.CovenantQuorum, sig); err != nil {
+			return err
+		}
+	case bytes.Equal(leaf.Script, slashing):
+		covenant, err := babylonMultiWitness(b.covenantKeys, b.CovenantQuorum, sig)
+		if err != nil {
+			return err
+		}
+		fp, err := babylonMultiWitness(b.fpKeys, 1, sig)
+		if err != nil {
+			return err
+		}
+		witness = append(covenant, fp...)
+	}
+	stakerSig := sig(b.stakerKey)
+	if stakerSig == nil {
+		return ErrBabylonSignature
+	}
+	witness = append(witness, stakerSig, leaf.Script, leaf.ControlBlock)
+	return finalizeHTLCWitness(in, witness)
+}
+
+// BuildBabylonWithdrawTx returns the hex of the signed transaction
+// withdrawing utxo to address once its timelock has passed.
+func BuildBabylonWithdrawTx(b *BabylonStaking, utxo *BabylonUtxo, address string, feeRate int64, privateKey string,
+	network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	sign := func(fee int64) (*wire.MsgTx, error) {
+		packet, err := NewBabylonWithdrawPsbt(b, utxo, address, fee, network)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := packet.Sign(privateKey); err != nil {
+			return nil, err
+		}
+		if err := FinalizeBabylonInput(packet, 0, b); err != nil {
+			return nil, err
+		}
+		return psbt.Extract(packet.Packet)
+	}
+	// schnorr signatures have a fixed size, the first pass gives the size
+	tx, err := sign(0)
+	if err != nil {
+		return "", err
+	}
+	tx, err = sign(RelayPolicyFor(network).Fee(GetTxVirtualSize(btcutil.NewTx(tx)), feeRate))
+	if err != nil {
+		return "", err
+	}
+	return GetTxHex(tx)
+}
@@ -0,0 +1,167 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const babylonTestStakerWif = "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+
+// babylonTestStaking has two finality providers, keys 1 and 2, and a 2 of 3
+// covenant committee, keys 3 to 5.
+func babylonTestStaking(t *testing.T) (*BabylonStaking, []string) {
+	wifs, pubKeys := testKeys(t, 5, &chaincfg.TestNet3Params)
+	b, err := NewBabylonStaking("0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f",
+		pubKeys[:2], pubKeys[2:], 2, 150, 50)
+	require.NoError(t, err)
+	return b, wifs
+}
+
+func babylonTestVerify(t *testing.T, p *PsbtPacket, utxo *BabylonUtxo, b *BabylonStaking, network *chaincfg.Params) string {
+	txHex, err := p.Extract()
+	require.NoError(t, err)
+	addr, err := b.Address(utxo.Output, network)
+	require.NoError(t, err)
+	res, err := VerifyTransaction(txHex, PrevOutputs{{TxId: utxo.TxId, VOut: utxo.VOut, Amount: utxo.Amount, Address: addr}}, network)
+	require.NoError(t, err)
+	assert.NoError(t, res.Err())
+	return res.TxId
+}
+
+func TestBabylonStakingTree(t *testing.T) {
+	b, _ := babylonTestStaking(t)
+	for _, output := range []BabylonOutput{BabylonStakingOutput, BabylonUnbondingOutput, BabylonSlashingChangeOutput} {
+		tree, err := b.TaprootTree(output)
+		require.NoError(t, err)
+		assembled := txscript.AssembleTaprootScriptTree(tree.Leaves...)
+		root := assembled.RootNode.TapHash()
+		assert.Equal(t, root[:], tree.MerkleRoot())
+	}
+	tree, err := b.TaprootTree(BabylonStakingOutput)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(tree.Leaves))
+	assert.Equal(t, "20"+b.StakerPubKey[2:]+"ad029600b2", hex.EncodeToString(tree.Leaves[0].Script))
+	unbonding, err := b.UnbondingLeaf()
+	require.NoError(t, err)
+	// staker check, then the covenant multi_a
+	assert.Equal(t, byte(txscript.OP_NUMEQUAL), unbonding[len(unbonding)-1])
+	slashing, err := b.SlashingLeaf()
+	require.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(slashing, unbonding[34:]))
+	assert.Contains(t, hex.EncodeToString(slashing), hex.EncodeToString([]byte{txscript.OP_CHECKSIGADD, txscript.OP_1, txscript.OP_NUMEQUALVERIFY}))
+
+	_, pubKeys := testKeys(t, 1, &chaincfg.TestNet3Params)
+	pubKey := pubKeys[0]
+	_, err = NewBabylonStaking(b.StakerPubKey, []string{pubKey}, b.CovenantPubKeys, 4, 150, 50)
+	assert.ErrorIs(t, err, ErrBabylonParams)
+	_, err = NewBabylonStaking(b.StakerPubKey, []string{pubKey}, []string{pubKey}, 1, 150, 50)
+	assert.ErrorIs(t, err, ErrBabylonParams)
+	_, err = NewBabylonStaking(b.StakerPubKey, []string{pubKey}, b.CovenantPubKeys, 1, 0, 50)
+	assert.ErrorIs(t, err, ErrBabylonParams)
+}
+
+func TestBabylonUnbondingAndSlashing(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	b, wifs := babylonTestStaking(t)
+	staking := &BabylonUtxo{Output: BabylonStakingOutput, TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 100000}
+
+	// unbonding with the staker and two covenant members, whose signatures
+	// come from their own copies
+	p, err := NewBabylonUnbondingPsbt(b, staking, 1000, network)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0xffffffff), p.Packet.UnsignedTx.TxIn[0].Sequence)
+	assert.Equal(t, int64(99000), p.Packet.UnsignedTx.TxOut[0].Value)
+	unsigned, err := p.B64Encode()
+	require.NoError(t, err)
+	signed, err := p.Sign(babylonTestStakerWif)
+	require.NoError(t, err)
+	assert.Equal(t, 1, signed)
+	assert.ErrorIs(t, FinalizeBabylonInput(p, 0, b), ErrBabylonSignature)
+	for _, j := range []int{2, 4} {
+		cp, err := ParsePsbtPacket(unsigned)
+		require.NoError(t, err)
+		_, err = cp.Sign(wifs[j])
+		require.NoError(t, err)
+		sig, err := BabylonSignature(cp, 0, b.CovenantPubKeys[j-2])
+		require.NoError(t, err)
+		assert.Error(t, AddBabylonSignature(p, 0, b.CovenantPubKeys[1], sig))
+		require.NoError(t, AddBabylonSignature(p, 0, b.CovenantPubKeys[j-2], sig))
+	}
+	require.NoError(t, FinalizeBabylonInput(p, 0, b))
+	unbondingTxId := babylonTestVerify(t, p, staking, b, network)
+
+	// slashing of the staking output
+	slashingScript, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.NoError(t, err)
+	slashing := &BabylonSlashing{PkScript: hex.EncodeToString(slashingScript), Rate: 10, Fee: 1000}
+	p, err = NewBabylonSlashingPsbt(b, staking, slashing, network)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(p.Packet.UnsignedTx.TxOut))
+	assert.Equal(t, int64(10000), p.Packet.UnsignedTx.TxOut[0].Value)
+	assert.Equal(t, int64(89000), p.Packet.UnsignedTx.TxOut[1].Value)
+	_, err = p.Sign(babylonTestStakerWif)
+	require.NoError(t, err)
+	preSig, err := BabylonSignature(p, 0, b.StakerPubKey)
+	require.NoError(t, err)
+	assert.Equal(t, 128, len(preSig))
+	for _, j := range []int{0, 3, 4} {
+		_, err = p.Sign(wifs[j])
+		require.NoError(t, err)
+	}
+	require.NoError(t, FinalizeBabylonInput(p, 0, b))
+	babylonTestVerify(t, p, staking, b, network)
+
+	// slashing of the unbonding output needs a finality provider signature
+	unbonding := &BabylonUtxo{Output: BabylonUnbondingOutput, TxId: unbondingTxId, Amount: 99000}
+	p, err = NewBabylonSlashingPsbt(b, unbonding, slashing, network)
+	require.NoError(t, err)
+	for _, wif := range []string{babylonTestStakerWif, wifs[2], wifs[3]} {
+		_, err = p.Sign(wif)
+		require.NoError(t, err)
+	}
+	assert.ErrorIs(t, FinalizeBabylonInput(p, 0, b), ErrBabylonSignature)
+	_, err = p.Sign(wifs[1])
+	require.NoError(t, err)
+	require.NoError(t, FinalizeBabylonInput(p, 0, b))
+	babylonTestVerify(t, p, unbonding, b, network)
+
+	_, err = NewBabylonUnbondingPsbt(b, unbonding, 1000, network)
+	assert.ErrorIs(t, err, ErrBabylonOutput)
+	_, err = NewBabylonSlashingPsbt(b, &BabylonUtxo{Output: BabylonSlashingChangeOutput, TxId: unbondingTxId, Amount: 89000}, slashing, network)
+	assert.ErrorIs(t, err, ErrBabylonOutput)
+}
+
+func TestBabylonWithdraw(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	b, wifs := babylonTestStaking(t)
+	for _, utxo := range []*BabylonUtxo{
+		{Output: BabylonStakingOutput, TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 100000},
+		{Output: BabylonSlashingChangeOutput, TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", VOut: 1, Amount: 89000},
+	} {
+		txHex, err := BuildBabylonWithdrawTx(b, utxo, "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 2, babylonTestStakerWif, network)
+		require.NoError(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.NoError(t, err)
+		lockTime := uint32(b.UnbondingTime)
+		if utxo.Output == BabylonStakingOutput {
+			lockTime = uint32(b.StakingTime)
+		}
+		assert.Equal(t, lockTime, tx.TxIn[0].Sequence)
+		assert.Equal(t, int64(2), (utxo.Amount-tx.TxOut[0].Value)/GetTxVirtualSize(btcutil.NewTx(tx)))
+		addr, err := b.Address(utxo.Output, network)
+		require.NoError(t, err)
+		res, err := VerifyTransaction(txHex, PrevOutputs{{TxId: utxo.TxId, VOut: utxo.VOut, Amount: utxo.Amount, Address: addr}}, network)
+		require.NoError(t, err)
+		assert.NoError(t, res.Err())
+	}
+
+	_, err := BuildBabylonWithdrawTx(b, &BabylonUtxo{Output: BabylonStakingOutput, TxId: "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206", Amount: 100000},
+		"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 2, wifs[0], network)
+	assert.ErrorIs(t, err, ErrBabylonSignature)
+}
@@ -0,0 +1,250 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"strings"
+)
+
+// CashAddr prefixes of the Bitcoin Cash networks.
+const (
+	CashAddrPrefixMainNet = "bitcoincash"
+	CashAddrPrefixTestNet = "bchtest"
+	CashAddrPrefixRegTest = "bchreg"
+)
+
+// CashAddr types, the token-aware ones tell that the wallet accepts CashTokens.
+const (
+	CashAddrP2PKH      byte = 0
+	CashAddrP2SH       byte = 1
+	CashAddrTokenP2PKH byte = 2
+	CashAddrTokenP2SH  byte = 3
+)
+
+const cashAddrCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var ErrInvalidCashAddr = errors.New("invalid cashaddr")
+
+var cashAddrHashSizes = []int{20, 24, 28, 32, 40, 48, 56, 64}
+
+// CashAddrPrefix returns the CashAddr prefix of network, empty when the network
+// does not use CashAddr: the BTC params never do, GetBCHTestNetParams and
+// GetBCHRegTestParams are the BCH test networks.
+func CashAddrPrefix(network *chaincfg.Params) string {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	switch network.Net {
+	case GetBCHmainNetParams().Net:
+		return CashAddrPrefixMainNet
+	case GetBCHTestNetParams().Net:
+		return CashAddrPrefixTestNet
+	case GetBCHRegTestParams().Net:
+		return CashAddrPrefixRegTest
+	}
+	return ""
+}
+
+func cashAddrPolyMod(values []byte) uint64 {
+	c := uint64(1)
+	for _, d := range values {
+		c0 := byte(c >> 35)
+		c = ((c & 0x07ffffffff) << 5) ^ uint64(d)
+		for i, g := range []uint64{0x98f2bc8e61, 0x79b76d99e2, 0xf33e5fb3c4, 0xae2eabe2a8, 0x1e4f43e470} {
+			if c0&(1<<i) != 0 {
+				c ^= g
+			}
+		}
+	}
+	return c ^ 1
+}
+
+func cashAddrPrefixValues(prefix string) []byte {
+	values := make([]byte, 0, len(prefix)+1)
+	for i := 0; i < len(prefix); i++ {
+		values = append(values, prefix[i]&0x1f)
+	}
+	return append(values, 0)
+}
+
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, bool) {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	var out []byte
+	for _, b := range data {
+		acc = acc<<fromBits | uint(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
+
+// EncodeCashAddr encodes hash as a CashAddr of addrType, the result always
+// starting with prefix and its separator.
+func EncodeCashAddr(prefix string, addrType byte, hash []byte) (string, error) {
+	sizeCode := -1
+	for i, size := range cashAddrHashSizes {
+		if size == len(hash) {
+			sizeCode = i
+		}
+	}
+	if sizeCode < 0 || addrType > 15 || prefix == "" {
+		return "", ErrInvalidCashAddr
+	}
+	payload, _ := convertBits(append([]byte{addrType<<3 | byte(sizeCode)}, hash...), 8, 5, true)
+	checksum := cashAddrPolyMod(append(append(cashAddrPrefixValues(prefix), payload...), make([]byte, 8)...))
+	var sb strings.Builder
+	sb.WriteString(prefix)
+	sb.WriteByte(':')
+	for _, v := range payload {
+		sb.WriteByte(cashAddrCharset[v])
+	}
+	for i := 0; i < 8; i++ {
+		sb.WriteByte(cashAddrCharset[checksum>>(5*(7-i))&0x1f])
+	}
+	return sb.String(), nil
+}
+
+// DecodeCashAddr decodes addr, defaultPrefix is assumed when addr has none.
+func DecodeCashAddr(addr, defaultPrefix string) (prefix string, addrType byte, hash []byte, err error) {
+	if strings.ToLower(addr) != addr && strings.ToUpper(addr) != addr {
+		return "", 0, nil, ErrInvalidCashAddr
+	}
+	addr = strings.ToLower(addr)
+	prefix, data := defaultPrefix, addr
+	if i := strings.LastIndexByte(addr, ':'); i >= 0 {
+		prefix, data = addr[:i], addr[i+1:]
+	}
+	if prefix == "" || len(data) < 8 {
+		return "", 0, nil, ErrInvalidCashAddr
+	}
+	values := make([]byte, len(data))
+	for i := 0; i < len(data); i++ {
+		v := strings.IndexByte(cashAddrCharset, data[i])
+		if v < 0 {
+			return "", 0, nil, ErrInvalidCashAddr
+		}
+		values[i] = byte(v)
+	}
+	if cashAddrPolyMod(append(cashAddrPrefixValues(prefix), values...)) != 0 {
+		return "", 0, nil, ErrInvalidCashAddr
+	}
+	payload, ok := convertBits(values[:len(values)-8], 5, 8, false)
+	if !ok || len(payload) == 0 || payload[0]&0x80 != 0 {
+		return "", 0, nil, ErrInvalidCashAddr
+	}
+	version := payload[0]
+	if len(payload)-1 != cashAddrHashSizes[version&0x07] {
+		return "", 0, nil, ErrInvalidCashAddr
+	}
+	return prefix, version >> 3, payload[1:], nil
+}
+
+// decodeNetworkCashAddr decodes addr, which must have the prefix of network
+// and one of the CashAddr types.
+func decodeNetworkCashAddr(addr string, network *chaincfg.Params) (byte, []byte, error) {
+	prefix := CashAddrPrefix(network)
+	decodedPrefix, addrType, hash, err := DecodeCashAddr(addr, prefix)
+	if err != nil {
+		return 0, nil, err
+	}
+	if prefix == "" || decodedPrefix != prefix {
+		return 0, nil, fmt.Errorf("%w: prefix %s", ErrInvalidCashAddr, decodedPrefix)
+	}
+	if addrType > CashAddrTokenP2SH {
+		return 0, nil, fmt.Errorf("%w: type %d", ErrInvalidCashAddr, addrType)
+	}
+	return addrType, hash, nil
+}
+
+// IsValidCashAddr reports whether addr is a CashAddr of network.
+func IsValidCashAddr(addr string, network *chaincfg.Params) bool {
+	_, _, err := decodeNetworkCashAddr(addr, network)
+	return err == nil
+}
+
+// CashAddrToPkScript returns the locking script of a CashAddr of network,
+// 32 byte script hashes being P2SH32.
+func CashAddrToPkScript(addr string, network *chaincfg.Params) ([]byte, error) {
+	addrType, hash, err := decodeNetworkCashAddr(addr, network)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case (addrType == CashAddrP2PKH || addrType == CashAddrTokenP2PKH) && len(hash) == 20:
+		return PayToPubKeyHashScript(hash)
+	case (addrType == CashAddrP2SH || addrType == CashAddrTokenP2SH) && len(hash) == 20:
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).AddData(hash).AddOp(txscript.OP_EQUAL).Script()
+	case (addrType == CashAddrP2SH || addrType == CashAddrTokenP2SH) && len(hash) == 32:
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH256).AddData(hash).AddOp(txscript.OP_EQUAL).Script()
+	}
+	return nil, ErrInvalidCashAddr
+}
+
+// IsTokenAwareCashAddr reports whether addr is a token-aware CashAddr of network.
+func IsTokenAwareCashAddr(addr string, network *chaincfg.Params) bool {
+	addrType, _, err := decodeNetworkCashAddr(addr, network)
+	return err == nil && (addrType == CashAddrTokenP2PKH || addrType == CashAddrTokenP2SH)
+}
+
+// LegacyToCashAddr converts a base58 address of network to a CashAddr,
+// tokenAware selects the token-aware type.
+func LegacyToCashAddr(addr string, network *chaincfg.Params, tokenAware bool) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	address, err := btcutil.DecodeAddress(addr, network)
+	if err != nil {
+		return "", err
+	}
+	var addrType byte
+	switch address.(type) {
+	case *btcutil.AddressPubKeyHash:
+		addrType = CashAddrP2PKH
+	case *btcutil.AddressScriptHash:
+		addrType = CashAddrP2SH
+	default:
+		return "", ErrInvalidCashAddr
+	}
+	if tokenAware {
+		addrType += CashAddrTokenP2PKH
+	}
+	return EncodeCashAddr(CashAddrPrefix(network), addrType, address.ScriptAddress())
+}
+
+// CashAddrToLegacy converts a 20 byte hash CashAddr of network to base58.
+func CashAddrToLegacy(addr string, network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	addrType, hash, err := decodeNetworkCashAddr(addr, network)
+	if err != nil {
+		return "", err
+	}
+	if len(hash) != 20 {
+		return "", ErrInvalidCashAddr
+	}
+	var address btcutil.Address
+	if addrType&1 == 0 {
+		address, err = btcutil.NewAddressPubKeyHash(hash, network)
+	} else {
+		address, err = btcutil.NewAddressScriptHashFromHash(hash, network)
+	}
+	if err != nil {
+		return "", err
+	}
+	return address.EncodeAddress(), nil
+}
@@ -0,0 +1,111 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestCashAddr(t *testing.T) {
+	network := GetBCHmainNetParams()
+	hash := mustDecodeHex(t, "76a04053bda0a88bda5177b86a15c3b29f559873")
+	for _, test := range []struct {
+		addrType byte
+		addr     string
+	}{
+		{CashAddrP2PKH, "bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a"},
+		{CashAddrP2SH, "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq"},
+		{CashAddrTokenP2PKH, "bitcoincash:zpm2qsznhks23z7629mms6s4cwef74vcwvrqekrq9w"},
+		{CashAddrTokenP2SH, "bitcoincash:rpm2qsznhks23z7629mms6s4cwef74vcwv59yeyr7n"},
+	} {
+		addr, err := EncodeCashAddr(CashAddrPrefixMainNet, test.addrType, hash)
+		require.NoError(t, err)
+		assert.Equal(t, test.addr, addr)
+		prefix, addrType, decoded, err := DecodeCashAddr(test.addr[len(CashAddrPrefixMainNet)+1:], CashAddrPrefixMainNet)
+		require.NoError(t, err)
+		assert.Equal(t, CashAddrPrefixMainNet, prefix)
+		assert.Equal(t, test.addrType, addrType)
+		assert.Equal(t, hash, decoded)
+		assert.True(t, IsValidCashAddr(test.addr, network))
+		assert.Equal(t, test.addrType >= CashAddrTokenP2PKH, IsTokenAwareCashAddr(test.addr, network))
+	}
+
+	legacy, err := CashAddrToLegacy("qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", network)
+	require.NoError(t, err)
+	assert.Equal(t, "1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", legacy)
+	cashAddr, err := LegacyToCashAddr("3CWFddi6m4ndiGyKqzYvsFYagqDLPVMTzC", network, false)
+	require.NoError(t, err)
+	assert.Equal(t, "bitcoincash:ppm2qsznhks23z7629mms6s4cwef74vcwvn0h829pq", cashAddr)
+
+	pkScript, err := AddrToPkScript("bitcoincash:zpm2qsznhks23z7629mms6s4cwef74vcwvrqekrq9w", network)
+	require.NoError(t, err)
+	assert.Equal(t, "76a91476a04053bda0a88bda5177b86a15c3b29f55987388ac", hex.EncodeToString(pkScript))
+	p2sh32, err := EncodeCashAddr(CashAddrPrefixMainNet, CashAddrP2SH, make([]byte, 32))
+	require.NoError(t, err)
+	pkScript, err = CashAddrToPkScript(p2sh32, network)
+	require.NoError(t, err)
+	assert.Equal(t, "aa20"+hex.EncodeToString(make([]byte, 32))+"87", hex.EncodeToString(pkScript))
+
+	for _, addr := range []string{
+		"bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6b",
+		"bitcoincash:Qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+		"bchtest:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a",
+	} {
+		assert.False(t, IsValidCashAddr(addr, network), addr)
+	}
+	assert.True(t, IsValidCashAddr("BITCOINCASH:QPM2QSZNHKS23Z7629MMS6S4CWEF74VCWVY22GDX6A", network))
+	_, err = AddrToPkScript("bitcoincash:qpm2qsznhks23z7629mms6s4cwef74vcwvy22gdx6a", &chaincfg.MainNetParams)
+	assert.Error(t, err)
+}
+
+func TestCashAddrNetworks(t *testing.T) {
+	hash := mustDecodeHex(t, "76a04053bda0a88bda5177b86a15c3b29f559873")
+	for _, test := range []struct {
+		bch    *chaincfg.Params
+		btc    *chaincfg.Params
+		prefix string
+	}{
+		{GetBCHTestNetParams(), &chaincfg.TestNet3Params, CashAddrPrefixTestNet},
+		{GetBCHRegTestParams(), &chaincfg.RegressionNetParams, CashAddrPrefixRegTest},
+	} {
+		addr, err := EncodeCashAddr(test.prefix, CashAddrP2PKH, hash)
+		require.NoError(t, err)
+		assert.Equal(t, test.prefix, CashAddrPrefix(test.bch))
+		assert.True(t, IsBCHNetwork(test.bch))
+		pkScript, err := AddrToPkScript(addr, test.bch)
+		require.NoError(t, err)
+		assert.Equal(t, "76a914"+hex.EncodeToString(hash)+"88ac", hex.EncodeToString(pkScript))
+
+		// the BTC test networks do not take CashAddrs
+		assert.Empty(t, CashAddrPrefix(test.btc))
+		assert.False(t, IsBCHNetwork(test.btc))
+		_, err = AddrToPkScript(addr, test.btc)
+		assert.Error(t, err, addr)
+
+		// nor are addresses of another network converted
+		_, err = CashAddrToLegacy(addr, GetBCHmainNetParams())
+		assert.ErrorIs(t, err, ErrInvalidCashAddr)
+		token, err := EncodeCashAddr(test.prefix, CashAddrTokenP2PKH, hash)
+		require.NoError(t, err)
+		assert.True(t, IsTokenAwareCashAddr(token, test.bch))
+		assert.False(t, IsTokenAwareCashAddr(token, GetBCHmainNetParams()))
+	}
+
+	mainnet, err := EncodeCashAddr(CashAddrPrefixMainNet, CashAddrP2PKH, hash)
+	require.NoError(t, err)
+	_, err = CashAddrToLegacy(mainnet, &chaincfg.MainNetParams)
+	assert.ErrorIs(t, err, ErrInvalidCashAddr)
+	_, err = CashAddrToLegacy(mainnet, nil)
+	assert.ErrorIs(t, err, ErrInvalidCashAddr)
+	assert.Empty(t, CashAddrPrefix(nil))
+
+	// types above the token-aware P2SH are not defined
+	unknown, err := EncodeCashAddr(CashAddrPrefixMainNet, 4, hash)
+	require.NoError(t, err)
+	_, err = CashAddrToLegacy(unknown, GetBCHmainNetParams())
+	assert.ErrorIs(t, err, ErrInvalidCashAddr)
+	assert.False(t, IsTokenAwareCashAddr(unknown, GetBCHmainNetParams()))
+	assert.False(t, IsValidCashAddr(unknown, GetBCHmainNetParams()))
+}
@@ -0,0 +1,320 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var ErrInvalidCBOR = errors.New("invalid cbor")
+
+const cborMaxDepth = 64
+
+// DecodeCBOR decodes a single CBOR item into the values encoding/json uses:
+// maps become map[string]interface{} with their keys formatted, integers are
+// uint64 or int64, and tags are dropped.
+func DecodeCBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, ErrInvalidCBOR
+	}
+	return v, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+var errCBORBreak = errors.New("cbor break")
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, ErrInvalidCBOR
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the initial byte and argument of an item, indefinite is set for
+// the additional information 31.
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		var v []byte
+		if v, err = d.next(1 << (info - 24)); err != nil {
+			return
+		}
+		for _, c := range v {
+			arg = arg<<8 | uint64(c)
+		}
+	case info == 31:
+		indefinite = true
+	default:
+		err = ErrInvalidCBOR
+	}
+	return
+}
+
+// item decodes an item where a break is not allowed.
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	v, err := d.decode(depth)
+	return v, cborItemErr(err)
+}
+
+func cborItemErr(err error) error {
+	if err == errCBORBreak {
+		return ErrInvalidCBOR
+	}
+	return err
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > cborMaxDepth {
+		return nil, ErrInvalidCBOR
+	}
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major < 2 || major == 6) {
+		return nil, ErrInvalidCBOR
+	}
+	switch major {
+	case 0:
+		return arg, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, ErrInvalidCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		var b []byte
+		if indefinite {
+			for {
+				chunk, err := d.decode(depth + 1)
+				if err == errCBORBreak {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				switch c := chunk.(type) {
+				case []byte:
+					b = append(b, c...)
+				case string:
+					b = append(b, c...)
+				default:
+					return nil, ErrInvalidCBOR
+				}
+			}
+		} else if b, err = d.next(arg); err != nil {
+			return nil, err
+		}
+		if major == 3 {
+			return string(b), nil
+		}
+		return append([]byte{}, b...), nil
+	case 4:
+		array := make([]interface{}, 0)
+		for i := uint64(0); indefinite || i < arg; i++ {
+			v, err := d.decode(depth + 1)
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, cborItemErr(err)
+			}
+			array = append(array, v)
+		}
+		return array, nil
+	case 5:
+		m := make(map[string]interface{})
+		for i := uint64(0); indefinite || i < arg; i++ {
+			k, err := d.decode(depth + 1)
+			if err == errCBORBreak && indefinite {
+				break
+			}
+			if err != nil {
+				return nil, cborItemErr(err)
+			}
+			v, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			m[key] = v
+		}
+		return m, nil
+	case 6:
+		return d.item(depth + 1)
+	}
+
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return float16ToFloat64(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	case 31:
+		return nil, errCBORBreak
+	}
+	if info <= 24 {
+		return arg, nil
+	}
+	return nil, ErrInvalidCBOR
+}
+
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp, frac := int(h>>10&0x1f), float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	}
+	return sign * math.Ldexp(frac+1024, exp-25)
+}
+
+// EncodeCBOR encodes the values DecodeCBOR returns, as well as the other Go
+// integer types and map[string]string, in the canonical form with map keys
+// sorted by their encoding.
+func EncodeCBOR(v interface{}) ([]byte, error) {
+	return appendCBOR(nil, v)
+}
+
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return append(b, major<<5|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major<<5|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major<<5|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major<<5|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major<<5|27), arg)
+}
+
+func appendCBORInt(b []byte, n int64) []byte {
+	if n < 0 {
+		return appendCBORHead(b, 1, uint64(-1-n))
+	}
+	return appendCBORHead(b, 0, uint64(n))
+}
+
+func appendCBOR(b []byte, v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(b, 0xf6), nil
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case uint64:
+		return appendCBORHead(b, 0, v), nil
+	case uint32:
+		return appendCBORHead(b, 0, uint64(v)), nil
+	case uint:
+		return appendCBORHead(b, 0, uint64(v)), nil
+	case int64:
+		return appendCBORInt(b, v), nil
+	case int32:
+		return appendCBORInt(b, int64(v)), nil
+	case int:
+		return appendCBORInt(b, int64(v)), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return appendCBORInt(b, int64(v)), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v)), nil
+	case []byte:
+		return append(appendCBORHead(b, 2, uint64(len(v))), v...), nil
+	case string:
+		return append(appendCBORHead(b, 3, uint64(len(v))), v...), nil
+	case []interface{}:
+		b = appendCBORHead(b, 4, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if b, err = appendCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = item
+		}
+		return appendCBOR(b, m)
+	case map[string]interface{}:
+		keys := make([][]byte, 0, len(v))
+		values := make(map[string][]byte, len(v))
+		for k, item := range v {
+			key := appendCBORHead(nil, 3, uint64(len(k)))
+			key = append(key, k...)
+			value, err := appendCBOR(nil, item)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+			values[string(key)] = value
+		}
+		sortCBORKeys(keys)
+		b = appendCBORHead(b, 5, uint64(len(v)))
+		for _, key := range keys {
+			b = append(append(b, key...), values[string(key)]...)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cbor: unsupported type %T", v)
+}
+
+// sortCBORKeys orders encoded keys shortest first, then bytewise, the
+// canonical CBOR order.
+func sortCBORKeys(keys [][]byte) {
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && cborKeyLess(keys[j], keys[j-1]); j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+}
+
+func cborKeyLess(a, b []byte) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return string(a) < string(b)
+}
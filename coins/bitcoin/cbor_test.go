@@ -0,0 +1,77 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	for _, test := range []struct {
+		hex      string
+		expected interface{}
+	}{
+		{"00", uint64(0)},
+		{"1903e8", uint64(1000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f93c00", 1.0},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"83010203", []interface{}{uint64(1), uint64(2), uint64(3)}},
+		{"9f018202039f0405ffff", []interface{}{uint64(1), []interface{}{uint64(2), uint64(3)}, []interface{}{uint64(4), uint64(5)}}},
+		{"a201020304", map[string]interface{}{"1": uint64(2), "3": uint64(4)}},
+		{"bf61610161629f0203ffff", map[string]interface{}{"a": uint64(1), "b": []interface{}{uint64(2), uint64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"},
+	} {
+		v, err := DecodeCBOR(mustDecodeHex(t, test.hex))
+		require.NoError(t, err, test.hex)
+		assert.Equal(t, test.expected, v, test.hex)
+	}
+
+	for _, invalid := range []string{"", "18", "ff", "6449", "8301", "0001", "a1ff", "9f"} {
+		_, err := DecodeCBOR(mustDecodeHex(t, invalid))
+		assert.ErrorIs(t, err, ErrInvalidCBOR, invalid)
+	}
+}
+
+func TestEncodeCBOR(t *testing.T) {
+	for _, test := range []struct {
+		value interface{}
+		hex   string
+	}{
+		{uint64(1000), "1903e8"},
+		{-1000, "3903e7"},
+		{float64(10), "0a"},
+		{1.1, "fb3ff199999999999a"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2}, "420102"},
+		{nil, "f6"},
+		{[]interface{}{true, false}, "82f5f4"},
+		// keys sorted by length first
+		{map[string]interface{}{"bb": uint64(2), "a": uint64(1), "c": "x"}, "a361610161636178626262" + "02"},
+		{map[string]string{"k": "v"}, "a1616b6176"},
+	} {
+		b, err := EncodeCBOR(test.value)
+		require.NoError(t, err)
+		assert.Equal(t, test.hex, hex.EncodeToString(b))
+	}
+	_, err := EncodeCBOR(struct{}{})
+	assert.Error(t, err)
+
+	v := map[string]interface{}{"name": "okx", "list": []interface{}{uint64(1), int64(-2), "three"}}
+	b, err := EncodeCBOR(v)
+	require.NoError(t, err)
+	decoded, err := DecodeCBOR(b)
+	require.NoError(t, err)
+	assert.Equal(t, v, decoded)
+}
@@ -0,0 +1,457 @@
+package bitcoin
+
+import (
+	"errors"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"math/rand"
+	"sort"
+)
+
+type CoinSelectionStrategy string
+
+const (
+	// CoinSelectionAuto tries branch-and-bound first and falls back to knapsack.
+	CoinSelectionAuto         CoinSelectionStrategy = ""
+	CoinSelectionBnB          CoinSelectionStrategy = "bnb"
+	CoinSelectionKnapsack     CoinSelectionStrategy = "knapsack"
+	CoinSelectionLargestFirst CoinSelectionStrategy = "largest_first"
+
+	// bnbMaxTries bounds the depth-first search, same limit as Bitcoin Core.
+	bnbMaxTries = 100000
+	// knapsackIterations is the number of stochastic passes of the approximation.
+	knapsackIterations = 1000
+
+	// signature sizes used for weight estimation, high-s free DER plus sighash byte
+	estimatedECDSASigSize   = 72
+	estimatedSchnorrSigSize = 64
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrNoSolutionFound     = errors.New("no coin selection solution found")
+	ErrDustOutput          = errors.New("output amount below dust threshold")
+)
+
+type CoinSelectionRequest struct {
+	Utxos          PrevOutputs           `json:"utxos"`
+	Outputs        []*TxOutput           `json:"outputs"`
+	FeeRate        int64                 `json:"feeRate"` // sat/vB
+	ChangeAddress  string                `json:"changeAddress"`
+	DustThreshold  int64                 `json:"dustThreshold"`
+	MinChangeValue int64                 `json:"minChangeValue"`
+	Strategy       CoinSelectionStrategy `json:"strategy"`
+	TxVersion      int32                 `json:"txVersion"`
+}
+
+type CoinSelectionResult struct {
+	Inputs   PrevOutputs           `json:"inputs"`
+	Outputs  []*TxOutput           `json:"outputs"`
+	Fee      int64                 `json:"fee"`
+	Change   int64                 `json:"change"`
+	VSize    int64                 `json:"vSize"`
+	Strategy CoinSelectionStrategy `json:"strategy"`
+	Builder  *TransactionBuilder   `json:"-"`
+}
+
+type selectionCandidate struct {
+	utxo           *PrevOutput
+	weight         int64
+	effectiveValue int64
+}
+
+type coinSelector struct {
+	candidates   []*selectionCandidate
+	target       int64 // outputs plus the fee of the fixed part of the tx, excluding change
+	costOfChange int64 // fee to create and later spend a change output
+	changeFee    int64 // fee to create a change output
+	minChange    int64
+}
+
+// SelectCoins picks inputs from request.Utxos to fund request.Outputs at
+// request.FeeRate and returns a builder ready to sign together with the exact fee.
+func SelectCoins(request *CoinSelectionRequest, network *chaincfg.Params) (*CoinSelectionResult, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if request == nil || len(request.Utxos) == 0 || len(request.Outputs) == 0 {
+		return nil, errors.New("invalid inputs or outputs")
+	}
+	if request.FeeRate <= 0 {
+		return nil, errors.New("invalid fee rate")
+	}
+	policy := RelayPolicyFor(network)
+	if request.FeeRate < policy.MinFeeRate() {
+		return nil, ErrFeeRateBelowMinimum
+	}
+	dustThreshold := request.DustThreshold
+	if dustThreshold <= 0 {
+		dustThreshold = policy.DustLimit
+	}
+	minChange := request.MinChangeValue
+	if minChange <= 0 {
+		minChange = policy.ChangeThreshold()
+	}
+	if minChange < dustThreshold {
+		minChange = dustThreshold
+	}
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, err
+	}
+
+	baseWeight := int64(TxOverheadWeight(len(request.Utxos), len(request.Outputs)+1, false))
+	var outputAmount int64
+	for _, out := range request.Outputs {
+		if out.Amount < dustThreshold {
+			return nil, ErrDustOutput
+		}
+		pkScript, err := AddrToPkScript(out.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		baseWeight += OutputWeight(pkScript)
+		outputAmount += out.Amount
+	}
+
+	hasWitness := false
+	candidates := make([]*selectionCandidate, 0, len(request.Utxos))
+	for _, utxo := range request.Utxos {
+		if utxo.HasAssets() {
+			// asset bearing utxos never fund plain payments
+			continue
+		}
+		pkScript, err := AddrToPkScript(utxo.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := InputWeight(pkScript)
+		if err != nil {
+			return nil, err
+		}
+		if !txscript.IsPayToPubKeyHash(pkScript) {
+			hasWitness = true
+		}
+		effectiveValue := utxo.Amount - FeeForWeight(weight, request.FeeRate)
+		if effectiveValue <= 0 {
+			continue
+		}
+		candidates = append(candidates, &selectionCandidate{utxo: utxo, weight: weight, effectiveValue: effectiveValue})
+	}
+	if hasWitness {
+		baseWeight += 2
+	}
+
+	changeOutputWeight := OutputWeight(changePkScript)
+	changeSpendWeight, err := InputWeight(changePkScript)
+	if err != nil {
+		return nil, err
+	}
+	selector := &coinSelector{
+		candidates:   candidates,
+		target:       outputAmount + FeeForWeight(baseWeight, request.FeeRate),
+		changeFee:    FeeForWeight(changeOutputWeight, request.FeeRate),
+		costOfChange: FeeForWeight(changeOutputWeight+changeSpendWeight, request.FeeRate),
+		minChange:    minChange,
+	}
+
+	var selected []*selectionCandidate
+	strategy := request.Strategy
+	switch strategy {
+	case CoinSelectionBnB:
+		selected = selector.branchAndBound()
+	case CoinSelectionKnapsack:
+		selected = selector.knapsack()
+	case CoinSelectionLargestFirst:
+		selected = selector.largestFirst()
+	case CoinSelectionAuto:
+		strategy = CoinSelectionBnB
+		if selected = selector.branchAndBound(); selected == nil {
+			strategy = CoinSelectionKnapsack
+			selected = selector.knapsack()
+		}
+	default:
+		return nil, errors.New("unsupported coin selection strategy")
+	}
+	if selected == nil {
+		if selector.available() < selector.target {
+			return nil, ErrInsufficientBalance
+		}
+		return nil, ErrNoSolutionFound
+	}
+
+	var inputAmount, inputWeight int64
+	inputs := make(PrevOutputs, 0, len(selected))
+	for _, c := range selected {
+		inputs = append(inputs, c.utxo)
+		inputAmount += c.utxo.Amount
+		inputWeight += c.weight
+	}
+	feeWithoutChange := FeeForWeight(baseWeight+inputWeight, request.FeeRate)
+	if inputAmount-outputAmount < feeWithoutChange {
+		return nil, ErrInsufficientBalance
+	}
+	outputs := make([]*TxOutput, 0, len(request.Outputs)+1)
+	outputs = append(outputs, request.Outputs...)
+	totalWeight := baseWeight + inputWeight
+	change := inputAmount - outputAmount - FeeForWeight(totalWeight+changeOutputWeight, request.FeeRate)
+	if change >= minChange {
+		outputs = append(outputs, &TxOutput{Address: request.ChangeAddress, Amount: change, IsChange: true})
+		totalWeight += changeOutputWeight
+	} else {
+		change = 0
+	}
+
+	version := request.TxVersion
+	if version == 0 {
+		version = DefaultTxVersion
+	}
+	builder := NewTxBuild(version, network)
+	for _, in := range inputs {
+		builder.AddInput2(in.TxId, in.VOut, in.PrivateKey, in.Address, in.Amount)
+	}
+	for _, out := range outputs {
+		builder.AddOutput(out.Address, out.Amount)
+	}
+
+	return &CoinSelectionResult{
+		Inputs:   inputs,
+		Outputs:  outputs,
+		Fee:      inputAmount - outputAmount - change,
+		Change:   change,
+		VSize:    (totalWeight + WitnessScaleFactor - 1) / WitnessScaleFactor,
+		Strategy: strategy,
+		Builder:  builder,
+	}, nil
+}
+
+func (s *coinSelector) available() int64 {
+	total := int64(0)
+	for _, c := range s.candidates {
+		total += c.effectiveValue
+	}
+	return total
+}
+
+// branchAndBound searches for an input set whose effective value lands in
+// [target, target+costOfChange] so that no change output is needed.
+func (s *coinSelector) branchAndBound() []*selectionCandidate {
+	pool := make([]*selectionCandidate, len(s.candidates))
+	copy(pool, s.candidates)
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].effectiveValue > pool[j].effectiveValue
+	})
+	remaining := make([]int64, len(pool)+1)
+	for i := len(pool) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + pool[i].effectiveValue
+	}
+	if remaining[0] < s.target {
+		return nil
+	}
+
+	upper := s.target + s.costOfChange
+	tries := 0
+	bestWaste := int64(-1)
+	var best []int
+	current := make([]int, 0, len(pool))
+
+	var search func(depth int, value int64) bool
+	search = func(depth int, value int64) bool {
+		tries++
+		if tries > bnbMaxTries {
+			return true
+		}
+		if value > upper || value+remaining[depth] < s.target {
+			return false
+		}
+		if value >= s.target {
+			waste := value - s.target
+			if bestWaste < 0 || waste < bestWaste {
+				bestWaste = waste
+				best = append(best[:0], current...)
+			}
+			return waste == 0
+		}
+		if depth == len(pool) {
+			return false
+		}
+		current = append(current, depth)
+		if search(depth+1, value+pool[depth].effectiveValue) {
+			return true
+		}
+		current = current[:len(current)-1]
+		// skip equivalent candidates, excluding one of them excludes all of them
+		next := depth + 1
+		for next < len(pool) && pool[next].effectiveValue == pool[depth].effectiveValue {
+			next++
+		}
+		return search(next, value)
+	}
+	search(0, 0)
+
+	if best == nil {
+		return nil
+	}
+	selected := make([]*selectionCandidate, len(best))
+	for i, idx := range best {
+		selected[i] = pool[idx]
+	}
+	return selected
+}
+
+// knapsack is the stochastic subset-sum approximation used by Bitcoin Core
+// before branch-and-bound. It aims for target plus a change of at least minChange.
+func (s *coinSelector) knapsack() []*selectionCandidate {
+	target := s.target + s.changeFee + s.minChange
+	var lowerTotal int64
+	var lower []*selectionCandidate
+	var lowestLarger *selectionCandidate
+	for _, c := range s.candidates {
+		if c.effectiveValue == s.target {
+			return []*selectionCandidate{c}
+		}
+		if c.effectiveValue < target {
+			lower = append(lower, c)
+			lowerTotal += c.effectiveValue
+		} else if lowestLarger == nil || c.effectiveValue < lowestLarger.effectiveValue {
+			lowestLarger = c
+		}
+	}
+	if lowerTotal == s.target {
+		return lower
+	}
+	if lowerTotal < s.target {
+		if lowestLarger == nil {
+			return nil
+		}
+		return []*selectionCandidate{lowestLarger}
+	}
+	changeless := lowerTotal < target
+	if changeless {
+		// the lower ones fund the outputs but not a change output, try changeless
+		target = s.target
+	}
+
+	sort.SliceStable(lower, func(i, j int) bool {
+		return lower[i].effectiveValue > lower[j].effectiveValue
+	})
+	best, bestValue := approximateBestSubset(lower, lowerTotal, target)
+	if lowestLarger != nil && ((changeless && bestValue != target) || lowestLarger.effectiveValue <= bestValue) {
+		return []*selectionCandidate{lowestLarger}
+	}
+	selected := make([]*selectionCandidate, 0, len(lower))
+	for i, included := range best {
+		if included {
+			selected = append(selected, lower[i])
+		}
+	}
+	return selected
+}
+
+func approximateBestSubset(pool []*selectionCandidate, total, target int64) ([]bool, int64) {
+	// a fixed seed keeps the selection reproducible for the same utxo set
+	r := rand.New(rand.NewSource(int64(len(pool))*31 + target))
+	best := make([]bool, len(pool))
+	for i := range best {
+		best[i] = true
+	}
+	bestValue := total
+	included := make([]bool, len(pool))
+	for rep := 0; rep < knapsackIterations && bestValue != target; rep++ {
+		for i := range included {
+			included[i] = false
+		}
+		value := int64(0)
+		reachedTarget := false
+		for pass := 0; pass < 2 && !reachedTarget; pass++ {
+			for i, c := range pool {
+				include := r.Intn(2) == 1
+				if pass == 1 {
+					include = !included[i]
+				}
+				if !include {
+					continue
+				}
+				value += c.effectiveValue
+				included[i] = true
+				if value >= target {
+					reachedTarget = true
+					if value < bestValue {
+						bestValue = value
+						copy(best, included)
+					}
+					value -= c.effectiveValue
+					included[i] = false
+				}
+			}
+		}
+	}
+	return best, bestValue
+}
+
+// largestFirst adds candidates by descending value until outputs, fee and a
+// change output of at least minChange are covered.
+func (s *coinSelector) largestFirst() []*selectionCandidate {
+	pool := make([]*selectionCandidate, len(s.candidates))
+	copy(pool, s.candidates)
+	sort.SliceStable(pool, func(i, j int) bool {
+		return pool[i].effectiveValue > pool[j].effectiveValue
+	})
+	var value int64
+	for i, c := range pool {
+		value += c.effectiveValue
+		if value >= s.target+s.changeFee+s.minChange || (value >= s.target && value <= s.target+s.costOfChange) {
+			return pool[:i+1]
+		}
+	}
+	if value >= s.target {
+		return pool
+	}
+	return nil
+}
+
+// InputWeight estimates the weight of a signed input spending pkScript with a
+// single key, matching the script types produced by Sign.
+func InputWeight(pkScript []byte) (int64, error) {
+	// outpoint + sequence
+	const base = 32 + 4 + 4
+	switch {
+	case txscript.IsPayToPubKeyHash(pkScript):
+		// scriptSig: push sig, push compressed pubkey
+		scriptSigLen := 1 + estimatedECDSASigSize + 1 + 33
+		return int64((base+wire.VarIntSerializeSize(uint64(scriptSigLen))+scriptSigLen)*WitnessScaleFactor + 1), nil
+	case txscript.IsPayToScriptHash(pkScript):
+		// p2sh-p2wpkh: scriptSig pushes the 22 byte redeem script
+		scriptSigLen := 1 + 22
+		witness := 1 + 1 + estimatedECDSASigSize + 1 + 33
+		return int64((base+1+scriptSigLen)*WitnessScaleFactor + witness), nil
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		witness := 1 + 1 + estimatedECDSASigSize + 1 + 33
+		return int64((base+1)*WitnessScaleFactor + witness), nil
+	case txscript.IsPayToTaproot(pkScript):
+		witness := 1 + 1 + estimatedSchnorrSigSize
+		return int64((base+1)*WitnessScaleFactor + witness), nil
+	}
+	return 0, errors.New("unsupported input script type")
+}
+
+// OutputWeight returns the weight of an output paying to pkScript.
+func OutputWeight(pkScript []byte) int64 {
+	return int64((8 + wire.VarIntSerializeSize(uint64(len(pkScript))) + len(pkScript)) * WitnessScaleFactor)
+}
+
+// TxOverheadWeight returns the weight of version, locktime and the in/out
+// counters, plus the segwit marker and flag when hasWitness is set.
+func TxOverheadWeight(numInputs, numOutputs int, hasWitness bool) int {
+	weight := (4 + 4 + wire.VarIntSerializeSize(uint64(numInputs)) + wire.VarIntSerializeSize(uint64(numOutputs))) * WitnessScaleFactor
+	if hasWitness {
+		weight += 2
+	}
+	return weight
+}
+
+// FeeForWeight converts weight to vbytes (rounded up) and multiplies by feeRate.
+func FeeForWeight(weight int64, feeRate int64) int64 {
+	return (weight + WitnessScaleFactor - 1) / WitnessScaleFactor * feeRate
+}
@@ -0,0 +1,110 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func coinSelectionUtxos(amounts ...int64) PrevOutputs {
+	txIds := []string{
+		"0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		"02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1",
+		"ce69ca86b68708afc8484dacb7730006e7eff6d0c18b18a16a9e91abeefeb08a",
+		"3cb62c77c5c3fc032100af4cae9eeb342829cbc5b49815f8db1bb8156314a784",
+		"c44a7f98434e5e875a573339f77d36022c79c525771fa88c72fa53f3a55eeaf7",
+	}
+	utxos := make(PrevOutputs, 0, len(amounts))
+	for i, amount := range amounts {
+		utxos = append(utxos, &PrevOutput{
+			TxId:       txIds[i%len(txIds)],
+			VOut:       uint32(i),
+			Amount:     amount,
+			Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		})
+	}
+	return utxos
+}
+
+func TestSelectCoinsBnB(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	// 1 p2wpkh input / 1 p2tr output is 122 vB, so 32250 funds 31000 at 10 sat/vB without change
+	request := &CoinSelectionRequest{
+		Utxos:         coinSelectionUtxos(100000, 50000, 32250, 20000),
+		Outputs:       []*TxOutput{{Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", Amount: 31000}},
+		FeeRate:       10,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		Strategy:      CoinSelectionBnB,
+	}
+	res, err := SelectCoins(request, network)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(res.Inputs))
+	assert.Equal(t, int64(32250), res.Inputs[0].Amount)
+	assert.Equal(t, int64(0), res.Change)
+	assert.Equal(t, 1, len(res.Outputs))
+	assert.Equal(t, int64(1250), res.Fee)
+
+	tx, err := res.Builder.Build()
+	require.NoError(t, err)
+	vSize := GetTxVirtualSize(btcutil.NewTx(tx))
+	assert.True(t, vSize <= res.VSize)
+	assert.True(t, res.Fee >= vSize*request.FeeRate)
+}
+
+func TestSelectCoinsKnapsackAndLargestFirst(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	request := &CoinSelectionRequest{
+		Utxos:         coinSelectionUtxos(5000, 8000, 12000, 70000, 3000),
+		Outputs:       []*TxOutput{{Address: "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", Amount: 15000}},
+		FeeRate:       5,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}
+	for _, strategy := range []CoinSelectionStrategy{CoinSelectionKnapsack, CoinSelectionLargestFirst, CoinSelectionAuto} {
+		request.Strategy = strategy
+		res, err := SelectCoins(request, network)
+		require.NoError(t, err, strategy)
+
+		var in, out int64
+		for _, v := range res.Inputs {
+			in += v.Amount
+		}
+		for _, v := range res.Outputs {
+			out += v.Amount
+		}
+		assert.Equal(t, in-out, res.Fee)
+		if res.Change > 0 {
+			assert.True(t, res.Change >= DefaultMinChangeValue)
+			assert.True(t, res.Outputs[len(res.Outputs)-1].IsChange)
+		}
+
+		tx, err := res.Builder.Build()
+		require.NoError(t, err)
+		vSize := GetTxVirtualSize(btcutil.NewTx(tx))
+		assert.True(t, res.Fee >= vSize*request.FeeRate, strategy)
+	}
+
+	request.Strategy = CoinSelectionLargestFirst
+	res, err := SelectCoins(request, network)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(res.Inputs))
+	assert.Equal(t, int64(70000), res.Inputs[0].Amount)
+}
+
+func TestSelectCoinsErrors(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	request := &CoinSelectionRequest{
+		Utxos:         coinSelectionUtxos(1000, 2000),
+		Outputs:       []*TxOutput{{Address: "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", Amount: 5000}},
+		FeeRate:       2,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}
+	_, err := SelectCoins(request, network)
+	assert.Equal(t, ErrInsufficientBalance, err)
+
+	request.Outputs[0].Amount = 100
+	_, err = SelectCoins(request, network)
+	assert.Equal(t, ErrDustOutput, err)
+}
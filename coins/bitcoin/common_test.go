@@ -1,10 +1,28 @@
 package bitcoin
 
 import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
+// testKeys returns the WIFs on network and the compressed hex public keys of
+// the test keys 1 to n.
+func testKeys(t *testing.T, n int, network *chaincfg.Params) ([]string, []string) {
+	var wifs, pubKeys []string
+	for i := 1; i <= n; i++ {
+		privKey, _ := btcec.PrivKeyFromBytes([]byte{31: byte(i)})
+		wif, err := btcutil.NewWIF(privKey, network, true)
+		require.NoError(t, err)
+		wifs = append(wifs, wif.String())
+		pubKeys = append(pubKeys, hex.EncodeToString(privKey.PubKey().SerializeCompressed()))
+	}
+	return wifs, pubKeys
+}
+
 func TestIsHexString(t *testing.T) {
 	s := "70736274ff01003d00000000012eb1ba16cbc7659ece4a151926de839f94e3e988c73ae6290af9d668f3b61061000000000000000000010000000000000000016a000000000001011f000000000000000016001480cd4b68ad2abace26a975b27b4689f91cd2c5f9010304010000000000"
 	require.True(t, IsHexString(s))
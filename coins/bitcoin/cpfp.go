@@ -0,0 +1,207 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"sort"
+)
+
+type CpfpRequest struct {
+	ParentTxs         []string    `json:"parentTxs"`         // unconfirmed parents, raw hex
+	ParentPrevOutputs PrevOutputs `json:"parentPrevOutputs"` // prevouts of parent inputs not created by another parent
+	SpendOutputs      PrevOutputs `json:"spendOutputs"`      // parent outputs controlled by the caller
+	ExtraUtxos        PrevOutputs `json:"extraUtxos"`        // confirmed utxos added when SpendOutputs cannot pay the fee
+	FeeRate           int64       `json:"feeRate"`           // target package fee rate, sat/vB
+	ChangeAddress     string      `json:"changeAddress"`
+	MinChangeValue    int64       `json:"minChangeValue"`
+}
+
+type CpfpResult struct {
+	Tx             string  `json:"tx"`
+	TxId           string  `json:"txId"`
+	Fee            int64   `json:"fee"`
+	VSize          int64   `json:"vSize"`
+	ParentsFee     int64   `json:"parentsFee"`
+	ParentsVSize   int64   `json:"parentsVSize"`
+	PackageFeeRate float64 `json:"packageFeeRate"`
+}
+
+// BuildCpfpTx builds a child spending request.SpendOutputs so that the package
+// made of the parents and the child reaches request.FeeRate.
+func BuildCpfpTx(request *CpfpRequest, network *chaincfg.Params) (*CpfpResult, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if request == nil || len(request.ParentTxs) == 0 || len(request.SpendOutputs) == 0 {
+		return nil, errors.New("invalid parents or spend outputs")
+	}
+	if request.FeeRate <= 0 {
+		return nil, errors.New("invalid fee rate")
+	}
+	minChangeValue := request.MinChangeValue
+	if minChangeValue <= 0 {
+		minChangeValue = DefaultMinChangeValue
+	}
+
+	parents := make([]*wire.MsgTx, 0, len(request.ParentTxs))
+	parentOutputs := make(map[wire.OutPoint]*wire.TxOut)
+	for _, raw := range request.ParentTxs {
+		tx, err := NewTxFromHex(raw)
+		if err != nil {
+			return nil, err
+		}
+		hash := tx.TxHash()
+		for i, out := range tx.TxOut {
+			parentOutputs[wire.OutPoint{Hash: hash, Index: uint32(i)}] = out
+		}
+		parents = append(parents, tx)
+	}
+	externalPrevOuts := make(map[wire.OutPoint]*wire.TxOut, len(request.ParentPrevOutputs))
+	for _, v := range request.ParentPrevOutputs {
+		outPoint, txOut, err := prevOutputToTxOut(v, network)
+		if err != nil {
+			return nil, err
+		}
+		externalPrevOuts[*outPoint] = txOut
+	}
+
+	var parentsFee, parentsVSize int64
+	for _, tx := range parents {
+		view := make(UtxoViewpoint, len(tx.TxIn))
+		fee := int64(0)
+		for _, in := range tx.TxIn {
+			prevOut, ok := parentOutputs[in.PreviousOutPoint]
+			if !ok {
+				prevOut, ok = externalPrevOuts[in.PreviousOutPoint]
+			}
+			if !ok {
+				return nil, fmt.Errorf("missing prevout %s of parent %s", in.PreviousOutPoint.String(), tx.TxHash().String())
+			}
+			view[in.PreviousOutPoint] = prevOut.PkScript
+			fee += prevOut.Value
+		}
+		for _, out := range tx.TxOut {
+			fee -= out.Value
+		}
+		if fee < 0 {
+			return nil, fmt.Errorf("parent %s spends more than its inputs", tx.TxHash().String())
+		}
+		parentsFee += fee
+		parentsVSize += GetTxVirtualSizeByView(btcutil.NewTx(tx), view)
+	}
+
+	changePkScript, err := AddrToPkScript(request.ChangeAddress, network)
+	if err != nil {
+		return nil, err
+	}
+	child := wire.NewMsgTx(DefaultTxVersion)
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	view := make(UtxoViewpoint)
+	var privateKeys []*btcec.PrivateKey
+	totalIn := int64(0)
+	inputWeight := int64(0)
+	addInput := func(v *PrevOutput) error {
+		outPoint, txOut, err := prevOutputToTxOut(v, network)
+		if err != nil {
+			return err
+		}
+		weight, err := InputWeight(txOut.PkScript)
+		if err != nil {
+			return err
+		}
+		wif, err := btcutil.DecodeWIF(v.PrivateKey)
+		if err != nil {
+			return err
+		}
+		in := wire.NewTxIn(outPoint, nil, nil)
+		in.Sequence = DefaultSequenceNum
+		child.AddTxIn(in)
+		prevOutFetcher.AddPrevOut(*outPoint, txOut)
+		view[*outPoint] = txOut.PkScript
+		privateKeys = append(privateKeys, wif.PrivKey)
+		totalIn += v.Amount
+		inputWeight += weight
+		return nil
+	}
+	for _, v := range request.SpendOutputs {
+		outPoint, txOut, err := prevOutputToTxOut(v, network)
+		if err != nil {
+			return nil, err
+		}
+		parentOut, ok := parentOutputs[*outPoint]
+		if !ok {
+			return nil, fmt.Errorf("%s is not an output of the parent transactions", outPoint.String())
+		}
+		if parentOut.Value != txOut.Value || !bytes.Equal(parentOut.PkScript, txOut.PkScript) {
+			return nil, fmt.Errorf("%s does not match the parent output", outPoint.String())
+		}
+		if err := addInput(v); err != nil {
+			return nil, err
+		}
+	}
+
+	extras := make(PrevOutputs, len(request.ExtraUtxos))
+	copy(extras, request.ExtraUtxos)
+	sort.SliceStable(extras, func(i, j int) bool {
+		return extras[i].Amount > extras[j].Amount
+	})
+
+	outputWeight := OutputWeight(changePkScript)
+	childFee := int64(0)
+	for next := 0; ; next++ {
+		// every input is segwit or taproot except p2pkh, an upper bound is fine here
+		weight := int64(TxOverheadWeight(len(child.TxIn), 1, true)) + inputWeight + outputWeight
+		childVSize := (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+		childFee = request.FeeRate*(parentsVSize+childVSize) - parentsFee
+		if minFee := childVSize * DefaultIncrementalRelayFeeRate; childFee < minFee {
+			childFee = minFee
+		}
+		if totalIn-childFee >= minChangeValue {
+			break
+		}
+		if next >= len(extras) {
+			return nil, ErrInsufficientBalance
+		}
+		if err := addInput(extras[next]); err != nil {
+			return nil, err
+		}
+	}
+	child.AddTxOut(wire.NewTxOut(totalIn-childFee, changePkScript))
+	if err := Sign(child, privateKeys, prevOutFetcher); err != nil {
+		return nil, err
+	}
+
+	txHex, err := GetTxHex(child)
+	if err != nil {
+		return nil, err
+	}
+	childVSize := GetTxVirtualSizeByView(btcutil.NewTx(child), view)
+	return &CpfpResult{
+		Tx:             txHex,
+		TxId:           child.TxHash().String(),
+		Fee:            childFee,
+		VSize:          childVSize,
+		ParentsFee:     parentsFee,
+		ParentsVSize:   parentsVSize,
+		PackageFeeRate: float64(parentsFee+childFee) / float64(parentsVSize+childVSize),
+	}, nil
+}
+
+func prevOutputToTxOut(v *PrevOutput, network *chaincfg.Params) (*wire.OutPoint, *wire.TxOut, error) {
+	h, err := chainhash.NewHashFromStr(v.TxId)
+	if err != nil {
+		return nil, nil, err
+	}
+	pkScript, err := AddrToPkScript(v.Address, network)
+	if err != nil {
+		return nil, nil, err
+	}
+	return wire.NewOutPoint(h, v.VOut), wire.NewTxOut(v.Amount, pkScript), nil
+}
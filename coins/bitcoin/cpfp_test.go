@@ -0,0 +1,72 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestBuildCpfpTxForInscription(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	commitTxPrevOutputList := PrevOutputs{{
+		TxId:       "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26",
+		VOut:       4,
+		Amount:     100000,
+		Address:    "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+	}}
+	request := &InscriptionRequest{
+		CommitTxPrevOutputList: commitTxPrevOutputList,
+		CommitFeeRate:          1,
+		RevealFeeRate:          1,
+		InscriptionDataList: []InscriptionData{{
+			ContentType: "text/plain;charset=utf-8",
+			Body:        []byte(`{"p":"brc-20","op":"mint","tick":"xcvb","amt":"100"}`),
+			RevealAddr:  "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		}},
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}
+	txs, err := Inscribe(network, request)
+	require.NoError(t, err)
+	commitTx, err := NewTxFromHex(txs.CommitTx)
+	require.NoError(t, err)
+	change := commitTx.TxOut[len(commitTx.TxOut)-1]
+
+	res, err := BuildCpfpTx(&CpfpRequest{
+		ParentTxs:         append([]string{txs.CommitTx}, txs.RevealTxs...),
+		ParentPrevOutputs: commitTxPrevOutputList,
+		SpendOutputs: PrevOutputs{{
+			TxId:       commitTx.TxHash().String(),
+			VOut:       uint32(len(commitTx.TxOut) - 1),
+			Amount:     change.Value,
+			Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+		FeeRate:       20,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}, network)
+	require.NoError(t, err)
+	assert.Equal(t, txs.CommitTxFee+txs.RevealTxFees[0], res.ParentsFee)
+	assert.True(t, res.PackageFeeRate >= 20)
+	assert.True(t, res.Fee > 20*res.VSize)
+
+	child, err := NewTxFromHex(res.Tx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(child.TxOut))
+	assert.Equal(t, change.Value-res.Fee, child.TxOut[0].Value)
+	verifyTxScripts(t, res.Tx, PrevOutputs{{
+		TxId:    commitTx.TxHash().String(),
+		VOut:    uint32(len(commitTx.TxOut) - 1),
+		Amount:  change.Value,
+		Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}}, network)
+
+	_, err = BuildCpfpTx(&CpfpRequest{
+		ParentTxs:     []string{txs.CommitTx},
+		SpendOutputs:  commitTxPrevOutputList,
+		FeeRate:       20,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}, network)
+	assert.Error(t, err)
+}
@@ -0,0 +1,750 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/okx/go-wallet-sdk/crypto/go-bip32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidDescriptor  = errors.New("invalid descriptor")
+	ErrDescriptorChecksum = errors.New("invalid descriptor checksum")
+)
+
+const (
+	descriptorInputCharset    = "0123456789()[],'/*abcdefgh@:$%{}IJKLMNOPQRSTUVWXYZ&+-.;<=>?!^_|~ijklmnopqrstuvwxyzABCDEFGH`#\"\\ "
+	descriptorChecksumCharset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	maxTaprootTreeDepth = 128
+	maxMultiSigKeys     = 16
+	maxWitnessMultiKeys = 20
+	maxMultiAKeys       = 999
+)
+
+var descriptorGenerator = [5]uint64{0xf5dee51989, 0xa9fdca3312, 0x1bab10e32d, 0x3706b1677a, 0x644d626ffd}
+
+// script context a descriptor expression is parsed in
+type descriptorContext int
+
+const (
+	descriptorTop descriptorContext = iota
+	descriptorP2SH
+	descriptorP2WSH
+	descriptorTapscript
+)
+
+type descriptorWildcard int
+
+const (
+	wildcardNone descriptorWildcard = iota
+	wildcardUnhardened
+	wildcardHardened
+)
+
+// Descriptor is a parsed output script descriptor, see BIP-380 to BIP-386.
+type Descriptor struct {
+	desc string
+	root *descriptorNode
+}
+
+// DescriptorKeyInfo is the origin of a key used by a derived output, in the
+// same form as TxInput.MasterFingerprint and TxInput.DerivationPath.
+type DescriptorKeyInfo struct {
+	PublicKey         string
+	MasterFingerprint uint32
+	DerivationPath    string
+}
+
+type DescriptorOutput struct {
+	ScriptPubKey       []byte
+	Address            string
+	RedeemScript       []byte
+	WitnessScript      []byte
+	TaprootInternalKey []byte
+	TaprootMerkleRoot  []byte
+	Keys               []*DescriptorKeyInfo
+}
+
+type descriptorKey struct {
+	fingerprint []byte
+	originPath  []uint32
+	pubKey      []byte // compressed or uncompressed, x-only keys are stored with an even prefix
+	xOnly       bool
+	extKey      string
+	extPubKey   []byte
+	path        []uint32
+	wildcard    descriptorWildcard
+}
+
+type descriptorNode struct {
+	name      string
+	keys      []*descriptorKey
+	threshold int
+	sub       *descriptorNode
+	tree      *descriptorTree
+	addr      string
+	raw       []byte
+}
+
+type descriptorTree struct {
+	leaf        *descriptorNode
+	left, right *descriptorTree
+}
+
+func descriptorPolyMod(c uint64, val int) uint64 {
+	c0 := c >> 35
+	c = (c&0x7ffffffff)<<5 ^ uint64(val)
+	for i := 0; i < 5; i++ {
+		if (c0>>uint(i))&1 != 0 {
+			c ^= descriptorGenerator[i]
+		}
+	}
+	return c
+}
+
+// DescriptorChecksum returns the BIP-380 checksum of desc, which must not carry a checksum already.
+func DescriptorChecksum(desc string) (string, error) {
+	c := uint64(1)
+	cls, clsCount := 0, 0
+	for _, ch := range desc {
+		pos := strings.IndexRune(descriptorInputCharset, ch)
+		if pos < 0 {
+			return "", fmt.Errorf("invalid character %q in descriptor", ch)
+		}
+		c = descriptorPolyMod(c, pos&31)
+		cls = cls*3 + pos>>5
+		clsCount++
+		if clsCount == 3 {
+			c = descriptorPolyMod(c, cls)
+			cls, clsCount = 0, 0
+		}
+	}
+	if clsCount > 0 {
+		c = descriptorPolyMod(c, cls)
+	}
+	for i := 0; i < 8; i++ {
+		c = descriptorPolyMod(c, 0)
+	}
+	c ^= 1
+	checksum := make([]byte, 8)
+	for i := 0; i < 8; i++ {
+		checksum[i] = descriptorChecksumCharset[(c>>(5*uint(7-i)))&31]
+	}
+	return string(checksum), nil
+}
+
+// AddDescriptorChecksum appends the checksum to a descriptor that has none.
+func AddDescriptorChecksum(desc string) (string, error) {
+	checksum, err := DescriptorChecksum(desc)
+	if err != nil {
+		return "", err
+	}
+	return desc + "#" + checksum, nil
+}
+
+// ParseDescriptor parses desc, the checksum is optional but verified when present.
+func ParseDescriptor(desc string) (*Descriptor, error) {
+	body := strings.TrimSpace(desc)
+	if i := strings.IndexByte(body, '#'); i >= 0 {
+		checksum, err := DescriptorChecksum(body[:i])
+		if err != nil {
+			return nil, err
+		}
+		if body[i+1:] != checksum {
+			return nil, ErrDescriptorChecksum
+		}
+		body = body[:i]
+	} else if _, err := DescriptorChecksum(body); err != nil {
+		return nil, err
+	}
+	root, err := parseDescriptorExpr(body, descriptorTop)
+	if err != nil {
+		return nil, err
+	}
+	return &Descriptor{desc: body, root: root}, nil
+}
+
+// String returns the descriptor with its checksum.
+func (d *Descriptor) String() string {
+	desc, _ := AddDescriptorChecksum(d.desc)
+	return desc
+}
+
+// IsRange reports whether the descriptor contains a ranged `/*` key.
+func (d *Descriptor) IsRange() bool {
+	ranged := false
+	d.root.walkKeys(func(key *descriptorKey) {
+		if key.wildcard != wildcardNone {
+			ranged = true
+		}
+	})
+	return ranged
+}
+
+// Derive expands the descriptor at index, index is ignored by descriptors that are not ranged.
+func (d *Descriptor) Derive(index uint32, network *chaincfg.Params) (*DescriptorOutput, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if index >= bip32.FirstHardenedChild {
+		return nil, errors.New("derivation index out of range")
+	}
+	out := &DescriptorOutput{}
+	script, err := d.root.expand(index, network, out)
+	if err != nil {
+		return nil, err
+	}
+	out.ScriptPubKey = script
+
+	switch d.root.name {
+	case "pkh", "wpkh", "sh", "tr":
+		key := d.root.keys
+		addrType := map[string]string{"pkh": LEGACY, "wpkh": SEGWIT_NATIVE, "sh": SEGWIT_NESTED, "tr": TAPROOT}[d.root.name]
+		if d.root.name == "sh" && d.root.sub.name == "wpkh" {
+			key = d.root.sub.keys
+		}
+		if len(key) == 1 && d.root.tree == nil {
+			pubKey, err := key[0].pubKeyAt(index)
+			if err != nil {
+				return nil, err
+			}
+			if out.Address, err = PubKeyToAddr(pubKey, addrType, network); err != nil {
+				return nil, err
+			}
+			return out, nil
+		}
+	}
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(script, network)
+	if err == nil && len(addrs) == 1 {
+		switch class {
+		case txscript.PubKeyHashTy, txscript.ScriptHashTy, txscript.WitnessV0PubKeyHashTy,
+			txscript.WitnessV0ScriptHashTy, txscript.WitnessV1TaprootTy:
+			out.Address = addrs[0].EncodeAddress()
+		}
+	}
+	return out, nil
+}
+
+// DeriveAddresses returns the addresses for indexes [start, start+count).
+func (d *Descriptor) DeriveAddresses(start, count uint32, network *chaincfg.Params) ([]string, error) {
+	addresses := make([]string, 0, count)
+	for i := uint32(0); i < count; i++ {
+		out, err := d.Derive(start+i, network)
+		if err != nil {
+			return nil, err
+		}
+		if out.Address == "" {
+			return nil, errors.New("descriptor has no address form")
+		}
+		addresses = append(addresses, out.Address)
+	}
+	return addresses, nil
+}
+
+func splitDescriptorCall(s string) (string, string, error) {
+	open := strings.IndexByte(s, '(')
+	if open <= 0 || !strings.HasSuffix(s, ")") {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidDescriptor, s)
+	}
+	return s[:open], s[open+1 : len(s)-1], nil
+}
+
+// splitDescriptorArgs splits s on the commas that are not nested in brackets.
+func splitDescriptorArgs(s string) ([]string, error) {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[', '{':
+			depth++
+		case ')', ']', '}':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("%w: unbalanced brackets", ErrInvalidDescriptor)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("%w: unbalanced brackets", ErrInvalidDescriptor)
+	}
+	return append(args, s[start:]), nil
+}
+
+func parseDescriptorExpr(s string, ctx descriptorContext) (*descriptorNode, error) {
+	name, inner, err := splitDescriptorCall(s)
+	if err != nil {
+		return nil, err
+	}
+	args, err := splitDescriptorArgs(inner)
+	if err != nil {
+		return nil, err
+	}
+	node := &descriptorNode{name: name}
+	allowed := false
+	switch name {
+	case "pk":
+		allowed = true
+	case "pkh", "multi", "sortedmulti":
+		allowed = ctx != descriptorTapscript
+	case "multi_a", "sortedmulti_a":
+		allowed = ctx == descriptorTapscript
+	case "wpkh", "wsh":
+		allowed = ctx == descriptorTop || ctx == descriptorP2SH
+	case "sh", "tr", "addr", "raw":
+		allowed = ctx == descriptorTop
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s() is not allowed here", ErrInvalidDescriptor, name)
+	}
+
+	switch name {
+	case "pk", "pkh", "wpkh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes one key", ErrInvalidDescriptor, name)
+		}
+		keyCtx := ctx
+		if name == "wpkh" {
+			keyCtx = descriptorP2WSH
+		}
+		key, err := parseDescriptorKey(args[0], keyCtx)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = []*descriptorKey{key}
+	case "sh", "wsh":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes one script", ErrInvalidDescriptor, name)
+		}
+		subCtx := descriptorP2SH
+		if name == "wsh" {
+			subCtx = descriptorP2WSH
+		}
+		if node.sub, err = parseDescriptorExpr(args[0], subCtx); err != nil {
+			return nil, err
+		}
+	case "multi", "sortedmulti", "multi_a", "sortedmulti_a":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: %s() needs a threshold and keys", ErrInvalidDescriptor, name)
+		}
+		threshold, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid threshold %s", ErrInvalidDescriptor, args[0])
+		}
+		maxKeys := maxMultiSigKeys
+		if ctx == descriptorP2WSH {
+			maxKeys = maxWitnessMultiKeys
+		} else if ctx == descriptorTapscript {
+			maxKeys = maxMultiAKeys
+		}
+		if threshold < 1 || threshold > len(args)-1 || len(args)-1 > maxKeys {
+			return nil, fmt.Errorf("%w: invalid multisig threshold %d of %d", ErrInvalidDescriptor, threshold, len(args)-1)
+		}
+		node.threshold = threshold
+		for _, arg := range args[1:] {
+			key, err := parseDescriptorKey(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			node.keys = append(node.keys, key)
+		}
+	case "tr":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("%w: tr() takes a key and an optional script tree", ErrInvalidDescriptor)
+		}
+		key, err := parseDescriptorKey(args[0], descriptorTapscript)
+		if err != nil {
+			return nil, err
+		}
+		node.keys = []*descriptorKey{key}
+		if len(args) == 2 {
+			if node.tree, err = parseDescriptorTree(args[1], 0); err != nil {
+				return nil, err
+			}
+		}
+	case "addr":
+		if len(args) != 1 || args[0] == "" {
+			return nil, fmt.Errorf("%w: addr() takes one address", ErrInvalidDescriptor)
+		}
+		node.addr = args[0]
+	case "raw":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: raw() takes one script", ErrInvalidDescriptor)
+		}
+		if node.raw, err = hex.DecodeString(args[0]); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func parseDescriptorTree(s string, depth int) (*descriptorTree, error) {
+	if depth > maxTaprootTreeDepth {
+		return nil, fmt.Errorf("%w: script tree too deep", ErrInvalidDescriptor)
+	}
+	if !strings.HasPrefix(s, "{") {
+		leaf, err := parseDescriptorExpr(s, descriptorTapscript)
+		if err != nil {
+			return nil, err
+		}
+		return &descriptorTree{leaf: leaf}, nil
+	}
+	if !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidDescriptor, s)
+	}
+	branches, err := splitDescriptorArgs(s[1 : len(s)-1])
+	if err != nil {
+		return nil, err
+	}
+	if len(branches) != 2 {
+		return nil, fmt.Errorf("%w: a tree branch needs exactly two children", ErrInvalidDescriptor)
+	}
+	left, err := parseDescriptorTree(branches[0], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	right, err := parseDescriptorTree(branches[1], depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return &descriptorTree{left: left, right: right}, nil
+}
+
+// parseDescriptorPath parses path elements, both ' and h mark a hardened step.
+func parseDescriptorPath(elements []string) ([]uint32, error) {
+	path := make([]uint32, 0, len(elements))
+	for _, e := range elements {
+		hardened := strings.HasSuffix(e, "'") || strings.HasSuffix(e, "h") || strings.HasSuffix(e, "H")
+		if hardened {
+			e = e[:len(e)-1]
+		}
+		v, err := strconv.ParseUint(e, 10, 32)
+		if err != nil || v >= uint64(bip32.FirstHardenedChild) {
+			return nil, fmt.Errorf("%w: invalid path element %s", ErrInvalidDescriptor, e)
+		}
+		if hardened {
+			v += uint64(bip32.FirstHardenedChild)
+		}
+		path = append(path, uint32(v))
+	}
+	return path, nil
+}
+
+func formatDerivationPath(path []uint32) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, v := range path {
+		if v >= bip32.FirstHardenedChild {
+			sb.WriteString(fmt.Sprintf("/%d'", v-bip32.FirstHardenedChild))
+		} else {
+			sb.WriteString(fmt.Sprintf("/%d", v))
+		}
+	}
+	return sb.String()
+}
+
+func parseDescriptorKey(s string, ctx descriptorContext) (*descriptorKey, error) {
+	key := &descriptorKey{}
+	if strings.HasPrefix(s, "[") {
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return nil, fmt.Errorf("%w: unterminated key origin", ErrInvalidDescriptor)
+		}
+		origin := strings.Split(s[1:end], "/")
+		fingerprint, err := hex.DecodeString(origin[0])
+		if err != nil || len(fingerprint) != 4 {
+			return nil, fmt.Errorf("%w: invalid fingerprint %s", ErrInvalidDescriptor, origin[0])
+		}
+		key.fingerprint = fingerprint
+		if key.originPath, err = parseDescriptorPath(origin[1:]); err != nil {
+			return nil, err
+		}
+		s = s[end+1:]
+	}
+
+	elements := strings.Split(s, "/")
+	if len(elements) == 1 {
+		pubKey, err := parseDescriptorPubKey(s, ctx)
+		if err != nil {
+			return nil, err
+		}
+		key.pubKey = pubKey
+		key.xOnly = ctx == descriptorTapscript
+		return key, nil
+	}
+
+	extKey, err := bip32.B58Deserialize(elements[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid extended key: %v", ErrInvalidDescriptor, err)
+	}
+	extPubKey, err := bip32.DerivePubKeyFromExtendedKey(elements[0], "")
+	if err != nil {
+		return nil, err
+	}
+	key.extKey = elements[0]
+	key.extPubKey = extPubKey
+	key.xOnly = ctx == descriptorTapscript
+
+	elements = elements[1:]
+	switch elements[len(elements)-1] {
+	case "*":
+		key.wildcard = wildcardUnhardened
+	case "*'", "*h", "*H":
+		key.wildcard = wildcardHardened
+	}
+	if key.wildcard != wildcardNone {
+		elements = elements[:len(elements)-1]
+	}
+	if key.path, err = parseDescriptorPath(elements); err != nil {
+		return nil, err
+	}
+	if !extKey.IsPrivate {
+		hardened := key.wildcard == wildcardHardened
+		for _, v := range key.path {
+			hardened = hardened || v >= bip32.FirstHardenedChild
+		}
+		if hardened {
+			return nil, fmt.Errorf("%w: hardened derivation requires a private key", ErrInvalidDescriptor)
+		}
+	}
+	return key, nil
+}
+
+// parseDescriptorPubKey parses a hex public key or a WIF private key.
+func parseDescriptorPubKey(s string, ctx descriptorContext) ([]byte, error) {
+	if b, err := hex.DecodeString(s); err == nil {
+		if ctx == descriptorTapscript {
+			if len(b) == 33 {
+				b = b[1:]
+			}
+			pubKey, err := schnorr.ParsePubKey(b)
+			if err != nil {
+				return nil, err
+			}
+			return pubKey.SerializeCompressed(), nil
+		}
+		pubKey, err := btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) != btcec.PubKeyBytesLenCompressed && ctx != descriptorTop && ctx != descriptorP2SH {
+			return nil, fmt.Errorf("%w: uncompressed keys are not allowed in segwit scripts", ErrInvalidDescriptor)
+		}
+		if len(b) == btcec.PubKeyBytesLenCompressed {
+			return pubKey.SerializeCompressed(), nil
+		}
+		return pubKey.SerializeUncompressed(), nil
+	}
+	wif, err := btcutil.DecodeWIF(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid key %s", ErrInvalidDescriptor, s)
+	}
+	if !wif.CompressPubKey && ctx != descriptorTop && ctx != descriptorP2SH {
+		return nil, fmt.Errorf("%w: uncompressed keys are not allowed in segwit scripts", ErrInvalidDescriptor)
+	}
+	return wif.SerializePubKey(), nil
+}
+
+func (k *descriptorKey) derivationPath(index uint32) []uint32 {
+	path := append([]uint32{}, k.path...)
+	switch k.wildcard {
+	case wildcardUnhardened:
+		path = append(path, index)
+	case wildcardHardened:
+		path = append(path, index+bip32.FirstHardenedChild)
+	}
+	return path
+}
+
+// pubKeyAt returns the compressed, or for plain keys possibly uncompressed, public key at index.
+func (k *descriptorKey) pubKeyAt(index uint32) ([]byte, error) {
+	if k.extKey == "" {
+		return k.pubKey, nil
+	}
+	return bip32.DerivePubKeyFromExtendedKey(k.extKey, formatDerivationPath(k.derivationPath(index)))
+}
+
+// scriptKeyAt returns the key as it is pushed in a script, x-only in tapscript.
+func (k *descriptorKey) scriptKeyAt(index uint32) ([]byte, error) {
+	pubKey, err := k.pubKeyAt(index)
+	if err != nil {
+		return nil, err
+	}
+	if k.xOnly {
+		return pubKey[1:], nil
+	}
+	return pubKey, nil
+}
+
+func (k *descriptorKey) info(index uint32) (*DescriptorKeyInfo, error) {
+	pubKey, err := k.pubKeyAt(index)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := k.fingerprint
+	path := append([]uint32{}, k.originPath...)
+	if fingerprint == nil {
+		if k.extKey != "" {
+			fingerprint = btcutil.Hash160(k.extPubKey)[:4]
+		} else {
+			fingerprint = btcutil.Hash160(pubKey)[:4]
+		}
+	}
+	if k.extKey != "" {
+		path = append(path, k.derivationPath(index)...)
+	}
+	return &DescriptorKeyInfo{
+		PublicKey:         hex.EncodeToString(pubKey),
+		MasterFingerprint: binary.LittleEndian.Uint32(fingerprint),
+		DerivationPath:    formatDerivationPath(path),
+	}, nil
+}
+
+func (n *descriptorNode) walkKeys(fn func(key *descriptorKey)) {
+	for _, key := range n.keys {
+		fn(key)
+	}
+	if n.sub != nil {
+		n.sub.walkKeys(fn)
+	}
+	if n.tree != nil {
+		n.tree.walkKeys(fn)
+	}
+}
+
+func (t *descriptorTree) walkKeys(fn func(key *descriptorKey)) {
+	if t.leaf != nil {
+		t.leaf.walkKeys(fn)
+		return
+	}
+	t.left.walkKeys(fn)
+	t.right.walkKeys(fn)
+}
+
+func (t *descriptorTree) tapNode(index uint32, network *chaincfg.Params, out *DescriptorOutput) (txscript.TapNode, error) {
+	if t.leaf != nil {
+		script, err := t.leaf.expand(index, network, out)
+		if err != nil {
+			return nil, err
+		}
+		return txscript.NewBaseTapLeaf(script), nil
+	}
+	left, err := t.left.tapNode(index, network, out)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.right.tapNode(index, network, out)
+	if err != nil {
+		return nil, err
+	}
+	return txscript.NewTapBranch(left, right), nil
+}
+
+// expand returns the script of the node at index and records keys, redeem and witness scripts in out.
+func (n *descriptorNode) expand(index uint32, network *chaincfg.Params, out *DescriptorOutput) ([]byte, error) {
+	keys := make([][]byte, 0, len(n.keys))
+	for _, key := range n.keys {
+		scriptKey, err := key.scriptKeyAt(index)
+		if err != nil {
+			return nil, err
+		}
+		info, err := key.info(index)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, scriptKey)
+		out.Keys = append(out.Keys, info)
+	}
+
+	switch n.name {
+	case "pk":
+		return txscript.NewScriptBuilder().AddData(keys[0]).AddOp(txscript.OP_CHECKSIG).Script()
+	case "pkh":
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+			AddData(btcutil.Hash160(keys[0])).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	case "wpkh":
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(btcutil.Hash160(keys[0])).Script()
+	case "multi", "sortedmulti":
+		if n.name == "sortedmulti" {
+			sort.Slice(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			})
+		}
+		builder := txscript.NewScriptBuilder().AddInt64(int64(n.threshold))
+		for _, key := range keys {
+			builder.AddData(key)
+		}
+		return builder.AddInt64(int64(len(keys))).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	case "multi_a", "sortedmulti_a":
+		if n.name == "sortedmulti_a" {
+			sort.Slice(keys, func(i, j int) bool {
+				return bytes.Compare(keys[i], keys[j]) < 0
+			})
+		}
+		return multiAScript(n.threshold, keys)
+	case "sh":
+		redeemScript, err := n.sub.expand(index, network, out)
+		if err != nil {
+			return nil, err
+		}
+		if len(redeemScript) > txscript.MaxScriptElementSize {
+			return nil, fmt.Errorf("redeem script is %d bytes, larger than %d", len(redeemScript), txscript.MaxScriptElementSize)
+		}
+		out.RedeemScript = redeemScript
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(redeemScript)).
+			AddOp(txscript.OP_EQUAL).Script()
+	case "wsh":
+		witnessScript, err := n.sub.expand(index, network, out)
+		if err != nil {
+			return nil, err
+		}
+		out.WitnessScript = witnessScript
+		scriptHash := sha256.Sum256(witnessScript)
+		return txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(scriptHash[:]).Script()
+	case "tr":
+		internalKey, err := schnorr.ParsePubKey(keys[0])
+		if err != nil {
+			return nil, err
+		}
+		var merkleRoot []byte
+		if n.tree != nil {
+			node, err := n.tree.tapNode(index, network, out)
+			if err != nil {
+				return nil, err
+			}
+			root := node.TapHash()
+			merkleRoot = root[:]
+		}
+		out.TaprootInternalKey = keys[0]
+		out.TaprootMerkleRoot = merkleRoot
+		return txscript.PayToTaprootScript(txscript.ComputeTaprootOutputKey(internalKey, merkleRoot))
+	case "addr":
+		addr, err := btcutil.DecodeAddress(n.addr, network)
+		if err != nil {
+			return nil, err
+		}
+		if !addr.IsForNet(network) {
+			return nil, fmt.Errorf("address %s is not for %s", n.addr, network.Name)
+		}
+		return txscript.PayToAddrScript(addr)
+	case "raw":
+		return n.raw, nil
+	}
+	return nil, fmt.Errorf("%w: unknown script %s", ErrInvalidDescriptor, n.name)
+}
@@ -0,0 +1,171 @@
+package bitcoin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDescriptorChecksum(t *testing.T) {
+	checksum, err := DescriptorChecksum("raw(deadbeef)")
+	require.NoError(t, err)
+	assert.Equal(t, "89f8spxm", checksum)
+
+	_, err = ParseDescriptor("raw(deadbeef)#89f8spxm")
+	assert.NoError(t, err)
+	_, err = ParseDescriptor("raw(deadbeef)#89f8spxn")
+	assert.Equal(t, ErrDescriptorChecksum, err)
+	_, err = ParseDescriptor("raw(deadbeef)#")
+	assert.Equal(t, ErrDescriptorChecksum, err)
+}
+
+func TestDescriptorSingleKey(t *testing.T) {
+	tests := []struct {
+		desc         string
+		scriptPubKey string
+	}{
+		{"pkh(02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5)", "76a914" + "06afd46bcdfd22ef94ac122aa11f241244a37ecc" + "88ac"},
+		{"wpkh(03a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd)", "0014" + "9a1c78a507689f6f54b847ad1cef1e614ee23f1e"},
+		{"sh(wpkh(03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556))", "a914" + "cc6ffbc0bf31af759451068f90ba7a0272b6b332" + "87"},
+	}
+	for _, tt := range tests {
+		d, err := ParseDescriptor(tt.desc)
+		require.NoError(t, err, tt.desc)
+		assert.False(t, d.IsRange())
+		out, err := d.Derive(0, nil)
+		require.NoError(t, err)
+		assert.Equal(t, tt.scriptPubKey, hex.EncodeToString(out.ScriptPubKey), tt.desc)
+
+		pkScript, err := AddrToPkScript(out.Address, &chaincfg.MainNetParams)
+		require.NoError(t, err)
+		assert.Equal(t, out.ScriptPubKey, pkScript)
+	}
+}
+
+func TestDescriptorXpub(t *testing.T) {
+	d, err := ParseDescriptor("wpkh([73c5da0a/84h/0h/0h]xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V/0/*)")
+	require.NoError(t, err)
+	assert.True(t, d.IsRange())
+	addresses, err := d.DeriveAddresses(0, 2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g"}, addresses)
+
+	out, err := d.Derive(0, nil)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(out.Keys))
+	assert.Equal(t, "m/84'/0'/0'/0/0", out.Keys[0].DerivationPath)
+	assert.Equal(t, uint32(0x0adac573), out.Keys[0].MasterFingerprint)
+
+	d, err = ParseDescriptor("tr([73c5da0a/86'/0'/0']xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ/0/*)")
+	require.NoError(t, err)
+	addresses, err = d.DeriveAddresses(0, 2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr", "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh"}, addresses)
+
+	_, err = ParseDescriptor("wpkh(xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V/0/*')")
+	assert.Error(t, err)
+}
+
+func TestDescriptorSortedMulti(t *testing.T) {
+	keys := []string{
+		"03fff97bd5755eeea420453a14355235d382f6472f8568a18b2f057a1460297556",
+		"02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+		"02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5",
+	}
+	sorted := []string{keys[2], keys[1], keys[0]}
+	redeemScript, err := GetRedeemScript(sorted, 2)
+	require.NoError(t, err)
+
+	d, err := ParseDescriptor("wsh(sortedmulti(2," + keys[0] + "," + keys[1] + "," + keys[2] + "))")
+	require.NoError(t, err)
+	out, err := d.Derive(0, &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	assert.Equal(t, redeemScript, out.WitnessScript)
+	assert.Equal(t, 3, len(out.Keys))
+	addr, err := btcutil.NewAddressWitnessScriptHash(witnessScriptHash(redeemScript), &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	assert.Equal(t, addr.EncodeAddress(), out.Address)
+
+	d, err = ParseDescriptor("sh(multi(2," + sorted[0] + "," + sorted[1] + "," + sorted[2] + "))")
+	require.NoError(t, err)
+	out, err = d.Derive(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, redeemScript, out.RedeemScript)
+	multiAddr, err := GenerateMultiAddress(redeemScript, nil)
+	require.NoError(t, err)
+	assert.Equal(t, multiAddr, out.Address)
+
+	_, err = ParseDescriptor("wsh(multi(4," + keys[0] + "," + keys[1] + "," + keys[2] + "))")
+	assert.Error(t, err)
+	_, err = ParseDescriptor("wsh(wpkh(" + keys[0] + "))")
+	assert.Error(t, err)
+}
+
+func TestDescriptorTaprootTree(t *testing.T) {
+	internal := "a34b99f22c790c4e36b2b3c2c35a36db06226e41c692fc82b8b56ac1c540c5bd"
+	leafA := "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"
+	leafB := "f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"
+	d, err := ParseDescriptor("tr(" + internal + ",{pk(" + leafA + "),pk(" + leafB + ")})")
+	require.NoError(t, err)
+	out, err := d.Derive(0, nil)
+	require.NoError(t, err)
+
+	leafScript := func(key string) []byte {
+		b, _ := hex.DecodeString(key)
+		if len(b) == 33 {
+			b = b[1:]
+		}
+		script, err := txscript.NewScriptBuilder().AddData(b).AddOp(txscript.OP_CHECKSIG).Script()
+		require.NoError(t, err)
+		return script
+	}
+	tree := txscript.AssembleTaprootScriptTree(txscript.NewBaseTapLeaf(leafScript(leafA)), txscript.NewBaseTapLeaf(leafScript(leafB)))
+	root := tree.RootNode.TapHash()
+	assert.Equal(t, root[:], out.TaprootMerkleRoot)
+
+	internalBytes, _ := hex.DecodeString(internal)
+	internalKey, err := schnorr.ParsePubKey(internalBytes)
+	require.NoError(t, err)
+	addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(txscript.ComputeTaprootOutputKey(internalKey, root[:])), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	assert.Equal(t, addr.EncodeAddress(), out.Address)
+	assert.Equal(t, 3, len(out.Keys))
+
+	_, err = ParseDescriptor("tr(" + internal + ",{pk(" + leafA + ")})")
+	assert.Error(t, err)
+	_, err = ParseDescriptor("tr(" + internal + ",pkh(" + leafA + "))")
+	assert.Error(t, err)
+}
+
+func TestDescriptorRoundTrip(t *testing.T) {
+	desc := "wpkh([73c5da0a/84'/0'/0']xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V/1/*)"
+	withChecksum, err := AddDescriptorChecksum(desc)
+	require.NoError(t, err)
+	d, err := ParseDescriptor(withChecksum)
+	require.NoError(t, err)
+	assert.Equal(t, withChecksum, d.String())
+
+	privateKey, err := btcec.NewPrivateKey()
+	require.NoError(t, err)
+	wif, err := btcutil.NewWIF(privateKey, &chaincfg.MainNetParams, true)
+	require.NoError(t, err)
+	d, err = ParseDescriptor("wpkh(" + wif.String() + ")")
+	require.NoError(t, err)
+	out, err := d.Derive(0, nil)
+	require.NoError(t, err)
+	addr, err := PubKeyToAddr(privateKey.PubKey().SerializeCompressed(), SEGWIT_NATIVE, nil)
+	require.NoError(t, err)
+	assert.Equal(t, addr, out.Address)
+}
+
+func witnessScriptHash(b []byte) []byte {
+	h := sha256.Sum256(b)
+	return h[:]
+}
@@ -0,0 +1,258 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"math"
+)
+
+const (
+	// SigHashForkID marks the replay protected digest of BCH and BSV.
+	SigHashForkID txscript.SigHashType = 0x40
+	// SigHashAllForkID is the SIGHASH_ALL of BCH and BSV, whose fork id is 0.
+	SigHashAllForkID = txscript.SigHashAll | SigHashForkID
+)
+
+// CashTokens prefix and bitfield, see CHIP-2022-02-CashTokens.
+const (
+	CashTokenPrefix = 0xef
+
+	cashTokenHasCommitment = 0x40
+	cashTokenHasNFT        = 0x20
+	cashTokenHasAmount     = 0x10
+	cashTokenReserved      = 0x80
+
+	CashTokenNone    byte = 0
+	CashTokenMutable byte = 1
+	CashTokenMinting byte = 2
+
+	CashTokenMaxCommitment = 40
+)
+
+var (
+	ErrInvalidCashToken = errors.New("invalid cashtoken")
+	ErrCashTokenBalance = errors.New("cashtoken amounts of inputs and outputs differ")
+	ErrNotTokenAware    = errors.New("address is not token-aware")
+)
+
+// CashToken is the token data an output carries in front of its locking
+// bytecode.
+type CashToken struct {
+	// Category is the txid of the genesis outpoint, as displayed.
+	Category string `json:"category"`
+	// Amount is the fungible amount, 0 for none.
+	Amount uint64 `json:"amount"`
+	// NFT is nil when the output carries no non-fungible token.
+	NFT *CashTokenNFT `json:"nft,omitempty"`
+}
+
+type CashTokenNFT struct {
+	Capability byte   `json:"capability"`
+	Commitment []byte `json:"commitment,omitempty"`
+}
+
+// Prefix serializes the token prefix.
+func (t *CashToken) Prefix() ([]byte, error) {
+	category, err := chainhash.NewHashFromStr(t.Category)
+	if err != nil {
+		return nil, err
+	}
+	if t.Amount > math.MaxInt64 || (t.Amount == 0 && t.NFT == nil) {
+		return nil, ErrInvalidCashToken
+	}
+	var bitfield byte
+	if t.NFT != nil {
+		if t.NFT.Capability > CashTokenMinting || len(t.NFT.Commitment) > CashTokenMaxCommitment {
+			return nil, ErrInvalidCashToken
+		}
+		bitfield = cashTokenHasNFT | t.NFT.Capability
+		if len(t.NFT.Commitment) > 0 {
+			bitfield |= cashTokenHasCommitment
+		}
+	}
+	if t.Amount > 0 {
+		bitfield |= cashTokenHasAmount
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(CashTokenPrefix)
+	buf.Write(category[:])
+	buf.WriteByte(bitfield)
+	if bitfield&cashTokenHasCommitment != 0 {
+		if err := wire.WriteVarBytes(&buf, 0, t.NFT.Commitment); err != nil {
+			return nil, err
+		}
+	}
+	if t.Amount > 0 {
+		if err := wire.WriteVarInt(&buf, 0, t.Amount); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeCashTokenPkScript splits an output script into its token data and
+// locking bytecode, token being nil when there is no prefix.
+func DecodeCashTokenPkScript(pkScript []byte) (token *CashToken, lockingScript []byte, err error) {
+	if len(pkScript) == 0 || pkScript[0] != CashTokenPrefix {
+		return nil, pkScript, nil
+	}
+	r := bytes.NewReader(pkScript[1:])
+	var category chainhash.Hash
+	if _, err := r.Read(category[:]); err != nil || r.Len() < 1 {
+		return nil, nil, ErrInvalidCashToken
+	}
+	bitfield, _ := r.ReadByte()
+	capability := bitfield & 0x0f
+	hasNFT := bitfield&cashTokenHasNFT != 0
+	if bitfield&cashTokenReserved != 0 || capability > CashTokenMinting ||
+		(!hasNFT && (capability != 0 || bitfield&cashTokenHasCommitment != 0)) ||
+		(!hasNFT && bitfield&cashTokenHasAmount == 0) {
+		return nil, nil, ErrInvalidCashToken
+	}
+	token = &CashToken{Category: category.String()}
+	if hasNFT {
+		token.NFT = &CashTokenNFT{Capability: capability}
+		if bitfield&cashTokenHasCommitment != 0 {
+			commitment, err := wire.ReadVarBytes(r, 0, CashTokenMaxCommitment, "commitment")
+			if err != nil || len(commitment) == 0 {
+				return nil, nil, ErrInvalidCashToken
+			}
+			token.NFT.Commitment = commitment
+		}
+	}
+	if bitfield&cashTokenHasAmount != 0 {
+		amount, err := wire.ReadVarInt(r, 0)
+		if err != nil || amount == 0 || amount > math.MaxInt64 {
+			return nil, nil, ErrInvalidCashToken
+		}
+		token.Amount = amount
+	}
+	return token, pkScript[len(pkScript)-r.Len():], nil
+}
+
+// CalcForkIDSignatureHash computes the BIP-143 style digest BCH and BSV sign
+// for every input type. scriptCode is the locking bytecode of the spent
+// output, token its token data if any.
+func CalcForkIDSignatureHash(tx *wire.MsgTx, idx int, scriptCode []byte, amount int64, token *CashToken, hashType txscript.SigHashType) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, fmt.Errorf("invalid input index %d", idx)
+	}
+	if hashType&SigHashForkID == 0 || hashType&0x20 != 0 {
+		return nil, fmt.Errorf("unsupported sighash type %#x", uint32(hashType))
+	}
+	baseType := hashType & 0x1f
+	anyoneCanPay := hashType&txscript.SigHashAnyOneCanPay != 0
+
+	var zero chainhash.Hash
+	hashPrevouts, hashSequence, hashOutputs := zero, zero, zero
+	if !anyoneCanPay {
+		var prevouts, sequences bytes.Buffer
+		for _, in := range tx.TxIn {
+			prevouts.Write(in.PreviousOutPoint.Hash[:])
+			_ = binary.Write(&prevouts, binary.LittleEndian, in.PreviousOutPoint.Index)
+			_ = binary.Write(&sequences, binary.LittleEndian, in.Sequence)
+		}
+		hashPrevouts = chainhash.DoubleHashH(prevouts.Bytes())
+		if baseType != txscript.SigHashSingle && baseType != txscript.SigHashNone {
+			hashSequence = chainhash.DoubleHashH(sequences.Bytes())
+		}
+	}
+	if baseType != txscript.SigHashSingle && baseType != txscript.SigHashNone {
+		var outputs bytes.Buffer
+		for _, out := range tx.TxOut {
+			if err := wire.WriteTxOut(&outputs, 0, 0, out); err != nil {
+				return nil, err
+			}
+		}
+		hashOutputs = chainhash.DoubleHashH(outputs.Bytes())
+	} else if baseType == txscript.SigHashSingle && idx < len(tx.TxOut) {
+		var output bytes.Buffer
+		if err := wire.WriteTxOut(&output, 0, 0, tx.TxOut[idx]); err != nil {
+			return nil, err
+		}
+		hashOutputs = chainhash.DoubleHashH(output.Bytes())
+	}
+
+	in := tx.TxIn[idx]
+	var preimage bytes.Buffer
+	_ = binary.Write(&preimage, binary.LittleEndian, tx.Version)
+	preimage.Write(hashPrevouts[:])
+	preimage.Write(hashSequence[:])
+	preimage.Write(in.PreviousOutPoint.Hash[:])
+	_ = binary.Write(&preimage, binary.LittleEndian, in.PreviousOutPoint.Index)
+	if token != nil {
+		prefix, err := token.Prefix()
+		if err != nil {
+			return nil, err
+		}
+		preimage.Write(prefix)
+	}
+	if err := wire.WriteVarBytes(&preimage, 0, scriptCode); err != nil {
+		return nil, err
+	}
+	_ = binary.Write(&preimage, binary.LittleEndian, amount)
+	_ = binary.Write(&preimage, binary.LittleEndian, in.Sequence)
+	preimage.Write(hashOutputs[:])
+	_ = binary.Write(&preimage, binary.LittleEndian, tx.LockTime)
+	_ = binary.Write(&preimage, binary.LittleEndian, uint32(hashType))
+	return chainhash.DoubleHashB(preimage.Bytes()), nil
+}
+
+// forkIDSignatureScript signs a P2PKH input with the fork id digest.
+func forkIDSignatureScript(tx *wire.MsgTx, idx int, pkScript []byte, amount int64, token *CashToken, privKey *btcec.PrivateKey) ([]byte, error) {
+	if !txscript.IsPayToPubKeyHash(pkScript) {
+		return nil, errors.New("only p2pkh inputs can be signed with fork id")
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+	if !bytes.Equal(btcutil.Hash160(pubKey), pkScript[3:23]) {
+		pubKey = privKey.PubKey().SerializeUncompressed()
+	}
+	hash, err := CalcForkIDSignatureHash(tx, idx, pkScript, amount, token, SigHashAllForkID)
+	if err != nil {
+		return nil, err
+	}
+	sig := append(ecdsa.Sign(privKey, hash).Serialize(), byte(SigHashAllForkID))
+	return txscript.NewScriptBuilder().AddData(sig).AddData(pubKey).Script()
+}
+
+// checkCashTokenBalance refuses to mint or burn fungible tokens, except for
+// the categories created by the tx, whose id is the txid of an input spending
+// output 0.
+func checkCashTokenBalance(tx *wire.MsgTx, inputTokens []*CashToken) error {
+	balance := make(map[string]int64)
+	for _, token := range inputTokens {
+		if token != nil {
+			balance[token.Category] += int64(token.Amount)
+		}
+	}
+	genesis := make(map[string]bool)
+	for _, in := range tx.TxIn {
+		if in.PreviousOutPoint.Index == 0 {
+			genesis[in.PreviousOutPoint.Hash.String()] = true
+		}
+	}
+	for _, out := range tx.TxOut {
+		token, _, err := DecodeCashTokenPkScript(out.PkScript)
+		if err != nil {
+			return err
+		}
+		if token == nil || genesis[token.Category] {
+			continue
+		}
+		balance[token.Category] -= int64(token.Amount)
+	}
+	for category, amount := range balance {
+		if amount != 0 && !genesis[category] {
+			return fmt.Errorf("%w: %s", ErrCashTokenBalance, category)
+		}
+	}
+	return nil
+}
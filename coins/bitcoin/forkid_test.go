@@ -0,0 +1,214 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const forkIDTestWif = "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+
+func forkIDTestAddress(t *testing.T, tokenAware bool) (string, []byte) {
+	wif, err := btcutil.DecodeWIF(forkIDTestWif)
+	require.NoError(t, err)
+	hash := btcutil.Hash160(wif.PrivKey.PubKey().SerializeCompressed())
+	addrType := CashAddrP2PKH
+	if tokenAware {
+		addrType = CashAddrTokenP2PKH
+	}
+	addr, err := EncodeCashAddr(CashAddrPrefixMainNet, addrType, hash)
+	require.NoError(t, err)
+	pkScript, err := PayToPubKeyHashScript(hash)
+	require.NoError(t, err)
+	return addr, pkScript
+}
+
+// verifyForkIDTx checks every input signature against the fork id digest.
+func verifyForkIDTx(t *testing.T, tx *wire.MsgTx, pkScripts [][]byte, amounts []int64, tokens []*CashToken) {
+	for i, in := range tx.TxIn {
+		pushes, err := txscript.PushedData(in.SignatureScript)
+		require.NoError(t, err)
+		require.Len(t, pushes, 2)
+		sig := pushes[0]
+		assert.Equal(t, byte(SigHashAllForkID), sig[len(sig)-1])
+		signature, err := ecdsa.ParseDERSignature(sig[:len(sig)-1])
+		require.NoError(t, err)
+		pubKey, err := btcec.ParsePubKey(pushes[1])
+		require.NoError(t, err)
+		hash, err := CalcForkIDSignatureHash(tx, i, pkScripts[i], amounts[i], tokens[i], SigHashAllForkID)
+		require.NoError(t, err)
+		assert.True(t, signature.Verify(hash, pubKey))
+	}
+}
+
+func TestCalcForkIDSignatureHash(t *testing.T) {
+	// without tokens the digest is the BIP-143 one over the fork id hash type
+	_, pkScript := forkIDTestAddress(t, false)
+	prevHash, err := chainhash.NewHashFromStr("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822")
+	require.NoError(t, err)
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, 1), nil, nil))
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, 2), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(9000, pkScript))
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	fetcher.AddPrevOut(tx.TxIn[0].PreviousOutPoint, wire.NewTxOut(5000, pkScript))
+	fetcher.AddPrevOut(tx.TxIn[1].PreviousOutPoint, wire.NewTxOut(6000, pkScript))
+	for _, hashType := range []txscript.SigHashType{SigHashAllForkID, txscript.SigHashSingle | SigHashForkID | txscript.SigHashAnyOneCanPay} {
+		hash, err := CalcForkIDSignatureHash(tx, 1, pkScript, 6000, nil, hashType)
+		require.NoError(t, err)
+		expected, err := txscript.CalcWitnessSigHash(pkScript, txscript.NewTxSigHashes(tx, fetcher), hashType, tx, 1, 6000)
+		require.NoError(t, err)
+		assert.Equal(t, expected, hash)
+	}
+	_, err = CalcForkIDSignatureHash(tx, 0, pkScript, 5000, nil, txscript.SigHashAll)
+	assert.Error(t, err)
+}
+
+func TestCashTokenPrefix(t *testing.T) {
+	category := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+	hash, err := chainhash.NewHashFromStr(category)
+	require.NoError(t, err)
+	for _, test := range []struct {
+		token *CashToken
+		tail  string
+	}{
+		{&CashToken{Category: category, Amount: 100}, "1064"},
+		{&CashToken{Category: category, NFT: &CashTokenNFT{}}, "20"},
+		{&CashToken{Category: category, Amount: 1000000, NFT: &CashTokenNFT{Capability: CashTokenMinting, Commitment: []byte{0xcc}}}, "7201ccfe40420f00"},
+	} {
+		prefix, err := test.token.Prefix()
+		require.NoError(t, err)
+		assert.Equal(t, "ef"+hex.EncodeToString(hash[:])+test.tail, hex.EncodeToString(prefix))
+		token, lockingScript, err := DecodeCashTokenPkScript(append(prefix, 0x51))
+		require.NoError(t, err)
+		assert.Equal(t, test.token, token)
+		assert.Equal(t, []byte{0x51}, lockingScript)
+	}
+	_, err = (&CashToken{Category: category}).Prefix()
+	assert.ErrorIs(t, err, ErrInvalidCashToken)
+	_, _, err = DecodeCashTokenPkScript(append(append([]byte{CashTokenPrefix}, hash[:]...), 0x40))
+	assert.ErrorIs(t, err, ErrInvalidCashToken)
+	token, lockingScript, err := DecodeCashTokenPkScript([]byte{0x51})
+	require.NoError(t, err)
+	assert.Nil(t, token)
+	assert.Equal(t, []byte{0x51}, lockingScript)
+}
+
+func TestForkIDTxBuild(t *testing.T) {
+	network := GetBCHmainNetParams()
+	addr, pkScript := forkIDTestAddress(t, false)
+	tokenAddr, _ := forkIDTestAddress(t, true)
+	txId := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+	token := &CashToken{Category: "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26", Amount: 1000}
+
+	builder := NewTxBuild(2, network)
+	builder.AddInput2(txId, 0, forkIDTestWif, addr, 20000)
+	builder.AddTokenInput(txId, 1, forkIDTestWif, tokenAddr, 1000, token)
+	builder.AddTokenOutput(tokenAddr, 1000, &CashToken{Category: token.Category, Amount: 600})
+	builder.AddTokenOutput(tokenAddr, 1000, &CashToken{Category: token.Category, Amount: 400})
+	builder.AddOutput(addr, 18000)
+	tx, err := builder.Build()
+	require.NoError(t, err)
+	verifyForkIDTx(t, tx, [][]byte{pkScript, pkScript}, []int64{20000, 1000}, []*CashToken{nil, token})
+	sent, lockingScript, err := DecodeCashTokenPkScript(tx.TxOut[0].PkScript)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(600), sent.Amount)
+	assert.Equal(t, pkScript, lockingScript)
+
+	// burning tokens and sending them to a non token-aware address are refused
+	builder = NewTxBuild(2, network)
+	builder.AddTokenInput(txId, 1, forkIDTestWif, tokenAddr, 1000, token)
+	builder.AddTokenOutput(tokenAddr, 900, &CashToken{Category: token.Category, Amount: 600})
+	_, err = builder.Build()
+	assert.ErrorIs(t, err, ErrCashTokenBalance)
+	builder = NewTxBuild(2, network)
+	builder.AddTokenInput(txId, 1, forkIDTestWif, tokenAddr, 1000, token)
+	builder.AddTokenOutput(addr, 900, token)
+	_, err = builder.Build()
+	assert.ErrorIs(t, err, ErrNotTokenAware)
+
+	// a new category is the txid of an input spending output 0
+	builder = NewTxBuild(2, network)
+	builder.AddInput2(txId, 0, forkIDTestWif, addr, 20000)
+	builder.AddTokenOutput(tokenAddr, 1000, &CashToken{Category: txId, Amount: 21000000, NFT: &CashTokenNFT{Capability: CashTokenMinting}})
+	_, err = builder.Build()
+	require.NoError(t, err)
+}
+
+func TestForkIDUnsignedTx(t *testing.T) {
+	network := GetBCHmainNetParams()
+	addr, pkScript := forkIDTestAddress(t, false)
+	wif, err := btcutil.DecodeWIF(forkIDTestWif)
+	require.NoError(t, err)
+	pubKey := hex.EncodeToString(wif.PrivKey.PubKey().SerializeCompressed())
+
+	// BSV shares the BTC params, fork id is opt-in
+	for _, builder := range []*TransactionBuilder{NewTxBuild(1, network), NewTxBuild(1, GetBSVMainNetParams())} {
+		if builder.netParams != network {
+			builder.EnableForkID()
+		}
+		builder.AddInput("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", 1, "", "", addr, 30000)
+		builder.AddOutput("1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", 29000)
+		pubKeyMap := map[int]string{0: pubKey}
+		txHex, hashes, err := builder.UnSignedTx(pubKeyMap)
+		require.NoError(t, err)
+		signatureMap := make(map[int]string)
+		for i, h := range hashes {
+			signatureMap[i] = hex.EncodeToString(ecdsa.Sign(wif.PrivKey, util.RemoveZeroHex(h)).Serialize())
+		}
+		txHex, err = SignTxWithSigHashType(txHex, pubKeyMap, signatureMap, SigHashAllForkID)
+		require.NoError(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.NoError(t, err)
+		verifyForkIDTx(t, tx, [][]byte{pkScript}, []int64{30000}, []*CashToken{nil})
+	}
+}
+
+func TestForkIDSignTxRoundTrip(t *testing.T) {
+	addr, pkScript := forkIDTestAddress(t, false)
+	wif, err := btcutil.DecodeWIF(forkIDTestWif)
+	require.NoError(t, err)
+	pubKey := hex.EncodeToString(wif.PrivKey.PubKey().SerializeCompressed())
+	btcAddr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(wif.PrivKey.PubKey().SerializeCompressed()), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		network  *chaincfg.Params
+		addr     string
+		hashType txscript.SigHashType
+	}{
+		{GetBCHmainNetParams(), addr, SigHashAllForkID},
+		{&chaincfg.MainNetParams, btcAddr.EncodeAddress(), txscript.SigHashAll},
+	} {
+		builder := NewTxBuild(1, test.network)
+		builder.AddInput("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", 1, "", "", test.addr, 30000)
+		builder.AddOutput("1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", 29000)
+		pubKeyMap := map[int]string{0: pubKey}
+		txHex, hashes, err := builder.UnSignedTx(pubKeyMap)
+		require.NoError(t, err)
+		signatureMap := make(map[int]string)
+		for i, h := range hashes {
+			signatureMap[i] = hex.EncodeToString(ecdsa.Sign(wif.PrivKey, util.RemoveZeroHex(h)).Serialize())
+		}
+		txHex, err = builder.SignTx(txHex, pubKeyMap, signatureMap)
+		require.NoError(t, err)
+		tx, err := NewTxFromHex(txHex)
+		require.NoError(t, err)
+		pushes, err := txscript.PushedData(tx.TxIn[0].SignatureScript)
+		require.NoError(t, err)
+		assert.Equal(t, byte(test.hashType), pushes[0][len(pushes[0])-1], test.network.Name)
+
+		res, err := VerifyTx(tx, []*wire.TxOut{wire.NewTxOut(30000, pkScript)}, test.network)
+		require.NoError(t, err)
+		assert.True(t, res.Valid, test.network.Name)
+	}
+}
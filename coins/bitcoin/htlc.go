@@ -0,0 +1,460 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// HTLCPreimageSize is the only preimage size the scripts accept, so that a
+// preimage revealed on one chain always claims on the other.
+const HTLCPreimageSize = 32
+
+var (
+	ErrInvalidPreimage = errors.New("preimage does not match the payment hash")
+	ErrHTLCSignature   = errors.New("htlc input has no signature for the spending path")
+)
+
+type HTLCType int
+
+const (
+	HTLCP2WSH HTLCType = iota
+	// HTLCP2SH is the legacy form, for chains without segwit such as DOGE.
+	HTLCP2SH
+	// HTLCTaproot has a claim leaf and a refund leaf.
+	HTLCTaproot
+)
+
+// The leaves of HTLC.TaprootTree.
+const (
+	htlcClaimLeaf = iota
+	htlcRefundLeaf
+)
+
+// HTLC is a BIP-199 hashed timelock contract: ClaimPubKey spends with the
+// sha256 preimage of PaymentHash, RefundPubKey once LockTime is reached.
+type HTLC struct {
+	PaymentHash  string `json:"paymentHash"`
+	ClaimPubKey  string `json:"claimPubKey"`
+	RefundPubKey string `json:"refundPubKey"`
+	// LockTime is a block height or, from LockTimeThreshold on, a unix timestamp.
+	LockTime uint32 `json:"lockTime"`
+
+	paymentHash  []byte
+	claimPubKey  *btcec.PublicKey
+	refundPubKey *btcec.PublicKey
+}
+
+// NewHTLCPreimage returns a random preimage and its payment hash, both hex.
+func NewHTLCPreimage() (string, string, error) {
+	preimage := make([]byte, HTLCPreimageSize)
+	if _, err := rand.Read(preimage); err != nil {
+		return "", "", err
+	}
+	hash := sha256.Sum256(preimage)
+	return hex.EncodeToString(preimage), hex.EncodeToString(hash[:]), nil
+}
+
+func NewHTLC(paymentHash, claimPubKey, refundPubKey string, lockTime uint32) (*HTLC, error) {
+	hash, err := hex.DecodeString(paymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if len(hash) != sha256.Size {
+		return nil, errors.New("payment hash must be 32 bytes")
+	}
+	if lockTime == 0 {
+		return nil, ErrInvalidTimelock
+	}
+	h := &HTLC{PaymentHash: paymentHash, ClaimPubKey: claimPubKey, RefundPubKey: refundPubKey, LockTime: lockTime, paymentHash: hash}
+	if h.claimPubKey, err = parseHexPubKey(claimPubKey); err != nil {
+		return nil, err
+	}
+	if h.refundPubKey, err = parseHexPubKey(refundPubKey); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseHexPubKey(pubKey string) (*btcec.PublicKey, error) {
+	b, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return btcec.ParsePubKey(b)
+}
+
+// Script is the P2WSH witness script and P2SH redeem script:
+//
+//	OP_IF OP_SIZE 32 OP_EQUALVERIFY OP_SHA256 <hash> OP_EQUALVERIFY OP_DUP OP_HASH160 <claim pkh>
+//	OP_ELSE <lockTime> OP_CHECKLOCKTIMEVERIFY OP_DROP OP_DUP OP_HASH160 <refund pkh>
+//	OP_ENDIF OP_EQUALVERIFY OP_CHECKSIG
+func (h *HTLC) Script() ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_IF).
+		AddOp(txscript.OP_SIZE).AddInt64(HTLCPreimageSize).AddOp(txscript.OP_EQUALVERIFY).
+		AddOp(txscript.OP_SHA256).AddData(h.paymentHash).AddOp(txscript.OP_EQUALVERIFY).
+		AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(h.claimPubKey.SerializeCompressed())).
+		AddOp(txscript.OP_ELSE).
+		AddInt64(int64(h.LockTime)).AddOp(txscript.OP_CHECKLOCKTIMEVERIFY).AddOp(txscript.OP_DROP).
+		AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(h.refundPubKey.SerializeCompressed())).
+		AddOp(txscript.OP_ENDIF).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+}
+
+// ClaimLeaf is the tapscript leaf spent with the preimage.
+func (h *HTLC) ClaimLeaf() ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddOp(txscript.OP_SIZE).AddInt64(HTLCPreimageSize).AddOp(txscript.OP_EQUALVERIFY).
+		AddOp(txscript.OP_SHA256).AddData(h.paymentHash).AddOp(txscript.OP_EQUALVERIFY).
+		AddData(schnorr.SerializePubKey(h.claimPubKey)).AddOp(txscript.OP_CHECKSIG).
+		Script()
+}
+
+// RefundLeaf is the tapscript leaf spent after LockTime.
+func (h *HTLC) RefundLeaf() ([]byte, error) {
+	return txscript.NewScriptBuilder().
+		AddInt64(int64(h.LockTime)).AddOp(txscript.OP_CHECKLOCKTIMEVERIFY).AddOp(txscript.OP_DROP).
+		AddData(schnorr.SerializePubKey(h.refundPubKey)).AddOp(txscript.OP_CHECKSIG).
+		Script()
+}
+
+// TaprootTree returns the tree of the claim and refund leaves under
+// internalPubKey, TaprootNUMSKey disabling the key path when empty. An
+// aggregate key of both parties allows a cooperative key path spend.
+func (h *HTLC) TaprootTree(internalPubKey string) (*TaprootScriptTree, error) {
+	if internalPubKey == "" {
+		internalPubKey = TaprootNUMSKey
+	}
+	claim, err := h.ClaimLeaf()
+	if err != nil {
+		return nil, err
+	}
+	refund, err := h.RefundLeaf()
+	if err != nil {
+		return nil, err
+	}
+	return NewTaprootScriptTree(internalPubKey, []*TapLeafSpec{
+		{Script: hex.EncodeToString(claim)},
+		{Script: hex.EncodeToString(refund)},
+	})
+}
+
+// Address returns the address of the HTLC in form typ, internalPubKey is only
+// used by HTLCTaproot. The form must be supported by the relay policy of network.
+func (h *HTLC) Address(typ HTLCType, internalPubKey string, network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	policy := RelayPolicyFor(network)
+	switch typ {
+	case HTLCP2WSH, HTLCP2SH:
+		if typ == HTLCP2WSH && !policy.SegWit {
+			return "", fmt.Errorf("%w: %s", ErrSegWitUnsupported, policy.Name)
+		}
+		script, err := h.Script()
+		if err != nil {
+			return "", err
+		}
+		var addr btcutil.Address
+		if typ == HTLCP2WSH {
+			hash := sha256.Sum256(script)
+			addr, err = btcutil.NewAddressWitnessScriptHash(hash[:], network)
+		} else {
+			addr, err = btcutil.NewAddressScriptHash(script, network)
+		}
+		if err != nil {
+			return "", err
+		}
+		return addr.EncodeAddress(), nil
+	case HTLCTaproot:
+		if !policy.Taproot {
+			return "", fmt.Errorf("%w: %s", ErrTaprootUnsupported, policy.Name)
+		}
+		tree, err := h.TaprootTree(internalPubKey)
+		if err != nil {
+			return "", err
+		}
+		return tree.Address(network)
+	}
+	return "", fmt.Errorf("unknown htlc type %d", typ)
+}
+
+// HTLCSpendRequest spends an HTLC output to Address, less a fee of FeeRate.
+type HTLCSpendRequest struct {
+	HTLC           *HTLC
+	Type           HTLCType
+	InternalPubKey string
+	TxId           string
+	VOut           uint32
+	Amount         int64
+	// NonWitnessUtxo is the funding transaction, needed by HTLCP2SH.
+	NonWitnessUtxo string
+	Address        string
+	FeeRate        int64
+	PrivateKey     string
+	// Preimage is the hex preimage a claim reveals.
+	Preimage string
+}
+
+// NewHTLCSpendPsbt creates the unsigned PSBT of the claim or the refund of req
+// with the given fee. A refund sets the lock time to HTLC.LockTime.
+func NewHTLCSpendPsbt(req *HTLCSpendRequest, claim bool, fee int64, network *chaincfg.Params) (*PsbtPacket, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	h := req.HTLC
+	if h == nil {
+		return nil, errors.New("missing htlc")
+	}
+	if req.Type == HTLCP2SH && req.NonWitnessUtxo == "" {
+		return nil, errors.New("p2sh htlc input needs its funding transaction")
+	}
+	addr, err := h.Address(req.Type, req.InternalPubKey, network)
+	if err != nil {
+		return nil, err
+	}
+
+	lockTime, sequence := uint32(0), uint32(wire.MaxTxInSequenceNum)
+	if !claim {
+		lockTime, sequence = h.LockTime, wire.MaxTxInSequenceNum-1
+	}
+	packet, err := NewPsbtPacket(0, 2, lockTime)
+	if err != nil {
+		return nil, err
+	}
+	in := &TxInput{TxId: req.TxId, VOut: req.VOut, Sequence: sequence, Amount: req.Amount, Address: addr, NonWitnessUtxo: req.NonWitnessUtxo}
+	if err := packet.AddInput(in); err != nil {
+		return nil, err
+	}
+	if err := packet.UpdateInput(0, in, network); err != nil {
+		return nil, err
+	}
+	switch req.Type {
+	case HTLCTaproot:
+		tree, err := h.TaprootTree(req.InternalPubKey)
+		if err != nil {
+			return nil, err
+		}
+		leaf := htlcRefundLeaf
+		if claim {
+			leaf = htlcClaimLeaf
+		}
+		if err := tree.AddLeafToPsbtInput(packet.Packet, 0, leaf); err != nil {
+			return nil, err
+		}
+	default:
+		script, err := h.Script()
+		if err != nil {
+			return nil, err
+		}
+		if req.Type == HTLCP2SH {
+			err = packet.AddInputScripts(0, script, nil)
+		} else {
+			err = packet.AddInputScripts(0, nil, script)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	out := &TxOutput{Address: req.Address, Amount: req.Amount - fee}
+	pkScript, err := AddrToPkScript(out.Address, network)
+	if err != nil {
+		return nil, err
+	}
+	if out.Amount < RelayPolicyFor(network).DustThreshold(pkScript) {
+		return nil, ErrDustOutput
+	}
+	if err := packet.AddOutput(out, network); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// FinalizeHTLCInput builds the final witness, or script sig for HTLCP2SH, of
+// input i from its signature: the claim path when preimage is given, the
+// refund path otherwise.
+func FinalizeHTLCInput(p *PsbtPacket, i int, h *HTLC, preimage []byte) error {
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	claim := preimage != nil
+	if claim {
+		hash := sha256.Sum256(preimage)
+		if len(preimage) != HTLCPreimageSize || !bytes.Equal(hash[:], h.paymentHash) {
+			return ErrInvalidPreimage
+		}
+	}
+	pubKey := h.refundPubKey
+	if claim {
+		pubKey = h.claimPubKey
+	}
+	in := &p.Packet.Inputs[i]
+
+	if len(in.TaprootLeafScript) > 0 {
+		leafScript, err := h.RefundLeaf()
+		if claim {
+			leafScript, err = h.ClaimLeaf()
+		}
+		if err != nil {
+			return err
+		}
+		var leaf *psbt.TaprootTapLeafScript
+		for _, l := range in.TaprootLeafScript {
+			if bytes.Equal(l.Script, leafScript) {
+				leaf = l
+			}
+		}
+		if leaf == nil {
+			return fmt.Errorf("input %d has no tap leaf for the spending path", i)
+		}
+		leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+		var sig []byte
+		for _, s := range in.TaprootScriptSpendSig {
+			if bytes.Equal(s.XOnlyPubKey, schnorr.SerializePubKey(pubKey)) && bytes.Equal(s.LeafHash, leafHash[:]) {
+				sig = s.Signature
+				if s.SigHash != txscript.SigHashDefault {
+					sig = append(append([]byte{}, sig...), byte(s.SigHash))
+				}
+			}
+		}
+		if sig == nil {
+			return ErrHTLCSignature
+		}
+		witness := [][]byte{sig, leaf.Script, leaf.ControlBlock}
+		if claim {
+			witness = [][]byte{sig, preimage, leaf.Script, leaf.ControlBlock}
+		}
+		return finalizeHTLCWitness(in, witness)
+	}
+
+	var sig []byte
+	for _, s := range in.PartialSigs {
+		if bytes.Equal(s.PubKey, pubKey.SerializeCompressed()) {
+			sig = s.Signature
+		}
+	}
+	if sig == nil {
+		return ErrHTLCSignature
+	}
+	stack := [][]byte{sig, pubKey.SerializeCompressed(), {}}
+	if claim {
+		stack = [][]byte{sig, pubKey.SerializeCompressed(), preimage, {1}}
+	}
+	if in.WitnessScript != nil {
+		return finalizeHTLCWitness(in, append(stack, in.WitnessScript))
+	}
+	if in.RedeemScript == nil {
+		return fmt.Errorf("input %d has no htlc script", i)
+	}
+	builder := txscript.NewScriptBuilder()
+	for _, item := range stack {
+		if len(item) == 0 {
+			builder.AddOp(txscript.OP_0)
+		} else if len(item) == 1 && item[0] == 1 {
+			builder.AddOp(txscript.OP_1)
+		} else {
+			builder.AddData(item)
+		}
+	}
+	scriptSig, err := builder.AddData(in.RedeemScript).Script()
+	if err != nil {
+		return err
+	}
+	in.FinalScriptSig = scriptSig
+	clearHTLCInput(in)
+	return nil
+}
+
+func finalizeHTLCWitness(in *psbt.PInput, witness [][]byte) error {
+	var buf bytes.Buffer
+	if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+		return err
+	}
+	in.FinalScriptWitness = buf.Bytes()
+	clearHTLCInput(in)
+	return nil
+}
+
+func clearHTLCInput(in *psbt.PInput) {
+	in.PartialSigs = nil
+	in.SighashType = 0
+	in.RedeemScript = nil
+	in.WitnessScript = nil
+	in.Bip32Derivation = nil
+	in.TaprootScriptSpendSig = nil
+	in.TaprootLeafScript = nil
+	in.TaprootBip32Derivation = nil
+	in.TaprootInternalKey = nil
+	in.TaprootMerkleRoot = nil
+}
+
+// BuildHTLCClaimTx returns the hex of the signed transaction claiming the HTLC
+// output of req with req.Preimage.
+func BuildHTLCClaimTx(req *HTLCSpendRequest, network *chaincfg.Params) (string, error) {
+	preimage, err := hex.DecodeString(req.Preimage)
+	if err != nil {
+		return "", err
+	}
+	if len(preimage) == 0 {
+		return "", ErrInvalidPreimage
+	}
+	return buildHTLCSpendTx(req, preimage, network)
+}
+
+// BuildHTLCRefundTx returns the hex of the signed transaction refunding the
+// HTLC output of req, it is valid from HTLC.LockTime on.
+func BuildHTLCRefundTx(req *HTLCSpendRequest, network *chaincfg.Params) (string, error) {
+	return buildHTLCSpendTx(req, nil, network)
+}
+
+func buildHTLCSpendTx(req *HTLCSpendRequest, preimage []byte, network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	sign := func(fee int64) (*wire.MsgTx, error) {
+		packet, err := NewHTLCSpendPsbt(req, preimage != nil, fee, network)
+		if err != nil {
+			return nil, err
+		}
+		signed, err := packet.Sign(req.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		if signed == 0 {
+			return nil, ErrHTLCSignature
+		}
+		if err := FinalizeHTLCInput(packet, 0, req.HTLC, preimage); err != nil {
+			return nil, err
+		}
+		return psbt.Extract(packet.Packet)
+	}
+	// ECDSA signatures vary in size by a byte, so the fee is raised until it
+	// covers the signed transaction
+	policy := RelayPolicyFor(network)
+	fee := int64(0)
+	for i := 0; i < 3; i++ {
+		tx, err := sign(fee)
+		if err != nil {
+			return "", err
+		}
+		required := policy.Fee(GetTxVirtualSize(btcutil.NewTx(tx)), req.FeeRate)
+		if required <= fee {
+			return GetTxHex(tx)
+		}
+		fee = required
+	}
+	return "", errors.New("htlc spend fee does not converge")
+}
@@ -0,0 +1,165 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHTLCClaimAndRefund(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	preimage, paymentHash, err := NewHTLCPreimage()
+	require.NoError(t, err)
+	htlc, err := NewHTLC(paymentHash, pubKeys[0], pubKeys[1], 2500000)
+	require.NoError(t, err)
+
+	for _, typ := range []HTLCType{HTLCP2WSH, HTLCP2SH, HTLCTaproot} {
+		addr, err := htlc.Address(typ, "", network)
+		require.NoError(t, err)
+		pkScript, err := AddrToPkScript(addr, network)
+		require.NoError(t, err)
+		funding := wire.NewMsgTx(2)
+		funding.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+		funding.AddTxOut(wire.NewTxOut(100000, pkScript))
+		fundingHex, err := GetTxHex(funding)
+		require.NoError(t, err)
+		prevOutputs := PrevOutputs{{TxId: funding.TxHash().String(), Amount: 100000, Address: addr}}
+
+		req := &HTLCSpendRequest{
+			HTLC:       htlc,
+			Type:       typ,
+			TxId:       funding.TxHash().String(),
+			Amount:     100000,
+			Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			FeeRate:    10,
+			PrivateKey: wifs[0],
+			Preimage:   preimage,
+		}
+		if typ == HTLCP2SH {
+			req.NonWitnessUtxo = fundingHex
+		}
+		txHex, err := BuildHTLCClaimTx(req, network)
+		require.NoError(t, err, typ)
+		verifyTxScripts(t, txHex, prevOutputs, network)
+		tx, err := NewTxFromHex(txHex)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0), tx.LockTime)
+		fee := 100000 - tx.TxOut[0].Value
+		assert.True(t, fee >= GetTxVirtualSize(btcutil.NewTx(tx))*10, typ)
+
+		// the refund key cannot claim
+		req.PrivateKey = wifs[1]
+		_, err = BuildHTLCClaimTx(req, network)
+		assert.ErrorIs(t, err, ErrHTLCSignature)
+
+		txHex, err = BuildHTLCRefundTx(req, network)
+		require.NoError(t, err, typ)
+		verifyTxScripts(t, txHex, prevOutputs, network)
+		tx, err = NewTxFromHex(txHex)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2500000), tx.LockTime)
+		assert.Equal(t, wire.MaxTxInSequenceNum-1, tx.TxIn[0].Sequence)
+	}
+}
+
+func TestHTLCErrors(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	_, paymentHash, err := NewHTLCPreimage()
+	require.NoError(t, err)
+	htlc, err := NewHTLC(paymentHash, pubKeys[0], pubKeys[1], 2500000)
+	require.NoError(t, err)
+
+	_, err = NewHTLC(paymentHash[2:], pubKeys[0], pubKeys[1], 2500000)
+	assert.Error(t, err)
+	_, err = NewHTLC(paymentHash, pubKeys[0], pubKeys[1], 0)
+	assert.ErrorIs(t, err, ErrInvalidTimelock)
+
+	// DOGE has neither segwit nor taproot
+	doge := GetDOGEMainNetParams()
+	_, err = htlc.Address(HTLCP2WSH, "", doge)
+	assert.ErrorIs(t, err, ErrSegWitUnsupported)
+	_, err = htlc.Address(HTLCTaproot, "", doge)
+	assert.ErrorIs(t, err, ErrTaprootUnsupported)
+	addr, err := htlc.Address(HTLCP2SH, "", doge)
+	require.NoError(t, err)
+	assert.Contains(t, "9A", addr[:1])
+
+	req := &HTLCSpendRequest{
+		HTLC:       htlc,
+		Type:       HTLCP2WSH,
+		TxId:       "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		Amount:     100000,
+		Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		FeeRate:    10,
+		PrivateKey: wifs[0],
+		Preimage:   hex.EncodeToString(make([]byte, HTLCPreimageSize)),
+	}
+	_, err = BuildHTLCClaimTx(req, &chaincfg.TestNet3Params)
+	assert.ErrorIs(t, err, ErrInvalidPreimage)
+
+	req.Type = HTLCP2SH
+	_, err = BuildHTLCRefundTx(req, &chaincfg.TestNet3Params)
+	assert.Error(t, err)
+
+	req.Type = HTLCP2WSH
+	req.Amount = 1000
+	req.PrivateKey = wifs[1]
+	_, err = BuildHTLCRefundTx(req, &chaincfg.TestNet3Params)
+	assert.ErrorIs(t, err, ErrDustOutput)
+}
+
+func TestHTLCDogeP2SH(t *testing.T) {
+	network := GetDOGEMainNetParams()
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	preimage, paymentHash, err := NewHTLCPreimage()
+	require.NoError(t, err)
+	htlc, err := NewHTLC(paymentHash, pubKeys[0], pubKeys[1], 5000000)
+	require.NoError(t, err)
+	addr, err := htlc.Address(HTLCP2SH, "", network)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+
+	funding := wire.NewMsgTx(1)
+	funding.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil, nil))
+	funding.AddTxOut(wire.NewTxOut(1000000000, pkScript))
+	fundingHex, err := GetTxHex(funding)
+	require.NoError(t, err)
+	claimPubKey, err := hex.DecodeString(pubKeys[0])
+	require.NoError(t, err)
+	to, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(claimPubKey), network)
+	require.NoError(t, err)
+
+	req := &HTLCSpendRequest{
+		HTLC:           htlc,
+		Type:           HTLCP2SH,
+		TxId:           funding.TxHash().String(),
+		Amount:         1000000000,
+		NonWitnessUtxo: fundingHex,
+		Address:        to.EncodeAddress(),
+		PrivateKey:     wifs[0],
+		Preimage:       preimage,
+	}
+	txHex, err := BuildHTLCClaimTx(req, network)
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: req.TxId, Amount: req.Amount, Address: addr}}, network)
+	tx, err := NewTxFromHex(txHex)
+	require.NoError(t, err)
+	// no fee rate given, the minimum relay fee is paid: that of the signed
+	// size, or of the size with the longer signature of an earlier round, at
+	// most 72 bytes with the hash type
+	pushes, err := txscript.PushedData(tx.TxIn[0].SignatureScript)
+	require.NoError(t, err)
+	vSize := GetTxVirtualSize(btcutil.NewTx(tx))
+	var fees []int64
+	for size := vSize; size <= vSize+int64(72-len(pushes[0])); size++ {
+		fees = append(fees, DOGERelayPolicy.MinRelayFee(size))
+	}
+	assert.Contains(t, fees, req.Amount-tx.TxOut[0].Value)
+}
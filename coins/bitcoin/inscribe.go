@@ -2,6 +2,7 @@ package bitcoin
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -13,12 +14,27 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
+	"strconv"
+	"strings"
 )
 
 type InscriptionData struct {
 	ContentType string `json:"contentType"`
 	Body        []byte `json:"body"`
 	RevealAddr  string `json:"revealAddr"`
+	// Metadata is CBOR encoded, see EncodeCBOR.
+	Metadata     []byte `json:"metadata,omitempty"`
+	Metaprotocol string `json:"metaprotocol,omitempty"`
+	// ContentEncoding names the encoding Body is already compressed with,
+	// such as br for brotli.
+	ContentEncoding string `json:"contentEncoding,omitempty"`
+	// Parents are inscription ids <txid>i<index>, the reveal tx must spend
+	// them so their outputs go in InscriptionRequest.ParentPrevOutputList.
+	Parents  []string `json:"parents,omitempty"`
+	Delegate string   `json:"delegate,omitempty"`
+	// Pointer is the sat offset in the reveal outputs to inscribe, set by
+	// batch mode.
+	Pointer *uint64 `json:"pointer,omitempty"`
 }
 
 type PrevOutput struct {
@@ -28,6 +44,9 @@ type PrevOutput struct {
 	Address    string `json:"address"`
 	PrivateKey string `json:"privateKey"`
 	PublicKey  string `json:"publicKey"`
+	// Assets tags the inscriptions, runes or ARC-20s the utxo carries so that
+	// it is not spent by accident.
+	Assets []*UtxoAsset `json:"assets,omitempty"`
 }
 
 type PrevOutputs []*PrevOutput
@@ -59,6 +78,12 @@ type InscriptionRequest struct {
 	RevealOutValue         int64             `json:"revealOutValue"`
 	ChangeAddress          string            `json:"changeAddress"`
 	MinChangeValue         int64             `json:"minChangeValue"`
+	// ParentPrevOutputList are the outputs holding the parent inscriptions,
+	// each is spent by the reveal tx and returned to its address.
+	ParentPrevOutputList PrevOutputs `json:"parentPrevOutputList,omitempty"`
+	// Batch reveals every inscription in one tx, each to its own output.
+	// Requests with parents are always revealed in batch mode.
+	Batch bool `json:"batch,omitempty"`
 }
 
 type inscriptionTxCtxData struct {
@@ -68,6 +93,9 @@ type inscriptionTxCtxData struct {
 	CommitTxAddressPkScript []byte
 	ControlBlockWitness     []byte
 	RevealTxPrevOutput      *wire.TxOut
+	// CommitInputIndex is the reveal tx input spending the commit output,
+	// parent inputs come before it.
+	CommitInputIndex int
 }
 
 type InscriptionBuilder struct {
@@ -77,6 +105,8 @@ type InscriptionBuilder struct {
 	InscriptionTxCtxDataList  []*inscriptionTxCtxData
 	RevealTxPrevOutputFetcher *txscript.MultiPrevOutFetcher
 	CommitTxPrevOutputList    []*PrevOutput
+	ParentPrevOutputList      PrevOutputs
+	ParentPrivateKeyList      []*btcec.PrivateKey
 	RevealTx                  []*wire.MsgTx
 	CommitTx                  *wire.MsgTx
 	MustCommitTxFee           int64
@@ -113,7 +143,12 @@ const (
 	OrdPrefix = "ord"
 )
 
+var ErrInvalidInscriptionId = errors.New("invalid inscription id")
+
 func NewInscriptionTool(network *chaincfg.Params, request *InscriptionRequest) (*InscriptionBuilder, error) {
+	if err := CheckUtxosSpendable(request.CommitTxPrevOutputList); err != nil {
+		return nil, err
+	}
 	var commitTxPrivateKeyList []*btcec.PrivateKey
 	for _, prevOutput := range request.CommitTxPrevOutputList {
 		privateKeyWif, err := btcutil.DecodeWIF(prevOutput.PrivateKey)
@@ -122,6 +157,14 @@ func NewInscriptionTool(network *chaincfg.Params, request *InscriptionRequest) (
 		}
 		commitTxPrivateKeyList = append(commitTxPrivateKeyList, privateKeyWif.PrivKey)
 	}
+	var parentPrivateKeyList []*btcec.PrivateKey
+	for _, prevOutput := range request.ParentPrevOutputList {
+		privateKeyWif, err := btcutil.DecodeWIF(prevOutput.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		parentPrivateKeyList = append(parentPrivateKeyList, privateKeyWif.PrivKey)
+	}
 	tool := &InscriptionBuilder{
 		Network:                   network,
 		CommitTxPrevOutputFetcher: txscript.NewMultiPrevOutFetcher(nil),
@@ -129,6 +172,8 @@ func NewInscriptionTool(network *chaincfg.Params, request *InscriptionRequest) (
 		InscriptionTxCtxDataList:  make([]*inscriptionTxCtxData, len(request.InscriptionDataList)),
 		RevealTxPrevOutputFetcher: txscript.NewMultiPrevOutFetcher(nil),
 		CommitTxPrevOutputList:    request.CommitTxPrevOutputList,
+		ParentPrevOutputList:      request.ParentPrevOutputList,
+		ParentPrivateKeyList:      parentPrivateKeyList,
 	}
 	return tool, tool.initTool(network, request)
 }
@@ -139,19 +184,29 @@ func (builder *InscriptionBuilder) initTool(network *chaincfg.Params, request *I
 	if request.RevealOutValue > 0 {
 		revealOutValue = request.RevealOutValue
 	}
-	minChangeValue := DefaultMinChangeValue
+	policy := RelayPolicyFor(network)
+	if !policy.Taproot {
+		return ErrTaprootUnsupported
+	}
+	minChangeValue := policy.ChangeThreshold()
 	if request.MinChangeValue > 0 {
 		minChangeValue = request.MinChangeValue
 	}
-	for i := 0; i < len(request.InscriptionDataList); i++ {
-		inscriptionTxCtxData, err := newInscriptionTxCtxData(network, request, i)
-		if err != nil {
-			return err
+	var totalRevealPrevOutputValue int64
+	var err error
+	if request.Batch || len(request.ParentPrevOutputList) > 0 {
+		totalRevealPrevOutputValue, err = builder.buildBatchRevealTx(request, revealOutValue)
+	} else {
+		for i := 0; i < len(request.InscriptionDataList); i++ {
+			inscriptionTxCtxData, err := newInscriptionTxCtxData(network, request, i)
+			if err != nil {
+				return err
+			}
+			builder.InscriptionTxCtxDataList[i] = inscriptionTxCtxData
+			destinations[i] = request.InscriptionDataList[i].RevealAddr
 		}
-		builder.InscriptionTxCtxDataList[i] = inscriptionTxCtxData
-		destinations[i] = request.InscriptionDataList[i].RevealAddr
+		totalRevealPrevOutputValue, err = builder.buildEmptyRevealTx(destinations, revealOutValue, request.RevealFeeRate)
 	}
-	totalRevealPrevOutputValue, err := builder.buildEmptyRevealTx(destinations, revealOutValue, request.RevealFeeRate)
 	if err != nil {
 		return err
 	}
@@ -171,38 +226,32 @@ func (builder *InscriptionBuilder) initTool(network *chaincfg.Params, request *I
 }
 
 func newInscriptionTxCtxData(network *chaincfg.Params, inscriptionRequest *InscriptionRequest, indexOfInscriptionDataList int) (*inscriptionTxCtxData, error) {
+	data := &inscriptionRequest.InscriptionDataList[indexOfInscriptionDataList]
+	envelope, err := buildInscriptionEnvelope(data, data.Pointer)
+	if err != nil {
+		return nil, err
+	}
+	return newInscriptionScriptCtxData(network, inscriptionRequest, envelope)
+}
+
+// newInscriptionScriptCtxData commits to <key> OP_CHECKSIG followed by the
+// envelopes.
+func newInscriptionScriptCtxData(network *chaincfg.Params, inscriptionRequest *InscriptionRequest, envelopes []byte) (*inscriptionTxCtxData, error) {
 	privateKeyWif, err := btcutil.DecodeWIF(inscriptionRequest.CommitTxPrevOutputList[0].PrivateKey)
 	if err != nil {
 		return nil, err
 	}
 	privateKey := privateKeyWif.PrivKey
 
-	inscriptionBuilder := txscript.NewScriptBuilder().
+	inscriptionScript, err := txscript.NewScriptBuilder().
 		AddData(schnorr.SerializePubKey(privateKey.PubKey())).
 		AddOp(txscript.OP_CHECKSIG).
-		AddOp(txscript.OP_FALSE).
-		AddOp(txscript.OP_IF).
-		AddData([]byte(OrdPrefix)).
-		AddOp(txscript.OP_DATA_1).
-		AddOp(txscript.OP_DATA_1).
-		AddData([]byte(inscriptionRequest.InscriptionDataList[indexOfInscriptionDataList].ContentType)).
-		AddOp(txscript.OP_0)
-	maxChunkSize := 520
-	// use taproot to skip txscript.MaxScriptSize 10000
-	bodySize := len(inscriptionRequest.InscriptionDataList[indexOfInscriptionDataList].Body)
-	for i := 0; i < bodySize; i += maxChunkSize {
-		end := i + maxChunkSize
-		if end > bodySize {
-			end = bodySize
-		}
-
-		inscriptionBuilder.AddFullData(inscriptionRequest.InscriptionDataList[indexOfInscriptionDataList].Body[i:end])
-	}
-	inscriptionScript, err := inscriptionBuilder.Script()
+		Script()
 	if err != nil {
 		return nil, err
 	}
-	inscriptionScript = append(inscriptionScript, txscript.OP_ENDIF)
+	// appended as is, txscript.MaxScriptSize 10000 does not apply to tapscripts
+	inscriptionScript = append(inscriptionScript, envelopes...)
 
 	proof := &txscript.TapscriptProof{
 		TapLeaf:  txscript.NewBaseTapLeaf(schnorr.SerializePubKey(privateKey.PubKey())),
@@ -280,6 +329,174 @@ func (builder *InscriptionBuilder) buildEmptyRevealTx(destination []string, reve
 	return totalPrevOutputValue, nil
 }
 
+// buildInscriptionEnvelope returns OP_FALSE OP_IF "ord" <fields> OP_0 <body>
+// OP_ENDIF with the fields in the order ord writes them. The body is left out
+// only for delegates without one.
+func buildInscriptionEnvelope(data *InscriptionData, pointer *uint64) ([]byte, error) {
+	script := appendEnvelopePush([]byte{txscript.OP_FALSE, txscript.OP_IF}, []byte(OrdPrefix))
+	field := func(tag byte, value []byte) {
+		script = appendEnvelopePush(appendEnvelopePush(script, []byte{tag}), value)
+	}
+	field(InscriptionTagContentType, []byte(data.ContentType))
+	if data.ContentEncoding != "" {
+		field(InscriptionTagContentEncoding, []byte(data.ContentEncoding))
+	}
+	if data.Metaprotocol != "" {
+		field(InscriptionTagMetaprotocol, []byte(data.Metaprotocol))
+	}
+	for _, parent := range data.Parents {
+		id, err := inscriptionIdBytes(parent)
+		if err != nil {
+			return nil, err
+		}
+		field(InscriptionTagParent, id)
+	}
+	if data.Delegate != "" {
+		id, err := inscriptionIdBytes(data.Delegate)
+		if err != nil {
+			return nil, err
+		}
+		field(InscriptionTagDelegate, id)
+	}
+	if pointer != nil {
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], *pointer)
+		field(InscriptionTagPointer, bytes.TrimRight(buf[:], "\x00"))
+	}
+	for i := 0; i < len(data.Metadata); i += txscript.MaxScriptElementSize {
+		end := i + txscript.MaxScriptElementSize
+		if end > len(data.Metadata) {
+			end = len(data.Metadata)
+		}
+		field(InscriptionTagMetadata, data.Metadata[i:end])
+	}
+	if len(data.Body) > 0 || data.Delegate == "" {
+		script = append(script, txscript.OP_0)
+		for i := 0; i < len(data.Body); i += txscript.MaxScriptElementSize {
+			end := i + txscript.MaxScriptElementSize
+			if end > len(data.Body) {
+				end = len(data.Body)
+			}
+			script = appendEnvelopePush(script, data.Body[i:end])
+		}
+	}
+	return append(script, txscript.OP_ENDIF), nil
+}
+
+// appendEnvelopePush appends a data push. Unlike ScriptBuilder.AddData it never
+// uses the small integer opcodes, which ord counts as a curse.
+func appendEnvelopePush(script []byte, data []byte) []byte {
+	switch n := len(data); {
+	case n == 0:
+		return append(script, txscript.OP_0)
+	case n < txscript.OP_PUSHDATA1:
+		script = append(script, byte(n))
+	case n <= 0xff:
+		script = append(script, txscript.OP_PUSHDATA1, byte(n))
+	default:
+		script = append(script, txscript.OP_PUSHDATA2, byte(n), byte(n>>8))
+	}
+	return append(script, data...)
+}
+
+// inscriptionIdBytes encodes an inscription id <txid>i<index> as the txid
+// followed by the little-endian index without trailing zeros.
+func inscriptionIdBytes(id string) ([]byte, error) {
+	i := strings.LastIndex(id, "i")
+	if i != chainhash.MaxHashStringSize {
+		return nil, ErrInvalidInscriptionId
+	}
+	hash, err := chainhash.NewHashFromStr(id[:i])
+	if err != nil {
+		return nil, ErrInvalidInscriptionId
+	}
+	index, err := strconv.ParseUint(id[i+1:], 10, 32)
+	if err != nil {
+		return nil, ErrInvalidInscriptionId
+	}
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(index))
+	return append(hash.CloneBytes(), bytes.TrimRight(buf[:], "\x00")...), nil
+}
+
+// buildBatchRevealTx puts every inscription in the script of one commit output
+// and builds the reveal tx spending the parents and that output. The parents
+// are returned first and each inscription gets its own output after them.
+func (builder *InscriptionBuilder) buildBatchRevealTx(request *InscriptionRequest, revealOutValue int64) (int64, error) {
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	privateKeys := append([]*btcec.PrivateKey{}, builder.ParentPrivateKeyList...)
+	parentsValue := int64(0)
+	for _, parent := range builder.ParentPrevOutputList {
+		txHash, err := chainhash.NewHashFromStr(parent.TxId)
+		if err != nil {
+			return 0, err
+		}
+		outPoint := wire.NewOutPoint(txHash, parent.VOut)
+		pkScript, err := AddrToPkScript(parent.Address, builder.Network)
+		if err != nil {
+			return 0, err
+		}
+		builder.RevealTxPrevOutputFetcher.AddPrevOut(*outPoint, wire.NewTxOut(parent.Amount, pkScript))
+		in := wire.NewTxIn(outPoint, nil, nil)
+		in.Sequence = DefaultSequenceNum
+		tx.AddTxIn(in)
+		tx.AddTxOut(wire.NewTxOut(parent.Amount, pkScript))
+		parentsValue += parent.Amount
+	}
+
+	var envelopes []byte
+	for i := range request.InscriptionDataList {
+		data := &request.InscriptionDataList[i]
+		// the first inscription lands on the first sat after the parents anyway
+		var pointer *uint64
+		if i > 0 {
+			offset := uint64(parentsValue + int64(i)*revealOutValue)
+			pointer = &offset
+		}
+		envelope, err := buildInscriptionEnvelope(data, pointer)
+		if err != nil {
+			return 0, err
+		}
+		envelopes = append(envelopes, envelope...)
+		pkScript, err := AddrToPkScript(data.RevealAddr, builder.Network)
+		if err != nil {
+			return 0, err
+		}
+		tx.AddTxOut(wire.NewTxOut(revealOutValue, pkScript))
+	}
+	ctx, err := newInscriptionScriptCtxData(builder.Network, request, envelopes)
+	if err != nil {
+		return 0, err
+	}
+	ctx.CommitInputIndex = len(tx.TxIn)
+	in := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+	in.Sequence = DefaultSequenceNum
+	tx.AddTxIn(in)
+
+	// estimate with the parents signed and an empty script path witness
+	estimateTx := tx.Copy()
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	prevOutFetcher.Merge(builder.RevealTxPrevOutputFetcher)
+	prevOutFetcher.AddPrevOut(wire.OutPoint{}, wire.NewTxOut(0, ctx.CommitTxAddressPkScript))
+	if err := Sign(estimateTx, append(privateKeys, ctx.PrivateKey), prevOutFetcher); err != nil {
+		return 0, err
+	}
+	emptySignature := make([]byte, 64)
+	estimateTx.TxIn[ctx.CommitInputIndex].Witness = wire.TxWitness{emptySignature, ctx.InscriptionScript, ctx.ControlBlockWitness}
+	fee := GetTxVirtualSize(btcutil.NewTx(estimateTx)) * request.RevealFeeRate
+
+	prevOutputValue := int64(len(request.InscriptionDataList))*revealOutValue + fee
+	ctx.RevealTxPrevOutput = &wire.TxOut{
+		PkScript: ctx.CommitTxAddressPkScript,
+		Value:    prevOutputValue,
+	}
+	builder.InscriptionTxCtxDataList = []*inscriptionTxCtxData{ctx}
+	builder.RevealTx = []*wire.MsgTx{tx}
+	builder.MustRevealTxFees = []int64{fee}
+	builder.CommitAddrs = []string{ctx.CommitTxAddress}
+	return prevOutputValue, nil
+}
+
 func (builder *InscriptionBuilder) buildCommitTx(commitTxPrevOutputList PrevOutputs, changeAddress string, totalRevealPrevOutputValue, commitFeeRate int64, minChangeValue int64) error {
 	totalSenderAmount := btcutil.Amount(0)
 	tx := wire.NewMsgTx(DefaultTxVersion)
@@ -345,12 +562,19 @@ func (builder *InscriptionBuilder) completeRevealTx() error {
 			Hash:  builder.CommitTx.TxHash(),
 			Index: uint32(i),
 		}, builder.InscriptionTxCtxDataList[i].RevealTxPrevOutput)
-		builder.RevealTx[i].TxIn[0].PreviousOutPoint.Hash = builder.CommitTx.TxHash()
+		builder.RevealTx[i].TxIn[builder.InscriptionTxCtxDataList[i].CommitInputIndex].PreviousOutPoint.Hash = builder.CommitTx.TxHash()
 	}
-	for i := range builder.InscriptionTxCtxDataList {
+	if len(builder.ParentPrevOutputList) > 0 {
+		// only the batch reveal tx spends parents, its commit input is signed below
+		privateKeys := append(append([]*btcec.PrivateKey{}, builder.ParentPrivateKeyList...), builder.InscriptionTxCtxDataList[0].PrivateKey)
+		if err := Sign(builder.RevealTx[0], privateKeys, builder.RevealTxPrevOutputFetcher); err != nil {
+			return err
+		}
+	}
+	for i, ctx := range builder.InscriptionTxCtxDataList {
 		revealTx := builder.RevealTx[i]
 		witnessArray, err := txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(revealTx, builder.RevealTxPrevOutputFetcher),
-			txscript.SigHashDefault, revealTx, 0, builder.RevealTxPrevOutputFetcher, txscript.NewBaseTapLeaf(builder.InscriptionTxCtxDataList[i].InscriptionScript))
+			txscript.SigHashDefault, revealTx, ctx.CommitInputIndex, builder.RevealTxPrevOutputFetcher, txscript.NewBaseTapLeaf(ctx.InscriptionScript))
 		if err != nil {
 			return err
 		}
@@ -358,14 +582,15 @@ func (builder *InscriptionBuilder) completeRevealTx() error {
 		if err != nil {
 			return err
 		}
-		witness := wire.TxWitness{signature.Serialize(), builder.InscriptionTxCtxDataList[i].InscriptionScript, builder.InscriptionTxCtxDataList[i].ControlBlockWitness}
-		builder.RevealTx[i].TxIn[0].Witness = witness
+		witness := wire.TxWitness{signature.Serialize(), ctx.InscriptionScript, ctx.ControlBlockWitness}
+		revealTx.TxIn[ctx.CommitInputIndex].Witness = witness
 	}
 	// check tx max tx wight
+	maxWeight := RelayPolicyFor(builder.Network).MaxStandardTxWeight
 	for i, tx := range builder.RevealTx {
 		revealWeight := GetTransactionWeight(btcutil.NewTx(tx))
-		if revealWeight > MaxStandardTxWeight {
-			return errors.New(fmt.Sprintf("reveal(index %d) transaction weight greater than %d (MAX_STANDARD_TX_WEIGHT): %d", i, MaxStandardTxWeight, revealWeight))
+		if revealWeight > maxWeight {
+			return errors.New(fmt.Sprintf("reveal(index %d) transaction weight greater than %d (MAX_STANDARD_TX_WEIGHT): %d", i, maxWeight, revealWeight))
 		}
 	}
 	return nil
@@ -380,7 +605,22 @@ func Sign(tx *wire.MsgTx, privateKeys []*btcec.PrivateKey, prevOutFetcher *txscr
 		prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
 		txSigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
 		privKey := privateKeys[i]
-		if txscript.IsPayToTaproot(prevOut.PkScript) {
+		if privKey == nil {
+			// signed by the caller
+			continue
+		}
+		if txscript.IsPayToTaproot(prevOut.PkScript) && bytes.Equal(prevOut.PkScript[2:], schnorr.SerializePubKey(privKey.PubKey())) {
+			// an untweaked output key, such as a silent payment output
+			hash, err := txscript.CalcTaprootSignatureHash(txSigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher)
+			if err != nil {
+				return err
+			}
+			sig, err := schnorr.Sign(privKey, hash)
+			if err != nil {
+				return err
+			}
+			in.Witness = wire.TxWitness{sig.Serialize()}
+		} else if txscript.IsPayToTaproot(prevOut.PkScript) {
 			witness, err := txscript.TaprootWitnessSignature(tx, txSigHashes, i, prevOut.Value, prevOut.PkScript, txscript.SigHashDefault, privKey)
 			if err != nil {
 				return err
@@ -453,11 +693,13 @@ func (builder *InscriptionBuilder) CalculateFee() (int64, []int64) {
 	revealTxFees := make([]int64, 0)
 	for _, tx := range builder.RevealTx {
 		revealTxFee := int64(0)
-		for i, in := range tx.TxIn {
+		for _, in := range tx.TxIn {
 			revealTxFee += builder.RevealTxPrevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
-			revealTxFee -= tx.TxOut[i].Value
-			revealTxFees = append(revealTxFees, revealTxFee)
 		}
+		for _, out := range tx.TxOut {
+			revealTxFee -= out.Value
+		}
+		revealTxFees = append(revealTxFees, revealTxFee)
 	}
 	return commitTxFee, revealTxFees
 }
@@ -541,6 +783,12 @@ func getTxVirtualSize(tx *btcutil.Tx) int64 {
 	return (GetTransactionWeight(tx) + (WitnessScaleFactor - 1)) / WitnessScaleFactor
 }
 func InscribeForMPCUnsigned(request *InscriptionRequest, network *chaincfg.Params, unsignedCommitHash, signedCommitTxHash *chainhash.Hash) (*InscribeForMPCRes, error) {
+	if request.Batch || len(request.ParentPrevOutputList) > 0 {
+		return nil, errors.New("batch inscriptions are not supported for mpc")
+	}
+	if err := CheckUtxosSpendable(request.CommitTxPrevOutputList); err != nil {
+		return nil, err
+	}
 
 	wif, err := btcutil.DecodeWIF(request.CommitTxPrevOutputList[0].PrivateKey)
 	if err != nil {
@@ -642,7 +890,7 @@ func InscribeForMPCUnsigned(request *InscriptionRequest, network *chaincfg.Param
 	view, _ := request.CommitTxPrevOutputList.UtxoViewpoint(network)
 	commitFee := GetTxVirtualSizeByView(btcutil.NewTx(estimateTx), view) * request.CommitFeeRate
 	changeValue := totalCommitInValue - totalRevealInValue - commitFee
-	minChangeValue := DefaultMinChangeValue
+	minChangeValue := RelayPolicyFor(network).ChangeThreshold()
 	if request.MinChangeValue > 0 {
 		minChangeValue = request.MinChangeValue
 	}
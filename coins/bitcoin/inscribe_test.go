@@ -172,3 +172,140 @@ func TestInscribeForMPCUnsigned(t *testing.T) {
 	assert.True(t, match)
 
 }
+
+func TestInscribeExtendedFields(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	metadata, err := EncodeCBOR(map[string]interface{}{"name": "okx #1", "traits": []interface{}{"gold", 7}})
+	require.NoError(t, err)
+	delegate := "6fb976ab49dcec017f1e201e84395983204ae1a7c2abf7ced0a85d692e442799i0"
+	pointer := uint64(100)
+	request := &InscriptionRequest{
+		CommitTxPrevOutputList: PrevOutputs{{
+			TxId:       "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26",
+			VOut:       4,
+			Amount:     1142196,
+			Address:    "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+		CommitFeeRate: 2,
+		RevealFeeRate: 2,
+		InscriptionDataList: []InscriptionData{
+			{
+				ContentType:     "text/html",
+				Body:            []byte{0x1b, 0x05, 0x00},
+				ContentEncoding: "br",
+				Metadata:        metadata,
+				Metaprotocol:    "okx",
+				Pointer:         &pointer,
+				RevealAddr:      "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			},
+			{Delegate: delegate, RevealAddr: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+		},
+		ChangeAddress: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+	}
+	txs, err := Inscribe(network, request)
+	require.NoError(t, err)
+	require.Len(t, txs.RevealTxs, 2)
+
+	inscriptions, err := DecodeInscriptionsFromHex(txs.RevealTxs[0])
+	require.NoError(t, err)
+	require.Len(t, inscriptions, 1)
+	inscription := inscriptions[0]
+	assert.Equal(t, "text/html", inscription.ContentType)
+	assert.Equal(t, "br", inscription.ContentEncoding)
+	assert.Equal(t, []byte{0x1b, 0x05, 0x00}, inscription.Body)
+	assert.Equal(t, "okx", inscription.Metaprotocol)
+	assert.Equal(t, pointer, *inscription.Pointer)
+	assert.False(t, inscription.Pushnum)
+	decoded, err := inscription.DecodedMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "okx #1", "traits": []interface{}{"gold", uint64(7)}}, decoded)
+
+	inscriptions, err = DecodeInscriptionsFromHex(txs.RevealTxs[1])
+	require.NoError(t, err)
+	require.Len(t, inscriptions, 1)
+	assert.Equal(t, delegate, inscriptions[0].Delegate)
+	assert.False(t, inscriptions[0].HasBody)
+
+	request.InscriptionDataList[1].Delegate = "6fb976ab49dcec017f1e201e84395983204ae1a7c2abf7ced0a85d692e442799"
+	_, err = Inscribe(network, request)
+	assert.ErrorIs(t, err, ErrInvalidInscriptionId)
+}
+
+func TestInscribeBatchWithParent(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	taprootAddr := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	wif := "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"
+	parentId := "22c8a4869f2aa9ee5994959c0978106130290cda53f6e933a8dda2dcb82508d4i0"
+	parent := &PrevOutput{
+		TxId:       "22c8a4869f2aa9ee5994959c0978106130290cda53f6e933a8dda2dcb82508d4",
+		VOut:       0,
+		Amount:     10000,
+		Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		PrivateKey: wif,
+	}
+	funding := &PrevOutput{
+		TxId:       "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26",
+		VOut:       4,
+		Amount:     1142196,
+		Address:    taprootAddr,
+		PrivateKey: wif,
+	}
+	var dataList []InscriptionData
+	for _, body := range []string{"child 1", "child 2", "child 3"} {
+		dataList = append(dataList, InscriptionData{
+			ContentType: "text/plain;charset=utf-8",
+			Body:        []byte(body),
+			Parents:     []string{parentId},
+			RevealAddr:  taprootAddr,
+		})
+	}
+	request := &InscriptionRequest{
+		CommitTxPrevOutputList: PrevOutputs{funding},
+		ParentPrevOutputList:   PrevOutputs{parent},
+		CommitFeeRate:          2,
+		RevealFeeRate:          2,
+		RevealOutValue:         600,
+		InscriptionDataList:    dataList,
+		ChangeAddress:          taprootAddr,
+	}
+	txs, err := Inscribe(network, request)
+	require.NoError(t, err)
+	require.Len(t, txs.RevealTxs, 1)
+	require.Len(t, txs.CommitAddrs, 1)
+	verifyTxScripts(t, txs.CommitTx, PrevOutputs{funding}, network)
+
+	commitTx, err := NewTxFromHex(txs.CommitTx)
+	require.NoError(t, err)
+	revealTx, err := NewTxFromHex(txs.RevealTxs[0])
+	require.NoError(t, err)
+	verifyTxScripts(t, txs.RevealTxs[0], PrevOutputs{parent, {
+		TxId:    commitTx.TxHash().String(),
+		VOut:    0,
+		Amount:  commitTx.TxOut[0].Value,
+		Address: txs.CommitAddrs[0],
+	}}, network)
+
+	require.Len(t, revealTx.TxIn, 2)
+	require.Len(t, revealTx.TxOut, 4)
+	assert.Equal(t, parent.TxId, revealTx.TxIn[0].PreviousOutPoint.Hash.String())
+	assert.Equal(t, parent.Amount, revealTx.TxOut[0].Value)
+	assert.Equal(t, commitTx.TxOut[0].Value-3*600, txs.RevealTxFees[0])
+
+	inscriptions := DecodeInscriptions(revealTx)
+	require.Len(t, inscriptions, 3)
+	for i, inscription := range inscriptions {
+		assert.Equal(t, 1, inscription.InputIndex)
+		assert.Equal(t, i, inscription.Offset)
+		assert.Equal(t, []string{parentId}, inscription.Parents)
+		assert.Equal(t, dataList[i].Body, inscription.Body)
+		if i == 0 {
+			assert.Nil(t, inscription.Pointer)
+		} else {
+			assert.Equal(t, uint64(parent.Amount+int64(i)*600), *inscription.Pointer)
+		}
+	}
+
+	_, err = InscribeForMPCUnsigned(request, network, nil, nil)
+	assert.Error(t, err)
+}
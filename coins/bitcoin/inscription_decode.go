@@ -0,0 +1,289 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"unicode/utf8"
+)
+
+// Envelope field tags, odd tags may be ignored by indexers while an unknown
+// even tag makes the inscription unbound.
+const (
+	InscriptionTagContentType     = 1
+	InscriptionTagPointer         = 2
+	InscriptionTagParent          = 3
+	InscriptionTagMetadata        = 5
+	InscriptionTagMetaprotocol    = 7
+	InscriptionTagContentEncoding = 9
+	InscriptionTagDelegate        = 11
+	InscriptionTagRune            = 13
+)
+
+// Inscription is an envelope found in a tapscript, as ord parses it.
+type Inscription struct {
+	// InputIndex is the input whose witness holds the envelope, Offset the
+	// position of the envelope among those of the input.
+	InputIndex      int      `json:"inputIndex"`
+	Offset          int      `json:"offset"`
+	ContentType     string   `json:"contentType,omitempty"`
+	ContentEncoding string   `json:"contentEncoding,omitempty"`
+	Body            []byte   `json:"body,omitempty"`
+	Pointer         *uint64  `json:"pointer,omitempty"`
+	Parents         []string `json:"parents,omitempty"`
+	// Metadata is the raw CBOR, see DecodedMetadata.
+	Metadata     []byte `json:"metadata,omitempty"`
+	Metaprotocol string `json:"metaprotocol,omitempty"`
+	Delegate     string `json:"delegate,omitempty"`
+	Rune         []byte `json:"rune,omitempty"`
+
+	HasBody               bool `json:"hasBody"`
+	DuplicateField        bool `json:"duplicateField"`
+	IncompleteField       bool `json:"incompleteField"`
+	UnrecognizedEvenField bool `json:"unrecognizedEvenField"`
+	Pushnum               bool `json:"pushnum"`
+	Stutter               bool `json:"stutter"`
+}
+
+// DecodedMetadata decodes the CBOR metadata, nil when there is none.
+func (i *Inscription) DecodedMetadata() (interface{}, error) {
+	if len(i.Metadata) == 0 {
+		return nil, nil
+	}
+	return DecodeCBOR(i.Metadata)
+}
+
+// DecodeInscriptionsFromHex decodes the inscriptions of a hex encoded tx.
+func DecodeInscriptionsFromHex(txHex string) ([]*Inscription, error) {
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeInscriptions(tx), nil
+}
+
+// DecodeInscriptions returns the envelopes of every script path spend of tx in
+// input order.
+func DecodeInscriptions(tx *wire.MsgTx) []*Inscription {
+	var inscriptions []*Inscription
+	for i, in := range tx.TxIn {
+		tapscript := WitnessTapscript(in.Witness)
+		if tapscript == nil {
+			continue
+		}
+		for _, inscription := range DecodeTapscriptInscriptions(tapscript) {
+			inscription.InputIndex = i
+			inscriptions = append(inscriptions, inscription)
+		}
+	}
+	return inscriptions
+}
+
+// WitnessTapscript returns the script of a script path witness, the element
+// before the control block and the optional annex.
+func WitnessTapscript(witness wire.TxWitness) []byte {
+	pos := 2
+	if len(witness) >= 2 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+		pos = 3
+	}
+	if len(witness) < pos {
+		return nil
+	}
+	return witness[len(witness)-pos]
+}
+
+// DecodeTapscriptInscriptions parses the OP_FALSE OP_IF "ord" ... OP_ENDIF
+// envelopes of a tapscript such as those CreateInscriptionScript writes.
+func DecodeTapscriptInscriptions(tapscript []byte) []*Inscription {
+	tokenizer := txscript.MakeScriptTokenizer(0, tapscript)
+	var inscriptions []*Inscription
+	stuttered := false
+	for tokenizer.Next() {
+		if !isPush(&tokenizer, nil) {
+			continue
+		}
+		payload, pushnum, stutter, ok := envelopePayload(&tokenizer)
+		if !ok {
+			stuttered = stutter
+			continue
+		}
+		inscription := parseEnvelope(payload)
+		inscription.Offset = len(inscriptions)
+		inscription.Pushnum = pushnum
+		inscription.Stutter = stuttered
+		inscriptions = append(inscriptions, inscription)
+		stuttered = false
+	}
+	if tokenizer.Err() != nil {
+		return nil
+	}
+	return inscriptions
+}
+
+// isPush reports whether the current instruction pushes data, pushes of the
+// small integer opcodes excluded.
+func isPush(tokenizer *txscript.ScriptTokenizer, data []byte) bool {
+	return tokenizer.Opcode() <= txscript.OP_PUSHDATA4 && bytes.Equal(tokenizer.Data(), data)
+}
+
+// envelopePayload reads the pushes of an envelope whose OP_FALSE was just read.
+// When it is not an envelope, stutter tells if OP_FALSE follows.
+func envelopePayload(tokenizer *txscript.ScriptTokenizer) (payload [][]byte, pushnum, stutter, ok bool) {
+	accept := func(match func(*txscript.ScriptTokenizer) bool) bool {
+		next := *tokenizer
+		if !next.Next() || !match(&next) {
+			return false
+		}
+		tokenizer.Next()
+		return true
+	}
+	isIf := func(t *txscript.ScriptTokenizer) bool { return t.Opcode() == txscript.OP_IF }
+	isOrd := func(t *txscript.ScriptTokenizer) bool { return isPush(t, []byte(OrdPrefix)) }
+	if !accept(isIf) || !accept(isOrd) {
+		next := *tokenizer
+		return nil, false, next.Next() && isPush(&next, nil), false
+	}
+	for tokenizer.Next() {
+		opcode := tokenizer.Opcode()
+		switch {
+		case opcode == txscript.OP_ENDIF:
+			return payload, pushnum, false, true
+		case opcode == txscript.OP_1NEGATE:
+			pushnum = true
+			payload = append(payload, []byte{0x81})
+		case opcode >= txscript.OP_1 && opcode <= txscript.OP_16:
+			pushnum = true
+			payload = append(payload, []byte{opcode - txscript.OP_1 + 1})
+		case opcode <= txscript.OP_PUSHDATA4:
+			payload = append(payload, append([]byte{}, tokenizer.Data()...))
+		default:
+			return nil, false, false, false
+		}
+	}
+	return nil, false, false, false
+}
+
+func parseEnvelope(payload [][]byte) *Inscription {
+	inscription := &Inscription{}
+	bodyIndex := -1
+	for i := 0; i < len(payload); i += 2 {
+		if len(payload[i]) == 0 {
+			bodyIndex = i
+			break
+		}
+	}
+	fieldsEnd := len(payload)
+	if bodyIndex >= 0 {
+		fieldsEnd = bodyIndex
+		inscription.HasBody = true
+		for _, chunk := range payload[bodyIndex+1:] {
+			inscription.Body = append(inscription.Body, chunk...)
+		}
+	}
+
+	fields := make(map[string][][]byte)
+	var tags []string
+	for i := 0; i < fieldsEnd; i += 2 {
+		if i+1 >= fieldsEnd {
+			inscription.IncompleteField = true
+			break
+		}
+		tag := string(payload[i])
+		if _, ok := fields[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		fields[tag] = append(fields[tag], payload[i+1])
+	}
+	for _, values := range fields {
+		if len(values) > 1 {
+			inscription.DuplicateField = true
+		}
+	}
+	take := func(tag byte) []byte {
+		key := string([]byte{tag})
+		values := fields[key]
+		if len(values) == 0 {
+			return nil
+		}
+		if fields[key] = values[1:]; len(fields[key]) == 0 {
+			delete(fields, key)
+		}
+		return values[0]
+	}
+	text := func(b []byte) string {
+		if !utf8.Valid(b) {
+			return ""
+		}
+		return string(b)
+	}
+
+	inscription.ContentEncoding = text(take(InscriptionTagContentEncoding))
+	inscription.ContentType = text(take(InscriptionTagContentType))
+	if delegate, ok := inscriptionIdField(take(InscriptionTagDelegate)); ok {
+		inscription.Delegate = delegate
+	}
+	// metadata is split into pushes of at most 520 bytes
+	metadataKey := string([]byte{InscriptionTagMetadata})
+	for _, chunk := range fields[metadataKey] {
+		inscription.Metadata = append(inscription.Metadata, chunk...)
+	}
+	delete(fields, metadataKey)
+	inscription.Metaprotocol = text(take(InscriptionTagMetaprotocol))
+	parentKey := string([]byte{InscriptionTagParent})
+	for _, parent := range fields[parentKey] {
+		if id, ok := inscriptionIdField(parent); ok {
+			inscription.Parents = append(inscription.Parents, id)
+		}
+	}
+	delete(fields, parentKey)
+	if pointer := take(InscriptionTagPointer); pointer != nil {
+		inscription.Pointer = inscriptionPointer(pointer)
+	}
+	inscription.Rune = take(InscriptionTagRune)
+
+	for _, tag := range tags {
+		if _, ok := fields[tag]; ok && len(tag) > 0 && tag[0]%2 == 0 {
+			inscription.UnrecognizedEvenField = true
+		}
+	}
+	return inscription
+}
+
+// inscriptionPointer decodes a little-endian pointer, nil when it does not fit
+// in 64 bits.
+func inscriptionPointer(b []byte) *uint64 {
+	if len(b) > 8 {
+		for _, c := range b[8:] {
+			if c != 0 {
+				return nil
+			}
+		}
+		b = b[:8]
+	}
+	var buf [8]byte
+	copy(buf[:], b)
+	pointer := binary.LittleEndian.Uint64(buf[:])
+	return &pointer
+}
+
+// inscriptionIdField decodes a txid followed by a little-endian index without
+// trailing zeros into the <txid>i<index> form.
+func inscriptionIdField(b []byte) (string, bool) {
+	if len(b) < chainhash.HashSize || len(b) > chainhash.HashSize+4 {
+		return "", false
+	}
+	index := b[chainhash.HashSize:]
+	if len(index) > 0 && index[len(index)-1] == 0 {
+		return "", false
+	}
+	var buf [4]byte
+	copy(buf[:], index)
+	hash, err := chainhash.NewHash(b[:chainhash.HashSize])
+	if err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("%si%d", hash, binary.LittleEndian.Uint32(buf[:])), true
+}
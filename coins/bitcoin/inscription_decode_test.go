@@ -0,0 +1,149 @@
+package bitcoin
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin/brc20"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecodeInscriptionsInscribe(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	bodies := [][]byte{
+		[]byte(`{"p":"brc-20","op":"mint","tick":"xcvb","amt":"100"}`),
+		bytes.Repeat([]byte{0xab}, 1200),
+	}
+	request := &InscriptionRequest{
+		CommitTxPrevOutputList: PrevOutputs{{
+			TxId:       "aa09fa48dda0e2b7de1843c3db8d3f2d7f2cbe0f83331a125b06516a348abd26",
+			VOut:       4,
+			Amount:     1142196,
+			Address:    "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+		CommitFeeRate: 2,
+		RevealFeeRate: 2,
+		InscriptionDataList: []InscriptionData{
+			{ContentType: "text/plain;charset=utf-8", Body: bodies[0], RevealAddr: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+			{ContentType: "application/octet-stream", Body: bodies[1], RevealAddr: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+		},
+		ChangeAddress: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+	}
+	txs, err := Inscribe(network, request)
+	require.NoError(t, err)
+
+	commitInscriptions, err := DecodeInscriptionsFromHex(txs.CommitTx)
+	require.NoError(t, err)
+	assert.Empty(t, commitInscriptions)
+	for i, revealTx := range txs.RevealTxs {
+		inscriptions, err := DecodeInscriptionsFromHex(revealTx)
+		require.NoError(t, err)
+		require.Len(t, inscriptions, 1)
+		inscription := inscriptions[0]
+		assert.Equal(t, 0, inscription.InputIndex)
+		assert.Equal(t, request.InscriptionDataList[i].ContentType, inscription.ContentType)
+		assert.Equal(t, bodies[i], inscription.Body)
+		assert.True(t, inscription.HasBody)
+		assert.False(t, inscription.DuplicateField || inscription.IncompleteField || inscription.UnrecognizedEvenField)
+	}
+}
+
+func TestDecodeTapscriptInscriptionsBrc20(t *testing.T) {
+	privateKey, err := btcutil.DecodeWIF("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.NoError(t, err)
+	body := []byte(`{"p":"brc-20","op":"transfer","tick":"ordi","amt":"1"}`)
+	script, err := brc20.CreateInscriptionScript(privateKey.PrivKey, "text/plain;charset=utf-8", body)
+	require.NoError(t, err)
+	inscriptions := DecodeTapscriptInscriptions(script)
+	require.Len(t, inscriptions, 1)
+	assert.Equal(t, "text/plain;charset=utf-8", inscriptions[0].ContentType)
+	assert.Equal(t, body, inscriptions[0].Body)
+}
+
+func TestDecodeTapscriptInscriptionsFields(t *testing.T) {
+	parentHash := chainhash.DoubleHashH([]byte("parent"))
+	parent := append(parentHash.CloneBytes(), 1)
+	delegate := parentHash.CloneBytes()
+	metadata := mustDecodeHex(t, "a2646e616d65646f6b78216674726169747382016161")
+
+	builder := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte(OrdPrefix)).
+		AddData([]byte{InscriptionTagContentType}).AddData([]byte("image/png")).
+		AddData([]byte{InscriptionTagPointer}).AddData([]byte{0x22, 0x02}).
+		AddData([]byte{InscriptionTagParent}).AddData(parent).
+		AddData([]byte{InscriptionTagParent}).AddData(delegate).
+		AddData([]byte{InscriptionTagMetadata}).AddData(metadata[:10]).
+		AddData([]byte{InscriptionTagMetadata}).AddData(metadata[10:]).
+		AddData([]byte{InscriptionTagMetaprotocol}).AddData([]byte("brc-20")).
+		AddData([]byte{InscriptionTagContentEncoding}).AddData([]byte("br")).
+		AddData([]byte{InscriptionTagDelegate}).AddData(delegate).
+		AddOp(txscript.OP_0).AddData([]byte("body")).AddData([]byte("!")).
+		AddOp(txscript.OP_ENDIF).
+		// a second envelope with an unknown even tag and a small integer push
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte(OrdPrefix)).
+		AddOp(txscript.OP_1).AddData([]byte("text/plain")).
+		AddData([]byte{22}).AddData([]byte{1}).
+		AddData([]byte{InscriptionTagMetaprotocol}).
+		AddOp(txscript.OP_ENDIF)
+	script, err := builder.Script()
+	require.NoError(t, err)
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{{0x01}, {0x02}}))
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, wire.TxWitness{make([]byte, 64), script, make([]byte, 33), {txscript.TaprootAnnexTag}}))
+	inscriptions := DecodeInscriptions(tx)
+	require.Len(t, inscriptions, 2)
+
+	first := inscriptions[0]
+	assert.Equal(t, 1, first.InputIndex)
+	assert.Equal(t, 0, first.Offset)
+	assert.Equal(t, "image/png", first.ContentType)
+	assert.Equal(t, "br", first.ContentEncoding)
+	assert.Equal(t, []byte("body!"), first.Body)
+	assert.Equal(t, uint64(0x0222), *first.Pointer)
+	assert.Equal(t, []string{parentHash.String() + "i1", parentHash.String() + "i0"}, first.Parents)
+	assert.Equal(t, parentHash.String()+"i0", first.Delegate)
+	assert.Equal(t, "brc-20", first.Metaprotocol)
+	assert.Equal(t, metadata, first.Metadata)
+	decoded, err := first.DecodedMetadata()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "okx!", "traits": []interface{}{uint64(1), "a"}}, decoded)
+	assert.True(t, first.DuplicateField)
+	assert.False(t, first.UnrecognizedEvenField)
+	assert.False(t, first.Stutter)
+
+	second := inscriptions[1]
+	assert.Equal(t, 1, second.Offset)
+	assert.Equal(t, "text/plain", second.ContentType)
+	assert.False(t, second.HasBody)
+	assert.True(t, second.Pushnum)
+	assert.True(t, second.Stutter)
+	assert.True(t, second.UnrecognizedEvenField)
+	assert.True(t, second.IncompleteField)
+
+	// a pointer beyond 64 bits and a parent with a padded index are dropped
+	script, err = txscript.NewScriptBuilder().
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte(OrdPrefix)).
+		AddData([]byte{InscriptionTagPointer}).AddData(mustDecodeHex(t, "000000000000000001")).
+		AddData([]byte{InscriptionTagParent}).AddData(append(parentHash.CloneBytes(), 1, 0)).
+		AddOp(txscript.OP_ENDIF).Script()
+	require.NoError(t, err)
+	inscriptions = DecodeTapscriptInscriptions(script)
+	require.Len(t, inscriptions, 1)
+	assert.Nil(t, inscriptions[0].Pointer)
+	assert.Empty(t, inscriptions[0].Parents)
+
+	// an opcode inside the envelope discards it
+	script, err = txscript.NewScriptBuilder().
+		AddOp(txscript.OP_FALSE).AddOp(txscript.OP_IF).AddData([]byte(OrdPrefix)).
+		AddOp(txscript.OP_DROP).AddOp(txscript.OP_ENDIF).Script()
+	require.NoError(t, err)
+	assert.Empty(t, DecodeTapscriptInscriptions(script))
+	assert.Empty(t, DecodeTapscriptInscriptions([]byte{txscript.OP_PUSHDATA1}))
+}
@@ -0,0 +1,640 @@
+/*
+Package miniscript implements miniscript for P2WSH and tapscript: parsing,
+type checking, script generation, satisfaction and a small policy compiler.
+
+See https://bitcoin.sipa.be/miniscript/ for the language reference.
+*/
+package miniscript
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"strconv"
+	"strings"
+)
+
+type Context int
+
+const (
+	P2WSH Context = iota
+	Tapscript
+)
+
+const (
+	// MaxStandardP2WSHScriptSize is the policy limit of a P2WSH witness script.
+	MaxStandardP2WSHScriptSize = 3600
+	// MaxP2WSHMultiKeys is the limit of keys in multi().
+	MaxP2WSHMultiKeys = 20
+	// MaxTapscriptMultiAKeys is the limit of keys in multi_a().
+	MaxTapscriptMultiAKeys = 999
+
+	locktimeThreshold      = 500000000
+	sequenceLocktimeIsTime = 1 << 22
+)
+
+var (
+	ErrInvalidExpression = errors.New("invalid miniscript expression")
+	ErrTypeCheck         = errors.New("miniscript type check failed")
+	ErrTimelockMix       = errors.New("miniscript mixes height and time timelocks")
+	ErrNotTopLevel       = errors.New("miniscript is not of type B")
+)
+
+// wrapper letters, applied right to left
+const wrappers = "asctdvjnlu"
+
+type basicType byte
+
+const (
+	typeB basicType = 'B'
+	typeV basicType = 'V'
+	typeK basicType = 'K'
+	typeW basicType = 'W'
+)
+
+type nodeType struct {
+	base basicType
+	z    bool // consumes exactly 0 stack elements
+	o    bool // consumes exactly 1 stack element
+	n    bool // the top stack element is never empty on satisfaction
+	d    bool // has a dissatisfaction
+	u    bool // leaves exactly 1 on the stack when satisfied
+
+	csvHeight, csvTime, cltvHeight, cltvTime bool
+}
+
+func (t nodeType) String() string {
+	var sb strings.Builder
+	sb.WriteByte(byte(t.base))
+	for _, p := range []struct {
+		set  bool
+		name byte
+	}{{t.z, 'z'}, {t.o, 'o'}, {t.n, 'n'}, {t.d, 'd'}, {t.u, 'u'}} {
+		if p.set {
+			sb.WriteByte(p.name)
+		}
+	}
+	return sb.String()
+}
+
+func (t nodeType) mixesWith(o nodeType) bool {
+	return (t.csvHeight && o.csvTime) || (t.csvTime && o.csvHeight) ||
+		(t.cltvHeight && o.cltvTime) || (t.cltvTime && o.cltvHeight)
+}
+
+func (t *nodeType) addTimelocks(o nodeType) {
+	t.csvHeight = t.csvHeight || o.csvHeight
+	t.csvTime = t.csvTime || o.csvTime
+	t.cltvHeight = t.cltvHeight || o.cltvHeight
+	t.cltvTime = t.cltvTime || o.cltvTime
+}
+
+type node struct {
+	fragment string
+	subs     []*node
+	keys     [][]byte
+	k        uint32 // threshold or timelock value
+	hash     []byte
+	typ      nodeType
+}
+
+// Miniscript is a type checked miniscript expression for a script context.
+type Miniscript struct {
+	root *node
+	ctx  Context
+}
+
+// Parse parses and type checks a miniscript expression, keys are hex encoded,
+// 33 bytes in P2WSH and 32 bytes x-only in tapscript.
+func Parse(expr string, ctx Context) (*Miniscript, error) {
+	root, err := parseNode(strings.Join(strings.Fields(expr), ""), ctx)
+	if err != nil {
+		return nil, err
+	}
+	if root.typ.base != typeB {
+		return nil, ErrNotTopLevel
+	}
+	m := &Miniscript{root: root, ctx: ctx}
+	if ctx == P2WSH {
+		script, err := m.Script()
+		if err != nil {
+			return nil, err
+		}
+		if len(script) > MaxStandardP2WSHScriptSize {
+			return nil, fmt.Errorf("witness script is %d bytes, larger than %d", len(script), MaxStandardP2WSHScriptSize)
+		}
+	}
+	return m, nil
+}
+
+func (m *Miniscript) String() string {
+	return m.root.String()
+}
+
+// Type returns the basic type and properties, "Bondu" for example.
+func (m *Miniscript) Type() string {
+	return m.root.typ.String()
+}
+
+func (m *Miniscript) Context() Context {
+	return m.ctx
+}
+
+// Keys returns the keys in the order they appear in the script.
+func (m *Miniscript) Keys() [][]byte {
+	var keys [][]byte
+	m.root.walk(func(n *node) {
+		keys = append(keys, n.keys...)
+	})
+	return keys
+}
+
+func (n *node) walk(fn func(n *node)) {
+	fn(n)
+	for _, sub := range n.subs {
+		sub.walk(fn)
+	}
+}
+
+// splitArgs splits s on the commas that are not nested in parentheses.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidExpression)
+			}
+		case ',':
+			if depth == 0 {
+				args = append(args, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("%w: unbalanced parentheses", ErrInvalidExpression)
+	}
+	return append(args, s[start:]), nil
+}
+
+func splitCall(s string) (string, []string, error) {
+	open := strings.IndexByte(s, '(')
+	if open < 0 {
+		return s, nil, nil
+	}
+	if open == 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, fmt.Errorf("%w: %s", ErrInvalidExpression, s)
+	}
+	args, err := splitArgs(s[open+1 : len(s)-1])
+	if err != nil {
+		return "", nil, err
+	}
+	return s[:open], args, nil
+}
+
+func parseNode(s string, ctx Context) (*node, error) {
+	open := strings.IndexByte(s, '(')
+	if colon := strings.IndexByte(s, ':'); colon > 0 && (open < 0 || colon < open) {
+		letters := s[:colon]
+		for _, c := range letters {
+			if !strings.ContainsRune(wrappers, c) {
+				return nil, fmt.Errorf("%w: unknown wrapper %c", ErrInvalidExpression, c)
+			}
+		}
+		n, err := parseNode(s[colon+1:], ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(letters) - 1; i >= 0; i-- {
+			if n, err = wrap(letters[i], n, ctx); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+
+	name, args, err := splitCall(s)
+	if err != nil {
+		return nil, err
+	}
+	n := &node{fragment: name}
+	switch name {
+	case "0", "1":
+		if args != nil {
+			return nil, fmt.Errorf("%w: %s takes no arguments", ErrInvalidExpression, name)
+		}
+	case "pk", "pkh", "pk_k", "pk_h":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes one key", ErrInvalidExpression, name)
+		}
+		key, err := parseKey(args[0], ctx)
+		if err != nil {
+			return nil, err
+		}
+		n.keys = [][]byte{key}
+		if name == "pk" || name == "pkh" {
+			n.fragment = map[string]string{"pk": "pk_k", "pkh": "pk_h"}[name]
+			if err := n.computeType(ctx); err != nil {
+				return nil, err
+			}
+			return newNode("c", ctx, n)
+		}
+	case "older", "after":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes one value", ErrInvalidExpression, name)
+		}
+		v, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || v < 1 || v >= 1<<31 {
+			return nil, fmt.Errorf("%w: invalid %s value %s", ErrInvalidExpression, name, args[0])
+		}
+		n.k = uint32(v)
+	case "sha256", "hash256", "ripemd160", "hash160":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("%w: %s() takes one hash", ErrInvalidExpression, name)
+		}
+		size := 32
+		if name == "ripemd160" || name == "hash160" {
+			size = 20
+		}
+		h, err := hex.DecodeString(args[0])
+		if err != nil || len(h) != size {
+			return nil, fmt.Errorf("%w: invalid %s hash %s", ErrInvalidExpression, name, args[0])
+		}
+		n.hash = h
+	case "andor", "and_v", "and_b", "and_n", "or_b", "or_c", "or_d", "or_i":
+		want := 2
+		if name == "andor" {
+			want = 3
+		}
+		if len(args) != want {
+			return nil, fmt.Errorf("%w: %s() takes %d arguments", ErrInvalidExpression, name, want)
+		}
+		for _, arg := range args {
+			sub, err := parseNode(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			n.subs = append(n.subs, sub)
+		}
+		if name == "and_n" {
+			n.fragment = "andor"
+			n.subs = append(n.subs, &node{fragment: "0"})
+			if err := n.subs[2].computeType(ctx); err != nil {
+				return nil, err
+			}
+		}
+	case "thresh", "multi", "multi_a":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: %s() needs a threshold and arguments", ErrInvalidExpression, name)
+		}
+		k, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || k < 1 || int(k) > len(args)-1 {
+			return nil, fmt.Errorf("%w: invalid %s threshold %s", ErrInvalidExpression, name, args[0])
+		}
+		n.k = uint32(k)
+		for _, arg := range args[1:] {
+			if name == "thresh" {
+				sub, err := parseNode(arg, ctx)
+				if err != nil {
+					return nil, err
+				}
+				n.subs = append(n.subs, sub)
+				continue
+			}
+			key, err := parseKey(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			n.keys = append(n.keys, key)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown fragment %s", ErrInvalidExpression, name)
+	}
+	if err := n.computeType(ctx); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func parseKey(s string, ctx Context) ([]byte, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid key %s", ErrInvalidExpression, s)
+	}
+	if ctx == Tapscript {
+		if len(b) == btcec.PubKeyBytesLenCompressed {
+			b = b[1:]
+		}
+		if _, err := schnorr.ParsePubKey(b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	if len(b) != btcec.PubKeyBytesLenCompressed {
+		return nil, fmt.Errorf("%w: P2WSH keys must be compressed", ErrInvalidExpression)
+	}
+	if _, err := btcec.ParsePubKey(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// wrap applies a single wrapper letter to n, t, l and u are rewritten to the fragments they alias.
+func wrap(letter byte, n *node, ctx Context) (*node, error) {
+	switch letter {
+	case 't':
+		one := &node{fragment: "1"}
+		_ = one.computeType(ctx)
+		return newNode("and_v", ctx, n, one)
+	case 'l':
+		zero := &node{fragment: "0"}
+		_ = zero.computeType(ctx)
+		return newNode("or_i", ctx, zero, n)
+	case 'u':
+		zero := &node{fragment: "0"}
+		_ = zero.computeType(ctx)
+		return newNode("or_i", ctx, n, zero)
+	}
+	return newNode(string(letter), ctx, n)
+}
+
+func newNode(fragment string, ctx Context, subs ...*node) (*node, error) {
+	n := &node{fragment: fragment, subs: subs}
+	if err := n.computeType(ctx); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func isWrapper(fragment string) bool {
+	return len(fragment) == 1 && strings.Contains(wrappers, fragment)
+}
+
+func typeError(fragment, reason string) error {
+	return fmt.Errorf("%w: %s %s", ErrTypeCheck, fragment, reason)
+}
+
+// computeType derives the type of n from its already typed children.
+func (n *node) computeType(ctx Context) error {
+	var t nodeType
+	subs := make([]nodeType, len(n.subs))
+	for i, sub := range n.subs {
+		subs[i] = sub.typ
+	}
+	switch n.fragment {
+	case "0":
+		t = nodeType{base: typeB, z: true, u: true, d: true}
+	case "1":
+		t = nodeType{base: typeB, z: true, u: true}
+	case "pk_k":
+		t = nodeType{base: typeK, o: true, n: true, d: true, u: true}
+	case "pk_h":
+		t = nodeType{base: typeK, n: true, d: true, u: true}
+	case "older":
+		t = nodeType{base: typeB, z: true}
+		if n.k&sequenceLocktimeIsTime != 0 {
+			t.csvTime = true
+		} else {
+			t.csvHeight = true
+		}
+	case "after":
+		t = nodeType{base: typeB, z: true}
+		if n.k >= locktimeThreshold {
+			t.cltvTime = true
+		} else {
+			t.cltvHeight = true
+		}
+	case "sha256", "hash256", "ripemd160", "hash160":
+		t = nodeType{base: typeB, o: true, n: true, d: true, u: true}
+	case "multi":
+		if ctx != P2WSH {
+			return typeError("multi", "is only valid in P2WSH, use multi_a")
+		}
+		if len(n.keys) > MaxP2WSHMultiKeys {
+			return typeError("multi", "has too many keys")
+		}
+		t = nodeType{base: typeB, n: true, d: true, u: true}
+	case "multi_a":
+		if ctx != Tapscript {
+			return typeError("multi_a", "is only valid in tapscript, use multi")
+		}
+		if len(n.keys) > MaxTapscriptMultiAKeys {
+			return typeError("multi_a", "has too many keys")
+		}
+		t.base, t.d, t.u = typeB, true, true
+	case "a":
+		x := subs[0]
+		if x.base != typeB {
+			return typeError("a:", "requires a B argument")
+		}
+		t.base, t.d, t.u = typeW, x.d, x.u
+	case "s":
+		x := subs[0]
+		if x.base != typeB || !x.o {
+			return typeError("s:", "requires a Bo argument")
+		}
+		t.base, t.d, t.u = typeW, x.d, x.u
+	case "c":
+		x := subs[0]
+		if x.base != typeK {
+			return typeError("c:", "requires a K argument")
+		}
+		t.base, t.o, t.n, t.d, t.u = typeB, x.o, x.n, x.d, true
+	case "d":
+		x := subs[0]
+		if x.base != typeV || !x.z {
+			return typeError("d:", "requires a Vz argument")
+		}
+		t.base, t.o, t.n, t.d, t.u = typeB, true, true, true, ctx == Tapscript
+	case "v":
+		x := subs[0]
+		if x.base != typeB {
+			return typeError("v:", "requires a B argument")
+		}
+		t.base, t.z, t.o, t.n = typeV, x.z, x.o, x.n
+	case "j":
+		x := subs[0]
+		if x.base != typeB || !x.n {
+			return typeError("j:", "requires a Bn argument")
+		}
+		t.base, t.o, t.n, t.d, t.u = typeB, x.o, true, true, x.u
+	case "n":
+		x := subs[0]
+		if x.base != typeB {
+			return typeError("n:", "requires a B argument")
+		}
+		t.base, t.z, t.o, t.n, t.d, t.u = typeB, x.z, x.o, x.n, x.d, true
+	case "andor":
+		x, y, z := subs[0], subs[1], subs[2]
+		if x.base != typeB || !x.d || !x.u {
+			return typeError("andor", "requires a Bdu first argument")
+		}
+		if y.base != z.base || y.base == typeW {
+			return typeError("andor", "requires B, K or V arguments of the same type")
+		}
+		if x.mixesWith(y) {
+			return ErrTimelockMix
+		}
+		t.base = y.base
+		t.z = x.z && y.z && z.z
+		t.o = (x.z && y.o && z.o) || (x.o && y.z && z.z)
+		t.u = y.u && z.u
+		t.d = z.d
+	case "and_v":
+		x, y := subs[0], subs[1]
+		if x.base != typeV || y.base == typeW {
+			return typeError("and_v", "requires a V first argument and a B, K or V second argument")
+		}
+		if x.mixesWith(y) {
+			return ErrTimelockMix
+		}
+		t.base = y.base
+		t.z = x.z && y.z
+		t.o = (x.z && y.o) || (x.o && y.z)
+		t.n = x.n || (x.z && y.n)
+		t.u = y.u
+	case "and_b":
+		x, y := subs[0], subs[1]
+		if x.base != typeB || y.base != typeW {
+			return typeError("and_b", "requires B and W arguments")
+		}
+		if x.mixesWith(y) {
+			return ErrTimelockMix
+		}
+		t.base = typeB
+		t.z = x.z && y.z
+		t.o = (x.z && y.o) || (x.o && y.z)
+		t.n = x.n || (x.z && y.n)
+		t.d = x.d && y.d
+		t.u = true
+	case "or_b":
+		x, z := subs[0], subs[1]
+		if x.base != typeB || !x.d || z.base != typeW || !z.d {
+			return typeError("or_b", "requires Bd and Wd arguments")
+		}
+		t.base = typeB
+		t.z = x.z && z.z
+		t.o = (x.z && z.o) || (x.o && z.z)
+		t.d, t.u = true, true
+	case "or_c":
+		x, z := subs[0], subs[1]
+		if x.base != typeB || !x.d || !x.u || z.base != typeV {
+			return typeError("or_c", "requires Bdu and V arguments")
+		}
+		t.base = typeV
+		t.z = x.z && z.z
+		t.o = x.o && z.z
+	case "or_d":
+		x, z := subs[0], subs[1]
+		if x.base != typeB || !x.d || !x.u || z.base != typeB {
+			return typeError("or_d", "requires Bdu and B arguments")
+		}
+		t.base = typeB
+		t.z = x.z && z.z
+		t.o = x.o && z.z
+		t.d, t.u = z.d, z.u
+	case "or_i":
+		x, z := subs[0], subs[1]
+		if x.base != z.base || x.base == typeW {
+			return typeError("or_i", "requires B, K or V arguments of the same type")
+		}
+		t.base = x.base
+		t.o = x.z && z.z
+		t.u = x.u && z.u
+		t.d = x.d || z.d
+	case "thresh":
+		zCount, oCount := 0, 0
+		for i, x := range subs {
+			if i == 0 && (x.base != typeB || !x.d || !x.u) {
+				return typeError("thresh", "requires a Bdu first argument")
+			}
+			if i > 0 && (x.base != typeW || !x.d || !x.u) {
+				return typeError("thresh", "requires Wdu arguments after the first")
+			}
+			if n.k > 1 {
+				for _, y := range subs[:i] {
+					if x.mixesWith(y) {
+						return ErrTimelockMix
+					}
+				}
+			}
+			if x.z {
+				zCount++
+			} else if x.o {
+				oCount++
+			}
+		}
+		t.base = typeB
+		t.z = zCount == len(subs)
+		t.o = zCount == len(subs)-1 && oCount == 1
+		t.d, t.u = true, true
+	default:
+		return fmt.Errorf("%w: unknown fragment %s", ErrInvalidExpression, n.fragment)
+	}
+	for _, sub := range subs {
+		t.addTimelocks(sub)
+	}
+	n.typ = t
+	return nil
+}
+
+// wrapper returns the wrapper letter n is printed with and the wrapped node,
+// including the t, l and u aliases.
+func (n *node) wrapper() (string, *node) {
+	switch {
+	case isWrapper(n.fragment):
+		return n.fragment, n.subs[0]
+	case n.fragment == "or_i" && n.subs[0].fragment == "0":
+		return "l", n.subs[1]
+	case n.fragment == "or_i" && n.subs[1].fragment == "0":
+		return "u", n.subs[0]
+	case n.fragment == "and_v" && n.subs[1].fragment == "1":
+		return "t", n.subs[0]
+	}
+	return "", nil
+}
+
+// isKeyAlias reports whether n prints as pk() or pkh().
+func (n *node) isKeyAlias() bool {
+	return n.fragment == "c" && (n.subs[0].fragment == "pk_k" || n.subs[0].fragment == "pk_h")
+}
+
+func (n *node) String() string {
+	if n.isKeyAlias() {
+		name := map[string]string{"pk_k": "pk", "pk_h": "pkh"}[n.subs[0].fragment]
+		return name + "(" + hex.EncodeToString(n.subs[0].keys[0]) + ")"
+	}
+	if letter, sub := n.wrapper(); sub != nil {
+		if subLetter, _ := sub.wrapper(); subLetter != "" && !sub.isKeyAlias() {
+			return letter + sub.String()
+		}
+		return letter + ":" + sub.String()
+	}
+	var args []string
+	switch n.fragment {
+	case "0", "1":
+		return n.fragment
+	case "pk_k", "pk_h":
+		args = []string{hex.EncodeToString(n.keys[0])}
+	case "older", "after":
+		args = []string{strconv.FormatUint(uint64(n.k), 10)}
+	case "sha256", "hash256", "ripemd160", "hash160":
+		args = []string{hex.EncodeToString(n.hash)}
+	case "thresh", "multi", "multi_a":
+		args = []string{strconv.FormatUint(uint64(n.k), 10)}
+		for _, key := range n.keys {
+			args = append(args, hex.EncodeToString(key))
+		}
+	}
+	for _, sub := range n.subs {
+		args = append(args, sub.String())
+	}
+	return n.fragment + "(" + strings.Join(args, ",") + ")"
+}
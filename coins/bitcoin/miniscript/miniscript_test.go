@@ -0,0 +1,240 @@
+package miniscript
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func testKeys(n int) ([]*btcec.PrivateKey, []string, []string) {
+	var privKeys []*btcec.PrivateKey
+	var pubKeys, xOnly []string
+	for i := 1; i <= n; i++ {
+		b := make([]byte, 32)
+		b[31] = byte(i)
+		privKey, _ := btcec.PrivKeyFromBytes(b)
+		privKeys = append(privKeys, privKey)
+		pubKeys = append(pubKeys, hex.EncodeToString(privKey.PubKey().SerializeCompressed()))
+		xOnly = append(xOnly, hex.EncodeToString(schnorr.SerializePubKey(privKey.PubKey())))
+	}
+	return privKeys, pubKeys, xOnly
+}
+
+func TestParseAndScript(t *testing.T) {
+	_, keys, _ := testKeys(3)
+	m, err := Parse("and_v(v:pk("+keys[0]+"),older(144))", P2WSH)
+	require.NoError(t, err)
+	assert.Equal(t, "B", m.Type()[:1])
+	key, _ := hex.DecodeString(keys[0])
+	expected, _ := txscript.NewScriptBuilder().AddData(key).AddOp(txscript.OP_CHECKSIGVERIFY).
+		AddInt64(144).AddOp(txscript.OP_CHECKSEQUENCEVERIFY).Script()
+	script, err := m.Script()
+	require.NoError(t, err)
+	assert.Equal(t, expected, script)
+
+	m, err = Parse("multi(2,"+keys[0]+","+keys[1]+","+keys[2]+")", P2WSH)
+	require.NoError(t, err)
+	assert.Equal(t, "Bndu", m.Type())
+	script, err = m.Script()
+	require.NoError(t, err)
+	disasm, err := txscript.DisasmString(script)
+	require.NoError(t, err)
+	assert.Equal(t, "2 "+keys[0]+" "+keys[1]+" "+keys[2]+" 3 OP_CHECKMULTISIG", disasm)
+
+	for _, expr := range []string{
+		"or_d(pk(" + keys[0] + "),and_v(v:pkh(" + keys[1] + "),older(1000)))",
+		"andor(pk(" + keys[0] + "),older(10),pk(" + keys[1] + "))",
+		"thresh(2,pk(" + keys[0] + "),s:pk(" + keys[1] + "),sln:older(12960))",
+		"or_i(and_v(v:after(500000001),pk(" + keys[0] + ")),and_v(v:sha256(" + hex.EncodeToString(make([]byte, 32)) + "),pk(" + keys[1] + ")))",
+	} {
+		m, err := Parse(expr, P2WSH)
+		require.NoError(t, err, expr)
+		assert.Equal(t, expr, m.String())
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, keys, xOnly := testKeys(2)
+	for _, expr := range []string{
+		"and_v(pk(" + keys[0] + "),older(1))",
+		"or_b(pk(" + keys[0] + "),pk(" + keys[1] + "))",
+		"and_v(v:after(100),after(500000001))",
+		"thresh(3,pk(" + keys[0] + "),s:pk(" + keys[1] + "))",
+		"older(0)",
+		"v:pk(" + keys[0] + ")",
+		"pk(" + xOnly[0] + ")",
+	} {
+		_, err := Parse(expr, P2WSH)
+		assert.Error(t, err, expr)
+	}
+	_, err := Parse("multi(1,"+xOnly[0]+","+xOnly[1]+")", Tapscript)
+	assert.Error(t, err)
+	_, err = Parse("multi_a(1,"+keys[0]+","+keys[1]+")", P2WSH)
+	assert.Error(t, err)
+}
+
+func TestMaxSatisfactionWeight(t *testing.T) {
+	_, keys, xOnly := testKeys(3)
+	tests := []struct {
+		expr   string
+		ctx    Context
+		weight int64
+	}{
+		{"pk(" + keys[0] + ")", P2WSH, 74},
+		{"multi(2," + keys[0] + "," + keys[1] + "," + keys[2] + ")", P2WSH, 1 + 2*74},
+		{"pkh(" + keys[0] + ")", P2WSH, 74 + 34},
+		{"or_i(pk(" + keys[0] + "),and_v(v:pk(" + keys[1] + "),older(10)))", P2WSH, 74 + 2},
+		{"or_d(pk(" + keys[0] + "),pk(" + keys[1] + "))", P2WSH, 1 + 74},
+		{"pk(" + xOnly[0] + ")", Tapscript, 66},
+		{"multi_a(2," + xOnly[0] + "," + xOnly[1] + "," + xOnly[2] + ")", Tapscript, 2*66 + 1},
+	}
+	for _, tt := range tests {
+		m, err := Parse(tt.expr, tt.ctx)
+		require.NoError(t, err, tt.expr)
+		weight, err := m.MaxSatisfactionWeight()
+		require.NoError(t, err)
+		assert.Equal(t, tt.weight, weight, tt.expr)
+	}
+}
+
+func TestCompilePolicy(t *testing.T) {
+	_, keys, xOnly := testKeys(3)
+	m, err := CompilePolicy("or(99@pk("+keys[0]+"),and(pk("+keys[1]+"),older(4032)))", P2WSH)
+	require.NoError(t, err)
+	assert.Equal(t, "or_d(pk("+keys[0]+"),and_v(v:pk("+keys[1]+"),older(4032)))", m.String())
+
+	m, err = CompilePolicy("thresh(2,pk("+xOnly[0]+"),pk("+xOnly[1]+"),pk("+xOnly[2]+"))", Tapscript)
+	require.NoError(t, err)
+	assert.Equal(t, "multi_a(2,"+xOnly[0]+","+xOnly[1]+","+xOnly[2]+")", m.String())
+
+	m, err = CompilePolicy("thresh(2,pk("+keys[0]+"),pk("+keys[1]+"),older(1000))", P2WSH)
+	require.NoError(t, err)
+	assert.Equal(t, "B", m.Type()[:1])
+
+	m, err = CompilePolicy("or(older(10),after(20))", P2WSH)
+	require.NoError(t, err)
+	assert.Equal(t, "or_i(older(10),after(20))", m.String())
+}
+
+// spendTx builds a one input one output transaction spending prevOut.
+func spendTx(prevOut *wire.TxOut, sequence, lockTime uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.LockTime = lockTime
+	in := wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil)
+	in.Sequence = sequence
+	tx.AddTxIn(in)
+	tx.AddTxOut(wire.NewTxOut(prevOut.Value-1000, prevOut.PkScript))
+	return tx
+}
+
+func executeFinalized(t *testing.T, packet *psbt.Packet, prevOut *wire.TxOut) {
+	tx, err := psbt.Extract(packet)
+	require.NoError(t, err)
+	fetcher := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+	vm, err := txscript.NewEngine(prevOut.PkScript, tx, 0, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(tx, fetcher), prevOut.Value, fetcher)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+}
+
+func TestFinalizeP2WSH(t *testing.T) {
+	privKeys, keys, _ := testKeys(3)
+	preimage := []byte("miniscript preimage test vector!")
+	hash := sha256.Sum256(preimage)
+	m, err := CompilePolicy("or(9@pk("+keys[0]+"),and(pk("+keys[1]+"),or(older(10),sha256("+hex.EncodeToString(hash[:])+"))))", P2WSH)
+	require.NoError(t, err)
+	script, err := m.Script()
+	require.NoError(t, err)
+	program, err := m.WitnessScriptHash()
+	require.NoError(t, err)
+	pkScript, _ := txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(program).Script()
+	prevOut := wire.NewTxOut(100000, pkScript)
+
+	sign := func(tx *wire.MsgTx, key *btcec.PrivateKey) *psbt.Packet {
+		packet, err := psbt.NewFromUnsignedTx(tx)
+		require.NoError(t, err)
+		packet.Inputs[0].WitnessUtxo = prevOut
+		packet.Inputs[0].WitnessScript = script
+		fetcher := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+		sig, err := txscript.RawTxInWitnessSignature(tx, txscript.NewTxSigHashes(tx, fetcher), 0, prevOut.Value, script, txscript.SigHashAll, key)
+		require.NoError(t, err)
+		packet.Inputs[0].PartialSigs = []*psbt.PartialSig{{PubKey: key.PubKey().SerializeCompressed(), Signature: sig}}
+		return packet
+	}
+
+	// primary key
+	packet := sign(spendTx(prevOut, wire.MaxTxInSequenceNum, 0), privKeys[0])
+	require.NoError(t, FinalizePsbtInput(packet, 0, m, nil))
+	executeFinalized(t, packet, prevOut)
+
+	// recovery key before the timelock without the preimage
+	packet = sign(spendTx(prevOut, 5, 0), privKeys[1])
+	assert.Equal(t, ErrCannotSatisfy, FinalizePsbtInput(packet, 0, m, nil))
+
+	// recovery key after the timelock
+	packet = sign(spendTx(prevOut, 10, 0), privKeys[1])
+	require.NoError(t, FinalizePsbtInput(packet, 0, m, nil))
+	executeFinalized(t, packet, prevOut)
+
+	// recovery key with the preimage
+	packet = sign(spendTx(prevOut, wire.MaxTxInSequenceNum, 0), privKeys[1])
+	require.NoError(t, FinalizePsbtInput(packet, 0, m, map[string][]byte{hex.EncodeToString(hash[:]): preimage}))
+	executeFinalized(t, packet, prevOut)
+
+	tx, err := psbt.Extract(packet)
+	require.NoError(t, err)
+	weight, err := m.MaxWitnessWeight(nil)
+	require.NoError(t, err)
+	assert.True(t, int64(tx.TxIn[0].Witness.SerializeSize()) <= weight)
+}
+
+func TestFinalizeTapscript(t *testing.T) {
+	privKeys, _, xOnly := testKeys(4)
+	m, err := Parse("multi_a(2,"+xOnly[0]+","+xOnly[1]+","+xOnly[2]+")", Tapscript)
+	require.NoError(t, err)
+	script, err := m.Script()
+	require.NoError(t, err)
+
+	leaf := txscript.NewBaseTapLeaf(script)
+	tree := txscript.AssembleTaprootScriptTree(leaf)
+	internalKey := privKeys[3].PubKey()
+	controlBlock := tree.LeafMerkleProofs[0].ToControlBlock(internalKey)
+	controlBlockBytes, err := controlBlock.ToBytes()
+	require.NoError(t, err)
+	root := tree.RootNode.TapHash()
+	pkScript, err := txscript.PayToTaprootScript(txscript.ComputeTaprootOutputKey(internalKey, root[:]))
+	require.NoError(t, err)
+	prevOut := wire.NewTxOut(100000, pkScript)
+
+	tx := spendTx(prevOut, wire.MaxTxInSequenceNum, 0)
+	packet, err := psbt.NewFromUnsignedTx(tx)
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = prevOut
+	packet.Inputs[0].TaprootLeafScript = []*psbt.TaprootTapLeafScript{{
+		ControlBlock: controlBlockBytes,
+		Script:       script,
+		LeafVersion:  txscript.BaseLeafVersion,
+	}}
+	leafHash := leaf.TapHash()
+	fetcher := txscript.NewCannedPrevOutputFetcher(prevOut.PkScript, prevOut.Value)
+	for _, key := range []*btcec.PrivateKey{privKeys[0], privKeys[2]} {
+		sig, err := txscript.RawTxInTapscriptSignature(tx, txscript.NewTxSigHashes(tx, fetcher), 0, prevOut.Value, prevOut.PkScript, leaf, txscript.SigHashDefault, key)
+		require.NoError(t, err)
+		packet.Inputs[0].TaprootScriptSpendSig = append(packet.Inputs[0].TaprootScriptSpendSig, &psbt.TaprootScriptSpendSig{
+			XOnlyPubKey: schnorr.SerializePubKey(key.PubKey()),
+			LeafHash:    leafHash[:],
+			Signature:   sig,
+			SigHash:     txscript.SigHashDefault,
+		})
+	}
+	require.NoError(t, FinalizePsbtInput(packet, 0, m, nil))
+	executeFinalized(t, packet, prevOut)
+}
@@ -0,0 +1,141 @@
+package miniscript
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompilePolicy compiles a spending policy into miniscript. The policy language
+// has pk, after, older, the hash fragments, and, or with optional `n@` weights
+// and thresh. The compiler is straightforward rather than size optimal: and
+// becomes and_v, or becomes or_d when one branch can be dissatisfied, or_i
+// otherwise, and a thresh of keys becomes multi or multi_a.
+func CompilePolicy(policy string, ctx Context) (*Miniscript, error) {
+	root, err := compilePolicy(strings.Join(strings.Fields(policy), ""), ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(root.String(), ctx)
+}
+
+func compilePolicy(s string, ctx Context) (*node, error) {
+	name, args, err := splitCall(s)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "pk", "after", "older", "sha256", "hash256", "ripemd160", "hash160":
+		return parseNode(s, ctx)
+	case "and":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: and() takes two policies", ErrInvalidExpression)
+		}
+		x, err := compilePolicy(args[0], ctx)
+		if err != nil {
+			return nil, err
+		}
+		y, err := compilePolicy(args[1], ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := newNode("v", ctx, x)
+		if err != nil {
+			return nil, err
+		}
+		return newNode("and_v", ctx, v, y)
+	case "or":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("%w: or() takes two policies", ErrInvalidExpression)
+		}
+		weights := make([]uint64, 2)
+		subs := make([]*node, 2)
+		for i, arg := range args {
+			weights[i] = 1
+			if at := strings.IndexByte(arg, '@'); at > 0 && !strings.Contains(arg[:at], "(") {
+				if weights[i], err = strconv.ParseUint(arg[:at], 10, 32); err != nil || weights[i] == 0 {
+					return nil, fmt.Errorf("%w: invalid weight %s", ErrInvalidExpression, arg[:at])
+				}
+				arg = arg[at+1:]
+			}
+			if subs[i], err = compilePolicy(arg, ctx); err != nil {
+				return nil, err
+			}
+		}
+		// the likelier branch goes first, it is the cheaper one to satisfy in or_d and or_i
+		if weights[1] > weights[0] {
+			subs[0], subs[1] = subs[1], subs[0]
+		}
+		for _, order := range [][2]int{{0, 1}, {1, 0}} {
+			x, z := subs[order[0]], subs[order[1]]
+			if x.typ.d && x.typ.u {
+				return newNode("or_d", ctx, x, z)
+			}
+		}
+		return newNode("or_i", ctx, subs[0], subs[1])
+	case "thresh":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("%w: thresh() needs a threshold and policies", ErrInvalidExpression)
+		}
+		k, err := strconv.ParseUint(args[0], 10, 32)
+		if err != nil || k < 1 || int(k) > len(args)-1 {
+			return nil, fmt.Errorf("%w: invalid thresh threshold %s", ErrInvalidExpression, args[0])
+		}
+		keys := make([]string, 0, len(args)-1)
+		for _, arg := range args[1:] {
+			if name, keyArgs, err := splitCall(arg); err == nil && name == "pk" && len(keyArgs) == 1 {
+				keys = append(keys, keyArgs[0])
+			}
+		}
+		if len(keys) == len(args)-1 && (ctx == Tapscript || len(keys) <= MaxP2WSHMultiKeys) {
+			multi := "multi"
+			if ctx == Tapscript {
+				multi = "multi_a"
+			}
+			return parseNode(multi+"("+args[0]+","+strings.Join(keys, ",")+")", ctx)
+		}
+		n := &node{fragment: "thresh", k: uint32(k)}
+		for i, arg := range args[1:] {
+			sub, err := compilePolicy(arg, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if sub, err = toThreshArg(sub, i == 0, ctx); err != nil {
+				return nil, err
+			}
+			n.subs = append(n.subs, sub)
+		}
+		if err := n.computeType(ctx); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: unknown policy %s", ErrInvalidExpression, name)
+}
+
+// toThreshArg wraps a B expression into the Bdu form thresh needs first and the
+// Wdu form it needs for the other arguments.
+func toThreshArg(n *node, first bool, ctx Context) (*node, error) {
+	var err error
+	if !n.typ.d {
+		zero := &node{fragment: "0"}
+		if err = zero.computeType(ctx); err != nil {
+			return nil, err
+		}
+		if n, err = newNode("or_i", ctx, zero, n); err != nil {
+			return nil, err
+		}
+	}
+	if !n.typ.u {
+		if n, err = newNode("n", ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	if first {
+		return n, nil
+	}
+	if n.typ.o {
+		return newNode("s", ctx, n)
+	}
+	return newNode("a", ctx, n)
+}
@@ -0,0 +1,161 @@
+package miniscript
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"golang.org/x/crypto/ripemd160"
+)
+
+// PsbtSatisfier satisfies an input of a PSBT from its partial signatures, the
+// sequence and lock time of the unsigned transaction and the given preimages,
+// which are keyed by the hex encoded hash.
+type PsbtSatisfier struct {
+	packet    *psbt.Packet
+	index     int
+	leafHash  []byte
+	preimages map[string][]byte
+}
+
+func NewPsbtSatisfier(packet *psbt.Packet, index int, preimages map[string][]byte) (*PsbtSatisfier, error) {
+	if packet == nil || index < 0 || index >= len(packet.Inputs) || index >= len(packet.UnsignedTx.TxIn) {
+		return nil, errors.New("invalid psbt input index")
+	}
+	return &PsbtSatisfier{packet: packet, index: index, preimages: preimages}, nil
+}
+
+func (s *PsbtSatisfier) Signature(pubKey []byte) ([]byte, bool) {
+	input := s.packet.Inputs[s.index]
+	if s.leafHash != nil {
+		for _, sig := range input.TaprootScriptSpendSig {
+			if bytes.Equal(sig.XOnlyPubKey, pubKey) && bytes.Equal(sig.LeafHash, s.leafHash) {
+				if sig.SigHash == txscript.SigHashDefault {
+					return sig.Signature, true
+				}
+				return append(append([]byte{}, sig.Signature...), byte(sig.SigHash)), true
+			}
+		}
+		return nil, false
+	}
+	for _, sig := range input.PartialSigs {
+		if bytes.Equal(sig.PubKey, pubKey) {
+			return sig.Signature, true
+		}
+	}
+	return nil, false
+}
+
+func (s *PsbtSatisfier) Preimage(hashFunc string, hash []byte) ([]byte, bool) {
+	preimage, ok := s.preimages[hex.EncodeToString(hash)]
+	if !ok {
+		return nil, false
+	}
+	var digest []byte
+	switch hashFunc {
+	case "sha256":
+		digest = chainhash.HashB(preimage)
+	case "hash256":
+		digest = chainhash.DoubleHashB(preimage)
+	case "ripemd160":
+		digest = ripemd160Hash(preimage)
+	case "hash160":
+		digest = btcutil.Hash160(preimage)
+	}
+	return preimage, bytes.Equal(digest, hash)
+}
+
+// CheckOlder follows BIP-68: a relative timelock of the same kind, at least as long.
+func (s *PsbtSatisfier) CheckOlder(n uint32) bool {
+	tx := s.packet.UnsignedTx
+	sequence := tx.TxIn[s.index].Sequence
+	if tx.Version < 2 || sequence&wire.SequenceLockTimeDisabled != 0 {
+		return false
+	}
+	mask := uint32(wire.SequenceLockTimeIsSeconds | wire.SequenceLockTimeMask)
+	if (sequence&wire.SequenceLockTimeIsSeconds != 0) != (n&wire.SequenceLockTimeIsSeconds != 0) {
+		return false
+	}
+	return sequence&mask >= n&mask
+}
+
+// CheckAfter follows BIP-65: an absolute timelock of the same kind, not later, on a non final input.
+func (s *PsbtSatisfier) CheckAfter(n uint32) bool {
+	tx := s.packet.UnsignedTx
+	if tx.TxIn[s.index].Sequence == wire.MaxTxInSequenceNum {
+		return false
+	}
+	if (tx.LockTime >= locktimeThreshold) != (n >= locktimeThreshold) {
+		return false
+	}
+	return tx.LockTime >= n
+}
+
+// FinalizePsbtInput satisfies input index with m and sets its final witness.
+// P2WSH inputs get the witness script appended, tapscript inputs the leaf
+// script and its control block from the input's TaprootLeafScript.
+func FinalizePsbtInput(packet *psbt.Packet, index int, m *Miniscript, preimages map[string][]byte) error {
+	satisfier, err := NewPsbtSatisfier(packet, index, preimages)
+	if err != nil {
+		return err
+	}
+	script, err := m.Script()
+	if err != nil {
+		return err
+	}
+	input := &packet.Inputs[index]
+
+	var tail [][]byte
+	if m.ctx == Tapscript {
+		var leaf *psbt.TaprootTapLeafScript
+		for _, l := range input.TaprootLeafScript {
+			if bytes.Equal(l.Script, script) {
+				leaf = l
+				break
+			}
+		}
+		if leaf == nil {
+			return fmt.Errorf("input %d has no tap leaf for the script", index)
+		}
+		leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+		satisfier.leafHash = leafHash[:]
+		tail = [][]byte{script, leaf.ControlBlock}
+	} else {
+		if input.WitnessScript != nil && !bytes.Equal(input.WitnessScript, script) {
+			return fmt.Errorf("input %d witness script does not match", index)
+		}
+		tail = [][]byte{script}
+	}
+
+	stack, err := m.Satisfy(satisfier)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := psbt.WriteTxWitness(&buf, append(stack, tail...)); err != nil {
+		return err
+	}
+	input.FinalScriptWitness = buf.Bytes()
+	input.PartialSigs = nil
+	input.SighashType = 0
+	input.WitnessScript = nil
+	input.RedeemScript = nil
+	input.Bip32Derivation = nil
+	input.TaprootScriptSpendSig = nil
+	input.TaprootLeafScript = nil
+	input.TaprootBip32Derivation = nil
+	input.TaprootInternalKey = nil
+	input.TaprootMerkleRoot = nil
+	return nil
+}
+
+func ripemd160Hash(b []byte) []byte {
+	h := ripemd160.New()
+	h.Write(b)
+	return h.Sum(nil)
+}
@@ -0,0 +1,306 @@
+package miniscript
+
+import (
+	"errors"
+	"github.com/btcsuite/btcd/wire"
+	"sort"
+)
+
+const (
+	// MaxECDSASignatureSize is a DER signature with the sighash byte.
+	MaxECDSASignatureSize = 73
+	// MaxSchnorrSignatureSize is a schnorr signature with a non default sighash byte.
+	MaxSchnorrSignatureSize = 65
+
+	preimageSize = 32
+)
+
+var ErrCannotSatisfy = errors.New("miniscript cannot be satisfied with the available data")
+
+// Satisfier provides the data a satisfaction needs.
+type Satisfier interface {
+	// Signature returns the signature, sighash byte included, for the key as it appears in the script.
+	Signature(pubKey []byte) ([]byte, bool)
+	// Preimage returns the preimage of hash for the named hash function.
+	Preimage(hashFunc string, hash []byte) ([]byte, bool)
+	CheckOlder(sequence uint32) bool
+	CheckAfter(lockTime uint32) bool
+}
+
+// witness is a candidate stack, bottom element first.
+type witness struct {
+	stack [][]byte
+	ok    bool
+}
+
+var unavailable = witness{}
+
+func available(items ...[]byte) witness {
+	return witness{stack: items, ok: true}
+}
+
+func (w witness) size() int {
+	size := 0
+	for _, item := range w.stack {
+		size += wire.VarIntSerializeSize(uint64(len(item))) + len(item)
+	}
+	return size
+}
+
+// concat places the stacks bottom first, so the witness of [X][Y] is concat(w(Y), w(X)).
+func concat(ws ...witness) witness {
+	res := witness{ok: true}
+	for _, w := range ws {
+		if !w.ok {
+			return unavailable
+		}
+		res.stack = append(res.stack, w.stack...)
+	}
+	return res
+}
+
+type satisfyMode struct {
+	satisfier Satisfier
+	// worst picks the largest witness, used to compute the maximum satisfaction size
+	worst bool
+}
+
+func (m satisfyMode) pick(a, b witness) witness {
+	if !a.ok {
+		return b
+	}
+	if !b.ok {
+		return a
+	}
+	if (b.size() < a.size()) != m.worst && b.size() != a.size() {
+		return b
+	}
+	return a
+}
+
+// Satisfy returns the smallest witness stack satisfying the script, the script
+// itself and a tapscript control block are not included.
+func (m *Miniscript) Satisfy(satisfier Satisfier) ([][]byte, error) {
+	sat, _ := m.root.satisfy(satisfyMode{satisfier: satisfier})
+	if !sat.ok {
+		return nil, ErrCannotSatisfy
+	}
+	return sat.stack, nil
+}
+
+// MaxSatisfactionWeight returns the weight of the largest satisfying witness
+// stack, each element with its length prefix. The element count, the script and
+// the control block are not included.
+func (m *Miniscript) MaxSatisfactionWeight() (int64, error) {
+	sat, _ := m.root.satisfy(satisfyMode{satisfier: &maxSatisfier{ctx: m.ctx}, worst: true})
+	if !sat.ok {
+		return 0, ErrCannotSatisfy
+	}
+	return int64(sat.size()), nil
+}
+
+// MaxWitnessWeight returns the weight of the largest complete witness: the
+// element count, the satisfaction, the script and, in tapscript, controlBlock.
+func (m *Miniscript) MaxWitnessWeight(controlBlock []byte) (int64, error) {
+	sat, _ := m.root.satisfy(satisfyMode{satisfier: &maxSatisfier{ctx: m.ctx}, worst: true})
+	if !sat.ok {
+		return 0, ErrCannotSatisfy
+	}
+	script, err := m.Script()
+	if err != nil {
+		return 0, err
+	}
+	sat.stack = append(sat.stack, script)
+	if m.ctx == Tapscript {
+		sat.stack = append(sat.stack, controlBlock)
+	}
+	return int64(wire.VarIntSerializeSize(uint64(len(sat.stack))) + sat.size()), nil
+}
+
+type maxSatisfier struct {
+	ctx Context
+}
+
+func (s *maxSatisfier) Signature([]byte) ([]byte, bool) {
+	if s.ctx == Tapscript {
+		return make([]byte, MaxSchnorrSignatureSize), true
+	}
+	return make([]byte, MaxECDSASignatureSize), true
+}
+
+func (s *maxSatisfier) Preimage(string, []byte) ([]byte, bool) {
+	return make([]byte, preimageSize), true
+}
+
+func (s *maxSatisfier) CheckOlder(uint32) bool {
+	return true
+}
+
+func (s *maxSatisfier) CheckAfter(uint32) bool {
+	return true
+}
+
+// satisfy returns the satisfaction and dissatisfaction of n.
+func (n *node) satisfy(m satisfyMode) (witness, witness) {
+	s := m.satisfier
+	switch n.fragment {
+	case "0":
+		return unavailable, available()
+	case "1":
+		return available(), unavailable
+	case "pk_k":
+		sig, ok := s.Signature(n.keys[0])
+		if !ok {
+			return unavailable, available([]byte{})
+		}
+		return available(sig), available([]byte{})
+	case "pk_h":
+		sig, ok := s.Signature(n.keys[0])
+		if !ok {
+			return unavailable, available([]byte{}, n.keys[0])
+		}
+		return available(sig, n.keys[0]), available([]byte{}, n.keys[0])
+	case "older":
+		if s.CheckOlder(n.k) {
+			return available(), unavailable
+		}
+		return unavailable, unavailable
+	case "after":
+		if s.CheckAfter(n.k) {
+			return available(), unavailable
+		}
+		return unavailable, unavailable
+	case "sha256", "hash256", "ripemd160", "hash160":
+		dsat := available(make([]byte, preimageSize))
+		preimage, ok := s.Preimage(n.fragment, n.hash)
+		if !ok {
+			return unavailable, dsat
+		}
+		return available(preimage), dsat
+	case "multi":
+		dsat := witness{stack: [][]byte{{}}, ok: true}
+		for i := uint32(0); i < n.k; i++ {
+			dsat.stack = append(dsat.stack, []byte{})
+		}
+		sat := available([]byte{})
+		for _, key := range n.keys {
+			if len(sat.stack) == int(n.k)+1 {
+				break
+			}
+			if sig, ok := s.Signature(key); ok {
+				sat.stack = append(sat.stack, sig)
+			}
+		}
+		if len(sat.stack) != int(n.k)+1 {
+			return unavailable, dsat
+		}
+		return sat, dsat
+	case "multi_a":
+		// the first key checks the top element, so signatures go in reverse key order
+		sat := witness{stack: make([][]byte, len(n.keys)), ok: true}
+		dsat := witness{stack: make([][]byte, len(n.keys)), ok: true}
+		count := uint32(0)
+		for i, key := range n.keys {
+			sat.stack[len(n.keys)-1-i] = []byte{}
+			dsat.stack[i] = []byte{}
+			if count == n.k {
+				continue
+			}
+			if sig, ok := s.Signature(key); ok {
+				sat.stack[len(n.keys)-1-i] = sig
+				count++
+			}
+		}
+		if count != n.k {
+			return unavailable, dsat
+		}
+		return sat, dsat
+	}
+
+	subs := make([][2]witness, len(n.subs))
+	for i, sub := range n.subs {
+		sat, dsat := sub.satisfy(m)
+		subs[i] = [2]witness{sat, dsat}
+	}
+	switch n.fragment {
+	case "a", "s", "c", "n":
+		return subs[0][0], subs[0][1]
+	case "d":
+		return concat(subs[0][0], available([]byte{1})), available([]byte{})
+	case "v":
+		return subs[0][0], unavailable
+	case "j":
+		return subs[0][0], available([]byte{})
+	case "andor":
+		x, y, z := subs[0], subs[1], subs[2]
+		return m.pick(concat(y[0], x[0]), concat(z[0], x[1])), concat(z[1], x[1])
+	case "and_v":
+		x, y := subs[0], subs[1]
+		return concat(y[0], x[0]), unavailable
+	case "and_b":
+		x, y := subs[0], subs[1]
+		return concat(y[0], x[0]), concat(y[1], x[1])
+	case "or_b":
+		x, z := subs[0], subs[1]
+		return m.pick(concat(z[1], x[0]), concat(z[0], x[1])), concat(z[1], x[1])
+	case "or_c":
+		x, z := subs[0], subs[1]
+		return m.pick(x[0], concat(z[0], x[1])), unavailable
+	case "or_d":
+		x, z := subs[0], subs[1]
+		return m.pick(x[0], concat(z[0], x[1])), concat(z[1], x[1])
+	case "or_i":
+		x, z := subs[0], subs[1]
+		return m.pick(concat(x[0], available([]byte{1})), concat(z[0], available([]byte{}))),
+			m.pick(concat(x[1], available([]byte{1})), concat(z[1], available([]byte{})))
+	case "thresh":
+		return n.satisfyThresh(m, subs)
+	}
+	return unavailable, unavailable
+}
+
+// satisfyThresh satisfies k of the subs and dissatisfies the others, choosing the
+// subs whose satisfaction costs the least over their dissatisfaction.
+func (n *node) satisfyThresh(m satisfyMode, subs [][2]witness) (witness, witness) {
+	dsats := make([]witness, len(subs))
+	for i := range subs {
+		dsats[len(subs)-1-i] = subs[i][1]
+	}
+	dsat := concat(dsats...)
+
+	var forced, optional []int
+	for i, sub := range subs {
+		switch {
+		case sub[0].ok && !sub[1].ok:
+			forced = append(forced, i)
+		case sub[0].ok:
+			optional = append(optional, i)
+		case !sub[1].ok:
+			return unavailable, dsat
+		}
+	}
+	if len(forced)+len(optional) < int(n.k) || len(forced) > int(n.k) {
+		return unavailable, dsat
+	}
+	sort.SliceStable(optional, func(a, b int) bool {
+		costA := subs[optional[a]][0].size() - subs[optional[a]][1].size()
+		costB := subs[optional[b]][0].size() - subs[optional[b]][1].size()
+		if m.worst {
+			return costA > costB
+		}
+		return costA < costB
+	})
+	chosen := make(map[int]bool)
+	for _, i := range append(forced, optional[:int(n.k)-len(forced)]...) {
+		chosen[i] = true
+	}
+	stacks := make([]witness, len(subs))
+	for i := range subs {
+		if chosen[i] {
+			stacks[len(subs)-1-i] = subs[i][0]
+		} else {
+			stacks[len(subs)-1-i] = subs[i][1]
+		}
+	}
+	return concat(stacks...), dsat
+}
@@ -0,0 +1,148 @@
+package miniscript
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+)
+
+// opcodes that v: turns into their VERIFY form instead of appending OP_VERIFY
+var verifyOps = map[byte]byte{
+	txscript.OP_EQUAL:         txscript.OP_EQUALVERIFY,
+	txscript.OP_CHECKSIG:      txscript.OP_CHECKSIGVERIFY,
+	txscript.OP_CHECKMULTISIG: txscript.OP_CHECKMULTISIGVERIFY,
+	txscript.OP_NUMEQUAL:      txscript.OP_NUMEQUALVERIFY,
+}
+
+var hashOps = map[string]byte{
+	"sha256":    txscript.OP_SHA256,
+	"hash256":   txscript.OP_HASH256,
+	"ripemd160": txscript.OP_RIPEMD160,
+	"hash160":   txscript.OP_HASH160,
+}
+
+// Script returns the witness script in P2WSH or the leaf script in tapscript.
+func (m *Miniscript) Script() ([]byte, error) {
+	return m.root.script()
+}
+
+// WitnessScriptHash returns the P2WSH program of the script.
+func (m *Miniscript) WitnessScriptHash() ([]byte, error) {
+	if m.ctx != P2WSH {
+		return nil, fmt.Errorf("witness script hash is only defined in P2WSH")
+	}
+	script, err := m.Script()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(script)
+	return h[:], nil
+}
+
+// P2WSHAddress returns the P2WSH address paying to the script.
+func (m *Miniscript) P2WSHAddress(network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	h, err := m.WitnessScriptHash()
+	if err != nil {
+		return "", err
+	}
+	addr, err := btcutil.NewAddressWitnessScriptHash(h, network)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+func (n *node) script() ([]byte, error) {
+	b := txscript.NewScriptBuilder()
+	subs := make([][]byte, len(n.subs))
+	for i, sub := range n.subs {
+		script, err := sub.script()
+		if err != nil {
+			return nil, err
+		}
+		subs[i] = script
+	}
+	switch n.fragment {
+	case "0":
+		b.AddOp(txscript.OP_0)
+	case "1":
+		b.AddOp(txscript.OP_1)
+	case "pk_k":
+		b.AddData(n.keys[0])
+	case "pk_h":
+		b.AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).AddData(btcutil.Hash160(n.keys[0])).AddOp(txscript.OP_EQUALVERIFY)
+	case "older":
+		b.AddInt64(int64(n.k)).AddOp(txscript.OP_CHECKSEQUENCEVERIFY)
+	case "after":
+		b.AddInt64(int64(n.k)).AddOp(txscript.OP_CHECKLOCKTIMEVERIFY)
+	case "sha256", "hash256", "ripemd160", "hash160":
+		b.AddOp(txscript.OP_SIZE).AddInt64(32).AddOp(txscript.OP_EQUALVERIFY).
+			AddOp(hashOps[n.fragment]).AddData(n.hash).AddOp(txscript.OP_EQUAL)
+	case "multi":
+		b.AddInt64(int64(n.k))
+		for _, key := range n.keys {
+			b.AddData(key)
+		}
+		b.AddInt64(int64(len(n.keys))).AddOp(txscript.OP_CHECKMULTISIG)
+	case "multi_a":
+		for i, key := range n.keys {
+			b.AddData(key)
+			if i == 0 {
+				b.AddOp(txscript.OP_CHECKSIG)
+			} else {
+				b.AddOp(txscript.OP_CHECKSIGADD)
+			}
+		}
+		b.AddInt64(int64(n.k)).AddOp(txscript.OP_NUMEQUAL)
+	case "a":
+		b.AddOp(txscript.OP_TOALTSTACK).AddOps(subs[0]).AddOp(txscript.OP_FROMALTSTACK)
+	case "s":
+		b.AddOp(txscript.OP_SWAP).AddOps(subs[0])
+	case "c":
+		b.AddOps(subs[0]).AddOp(txscript.OP_CHECKSIG)
+	case "d":
+		b.AddOp(txscript.OP_DUP).AddOp(txscript.OP_IF).AddOps(subs[0]).AddOp(txscript.OP_ENDIF)
+	case "v":
+		// every B fragment ends with an opcode, so the last byte is never push data
+		sub := subs[0]
+		if op, ok := verifyOps[sub[len(sub)-1]]; ok {
+			b.AddOps(sub[:len(sub)-1]).AddOp(op)
+		} else {
+			b.AddOps(sub).AddOp(txscript.OP_VERIFY)
+		}
+	case "j":
+		b.AddOp(txscript.OP_SIZE).AddOp(txscript.OP_0NOTEQUAL).AddOp(txscript.OP_IF).AddOps(subs[0]).AddOp(txscript.OP_ENDIF)
+	case "n":
+		b.AddOps(subs[0]).AddOp(txscript.OP_0NOTEQUAL)
+	case "andor":
+		b.AddOps(subs[0]).AddOp(txscript.OP_NOTIF).AddOps(subs[2]).AddOp(txscript.OP_ELSE).AddOps(subs[1]).AddOp(txscript.OP_ENDIF)
+	case "and_v":
+		b.AddOps(subs[0]).AddOps(subs[1])
+	case "and_b":
+		b.AddOps(subs[0]).AddOps(subs[1]).AddOp(txscript.OP_BOOLAND)
+	case "or_b":
+		b.AddOps(subs[0]).AddOps(subs[1]).AddOp(txscript.OP_BOOLOR)
+	case "or_c":
+		b.AddOps(subs[0]).AddOp(txscript.OP_NOTIF).AddOps(subs[1]).AddOp(txscript.OP_ENDIF)
+	case "or_d":
+		b.AddOps(subs[0]).AddOp(txscript.OP_IFDUP).AddOp(txscript.OP_NOTIF).AddOps(subs[1]).AddOp(txscript.OP_ENDIF)
+	case "or_i":
+		b.AddOp(txscript.OP_IF).AddOps(subs[0]).AddOp(txscript.OP_ELSE).AddOps(subs[1]).AddOp(txscript.OP_ENDIF)
+	case "thresh":
+		for i, sub := range subs {
+			b.AddOps(sub)
+			if i > 0 {
+				b.AddOp(txscript.OP_ADD)
+			}
+		}
+		b.AddInt64(int64(n.k)).AddOp(txscript.OP_EQUAL)
+	default:
+		return nil, fmt.Errorf("%w: unknown fragment %s", ErrInvalidExpression, n.fragment)
+	}
+	return b.Script()
+}
@@ -0,0 +1,466 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/ethereum/go-ethereum/accounts"
+	"strings"
+)
+
+// A multisig wallet is kept as the output descriptor Sparrow and Specter
+// export and import: wsh(sortedmulti(...)), sh(wsh(sortedmulti(...))) or
+// tr(<internal key>,sortedmulti_a(...)), with plain keys or ranged xpubs of
+// the cosigners. Each cosigner signs the PSBT with PsbtPacket.Sign, the
+// partial PSBTs are merged with CombinePsbtPackets and Finalize builds the
+// multisig script sig and witness.
+
+const (
+	MultisigP2WSH     = "p2wsh"
+	MultisigP2SHP2WSH = "p2sh-p2wsh"
+	MultisigP2TR      = "p2tr"
+)
+
+var (
+	ErrNotMultisig            = errors.New("descriptor is not a multisig wallet")
+	ErrMultisigScriptMismatch = errors.New("script does not pay the multisig wallet")
+	ErrMultisigSignatures     = errors.New("not enough multisig signatures")
+)
+
+type MultisigWallet struct {
+	ScriptType string
+	Threshold  int
+	descriptor *Descriptor
+	multi      *descriptorNode
+	network    *chaincfg.Params
+}
+
+// NewMultisigDescriptor returns the descriptor, with its checksum, of a
+// threshold of keys multisig of scriptType. keys are descriptor keys, hex
+// public keys or extended keys with an optional origin such as
+// [73c5da0a/48'/0'/0'/2']xpub.../0/*; sorted sorts the keys of every
+// address as BIP-67 does. A taproot multisig has the NUMS point as internal
+// key and can only be spent through its multi_a leaf.
+func NewMultisigDescriptor(scriptType string, threshold int, keys []string, sorted bool) (string, error) {
+	name := "multi"
+	if scriptType == MultisigP2TR {
+		name = "multi_a"
+	}
+	if sorted {
+		name = "sorted" + name
+	}
+	multi := fmt.Sprintf("%s(%d,%s)", name, threshold, strings.Join(keys, ","))
+	var desc string
+	switch scriptType {
+	case MultisigP2WSH:
+		desc = "wsh(" + multi + ")"
+	case MultisigP2SHP2WSH:
+		desc = "sh(wsh(" + multi + "))"
+	case MultisigP2TR:
+		desc = "tr(" + TaprootNUMSKey[2:] + "," + multi + ")"
+	default:
+		return "", fmt.Errorf("%w: unknown script type %s", ErrNotMultisig, scriptType)
+	}
+	if _, err := ParseDescriptor(desc); err != nil {
+		return "", err
+	}
+	return AddDescriptorChecksum(desc)
+}
+
+// NewMultisigWallet parses the descriptor of a P2WSH, P2SH-P2WSH or taproot
+// multisig, a taproot one having its multi_a leaf as the only leaf.
+func NewMultisigWallet(descriptor string, network *chaincfg.Params) (*MultisigWallet, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	d, err := ParseDescriptor(descriptor)
+	if err != nil {
+		return nil, err
+	}
+	w := &MultisigWallet{descriptor: d, network: network}
+	root := d.root
+	switch {
+	case root.name == "wsh":
+		w.ScriptType, w.multi = MultisigP2WSH, root.sub
+	case root.name == "sh" && root.sub.name == "wsh":
+		w.ScriptType, w.multi = MultisigP2SHP2WSH, root.sub.sub
+	case root.name == "tr" && root.tree != nil && root.tree.leaf != nil:
+		w.ScriptType, w.multi = MultisigP2TR, root.tree.leaf
+	}
+	if w.multi == nil {
+		return nil, ErrNotMultisig
+	}
+	switch w.multi.name {
+	case "multi", "sortedmulti", "multi_a", "sortedmulti_a":
+	default:
+		return nil, ErrNotMultisig
+	}
+	w.Threshold = w.multi.threshold
+	return w, nil
+}
+
+// GenerateMultisigAddress returns the address of a sorted threshold of
+// pubKeys multisig of scriptType.
+func GenerateMultisigAddress(scriptType string, threshold int, pubKeys []string, network *chaincfg.Params) (string, error) {
+	desc, err := NewMultisigDescriptor(scriptType, threshold, pubKeys, true)
+	if err != nil {
+		return "", err
+	}
+	w, err := NewMultisigWallet(desc, network)
+	if err != nil {
+		return "", err
+	}
+	return w.Address(0)
+}
+
+// Descriptor returns the descriptor of the wallet with its checksum.
+func (w *MultisigWallet) Descriptor() string {
+	return w.descriptor.String()
+}
+
+// Derive returns the scripts and cosigner keys of the address at index.
+func (w *MultisigWallet) Derive(index uint32) (*DescriptorOutput, error) {
+	return w.descriptor.Derive(index, w.network)
+}
+
+func (w *MultisigWallet) Address(index uint32) (string, error) {
+	out, err := w.Derive(index)
+	if err != nil {
+		return "", err
+	}
+	return out.Address, nil
+}
+
+// tapLeaf returns the multi_a leaf of a taproot wallet at index.
+func (w *MultisigWallet) tapLeaf(index uint32, out *DescriptorOutput) (*TaprootScriptTree, error) {
+	script, err := w.multi.expand(index, w.network, &DescriptorOutput{})
+	if err != nil {
+		return nil, err
+	}
+	return NewTaprootScriptTree(hex.EncodeToString(out.TaprootInternalKey), []*TapLeafSpec{{Script: hex.EncodeToString(script)}})
+}
+
+// UpdatePsbtInput makes input i, spending amount from the address at index,
+// signable by the cosigners: it adds the witness utxo, the redeem and
+// witness scripts or the multi_a leaf, and the key origin of every cosigner
+// key that has one.
+func (w *MultisigWallet) UpdatePsbtInput(p *PsbtPacket, i int, index uint32, amount int64) error {
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	out, err := w.Derive(index)
+	if err != nil {
+		return err
+	}
+	pIn := &p.Packet.Inputs[i]
+	if pIn.WitnessUtxo != nil && !bytes.Equal(pIn.WitnessUtxo.PkScript, out.ScriptPubKey) {
+		return fmt.Errorf("%w: input %d", ErrMultisigScriptMismatch, i)
+	}
+	updater, err := psbt.NewUpdater(p.Packet)
+	if err != nil {
+		return err
+	}
+	if err := updater.AddInWitnessUtxo(wire.NewTxOut(amount, out.ScriptPubKey), i); err != nil {
+		return err
+	}
+
+	if w.ScriptType == MultisigP2TR {
+		tree, err := w.tapLeaf(index, out)
+		if err != nil {
+			return err
+		}
+		if err := tree.AddLeafToPsbtInput(p.Packet, i, 0); err != nil {
+			return err
+		}
+		leafHash := tree.Leaves[0].TapHash()
+		pIn.TaprootInternalKey = out.TaprootInternalKey
+		pIn.TaprootMerkleRoot = out.TaprootMerkleRoot
+		pIn.TaprootBip32Derivation, err = tapKeyOrigins(pIn.TaprootBip32Derivation, out.Keys, leafHash[:])
+		return err
+	}
+
+	if out.RedeemScript != nil && pIn.RedeemScript == nil {
+		if err := updater.AddInRedeemScript(out.RedeemScript, i); err != nil {
+			return err
+		}
+	}
+	if pIn.WitnessScript == nil {
+		if err := updater.AddInWitnessScript(out.WitnessScript, i); err != nil {
+			return err
+		}
+	}
+	for _, key := range out.Keys {
+		if key.DerivationPath == "m" {
+			continue
+		}
+		in := &TxInput{PublicKey: key.PublicKey, MasterFingerprint: key.MasterFingerprint, DerivationPath: key.DerivationPath}
+		if err := addInputKeyOrigin(p.Packet, i, in, out.ScriptPubKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdatePsbtOutput adds the scripts and cosigner key origins of change
+// output i, which has to pay the address at index.
+func (w *MultisigWallet) UpdatePsbtOutput(p *PsbtPacket, i int, index uint32) error {
+	if i < 0 || i >= len(p.Packet.Outputs) {
+		return errors.New("psbt output index out of range")
+	}
+	out, err := w.Derive(index)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(p.Packet.UnsignedTx.TxOut[i].PkScript, out.ScriptPubKey) {
+		return fmt.Errorf("%w: output %d", ErrMultisigScriptMismatch, i)
+	}
+	pOut := &p.Packet.Outputs[i]
+
+	if w.ScriptType == MultisigP2TR {
+		tree, err := w.tapLeaf(index, out)
+		if err != nil {
+			return err
+		}
+		// PSBT_OUT_TAP_TREE of the single leaf: depth, leaf version and script
+		var tapTree bytes.Buffer
+		tapTree.Write([]byte{0, byte(tree.Leaves[0].LeafVersion)})
+		if err := wire.WriteVarBytes(&tapTree, 0, tree.Leaves[0].Script); err != nil {
+			return err
+		}
+		leafHash := tree.Leaves[0].TapHash()
+		pOut.TaprootInternalKey = out.TaprootInternalKey
+		pOut.TaprootTapTree = tapTree.Bytes()
+		pOut.TaprootBip32Derivation, err = tapKeyOrigins(pOut.TaprootBip32Derivation, out.Keys, leafHash[:])
+		return err
+	}
+
+	updater, err := psbt.NewUpdater(p.Packet)
+	if err != nil {
+		return err
+	}
+	if out.RedeemScript != nil && pOut.RedeemScript == nil {
+		if err := updater.AddOutRedeemScript(out.RedeemScript, i); err != nil {
+			return err
+		}
+	}
+	if pOut.WitnessScript == nil {
+		if err := updater.AddOutWitnessScript(out.WitnessScript, i); err != nil {
+			return err
+		}
+	}
+	for _, key := range out.Keys {
+		if key.DerivationPath == "m" {
+			continue
+		}
+		publicKey, err := hex.DecodeString(key.PublicKey)
+		if err != nil {
+			return err
+		}
+		path, err := accounts.ParseDerivationPath(key.DerivationPath)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, d := range pOut.Bip32Derivation {
+			if bytes.Equal(d.PubKey, publicKey) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := updater.AddOutBip32Derivation(key.MasterFingerprint, path, publicKey, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tapKeyOrigins appends the origins of keys missing from derivations, keys
+// being the internal key followed by the keys of the leaf with leafHash.
+func tapKeyOrigins(derivations []*psbt.TaprootBip32Derivation, keys []*DescriptorKeyInfo, leafHash []byte) ([]*psbt.TaprootBip32Derivation, error) {
+	for j, key := range keys {
+		if key.DerivationPath == "m" {
+			continue
+		}
+		publicKey, err := hex.DecodeString(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		path, err := accounts.ParseDerivationPath(key.DerivationPath)
+		if err != nil {
+			return nil, err
+		}
+		xOnly := xOnlyKey(publicKey)
+		found := false
+		for _, d := range derivations {
+			if bytes.Equal(d.XOnlyPubKey, xOnly) {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+		d := &psbt.TaprootBip32Derivation{XOnlyPubKey: xOnly, MasterKeyFingerprint: key.MasterFingerprint, Bip32Path: path}
+		if j > 0 {
+			d.LeafHashes = [][]byte{leafHash}
+		}
+		derivations = append(derivations, d)
+	}
+	return derivations, nil
+}
+
+// multiAScript returns the BIP-387 multi_a script
+// <key1> OP_CHECKSIG <key2> OP_CHECKSIGADD ... <threshold> OP_NUMEQUAL.
+func multiAScript(threshold int, keys [][]byte) ([]byte, error) {
+	builder := txscript.NewScriptBuilder().AddData(keys[0]).AddOp(txscript.OP_CHECKSIG)
+	for _, key := range keys[1:] {
+		builder.AddData(key).AddOp(txscript.OP_CHECKSIGADD)
+	}
+	return builder.AddInt64(int64(threshold)).AddOp(txscript.OP_NUMEQUAL).Script()
+}
+
+// parseMultiAScript returns the keys and threshold of a multi_a script.
+func parseMultiAScript(script []byte) ([][]byte, int, bool) {
+	type token struct {
+		op   byte
+		data []byte
+	}
+	var tokens []token
+	tokenizer := txscript.MakeScriptTokenizer(0, script)
+	for tokenizer.Next() {
+		tokens = append(tokens, token{tokenizer.Opcode(), tokenizer.Data()})
+	}
+	if tokenizer.Err() != nil || len(tokens) < 4 || len(tokens)%2 != 0 {
+		return nil, 0, false
+	}
+	n := len(tokens)/2 - 1
+	keys := make([][]byte, 0, n)
+	for j := 0; j < n; j++ {
+		op := byte(txscript.OP_CHECKSIGADD)
+		if j == 0 {
+			op = txscript.OP_CHECKSIG
+		}
+		if tokens[2*j].op != txscript.OP_DATA_32 || tokens[2*j+1].op != op {
+			return nil, 0, false
+		}
+		keys = append(keys, tokens[2*j].data)
+	}
+	if tokens[len(tokens)-1].op != txscript.OP_NUMEQUAL {
+		return nil, 0, false
+	}
+	threshold := 0
+	switch last := tokens[len(tokens)-2]; {
+	case last.op >= txscript.OP_1 && last.op <= txscript.OP_16:
+		threshold = int(last.op-txscript.OP_1) + 1
+	case len(last.data) > 0 && len(last.data) <= 2 && last.data[len(last.data)-1]&0x80 == 0:
+		for k, b := range last.data {
+			threshold |= int(b) << (8 * k)
+		}
+	}
+	if threshold < 1 || threshold > n {
+		return nil, 0, false
+	}
+	return keys, threshold, true
+}
+
+// finalizeMultisigInput finalizes input i when it spends a P2WSH or
+// P2SH-P2WSH multisig or a multi_a leaf, with the signatures of the first
+// threshold keys that signed. It reports false for the other inputs, which
+// are left to the btcd finalizer.
+func finalizeMultisigInput(p *psbt.Packet, i int) (bool, error) {
+	in := &p.Inputs[i]
+	if in.FinalScriptSig != nil || in.FinalScriptWitness != nil || in.WitnessUtxo == nil {
+		return false, nil
+	}
+	var (
+		sigScript []byte
+		witness   wire.TxWitness
+	)
+	if in.WitnessScript != nil {
+		if ok, err := txscript.IsMultisigScript(in.WitnessScript); err != nil || !ok {
+			return false, nil
+		}
+		_, threshold, err := txscript.CalcMultiSigStats(in.WitnessScript)
+		if err != nil {
+			return false, err
+		}
+		pushes, err := txscript.PushedData(in.WitnessScript)
+		if err != nil {
+			return false, err
+		}
+		witness = wire.TxWitness{nil}
+		for _, key := range pushes {
+			for _, sig := range in.PartialSigs {
+				if len(witness) <= threshold && bytes.Equal(sig.PubKey, key) {
+					witness = append(witness, sig.Signature)
+				}
+			}
+		}
+		if len(witness) <= threshold {
+			return false, fmt.Errorf("%w: input %d has %d of %d", ErrMultisigSignatures, i, len(witness)-1, threshold)
+		}
+		witness = append(witness, in.WitnessScript)
+		if in.RedeemScript != nil {
+			if sigScript, err = txscript.NewScriptBuilder().AddData(in.RedeemScript).Script(); err != nil {
+				return false, err
+			}
+		}
+	} else if txscript.IsPayToTaproot(in.WitnessUtxo.PkScript) && len(in.TaprootKeySpendSig) == 0 {
+		var missing error
+		for _, leaf := range in.TaprootLeafScript {
+			keys, threshold, ok := parseMultiAScript(leaf.Script)
+			if !ok {
+				continue
+			}
+			leafHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+			sigs := make([][]byte, len(keys))
+			count := 0
+			for j, key := range keys {
+				for _, sig := range in.TaprootScriptSpendSig {
+					if count < threshold && sigs[j] == nil && bytes.Equal(sig.XOnlyPubKey, key) && bytes.Equal(sig.LeafHash, leafHash[:]) {
+						sigs[j] = append([]byte{}, sig.Signature...)
+						if sig.SigHash != txscript.SigHashDefault {
+							sigs[j] = append(sigs[j], byte(sig.SigHash))
+						}
+						count++
+					}
+				}
+			}
+			if count < threshold {
+				missing = fmt.Errorf("%w: input %d has %d of %d", ErrMultisigSignatures, i, count, threshold)
+				continue
+			}
+			// the first key checks the top of the stack, keys that did not
+			// sign get an empty signature
+			for j := len(keys) - 1; j >= 0; j-- {
+				witness = append(witness, sigs[j])
+			}
+			witness = append(witness, leaf.Script, leaf.ControlBlock)
+			break
+		}
+		if witness == nil {
+			return false, missing
+		}
+	} else {
+		return false, nil
+	}
+
+	var buf bytes.Buffer
+	if err := psbt.WriteTxWitness(&buf, witness); err != nil {
+		return false, err
+	}
+	finalized := psbt.NewPsbtInput(nil, in.WitnessUtxo)
+	if len(sigScript) > 0 {
+		finalized.FinalScriptSig = sigScript
+	}
+	finalized.FinalScriptWitness = buf.Bytes()
+	p.Inputs[i] = *finalized
+	return true, nil
+}
@@ -0,0 +1,170 @@
+package bitcoin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/okx/go-wallet-sdk/crypto/go-bip32"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"testing"
+)
+
+// multisigTestCosigners returns the BIP-48 account keys of three cosigners
+// as Sparrow exports them, and their WIF keys of the address at index.
+func multisigTestCosigners(t *testing.T, index uint32, network *chaincfg.Params) ([]string, []string) {
+	var keys, wifs []string
+	for i := 1; i <= 3; i++ {
+		master, err := bip32.NewMasterKey(bytes.Repeat([]byte{byte(i)}, 32))
+		require.NoError(t, err)
+		account, err := master.NewChildKeyByPathString("m/48'/1'/0'/2'")
+		require.NoError(t, err)
+		fingerprint := btcutil.Hash160(master.PublicKey().Key)[:4]
+		keys = append(keys, fmt.Sprintf("[%x/48'/1'/0'/2']%s/0/*", fingerprint, account.PublicKey().B58Serialize()))
+		child, err := master.NewChildKeyByPathString(fmt.Sprintf("m/48'/1'/0'/2'/0/%d", index))
+		require.NoError(t, err)
+		privKey, _ := btcec.PrivKeyFromBytes(child.Key)
+		wif, err := btcutil.NewWIF(privKey, network, true)
+		require.NoError(t, err)
+		wifs = append(wifs, wif.String())
+	}
+	return keys, wifs
+}
+
+func TestMultisigWallet(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	keys, wifs := multisigTestCosigners(t, 5, network)
+	txId := "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206"
+
+	for _, scriptType := range []string{MultisigP2WSH, MultisigP2SHP2WSH, MultisigP2TR} {
+		t.Run(scriptType, func(t *testing.T) {
+			desc, err := NewMultisigDescriptor(scriptType, 2, keys, true)
+			require.NoError(t, err)
+			w, err := NewMultisigWallet(desc, network)
+			require.NoError(t, err)
+			assert.Equal(t, scriptType, w.ScriptType)
+			assert.Equal(t, 2, w.Threshold)
+			assert.Equal(t, desc, w.Descriptor())
+			addr, err := w.Address(5)
+			require.NoError(t, err)
+			change, err := w.Address(6)
+			require.NoError(t, err)
+
+			p, err := NewPsbtPacket(0, 2, 0)
+			require.NoError(t, err)
+			require.NoError(t, p.AddInput(&TxInput{TxId: txId, VOut: 0}))
+			require.NoError(t, p.AddOutput(&TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 60000}, network))
+			require.NoError(t, p.AddOutput(&TxOutput{Address: change, Amount: 39000}, network))
+			require.NoError(t, w.UpdatePsbtInput(p, 0, 5, 100000))
+			require.NoError(t, w.UpdatePsbtOutput(p, 1, 6))
+			assert.ErrorIs(t, w.UpdatePsbtOutput(p, 0, 6), ErrMultisigScriptMismatch)
+			assert.ErrorIs(t, w.UpdatePsbtInput(p, 0, 6, 100000), ErrMultisigScriptMismatch)
+			if scriptType == MultisigP2TR {
+				require.Equal(t, 3, len(p.Packet.Inputs[0].TaprootBip32Derivation))
+				assert.Equal(t, 1, len(p.Packet.Inputs[0].TaprootBip32Derivation[0].LeafHashes))
+				assert.Equal(t, 3, len(p.Packet.Outputs[1].TaprootBip32Derivation))
+				assert.NotEmpty(t, p.Packet.Outputs[1].TaprootTapTree)
+			} else {
+				require.Equal(t, 3, len(p.Packet.Inputs[0].Bip32Derivation))
+				assert.Equal(t, "m/48'/1'/0'/2'/0/5", formatDerivationPath(p.Packet.Inputs[0].Bip32Derivation[0].Bip32Path))
+				assert.Equal(t, 3, len(p.Packet.Outputs[1].Bip32Derivation))
+				assert.NotNil(t, p.Packet.Outputs[1].WitnessScript)
+			}
+			b64, err := p.B64Encode()
+			require.NoError(t, err)
+			prevOutputs := PrevOutputs{{TxId: txId, VOut: 0, Amount: 100000, Address: addr}}
+
+			// the first and third cosigners sign their own copies
+			var partial []*PsbtPacket
+			for _, j := range []int{0, 2} {
+				cp, err := ParsePsbtPacket(b64)
+				require.NoError(t, err)
+				signed, err := cp.Sign(wifs[j])
+				require.NoError(t, err)
+				assert.Equal(t, 1, signed)
+				partial = append(partial, cp)
+			}
+			assert.ErrorIs(t, partial[0].Finalize(), ErrMultisigSignatures)
+			combined, err := CombinePsbtPackets(partial...)
+			require.NoError(t, err)
+			require.NoError(t, combined.Finalize())
+			txHex, err := combined.Extract()
+			require.NoError(t, err)
+			res, err := VerifyTransaction(txHex, prevOutputs, network)
+			require.NoError(t, err)
+			assert.NoError(t, res.Err())
+
+			// a signature beyond the threshold is left out
+			cp, err := ParsePsbtPacket(b64)
+			require.NoError(t, err)
+			for _, wif := range wifs {
+				_, err := cp.Sign(wif)
+				require.NoError(t, err)
+			}
+			require.NoError(t, cp.Finalize())
+			txHex, err = cp.Extract()
+			require.NoError(t, err)
+			res, err = VerifyTransaction(txHex, prevOutputs, network)
+			require.NoError(t, err)
+			assert.NoError(t, res.Err())
+		})
+	}
+}
+
+func TestGenerateMultisigAddress(t *testing.T) {
+	var pubKeys [][]byte
+	var pubKeysHex []string
+	for i := 1; i <= 3; i++ {
+		_, pubKey := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{byte(i)}, 32))
+		pubKeys = append(pubKeys, pubKey.SerializeCompressed())
+		pubKeysHex = append(pubKeysHex, hex.EncodeToString(pubKey.SerializeCompressed()))
+	}
+	sort.Slice(pubKeys, func(i, j int) bool {
+		return bytes.Compare(pubKeys[i], pubKeys[j]) < 0
+	})
+	builder := txscript.NewScriptBuilder().AddInt64(2)
+	for _, pubKey := range pubKeys {
+		builder.AddData(pubKey)
+	}
+	witnessScript, err := builder.AddInt64(3).AddOp(txscript.OP_CHECKMULTISIG).Script()
+	require.NoError(t, err)
+	scriptHash := sha256.Sum256(witnessScript)
+	expected, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], &chaincfg.MainNetParams)
+	require.NoError(t, err)
+
+	addr, err := GenerateMultisigAddress(MultisigP2WSH, 2, pubKeysHex, nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected.EncodeAddress(), addr)
+
+	addr, err = GenerateMultisigAddress(MultisigP2TR, 2, pubKeysHex, nil)
+	require.NoError(t, err)
+	desc, err := NewMultisigDescriptor(MultisigP2TR, 2, pubKeysHex, true)
+	require.NoError(t, err)
+	d, err := ParseDescriptor(desc)
+	require.NoError(t, err)
+	out, err := d.Derive(0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, addr, out.Address)
+	leaf, err := d.root.tree.leaf.expand(0, &chaincfg.MainNetParams, &DescriptorOutput{})
+	require.NoError(t, err)
+	keys, threshold, ok := parseMultiAScript(leaf)
+	require.True(t, ok)
+	assert.Equal(t, 2, threshold)
+	require.Equal(t, 3, len(keys))
+	assert.True(t, bytes.Compare(keys[0], keys[1]) < 0 && bytes.Compare(keys[1], keys[2]) < 0)
+
+	_, err = GenerateMultisigAddress("p2sh", 2, pubKeysHex, nil)
+	assert.ErrorIs(t, err, ErrNotMultisig)
+	_, err = GenerateMultisigAddress(MultisigP2WSH, 4, pubKeysHex, nil)
+	assert.ErrorIs(t, err, ErrInvalidDescriptor)
+	_, err = ParseDescriptor("wsh(multi_a(1," + pubKeysHex[0] + "))")
+	assert.ErrorIs(t, err, ErrInvalidDescriptor)
+	_, err = NewMultisigWallet("wpkh("+pubKeysHex[0]+")", nil)
+	assert.ErrorIs(t, err, ErrNotMultisig)
+}
@@ -0,0 +1,775 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"sort"
+)
+
+// BIP-373 MuSig2 PSBT field types.
+const (
+	PsbtInMuSig2ParticipantPubKeys  psbt.InputType  = 0x1a
+	PsbtInMuSig2PubNonce            psbt.InputType  = 0x1b
+	PsbtInMuSig2PartialSig          psbt.InputType  = 0x1c
+	PsbtOutMuSig2ParticipantPubKeys psbt.OutputType = 0x08
+)
+
+var (
+	ErrMuSig2MissingNonce   = errors.New("musig2 public nonce missing")
+	ErrMuSig2InvalidPartial = errors.New("musig2 partial signature is invalid")
+	ErrInvalidPsbtUtxo      = errors.New("invalid psbt input utxo")
+	ErrMuSig2AggregateKey   = errors.New("musig2 aggregate key does not match the participants")
+)
+
+type MuSig2KeyAgg struct {
+	PubKeys      []string `json:"pubKeys"`      // participant keys in the aggregation order
+	AggregateKey string   `json:"aggregateKey"` // untweaked aggregate, compressed
+	InternalKey  string   `json:"internalKey"`  // x-only taproot internal key
+	OutputKey    string   `json:"outputKey"`    // x-only tweaked taproot output key
+	MerkleRoot   string   `json:"merkleRoot"`
+}
+
+// MuSig2Session is the state shared by the signers of one message. It holds no
+// secret and can be passed between processes with Serialize.
+type MuSig2Session struct {
+	PubKeys     []string          `json:"pubKeys"`
+	MerkleRoot  string            `json:"merkleRoot"` // empty for a BIP-86 key-path only output
+	Message     string            `json:"message"`
+	PubNonces   map[string]string `json:"pubNonces"`
+	PartialSigs map[string]string `json:"partialSigs"`
+}
+
+// parseMuSig2PubKeys parses pubKeys in the order given, BIP-327 leaving the
+// sorting to the caller, and returns them hex encoded in compressed form.
+func parseMuSig2PubKeys(pubKeys []string) ([]*btcec.PublicKey, []string, error) {
+	if len(pubKeys) < 2 {
+		return nil, nil, errors.New("musig2 needs at least two public keys")
+	}
+	keys := make([]*btcec.PublicKey, 0, len(pubKeys))
+	encoded := make([]string, 0, len(pubKeys))
+	for _, v := range pubKeys {
+		b, err := hex.DecodeString(v)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err := btcec.ParsePubKey(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+		encoded = append(encoded, hex.EncodeToString(key.SerializeCompressed()))
+	}
+	return keys, encoded, nil
+}
+
+// MuSig2KeySort sorts pubKeys by their compressed encoding, the KeySort of
+// BIP-327, for an aggregate which does not depend on the order the
+// participants list their keys in.
+func MuSig2KeySort(pubKeys []string) ([]string, error) {
+	_, encoded, err := parseMuSig2PubKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	// the lowercase hex of the keys sorts like their bytes
+	sort.Strings(encoded)
+	return encoded, nil
+}
+
+// muSig2PubKeyHex returns pubKey hex encoded in compressed form, as the keys
+// of a session are.
+func muSig2PubKeyHex(pubKey string) (string, error) {
+	b, err := hex.DecodeString(pubKey)
+	if err != nil {
+		return "", err
+	}
+	key, err := btcec.ParsePubKey(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key.SerializeCompressed()), nil
+}
+
+func muSig2TweakOption(merkleRoot []byte) musig2.KeyAggOption {
+	if len(merkleRoot) == 0 {
+		return musig2.WithBIP86KeyTweak()
+	}
+	return musig2.WithTaprootKeyTweak(merkleRoot)
+}
+
+func muSig2SignTweakOption(merkleRoot []byte) musig2.SignOption {
+	if len(merkleRoot) == 0 {
+		return musig2.WithBip86SignTweak()
+	}
+	return musig2.WithTaprootSignTweak(merkleRoot)
+}
+
+// MuSig2AggregateKeys aggregates pubKeys in the order given and applies the
+// taproot tweak; MuSig2KeySort makes the aggregate independent of the order. A
+// nil merkleRoot gives a BIP-86 key-path only output.
+func MuSig2AggregateKeys(pubKeys []string, merkleRoot []byte) (*MuSig2KeyAgg, error) {
+	keys, encoded, err := parseMuSig2PubKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	untweaked, _, _, err := musig2.AggregateKeys(keys, false)
+	if err != nil {
+		return nil, err
+	}
+	tweaked, _, _, err := musig2.AggregateKeys(keys, false, muSig2TweakOption(merkleRoot))
+	if err != nil {
+		return nil, err
+	}
+	return &MuSig2KeyAgg{
+		PubKeys:      encoded,
+		AggregateKey: hex.EncodeToString(untweaked.FinalKey.SerializeCompressed()),
+		InternalKey:  hex.EncodeToString(schnorr.SerializePubKey(tweaked.PreTweakedKey)),
+		OutputKey:    hex.EncodeToString(schnorr.SerializePubKey(tweaked.FinalKey)),
+		MerkleRoot:   hex.EncodeToString(merkleRoot),
+	}, nil
+}
+
+// MuSig2Address returns the taproot address of the n-of-n aggregate of pubKeys.
+func MuSig2Address(pubKeys []string, merkleRoot []byte, network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	agg, err := MuSig2AggregateKeys(pubKeys, merkleRoot)
+	if err != nil {
+		return "", err
+	}
+	outputKey, err := hex.DecodeString(agg.OutputKey)
+	if err != nil {
+		return "", err
+	}
+	addr, err := btcutil.NewAddressTaproot(outputKey, network)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+// NewMuSig2Session starts a signing session of msg, a 32-byte taproot sighash,
+// for the aggregate of pubKeys in the order given.
+func NewMuSig2Session(pubKeys []string, merkleRoot []byte, msg []byte) (*MuSig2Session, error) {
+	if len(msg) != 32 {
+		return nil, errors.New("musig2 message must be 32 bytes")
+	}
+	_, encoded, err := parseMuSig2PubKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &MuSig2Session{
+		PubKeys:     encoded,
+		MerkleRoot:  hex.EncodeToString(merkleRoot),
+		Message:     hex.EncodeToString(msg),
+		PubNonces:   make(map[string]string),
+		PartialSigs: make(map[string]string),
+	}, nil
+}
+
+func (s *MuSig2Session) Serialize() (string, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func DeserializeMuSig2Session(data string) (*MuSig2Session, error) {
+	s := &MuSig2Session{}
+	if err := json.Unmarshal([]byte(data), s); err != nil {
+		return nil, err
+	}
+	if _, _, err := s.params(); err != nil {
+		return nil, err
+	}
+	if s.PubNonces == nil {
+		s.PubNonces = make(map[string]string)
+	}
+	if s.PartialSigs == nil {
+		s.PartialSigs = make(map[string]string)
+	}
+	return s, nil
+}
+
+func (s *MuSig2Session) params() ([]*btcec.PublicKey, [32]byte, error) {
+	var msg [32]byte
+	keys, _, err := parseMuSig2PubKeys(s.PubKeys)
+	if err != nil {
+		return nil, msg, err
+	}
+	b, err := hex.DecodeString(s.Message)
+	if err != nil || len(b) != 32 {
+		return nil, msg, errors.New("musig2 message must be 32 bytes")
+	}
+	copy(msg[:], b)
+	if _, err := hex.DecodeString(s.MerkleRoot); err != nil {
+		return nil, msg, err
+	}
+	return keys, msg, nil
+}
+
+func (s *MuSig2Session) merkleRoot() []byte {
+	b, _ := hex.DecodeString(s.MerkleRoot)
+	return b
+}
+
+func (s *MuSig2Session) isParticipant(pubKey string) bool {
+	for _, v := range s.PubKeys {
+		if v == pubKey {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateNonce creates the nonce of privKey, a WIF key, for this session and
+// records the public nonce. The secret nonce must be kept by the signer, used
+// for exactly one Sign call and then discarded: reusing it leaks the key.
+func (s *MuSig2Session) GenerateNonce(privKey string) (string, string, error) {
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return "", "", err
+	}
+	keys, msg, err := s.params()
+	if err != nil {
+		return "", "", err
+	}
+	pubKey := hex.EncodeToString(wif.PrivKey.PubKey().SerializeCompressed())
+	if !s.isParticipant(pubKey) {
+		return "", "", errors.New("key is not a participant of the session")
+	}
+	agg, _, _, err := musig2.AggregateKeys(keys, false, muSig2TweakOption(s.merkleRoot()))
+	if err != nil {
+		return "", "", err
+	}
+	nonces, err := musig2.GenNonces(
+		musig2.WithPublicKey(wif.PrivKey.PubKey()),
+		musig2.WithNonceSecretKeyAux(wif.PrivKey),
+		musig2.WithNonceCombinedKeyAux(agg.FinalKey),
+		musig2.WithNonceMessageAux(msg),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	pubNonce := hex.EncodeToString(nonces.PubNonce[:])
+	s.PubNonces[pubKey] = pubNonce
+	return hex.EncodeToString(nonces.SecNonce[:]), pubNonce, nil
+}
+
+// AddPubNonce records the public nonce of another participant.
+func (s *MuSig2Session) AddPubNonce(pubKey, pubNonce string) error {
+	pubKey, err := muSig2PubKeyHex(pubKey)
+	if err != nil {
+		return err
+	}
+	if !s.isParticipant(pubKey) {
+		return errors.New("key is not a participant of the session")
+	}
+	b, err := hex.DecodeString(pubNonce)
+	if err != nil || len(b) != musig2.PubNonceSize {
+		return errors.New("invalid musig2 public nonce")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := btcec.ParsePubKey(b[i*33 : (i+1)*33]); err != nil {
+			return err
+		}
+	}
+	s.PubNonces[pubKey] = pubNonce
+	return nil
+}
+
+func (s *MuSig2Session) pubNonce(pubKey string) ([musig2.PubNonceSize]byte, error) {
+	var nonce [musig2.PubNonceSize]byte
+	v, ok := s.PubNonces[pubKey]
+	if !ok {
+		return nonce, ErrMuSig2MissingNonce
+	}
+	b, err := hex.DecodeString(v)
+	if err != nil || len(b) != musig2.PubNonceSize {
+		return nonce, errors.New("invalid musig2 public nonce")
+	}
+	copy(nonce[:], b)
+	return nonce, nil
+}
+
+// AggregateNonce returns the aggregate of all participants' public nonces.
+func (s *MuSig2Session) AggregateNonce() ([musig2.PubNonceSize]byte, error) {
+	nonces := make([][musig2.PubNonceSize]byte, 0, len(s.PubKeys))
+	for _, pubKey := range s.PubKeys {
+		nonce, err := s.pubNonce(pubKey)
+		if err != nil {
+			return [musig2.PubNonceSize]byte{}, err
+		}
+		nonces = append(nonces, nonce)
+	}
+	return musig2.AggregateNonces(nonces)
+}
+
+// Sign creates the partial signature of privKey once every public nonce is known.
+func (s *MuSig2Session) Sign(privKey string, secNonce string) (string, error) {
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return "", err
+	}
+	keys, msg, err := s.params()
+	if err != nil {
+		return "", err
+	}
+	b, err := hex.DecodeString(secNonce)
+	if err != nil || len(b) != musig2.SecNonceSize {
+		return "", errors.New("invalid musig2 secret nonce")
+	}
+	var nonce [musig2.SecNonceSize]byte
+	copy(nonce[:], b)
+	aggNonce, err := s.AggregateNonce()
+	if err != nil {
+		return "", err
+	}
+	partialSig, err := musig2.Sign(nonce, wif.PrivKey, aggNonce, keys, msg, muSig2SignTweakOption(s.merkleRoot()))
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := partialSig.Encode(&buf); err != nil {
+		return "", err
+	}
+	sig := hex.EncodeToString(buf.Bytes())
+	s.PartialSigs[hex.EncodeToString(wif.PrivKey.PubKey().SerializeCompressed())] = sig
+	return sig, nil
+}
+
+// AddPartialSig verifies and records the partial signature of a participant.
+func (s *MuSig2Session) AddPartialSig(pubKey, partialSig string) error {
+	keys, msg, err := s.params()
+	if err != nil {
+		return err
+	}
+	if pubKey, err = muSig2PubKeyHex(pubKey); err != nil {
+		return err
+	}
+	if !s.isParticipant(pubKey) {
+		return errors.New("key is not a participant of the session")
+	}
+	sig, err := decodeMuSig2PartialSig(partialSig)
+	if err != nil {
+		return err
+	}
+	pubNonce, err := s.pubNonce(pubKey)
+	if err != nil {
+		return err
+	}
+	aggNonce, err := s.AggregateNonce()
+	if err != nil {
+		return err
+	}
+	keyBytes, _ := hex.DecodeString(pubKey)
+	signingKey, err := btcec.ParsePubKey(keyBytes)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(pubNonce, aggNonce, keys, signingKey, msg, muSig2SignTweakOption(s.merkleRoot())) {
+		return ErrMuSig2InvalidPartial
+	}
+	s.PartialSigs[pubKey] = partialSig
+	return nil
+}
+
+func decodeMuSig2PartialSig(partialSig string) (*musig2.PartialSignature, error) {
+	b, err := hex.DecodeString(partialSig)
+	if err != nil || len(b) != 32 {
+		return nil, errors.New("invalid musig2 partial signature")
+	}
+	sig := &musig2.PartialSignature{}
+	if err := sig.Decode(bytes.NewReader(b)); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// FinalSignature aggregates the partial signatures into a BIP-340 signature
+// valid for the tweaked output key.
+func (s *MuSig2Session) FinalSignature() ([]byte, error) {
+	keys, msg, err := s.params()
+	if err != nil {
+		return nil, err
+	}
+	sigs := make([]*musig2.PartialSignature, 0, len(s.PubKeys))
+	for _, pubKey := range s.PubKeys {
+		v, ok := s.PartialSigs[pubKey]
+		if !ok {
+			return nil, fmt.Errorf("missing partial signature of %s", pubKey)
+		}
+		sig, err := decodeMuSig2PartialSig(v)
+		if err != nil {
+			return nil, err
+		}
+		sigs = append(sigs, sig)
+	}
+	aggNonce, err := s.AggregateNonce()
+	if err != nil {
+		return nil, err
+	}
+	agg, _, _, err := musig2.AggregateKeys(keys, false, muSig2TweakOption(s.merkleRoot()))
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := muSig2FinalNonce(aggNonce, agg.FinalKey, msg)
+	if err != nil {
+		return nil, err
+	}
+	var combine musig2.CombineOption
+	if len(s.merkleRoot()) == 0 {
+		combine = musig2.WithBip86TweakedCombine(msg, keys, false)
+	} else {
+		combine = musig2.WithTaprootTweakedCombine(msg, keys, s.merkleRoot(), false)
+	}
+	sig := musig2.CombineSigs(nonce, sigs, combine)
+	if !sig.Verify(msg[:], agg.FinalKey) {
+		return nil, errors.New("aggregated musig2 signature is invalid")
+	}
+	return sig.Serialize(), nil
+}
+
+// muSig2FinalNonce computes R = R1 + b*R2 of BIP-327 from the aggregate nonce.
+func muSig2FinalNonce(aggNonce [musig2.PubNonceSize]byte, outputKey *btcec.PublicKey, msg [32]byte) (*btcec.PublicKey, error) {
+	var buf bytes.Buffer
+	buf.Write(aggNonce[:])
+	buf.Write(schnorr.SerializePubKey(outputKey))
+	buf.Write(msg[:])
+	h := chainhash.TaggedHash(musig2.NonceBlindTag, buf.Bytes())
+	var b btcec.ModNScalar
+	b.SetByteSlice(h[:])
+
+	r1, err := btcec.ParseJacobian(aggNonce[:btcec.PubKeyBytesLenCompressed])
+	if err != nil {
+		return nil, err
+	}
+	r2, err := btcec.ParseJacobian(aggNonce[btcec.PubKeyBytesLenCompressed:])
+	if err != nil {
+		return nil, err
+	}
+	var r btcec.JacobianPoint
+	btcec.ScalarMultNonConst(&b, &r2, &r2)
+	btcec.AddNonConst(&r1, &r2, &r)
+	if (r.X.IsZero() && r.Y.IsZero()) || r.Z.IsZero() {
+		btcec.Generator().AsJacobian(&r)
+	}
+	r.ToAffine()
+	return btcec.NewPublicKey(&r.X, &r.Y), nil
+}
+
+// muSig2PsbtInput is the BIP-373 state of one PSBT input.
+type muSig2PsbtInput struct {
+	aggregateKey []byte
+	session      *MuSig2Session
+}
+
+func muSig2UnknownKey(keyType psbt.InputType, keyData ...[]byte) []byte {
+	return append([]byte{byte(keyType)}, bytes.Join(keyData, nil)...)
+}
+
+func setPsbtUnknown(unknowns []*psbt.Unknown, key, value []byte) []*psbt.Unknown {
+	for _, u := range unknowns {
+		if bytes.Equal(u.Key, key) {
+			u.Value = value
+			return unknowns
+		}
+	}
+	return append(unknowns, &psbt.Unknown{Key: key, Value: value})
+}
+
+// checkPsbtUtxos checks the utxos of the inputs of p: a non-witness utxo must
+// be the transaction of the outpoint and hold its output, a witness utxo next
+// to it must be that output.
+func checkPsbtUtxos(p *psbt.Packet) error {
+	for i, in := range p.UnsignedTx.TxIn {
+		utxo := p.Inputs[i].NonWitnessUtxo
+		if utxo == nil {
+			continue
+		}
+		if utxo.TxHash() != in.PreviousOutPoint.Hash {
+			return fmt.Errorf("%w: input %d non-witness utxo is not the spent transaction", ErrInvalidPsbtUtxo, i)
+		}
+		if int(in.PreviousOutPoint.Index) >= len(utxo.TxOut) {
+			return fmt.Errorf("%w: input %d spends output %d of %d", ErrInvalidPsbtUtxo, i, in.PreviousOutPoint.Index, len(utxo.TxOut))
+		}
+		prevOut := utxo.TxOut[in.PreviousOutPoint.Index]
+		if w := p.Inputs[i].WitnessUtxo; w != nil && (w.Value != prevOut.Value || !bytes.Equal(w.PkScript, prevOut.PkScript)) {
+			return fmt.Errorf("%w: input %d witness utxo differs from the non-witness utxo", ErrInvalidPsbtUtxo, i)
+		}
+	}
+	return nil
+}
+
+// psbtPrevOutFetcher returns the outputs the inputs of p spend, callers check
+// them with checkPsbtUtxos first.
+func psbtPrevOutFetcher(p *psbt.Packet) *txscript.MultiPrevOutFetcher {
+	prevOuts := make(map[wire.OutPoint]*wire.TxOut)
+	for i, in := range p.UnsignedTx.TxIn {
+		if utxo := p.Inputs[i].NonWitnessUtxo; utxo != nil && int(in.PreviousOutPoint.Index) < len(utxo.TxOut) {
+			prevOuts[in.PreviousOutPoint] = utxo.TxOut[in.PreviousOutPoint.Index]
+		}
+		if p.Inputs[i].WitnessUtxo != nil {
+			prevOuts[in.PreviousOutPoint] = p.Inputs[i].WitnessUtxo
+		}
+	}
+	return txscript.NewMultiPrevOutFetcher(prevOuts)
+}
+
+// muSig2InputSession rebuilds the session of input i from its BIP-373 fields,
+// it returns nil for inputs without MuSig2 participants. Script path entries,
+// the ones keyed with a leaf hash, are ignored.
+func muSig2InputSession(p *psbt.Packet, i int, fetcher *txscript.MultiPrevOutFetcher) (*muSig2PsbtInput, error) {
+	in := &p.Inputs[i]
+	var state *muSig2PsbtInput
+	for _, u := range in.Unknowns {
+		if len(u.Key) == 1+33 && psbt.InputType(u.Key[0]) == PsbtInMuSig2ParticipantPubKeys {
+			if len(u.Value) == 0 || len(u.Value)%33 != 0 {
+				return nil, errors.New("invalid musig2 participant pubkeys")
+			}
+			var pubKeys []string
+			for j := 0; j < len(u.Value); j += 33 {
+				pubKeys = append(pubKeys, hex.EncodeToString(u.Value[j:j+33]))
+			}
+			// BIP-373 aggregates the participants in the order they are stored
+			agg, err := MuSig2AggregateKeys(pubKeys, nil)
+			if err != nil {
+				return nil, err
+			}
+			if agg.AggregateKey != hex.EncodeToString(u.Key[1:]) {
+				return nil, fmt.Errorf("%w: input %d", ErrMuSig2AggregateKey, i)
+			}
+			// an explicit SIGHASH_ALL is signed as such, not as DEFAULT
+			sigHash, err := txscript.CalcTaprootSignatureHash(txscript.NewTxSigHashes(p.UnsignedTx, fetcher), in.SighashType, p.UnsignedTx, i, fetcher)
+			if err != nil {
+				return nil, err
+			}
+			session, err := NewMuSig2Session(pubKeys, in.TaprootMerkleRoot, sigHash)
+			if err != nil {
+				return nil, err
+			}
+			state = &muSig2PsbtInput{aggregateKey: u.Key[1:], session: session}
+		}
+	}
+	if state == nil {
+		return nil, nil
+	}
+	for _, u := range in.Unknowns {
+		if len(u.Key) != 1+33+33 || !bytes.Equal(u.Key[34:], state.aggregateKey) {
+			continue
+		}
+		pubKey := hex.EncodeToString(u.Key[1:34])
+		switch psbt.InputType(u.Key[0]) {
+		case PsbtInMuSig2PubNonce:
+			if err := state.session.AddPubNonce(pubKey, hex.EncodeToString(u.Value)); err != nil {
+				return nil, err
+			}
+		case PsbtInMuSig2PartialSig:
+			state.session.PartialSigs[pubKey] = hex.EncodeToString(u.Value)
+		}
+	}
+	return state, nil
+}
+
+// GenerateMuSig2UnsignedPSBT prepares the taproot inputs paying to the MuSig2
+// aggregate of pubKeys, in the order given, for signing: it sets the internal
+// key and the BIP-373 participant field, and returns the key-path sighash of each input, empty for
+// inputs the aggregate does not control. Outputs paying back to the BIP-86
+// aggregate get the BIP-373 output participant field.
+func GenerateMuSig2UnsignedPSBT(psbtStr string, pubKeys []string) (*GenerateMPCPSbtTxRes, error) {
+	p, err := GetPsbtFromString(psbtStr)
+	if err != nil {
+		return nil, err
+	}
+	keys, encoded, err := parseMuSig2PubKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	untweaked, _, _, err := musig2.AggregateKeys(keys, false)
+	if err != nil {
+		return nil, err
+	}
+	aggregateKey := untweaked.FinalKey.SerializeCompressed()
+	var participants []byte
+	for _, v := range encoded {
+		b, _ := hex.DecodeString(v)
+		participants = append(participants, b...)
+	}
+
+	if err := checkPsbtUtxos(p); err != nil {
+		return nil, err
+	}
+	fetcher := psbtPrevOutFetcher(p)
+	sigHashList := make([]string, len(p.Inputs))
+	for i := range p.Inputs {
+		prevOut := fetcher.FetchPrevOutput(p.UnsignedTx.TxIn[i].PreviousOutPoint)
+		if prevOut == nil || !txscript.IsPayToTaproot(prevOut.PkScript) {
+			continue
+		}
+		in := &p.Inputs[i]
+		agg, _, _, err := musig2.AggregateKeys(keys, false, muSig2TweakOption(in.TaprootMerkleRoot))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(prevOut.PkScript[2:], schnorr.SerializePubKey(agg.FinalKey)) {
+			continue
+		}
+		in.TaprootInternalKey = schnorr.SerializePubKey(agg.PreTweakedKey)
+		in.Unknowns = setPsbtUnknown(in.Unknowns, muSig2UnknownKey(PsbtInMuSig2ParticipantPubKeys, aggregateKey), participants)
+		state, err := muSig2InputSession(p, i, fetcher)
+		if err != nil {
+			return nil, err
+		}
+		sigHashList[i] = state.session.Message
+	}
+	// change back to the aggregate is tagged so the signers can recognise it
+	bip86, _, _, err := musig2.AggregateKeys(keys, false, musig2.WithBIP86KeyTweak())
+	if err != nil {
+		return nil, err
+	}
+	for i, out := range p.UnsignedTx.TxOut {
+		if !txscript.IsPayToTaproot(out.PkScript) || !bytes.Equal(out.PkScript[2:], schnorr.SerializePubKey(bip86.FinalKey)) {
+			continue
+		}
+		p.Outputs[i].TaprootInternalKey = schnorr.SerializePubKey(bip86.PreTweakedKey)
+		key := append([]byte{byte(PsbtOutMuSig2ParticipantPubKeys)}, aggregateKey...)
+		p.Outputs[i].Unknowns = setPsbtUnknown(p.Outputs[i].Unknowns, key, participants)
+	}
+	psbtBase64, err := p.B64Encode()
+	if err != nil {
+		return nil, err
+	}
+	return &GenerateMPCPSbtTxRes{PsbtTx: psbtBase64, SignHashList: sigHashList}, nil
+}
+
+// MuSig2PsbtNonceRound adds the public nonce of privKey to every input it
+// takes part in. The returned secret nonces, by input index and empty for
+// other inputs, must be kept for MuSig2PsbtSignRound and never reused.
+func MuSig2PsbtNonceRound(psbtStr string, privKey string) (string, []string, error) {
+	p, err := GetPsbtFromString(psbtStr)
+	if err != nil {
+		return "", nil, err
+	}
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return "", nil, err
+	}
+	pubKey := wif.PrivKey.PubKey().SerializeCompressed()
+	if err := checkPsbtUtxos(p); err != nil {
+		return "", nil, err
+	}
+	fetcher := psbtPrevOutFetcher(p)
+	secNonces := make([]string, len(p.Inputs))
+	for i := range p.Inputs {
+		state, err := muSig2InputSession(p, i, fetcher)
+		if err != nil {
+			return "", nil, err
+		}
+		if state == nil || !state.session.isParticipant(hex.EncodeToString(pubKey)) {
+			continue
+		}
+		secNonce, pubNonce, err := state.session.GenerateNonce(privKey)
+		if err != nil {
+			return "", nil, err
+		}
+		nonce, _ := hex.DecodeString(pubNonce)
+		p.Inputs[i].Unknowns = setPsbtUnknown(p.Inputs[i].Unknowns, muSig2UnknownKey(PsbtInMuSig2PubNonce, pubKey, state.aggregateKey), nonce)
+		secNonces[i] = secNonce
+	}
+	psbtBase64, err := p.B64Encode()
+	if err != nil {
+		return "", nil, err
+	}
+	return psbtBase64, secNonces, nil
+}
+
+// MuSig2PsbtSignRound adds the partial signature of privKey to every input it
+// generated a nonce for, once all participants' nonces are in the PSBT.
+func MuSig2PsbtSignRound(psbtStr string, privKey string, secNonces []string) (string, error) {
+	p, err := GetPsbtFromString(psbtStr)
+	if err != nil {
+		return "", err
+	}
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return "", err
+	}
+	pubKey := wif.PrivKey.PubKey().SerializeCompressed()
+	if err := checkPsbtUtxos(p); err != nil {
+		return "", err
+	}
+	fetcher := psbtPrevOutFetcher(p)
+	for i := range p.Inputs {
+		if i >= len(secNonces) || secNonces[i] == "" {
+			continue
+		}
+		state, err := muSig2InputSession(p, i, fetcher)
+		if err != nil {
+			return "", err
+		}
+		if state == nil {
+			return "", fmt.Errorf("input %d has no musig2 participants", i)
+		}
+		partialSig, err := state.session.Sign(privKey, secNonces[i])
+		if err != nil {
+			return "", err
+		}
+		sig, _ := hex.DecodeString(partialSig)
+		p.Inputs[i].Unknowns = setPsbtUnknown(p.Inputs[i].Unknowns, muSig2UnknownKey(PsbtInMuSig2PartialSig, pubKey, state.aggregateKey), sig)
+	}
+	return p.B64Encode()
+}
+
+// FinalizeMuSig2PSBT verifies and aggregates the partial signatures of every
+// MuSig2 input into its taproot key spend signature and finalizes the PSBT.
+func FinalizeMuSig2PSBT(psbtStr string) (string, error) {
+	p, err := GetPsbtFromString(psbtStr)
+	if err != nil {
+		return "", err
+	}
+	if err := checkPsbtUtxos(p); err != nil {
+		return "", err
+	}
+	fetcher := psbtPrevOutFetcher(p)
+	for i := range p.Inputs {
+		state, err := muSig2InputSession(p, i, fetcher)
+		if err != nil {
+			return "", err
+		}
+		if state == nil {
+			continue
+		}
+		partialSigs := state.session.PartialSigs
+		state.session.PartialSigs = make(map[string]string)
+		for pubKey, sig := range partialSigs {
+			if err := state.session.AddPartialSig(pubKey, sig); err != nil {
+				return "", fmt.Errorf("input %d: %w", i, err)
+			}
+		}
+		sig, err := state.session.FinalSignature()
+		if err != nil {
+			return "", fmt.Errorf("input %d: %w", i, err)
+		}
+		if hashType := p.Inputs[i].SighashType; hashType != txscript.SigHashDefault {
+			sig = append(sig, byte(hashType))
+		}
+		p.Inputs[i].TaprootKeySpendSig = sig
+		if err := psbt.Finalize(p, i); err != nil {
+			return "", err
+		}
+	}
+	return p.B64Encode()
+}
@@ -0,0 +1,470 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestMuSig2AggregateKeys(t *testing.T) {
+	_, pubKeys := testKeys(t, 3, &chaincfg.MainNetParams)
+	a, err := MuSig2AggregateKeys(pubKeys, nil)
+	require.NoError(t, err)
+	assert.Equal(t, pubKeys, a.PubKeys)
+	assert.NotEqual(t, a.InternalKey, a.OutputKey)
+
+	// the keys are aggregated in the order given, MuSig2KeySort sorts them
+	// by their bytes whatever their hex case
+	reordered := []string{strings.ToUpper(pubKeys[2]), pubKeys[0], pubKeys[1]}
+	b, err := MuSig2AggregateKeys(reordered, nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, a.AggregateKey, b.AggregateKey)
+	sorted, err := MuSig2KeySort(pubKeys)
+	require.NoError(t, err)
+	resorted, err := MuSig2KeySort(reordered)
+	require.NoError(t, err)
+	assert.Equal(t, sorted, resorted)
+	for i := 1; i < len(sorted); i++ {
+		assert.True(t, sorted[i-1] < sorted[i])
+	}
+
+	internalKey, _ := hex.DecodeString(a.InternalKey)
+	key, err := schnorr.ParsePubKey(internalKey)
+	require.NoError(t, err)
+	outputKey := txscript.ComputeTaprootKeyNoScript(key)
+	assert.Equal(t, a.OutputKey, hex.EncodeToString(schnorr.SerializePubKey(outputKey)))
+
+	addr, err := MuSig2Address(pubKeys, nil, nil)
+	require.NoError(t, err)
+	taproot, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(outputKey), &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	assert.Equal(t, taproot.EncodeAddress(), addr)
+
+	_, err = MuSig2AggregateKeys(pubKeys[:1], nil)
+	assert.Error(t, err)
+}
+
+// The BIP-327 test vectors, https://github.com/bitcoin/bips/tree/master/bip-0327/vectors.
+var muSig2VectorKeys = []string{
+	"02F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+	"03DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+	"023590A94E768F8E1815C2F24B4D80A8E3149316C3518CE7B7AD338368D038CA66",
+	"020000000000000000000000000000000000000000000000000000000000000005",
+	"02FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+	"04F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+}
+
+func muSig2VectorPubKeys(indices ...int) []string {
+	var pubKeys []string
+	for _, i := range indices {
+		pubKeys = append(pubKeys, muSig2VectorKeys[i])
+	}
+	return pubKeys
+}
+
+func TestMuSig2KeyAggVectors(t *testing.T) {
+	for _, test := range []struct {
+		keys     []int
+		expected string
+	}{
+		{[]int{0, 1, 2}, "90539EEDE565F5D054F32CC0C220126889ED1E5D193BAF15AEF344FE59D4610C"},
+		{[]int{2, 1, 0}, "6204DE8B083426DC6EAF9502D27024D53FC826BF7D2012148A0575435DF54B2B"},
+		{[]int{0, 0, 0}, "B436E3BAD62B8CD409969A224731C193D051162D8C5AE8B109306127DA3AA935"},
+		{[]int{0, 0, 1, 1}, "69BC22BFA5D106306E48A20679DE1D7389386124D07571D0D872686028C26A3E"},
+	} {
+		a, err := MuSig2AggregateKeys(muSig2VectorPubKeys(test.keys...), nil)
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToLower(test.expected), a.AggregateKey[2:], test.keys)
+	}
+	// an invalid key, a key exceeding the field size and a bad first byte
+	for _, keys := range [][]int{{0, 3}, {0, 4}, {5, 0}} {
+		_, err := MuSig2AggregateKeys(muSig2VectorPubKeys(keys...), nil)
+		assert.Error(t, err, keys)
+	}
+}
+
+func TestMuSig2KeySortVectors(t *testing.T) {
+	sorted, err := MuSig2KeySort([]string{
+		"02DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+		"02F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"03DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+		"023590A94E768F8E1815C2F24B4D80A8E3149316C3518CE7B7AD338368D038CA66",
+		"02DD308AFEC5777E13121FA72B9CC1B7CC0139715309B086C960E18FD969774EB8",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"023590a94e768f8e1815c2f24b4d80a8e3149316c3518ce7b7ad338368d038ca66",
+		"02dd308afec5777e13121fa72b9cc1b7cc0139715309b086c960e18fd969774eb8",
+		"02dd308afec5777e13121fa72b9cc1b7cc0139715309b086c960e18fd969774eb8",
+		"02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9",
+		"03dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659",
+	}, sorted)
+}
+
+func TestMuSig2NonceAggVectors(t *testing.T) {
+	pnonces := []string{
+		"020151C80F435648DF67A22B749CD798CE54E0321D034B92B709B567D60A42E66603BA47FBC1834437B3212E89A84D8425E7BF12E0245D98262268EBDCB385D50641",
+		"03FF406FFD8ADB9CD29877E4985014F66A59F6CD01C0E88CAA8E5F3166B1F676A60248C264CDD57D3C24D79990B0F865674EB62A0F9018277A95011B41BFC193B833",
+		"020151C80F435648DF67A22B749CD798CE54E0321D034B92B709B567D60A42E6660279BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798",
+		"03FF406FFD8ADB9CD29877E4985014F66A59F6CD01C0E88CAA8E5F3166B1F676A60379BE667EF9DCBBAC55A06295CE870B07029BFCDB2DCE28D959F2815B16F81798",
+		"04FF406FFD8ADB9CD29877E4985014F66A59F6CD01C0E88CAA8E5F3166B1F676A60248C264CDD57D3C24D79990B0F865674EB62A0F9018277A95011B41BFC193B833",
+		"03FF406FFD8ADB9CD29877E4985014F66A59F6CD01C0E88CAA8E5F3166B1F676A60248C264CDD57D3C24D79990B0F865674EB62A0F9018277A95011B41BFC193B831",
+		"03FF406FFD8ADB9CD29877E4985014F66A59F6CD01C0E88CAA8E5F3166B1F676A602FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFEFFFFFC30",
+	}
+	_, pubKeys := testKeys(t, 2, &chaincfg.MainNetParams)
+	msg := chainhash.HashB([]byte("musig2 message"))
+	for _, test := range []struct {
+		nonces   []int
+		expected string
+	}{
+		{[]int{0, 1}, "035FE1873B4F2967F52FEA4A06AD5A8ECCBE9D0FD73068012C894E2E87CCB5804B024725377345BDE0E9C33AF3C43C0A29A9249F2F2956FA8CFEB55C8573D0262DC8"},
+		// the second points sum to infinity, serialized as 33 zero bytes
+		{[]int{2, 3}, "035FE1873B4F2967F52FEA4A06AD5A8ECCBE9D0FD73068012C894E2E87CCB5804B000000000000000000000000000000000000000000000000000000000000000000"},
+	} {
+		session, err := NewMuSig2Session(pubKeys, nil, msg)
+		require.NoError(t, err)
+		for i, pubKey := range pubKeys {
+			require.NoError(t, session.AddPubNonce(pubKey, pnonces[test.nonces[i]]))
+		}
+		aggNonce, err := session.AggregateNonce()
+		require.NoError(t, err)
+		assert.Equal(t, strings.ToLower(test.expected), hex.EncodeToString(aggNonce[:]))
+	}
+	// a wrong tag, an x coordinate not on the curve and one exceeding the field size
+	session, err := NewMuSig2Session(pubKeys, nil, msg)
+	require.NoError(t, err)
+	for _, i := range []int{4, 5, 6} {
+		assert.Error(t, session.AddPubNonce(pubKeys[0], pnonces[i]), i)
+	}
+}
+
+// muSig2VectorSign signs with the BIP-327 sign and tweak vector key, nonce
+// and message, the participants being pubKeys ordered by keys.
+func muSig2VectorSign(t *testing.T, pubKeys []string, keys []int, aggNonce string, opts ...musig2.SignOption) string {
+	var ordered []string
+	for _, i := range keys {
+		ordered = append(ordered, pubKeys[i])
+	}
+	parsed, _, err := parseMuSig2PubKeys(ordered)
+	require.NoError(t, err)
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		require.NoError(t, err)
+		return b
+	}
+	privKey, _ := btcec.PrivKeyFromBytes(decode("7FB9E0E687ADA1EEBF7ECFE2F21E73EBDB51A7D450948DFE8D76D7F2D1007671"))
+	var secNonce [musig2.SecNonceSize]byte
+	copy(secNonce[:], decode("508B81A611F100A6B2B6B29656590898AF488BCF2E1F55CF22E5CFB84421FE61FA27FD49B1D50085B481285E1CA205D55C82CC1B31FF5CD54A489829355901F703935F972DA013F80AE011890FA89B67A27B7BE6CCB24D3274D18B2D4067F261A9"))
+	var pubNonce [musig2.PubNonceSize]byte
+	copy(pubNonce[:], decode(aggNonce))
+	var msg [32]byte
+	copy(msg[:], decode("F95466D086770E689964664219266FE5ED215C92AE20BAB5C9D79ADDDDF3C0CF"))
+	sig, err := musig2.Sign(secNonce, privKey, pubNonce, parsed, msg, opts...)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, sig.Encode(&buf))
+	return strings.ToUpper(hex.EncodeToString(buf.Bytes()))
+}
+
+func TestMuSig2SignVectors(t *testing.T) {
+	const aggNonce = "028465FCF0BBDBCF443AABCCE533D42B4B5A10966AC09A49655E8C42DAAB8FCD61037496A3CC86926D452CAFCFD55D25972CA1675D549310DE296BFF42F72EEEA8C9"
+	pubKeys := []string{
+		"03935F972DA013F80AE011890FA89B67A27B7BE6CCB24D3274D18B2D4067F261A9",
+		"02F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"02DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA661",
+	}
+	assert.Equal(t, "012ABBCB52B3016AC03AD82395A1A415C48B93DEF78718E62A7A90052FE224FB", muSig2VectorSign(t, pubKeys, []int{0, 1, 2}, aggNonce))
+	assert.Equal(t, "9FF2F7AAA856150CC8819254218D3ADEEB0535269051897724F9DB3789513A52", muSig2VectorSign(t, pubKeys, []int{1, 0, 2}, aggNonce))
+	assert.Equal(t, "FA23C359F6FAC4E7796BB93BC9F0532A95468C539BA20FF86D7C76ED92227900", muSig2VectorSign(t, pubKeys, []int{1, 2, 0}, aggNonce))
+}
+
+func TestMuSig2TweakVectors(t *testing.T) {
+	const aggNonce = "028465FCF0BBDBCF443AABCCE533D42B4B5A10966AC09A49655E8C42DAAB8FCD61037496A3CC86926D452CAFCFD55D25972CA1675D549310DE296BFF42F72EEEA8C9"
+	pubKeys := []string{
+		"03935F972DA013F80AE011890FA89B67A27B7BE6CCB24D3274D18B2D4067F261A9",
+		"02F9308A019258C31049344F85F89D5229B531C845836F99B08601F113BCE036F9",
+		"02DFF1D77F2A671C5F36183726DB2341BE58FEAE1DA2DECED843240F7B502BA659",
+	}
+	var tweaks [4][32]byte
+	for i, v := range []string{
+		"E8F791FF9225A2AF0102AFFF4A9A723D9612A682A25EBE79802B263CDFCD83BB",
+		"AE2EA797CC0FE72AC5B97B97F3C6957D7E4199A167A58EB08BCAFFDA70AC0455",
+		"F52ECBC565B3D8BEA2DFD5B75A4F457E54369809322E4120831626F290FA87E0",
+		"1969AD73CC177FA0B4FCED6DF1F7BF9907E665FDE9BA196A74FED0A3CF5AEF9D",
+	} {
+		b, err := hex.DecodeString(v)
+		require.NoError(t, err)
+		copy(tweaks[i][:], b)
+	}
+	for _, test := range []struct {
+		xOnly    []bool
+		expected string
+	}{
+		{[]bool{true}, "E28A5C66E61E178C2BA19DB77B6CF9F7E2F0F56C17918CD13135E60CC848FE91"},
+		{[]bool{false}, "38B0767798252F21BF5702C48028B095428320F73A4B14DB1E25DE58543D2D2D"},
+		{[]bool{false, true}, "408A0A21C4A0F5DACAF9646AD6EB6FECD7F7A11F03ED1F48DFFF2185BC2C2408"},
+		{[]bool{false, false, true, true}, "45ABD206E61E3DF2EC9E264A6FEC8292141A633C28586388235541F9ADE75435"},
+		{[]bool{true, false, true, false}, "B255FDCAC27B40C7CE7848E2D3B7BF5EA0ED756DA81565AC804CCCA3E1D5D239"},
+	} {
+		var descs []musig2.KeyTweakDesc
+		for i, xOnly := range test.xOnly {
+			descs = append(descs, musig2.KeyTweakDesc{Tweak: tweaks[i], IsXOnly: xOnly})
+		}
+		sig := muSig2VectorSign(t, pubKeys, []int{1, 2, 0}, aggNonce, musig2.WithTweaks(descs...))
+		assert.Equal(t, test.expected, sig, test.xOnly)
+	}
+}
+
+func TestMuSig2SessionSerialized(t *testing.T) {
+	for _, merkleRoot := range [][]byte{nil, chainhash.HashB([]byte("tap tree"))} {
+		wifs, pubKeys := testKeys(t, 3, &chaincfg.MainNetParams)
+		msg := chainhash.HashB([]byte("musig2 message"))
+		session, err := NewMuSig2Session(pubKeys, merkleRoot, msg)
+		require.NoError(t, err)
+
+		// every signer works on its own copy of the serialized session
+		secNonces := make([]string, len(wifs))
+		pubNonces := make([]string, len(wifs))
+		for i, wif := range wifs {
+			data, err := session.Serialize()
+			require.NoError(t, err)
+			own, err := DeserializeMuSig2Session(data)
+			require.NoError(t, err)
+			secNonces[i], pubNonces[i], err = own.GenerateNonce(wif)
+			require.NoError(t, err)
+		}
+		for i, pubKey := range pubKeys {
+			require.NoError(t, session.AddPubNonce(pubKey, pubNonces[i]))
+		}
+
+		data, err := session.Serialize()
+		require.NoError(t, err)
+		partialSigs := make([]string, len(wifs))
+		for i, wif := range wifs {
+			own, err := DeserializeMuSig2Session(data)
+			require.NoError(t, err)
+			partialSigs[i], err = own.Sign(wif, secNonces[i])
+			require.NoError(t, err)
+		}
+
+		assert.ErrorIs(t, session.AddPartialSig(pubKeys[0], partialSigs[1]), ErrMuSig2InvalidPartial)
+		for i, pubKey := range pubKeys {
+			require.NoError(t, session.AddPartialSig(pubKey, partialSigs[i]))
+		}
+		sig, err := session.FinalSignature()
+		require.NoError(t, err)
+
+		agg, err := MuSig2AggregateKeys(pubKeys, merkleRoot)
+		require.NoError(t, err)
+		outputKey, _ := hex.DecodeString(agg.OutputKey)
+		key, err := schnorr.ParsePubKey(outputKey)
+		require.NoError(t, err)
+		parsed, err := schnorr.ParseSignature(sig)
+		require.NoError(t, err)
+		assert.True(t, parsed.Verify(msg, key))
+	}
+}
+
+func TestMuSig2SessionMissingNonce(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.MainNetParams)
+	session, err := NewMuSig2Session(pubKeys, nil, chainhash.HashB([]byte("msg")))
+	require.NoError(t, err)
+	secNonce, _, err := session.GenerateNonce(wifs[0])
+	require.NoError(t, err)
+	_, err = session.Sign(wifs[0], secNonce)
+	assert.ErrorIs(t, err, ErrMuSig2MissingNonce)
+
+	other, _ := testKeys(t, 3, &chaincfg.MainNetParams)
+	_, _, err = session.GenerateNonce(other[2])
+	assert.Error(t, err)
+}
+
+func TestMuSig2PSBT(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 2, network)
+	addr, err := MuSig2Address(pubKeys, nil, network)
+	require.NoError(t, err)
+
+	prevTxId := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+	prevHash, err := chainhash.NewHashFromStr(prevTxId)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+	toScript, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.NoError(t, err)
+	packet, err := psbt.New([]*wire.OutPoint{wire.NewOutPoint(prevHash, 1)}, []*wire.TxOut{wire.NewTxOut(50000, toScript), wire.NewTxOut(49000, pkScript)},
+		2, 0, []uint32{wire.MaxTxInSequenceNum})
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, pkScript)
+	psbtBase64, err := packet.B64Encode()
+	require.NoError(t, err)
+
+	res, err := GenerateMuSig2UnsignedPSBT(psbtBase64, pubKeys)
+	require.NoError(t, err)
+	require.Len(t, res.SignHashList, 1)
+	assert.Len(t, res.SignHashList[0], 64)
+
+	psbtStr := res.PsbtTx
+	p, err := psbt.NewFromRawBytes(bytes.NewReader([]byte(psbtStr)), true)
+	require.NoError(t, err)
+	assert.Empty(t, p.Outputs[0].Unknowns)
+	require.Len(t, p.Outputs[1].Unknowns, 1)
+	assert.Equal(t, byte(PsbtOutMuSig2ParticipantPubKeys), p.Outputs[1].Unknowns[0].Key[0])
+	secNonces := make([][]string, len(wifs))
+	for i, wif := range wifs {
+		psbtStr, secNonces[i], err = MuSig2PsbtNonceRound(psbtStr, wif)
+		require.NoError(t, err)
+	}
+	for i, wif := range wifs {
+		psbtStr, err = MuSig2PsbtSignRound(psbtStr, wif, secNonces[i])
+		require.NoError(t, err)
+	}
+
+	p, err = psbt.NewFromRawBytes(bytes.NewReader([]byte(psbtStr)), true)
+	require.NoError(t, err)
+	fields := make(map[psbt.InputType]int)
+	for _, u := range p.Inputs[0].Unknowns {
+		fields[psbt.InputType(u.Key[0])]++
+	}
+	assert.Equal(t, map[psbt.InputType]int{
+		PsbtInMuSig2ParticipantPubKeys: 1,
+		PsbtInMuSig2PubNonce:           2,
+		PsbtInMuSig2PartialSig:         2,
+	}, fields)
+
+	psbtStr, err = FinalizeMuSig2PSBT(psbtStr)
+	require.NoError(t, err)
+	p, err = psbt.NewFromRawBytes(bytes.NewReader([]byte(psbtStr)), true)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.Serialize(&buf))
+	txHex, err := ExtractTxFromSignedPSBT(hex.EncodeToString(buf.Bytes()))
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: prevTxId, VOut: 1, Amount: 100000, Address: addr}}, network)
+}
+
+func TestMuSig2PSBTInvalidUtxo(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	_, pubKeys := testKeys(t, 2, network)
+	addr, err := MuSig2Address(pubKeys, nil, network)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(100000, pkScript))
+
+	// the non-witness utxo has no output 1
+	prevHash := prevTx.TxHash()
+	packet, err := psbt.New([]*wire.OutPoint{wire.NewOutPoint(&prevHash, 1)}, []*wire.TxOut{wire.NewTxOut(99000, pkScript)},
+		2, 0, []uint32{wire.MaxTxInSequenceNum})
+	require.NoError(t, err)
+	packet.Inputs[0].NonWitnessUtxo = prevTx
+	psbtBase64, err := packet.B64Encode()
+	require.NoError(t, err)
+	_, err = GenerateMuSig2UnsignedPSBT(psbtBase64, pubKeys)
+	assert.ErrorIs(t, err, ErrInvalidPsbtUtxo)
+}
+
+// muSig2SignPsbt runs the nonce, sign and finalize rounds of wifs on psbtStr
+// and returns the finalized PSBT and the extracted transaction.
+func muSig2SignPsbt(t *testing.T, psbtStr string, wifs []string) (*psbt.Packet, string) {
+	var err error
+	secNonces := make([][]string, len(wifs))
+	for i, wif := range wifs {
+		psbtStr, secNonces[i], err = MuSig2PsbtNonceRound(psbtStr, wif)
+		require.NoError(t, err)
+	}
+	for i, wif := range wifs {
+		psbtStr, err = MuSig2PsbtSignRound(psbtStr, wif, secNonces[i])
+		require.NoError(t, err)
+	}
+	psbtStr, err = FinalizeMuSig2PSBT(psbtStr)
+	require.NoError(t, err)
+	p, err := GetPsbtFromString(psbtStr)
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, p.Serialize(&buf))
+	txHex, err := ExtractTxFromSignedPSBT(hex.EncodeToString(buf.Bytes()))
+	require.NoError(t, err)
+	return p, txHex
+}
+
+func TestMuSig2PSBTParticipantOrder(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 2, network)
+	for _, order := range [][]string{pubKeys, {pubKeys[1], pubKeys[0]}} {
+		addr, err := MuSig2Address(order, nil, network)
+		require.NoError(t, err)
+		prevTxId := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+		prevHash, err := chainhash.NewHashFromStr(prevTxId)
+		require.NoError(t, err)
+		pkScript, err := AddrToPkScript(addr, network)
+		require.NoError(t, err)
+		packet, err := psbt.New([]*wire.OutPoint{wire.NewOutPoint(prevHash, 0)}, []*wire.TxOut{wire.NewTxOut(99000, pkScript)},
+			2, 0, []uint32{wire.MaxTxInSequenceNum})
+		require.NoError(t, err)
+		packet.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, pkScript)
+		psbtBase64, err := packet.B64Encode()
+		require.NoError(t, err)
+		res, err := GenerateMuSig2UnsignedPSBT(psbtBase64, order)
+		require.NoError(t, err)
+		require.NotEmpty(t, res.SignHashList[0])
+
+		// the participants are stored in the order given
+		unsigned, err := GetPsbtFromString(res.PsbtTx)
+		require.NoError(t, err)
+		require.Len(t, unsigned.Inputs[0].Unknowns, 1)
+		participants := unsigned.Inputs[0].Unknowns[0]
+		assert.Equal(t, mustDecodeHex(t, order[0]+order[1]), participants.Value)
+
+		_, txHex := muSig2SignPsbt(t, res.PsbtTx, wifs)
+		verifyTxScripts(t, txHex, PrevOutputs{{TxId: prevTxId, VOut: 0, Amount: 100000, Address: addr}}, network)
+
+		// participants which do not aggregate to the key of the field are refused
+		participants.Value = mustDecodeHex(t, order[1]+order[0])
+		tampered, err := unsigned.B64Encode()
+		require.NoError(t, err)
+		_, _, err = MuSig2PsbtNonceRound(tampered, wifs[0])
+		assert.ErrorIs(t, err, ErrMuSig2AggregateKey)
+	}
+}
+
+func TestMuSig2PSBTSigHashAll(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 2, network)
+	addr, err := MuSig2Address(pubKeys, nil, network)
+	require.NoError(t, err)
+	prevTxId := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+	prevHash, err := chainhash.NewHashFromStr(prevTxId)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+	packet, err := psbt.New([]*wire.OutPoint{wire.NewOutPoint(prevHash, 0)}, []*wire.TxOut{wire.NewTxOut(99000, pkScript)},
+		2, 0, []uint32{wire.MaxTxInSequenceNum})
+	require.NoError(t, err)
+	packet.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, pkScript)
+	packet.Inputs[0].SighashType = txscript.SigHashAll
+	psbtBase64, err := packet.B64Encode()
+	require.NoError(t, err)
+	res, err := GenerateMuSig2UnsignedPSBT(psbtBase64, pubKeys)
+	require.NoError(t, err)
+
+	// the signature commits to SIGHASH_ALL and says so
+	p, txHex := muSig2SignPsbt(t, res.PsbtTx, wifs)
+	require.Len(t, p.Inputs[0].FinalScriptWitness, 1+1+65)
+	assert.Equal(t, byte(txscript.SigHashAll), p.Inputs[0].FinalScriptWitness[1+1+64])
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: prevTxId, VOut: 0, Amount: 100000, Address: addr}}, network)
+}
@@ -121,6 +121,30 @@ func GetBCHmainNetParams() *chaincfg.Params {
 	return &mainNetParams
 }
 
+// GetBCHTestNetParams BCH testnet3, whose magic differs from the BTC one so
+// that CashAddr and fork id are only on for BCH.
+func GetBCHTestNetParams() *chaincfg.Params {
+	testNetParams := chaincfg.TestNet3Params
+	testNetParams.Name = "bchtest"
+	testNetParams.Net = 0xf4f3e5f4
+	testNetParams.Bech32HRPSegwit = ""
+	return &testNetParams
+}
+
+// GetBCHRegTestParams BCH regtest
+func GetBCHRegTestParams() *chaincfg.Params {
+	regTestParams := chaincfg.RegressionNetParams
+	regTestParams.Name = "bchreg"
+	regTestParams.Net = 0xfabfb5da
+	regTestParams.Bech32HRPSegwit = ""
+	return &regTestParams
+}
+
+// IsBCHNetwork reports whether network is one of the BCH params.
+func IsBCHNetwork(network *chaincfg.Params) bool {
+	return CashAddrPrefix(network) != ""
+}
+
 // GetLTCMainNetParams LTC
 func GetLTCMainNetParams() *chaincfg.Params {
 	mainNetParams := chaincfg.MainNetParams
@@ -0,0 +1,201 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin/doginals"
+)
+
+var (
+	ErrTxWeight            = errors.New("transaction weight above the standard limit")
+	ErrSegWitUnsupported   = errors.New("segwit is not available on this chain")
+	ErrTaprootUnsupported  = errors.New("taproot is not available on this chain")
+	ErrFeeRateBelowMinimum = errors.New("fee rate below the minimum relay fee rate")
+)
+
+// RelayPolicy is the mempool policy of the reference node of a chain, what a
+// transaction must follow to be relayed. Fee rates passed to the SDK are in
+// sat/vB on every chain, MinRelayFeeRate and DustRelayFeeRate are in the unit
+// the chain quotes them.
+type RelayPolicy struct {
+	Name string `json:"name"`
+	// DustRelayFeeRate is the sat/kvB rate the dust threshold of each output
+	// type is derived from as in Bitcoin Core, 0 when the chain uses the flat
+	// DustLimit.
+	DustRelayFeeRate int64 `json:"dustRelayFeeRate"`
+	// DustLimit is the dust threshold of a p2pkh output.
+	DustLimit int64 `json:"dustLimit"`
+	// MinChangeValue is the default change threshold of the builders,
+	// DustLimit when 0. DOGE sets its 0.01 DOGE soft dust limit, every output
+	// below it costing 0.01 DOGE of extra fee.
+	MinChangeValue int64 `json:"minChangeValue"`
+	// MinRelayFeeRate is the minimum fee per FeeRateUnit vbytes.
+	MinRelayFeeRate int64 `json:"minRelayFeeRate"`
+	// FeeRateUnit is 1 for chains quoting sat/vB, 1000 for those quoting per kB.
+	FeeRateUnit         int64 `json:"feeRateUnit"`
+	SegWit              bool  `json:"segWit"`
+	Taproot             bool  `json:"taproot"`
+	MaxStandardTxWeight int64 `json:"maxStandardTxWeight"`
+}
+
+var (
+	BTCRelayPolicy  = &RelayPolicy{Name: "btc", DustRelayFeeRate: 3000, DustLimit: 546, MinRelayFeeRate: 1, FeeRateUnit: 1, SegWit: true, Taproot: true, MaxStandardTxWeight: MaxStandardTxWeight}
+	LTCRelayPolicy  = &RelayPolicy{Name: "ltc", DustRelayFeeRate: 30000, DustLimit: 5460, MinRelayFeeRate: 1, FeeRateUnit: 1, SegWit: true, Taproot: true, MaxStandardTxWeight: MaxStandardTxWeight}
+	DOGERelayPolicy = &RelayPolicy{Name: "doge", DustLimit: 100000, MinChangeValue: 1000000, MinRelayFeeRate: 100000, FeeRateUnit: 1000, MaxStandardTxWeight: 100000 * WitnessScaleFactor}
+	DASHRelayPolicy = &RelayPolicy{Name: "dash", DustRelayFeeRate: 3000, DustLimit: 546, MinRelayFeeRate: 1000, FeeRateUnit: 1000, MaxStandardTxWeight: 100000 * WitnessScaleFactor}
+	DGBRelayPolicy  = &RelayPolicy{Name: "dgb", DustRelayFeeRate: 30000, DustLimit: 5460, MinRelayFeeRate: 100000, FeeRateUnit: 1000, SegWit: true, MaxStandardTxWeight: MaxStandardTxWeight}
+	RVNRelayPolicy  = &RelayPolicy{Name: "rvn", DustRelayFeeRate: 3000, DustLimit: 546, MinRelayFeeRate: 1000000, FeeRateUnit: 1000, MaxStandardTxWeight: 100000 * WitnessScaleFactor}
+	QTUMRelayPolicy = &RelayPolicy{Name: "qtum", DustRelayFeeRate: 400000, DustLimit: 72800, MinRelayFeeRate: 400000, FeeRateUnit: 1000, SegWit: true, MaxStandardTxWeight: MaxStandardTxWeight}
+	BTGRelayPolicy  = &RelayPolicy{Name: "btg", DustRelayFeeRate: 3000, DustLimit: 546, MinRelayFeeRate: 1, FeeRateUnit: 1, SegWit: true, MaxStandardTxWeight: MaxStandardTxWeight}
+	BCHRelayPolicy  = &RelayPolicy{Name: "bch", DustRelayFeeRate: 3000, DustLimit: 546, MinRelayFeeRate: 1, FeeRateUnit: 1, MaxStandardTxWeight: 100000 * WitnessScaleFactor}
+	// BSVRelayPolicy has to be set explicitly, BSV shares the BTC params.
+	BSVRelayPolicy = &RelayPolicy{Name: "bsv", DustLimit: 1, MinRelayFeeRate: 1, FeeRateUnit: 1000, MaxStandardTxWeight: 10000000 * WitnessScaleFactor}
+	// ZECRelayPolicy covers transparent transactions, the ZIP-317 fee is
+	// computed by the zcash package.
+	ZECRelayPolicy = &RelayPolicy{Name: "zec", DustRelayFeeRate: 300, DustLimit: 54, MinRelayFeeRate: 1000, FeeRateUnit: 1000, MaxStandardTxWeight: 100000 * WitnessScaleFactor}
+)
+
+// relayPolicyAddrIDs are the p2pkh and p2sh address IDs of the DOGE and DASH
+// networks, whose params may keep the BTC magic.
+var relayPolicyAddrIDs = map[[2]byte]*RelayPolicy{
+	{doginals.PubKeyHashAddrID, doginals.ScriptHashAddrID}: DOGERelayPolicy,
+	{113, 196}: DOGERelayPolicy,
+	{76, 16}:   DASHRelayPolicy,
+	{140, 19}:  DASHRelayPolicy,
+}
+
+// relayPolicyHRPs are the segwit HRPs of the mainnet, testnet and regtest
+// networks, the regtests sharing the BTC magic.
+var relayPolicyHRPs = map[string]*RelayPolicy{
+	"ltc": LTCRelayPolicy, "tltc": LTCRelayPolicy, "rltc": LTCRelayPolicy,
+	"dgb": DGBRelayPolicy, "dgbt": DGBRelayPolicy, "dgbrt": DGBRelayPolicy,
+	"qc": QTUMRelayPolicy, "tq": QTUMRelayPolicy, "qcrt": QTUMRelayPolicy,
+	"btg": BTGRelayPolicy, "tbtg": BTGRelayPolicy, "btgrt": BTGRelayPolicy,
+}
+
+// relayPolicyNets are the magics of the mainnet, testnet and regtest networks
+// of the chains without segwit.
+var relayPolicyNets = map[wire.BitcoinNet]*RelayPolicy{
+	GetRVNMainNetParams().Net: RVNRelayPolicy,
+	0x544e5652:                RVNRelayPolicy,
+	0x574f5243:                RVNRelayPolicy,
+	GetZECMainNetParams().Net: ZECRelayPolicy,
+	0xbff91afa:                ZECRelayPolicy,
+	0x5f3fe8aa:                ZECRelayPolicy,
+}
+
+// RelayPolicyFor returns the policy of network, the BTC one for the Bitcoin
+// networks and the unknown ones. DOGE and DASH are told by their address IDs,
+// the segwit chains by their HRP and the others by their magic.
+func RelayPolicyFor(network *chaincfg.Params) *RelayPolicy {
+	if network == nil {
+		return BTCRelayPolicy
+	}
+	if p, ok := relayPolicyAddrIDs[[2]byte{network.PubKeyHashAddrID, network.ScriptHashAddrID}]; ok {
+		return p
+	}
+	if p, ok := relayPolicyHRPs[network.Bech32HRPSegwit]; ok {
+		return p
+	}
+	if p, ok := relayPolicyNets[network.Net]; ok {
+		return p
+	}
+	if IsBCHNetwork(network) {
+		return BCHRelayPolicy
+	}
+	return BTCRelayPolicy
+}
+
+// DustThreshold is the smallest standard value of an output paying to
+// pkScript, 0 for OP_RETURN outputs.
+func (p *RelayPolicy) DustThreshold(pkScript []byte) int64 {
+	if len(pkScript) > 0 && pkScript[0] == txscript.OP_RETURN {
+		return 0
+	}
+	if p.DustRelayFeeRate == 0 {
+		return p.DustLimit
+	}
+	// the output plus the input spending it, a witness input counts its
+	// signature and key at the witness discount
+	size := int64(wire.NewTxOut(0, pkScript).SerializeSize())
+	if txscript.IsWitnessProgram(pkScript) {
+		size += 32 + 4 + 1 + 107/WitnessScaleFactor + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return size * p.DustRelayFeeRate / 1000
+}
+
+// ChangeThreshold is the smallest change output the builders add by default.
+func (p *RelayPolicy) ChangeThreshold() int64 {
+	if p.MinChangeValue > 0 {
+		return p.MinChangeValue
+	}
+	return p.DustLimit
+}
+
+// MinRelayFee is the smallest fee of a transaction of vSize vbytes.
+func (p *RelayPolicy) MinRelayFee(vSize int64) int64 {
+	return (vSize*p.MinRelayFeeRate + p.FeeRateUnit - 1) / p.FeeRateUnit
+}
+
+// MinFeeRate is the minimum relay fee rate in sat/vB, rounded up.
+func (p *RelayPolicy) MinFeeRate() int64 {
+	return (p.MinRelayFeeRate + p.FeeRateUnit - 1) / p.FeeRateUnit
+}
+
+// Fee is the fee of a transaction of vSize vbytes at feeRate sat/vB, raised to
+// the minimum relay fee.
+func (p *RelayPolicy) Fee(vSize int64, feeRate int64) int64 {
+	fee := vSize * feeRate
+	if minFee := p.MinRelayFee(vSize); fee < minFee {
+		return minFee
+	}
+	return fee
+}
+
+// CheckOutputTypes refuses outputs the chain would not be able to spend.
+func (p *RelayPolicy) CheckOutputTypes(outputs []*wire.TxOut) error {
+	for i, out := range outputs {
+		if !p.SegWit && txscript.IsWitnessProgram(out.PkScript) {
+			return fmt.Errorf("%w: output %d", ErrSegWitUnsupported, i)
+		}
+		if !p.Taproot && txscript.IsPayToTaproot(out.PkScript) {
+			return fmt.Errorf("%w: output %d", ErrTaprootUnsupported, i)
+		}
+	}
+	return nil
+}
+
+// checkWeight refuses witnesses where segwit is not available and
+// transactions above the standard weight, the checks TransactionBuilder runs.
+func (p *RelayPolicy) checkWeight(tx *wire.MsgTx) error {
+	if !p.SegWit && tx.HasWitness() {
+		return ErrSegWitUnsupported
+	}
+	if weight := GetTransactionWeight(btcutil.NewTx(tx)); weight > p.MaxStandardTxWeight {
+		return fmt.Errorf("%w: %d", ErrTxWeight, weight)
+	}
+	return nil
+}
+
+// CheckTx checks the output types, the dust, the weight and that no witness
+// is used where segwit is not available.
+func (p *RelayPolicy) CheckTx(tx *wire.MsgTx) error {
+	if err := p.checkWeight(tx); err != nil {
+		return err
+	}
+	if err := p.CheckOutputTypes(tx.TxOut); err != nil {
+		return err
+	}
+	for i, out := range tx.TxOut {
+		if out.Value < p.DustThreshold(out.PkScript) {
+			return fmt.Errorf("%w: output %d", ErrDustOutput, i)
+		}
+	}
+	return nil
+}
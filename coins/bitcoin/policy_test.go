@@ -0,0 +1,172 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin/doginals"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRelayPolicyFor(t *testing.T) {
+	assert.Equal(t, BTCRelayPolicy, RelayPolicyFor(nil))
+	assert.Equal(t, BTCRelayPolicy, RelayPolicyFor(&chaincfg.TestNet3Params))
+	assert.Equal(t, BTCRelayPolicy, RelayPolicyFor(GetBSVMainNetParams()))
+	assert.Equal(t, LTCRelayPolicy, RelayPolicyFor(GetLTCMainNetParams()))
+	assert.Equal(t, DOGERelayPolicy, RelayPolicyFor(GetDOGEMainNetParams()))
+	assert.Equal(t, DOGERelayPolicy, RelayPolicyFor(&doginals.DogeMainNetParams))
+	assert.Equal(t, DASHRelayPolicy, RelayPolicyFor(GetDASHMainNetParams()))
+	assert.Equal(t, DGBRelayPolicy, RelayPolicyFor(GetDGBMainNetParams()))
+	assert.Equal(t, RVNRelayPolicy, RelayPolicyFor(GetRVNMainNetParams()))
+	assert.Equal(t, QTUMRelayPolicy, RelayPolicyFor(GetQTUMMainNetParams()))
+	assert.Equal(t, BTGRelayPolicy, RelayPolicyFor(GetBTGMainNetParams()))
+	assert.Equal(t, BCHRelayPolicy, RelayPolicyFor(GetBCHmainNetParams()))
+	assert.Equal(t, ZECRelayPolicy, RelayPolicyFor(GetZECMainNetParams()))
+	assert.Equal(t, BCHRelayPolicy, RelayPolicyFor(GetBCHTestNetParams()))
+	assert.Equal(t, BCHRelayPolicy, RelayPolicyFor(GetBCHRegTestParams()))
+}
+
+func TestRelayPolicyForTestNetworks(t *testing.T) {
+	params := func(base chaincfg.Params, net wire.BitcoinNet, pkh, sh byte, hrp string) *chaincfg.Params {
+		base.Net = net
+		base.PubKeyHashAddrID = pkh
+		base.ScriptHashAddrID = sh
+		base.Bech32HRPSegwit = hrp
+		return &base
+	}
+	testNet, regTest := chaincfg.TestNet3Params, chaincfg.RegressionNetParams
+	for _, test := range []struct {
+		network *chaincfg.Params
+		policy  *RelayPolicy
+	}{
+		{params(testNet, 0xf1c8d2fd, 111, 58, "tltc"), LTCRelayPolicy},
+		{params(regTest, regTest.Net, 111, 58, "rltc"), LTCRelayPolicy},
+		{params(testNet, 0xdcb7c1fc, 113, 196, ""), DOGERelayPolicy},
+		{params(testNet, 0xffcae2ce, 140, 19, ""), DASHRelayPolicy},
+		{params(regTest, 0xdcb7c1fc, 140, 19, ""), DASHRelayPolicy},
+		{params(testNet, 0xddbdc8fd, 126, 140, "dgbt"), DGBRelayPolicy},
+		{params(regTest, regTest.Net, 126, 140, "dgbrt"), DGBRelayPolicy},
+		{params(testNet, 0x544e5652, 111, 196, ""), RVNRelayPolicy},
+		{params(regTest, 0x574f5243, 111, 196, ""), RVNRelayPolicy},
+		{params(testNet, 0x0615220d, 120, 110, "tq"), QTUMRelayPolicy},
+		{params(regTest, 0xe1c6ddfd, 120, 110, "qcrt"), QTUMRelayPolicy},
+		{params(testNet, 0x456e48e2, 111, 196, "tbtg"), BTGRelayPolicy},
+		{params(regTest, regTest.Net, 111, 196, "btgrt"), BTGRelayPolicy},
+		{params(testNet, 0xbff91afa, 0x1D, 0x25, ""), ZECRelayPolicy},
+		{params(regTest, 0x5f3fe8aa, 0x1D, 0x25, ""), ZECRelayPolicy},
+		{&regTest, BTCRelayPolicy},
+		{&chaincfg.SigNetParams, BTCRelayPolicy},
+	} {
+		assert.Equal(t, test.policy, RelayPolicyFor(test.network), test.network.Bech32HRPSegwit)
+	}
+}
+
+func TestRelayPolicyChangeThreshold(t *testing.T) {
+	assert.Equal(t, int64(546), BTCRelayPolicy.ChangeThreshold())
+	// change below the 0.01 DOGE soft dust limit would cost 0.01 DOGE more
+	assert.Equal(t, int64(1000000), DOGERelayPolicy.ChangeThreshold())
+	assert.Equal(t, int64(100000), DOGERelayPolicy.DustThreshold([]byte{txscript.OP_DUP}))
+}
+
+func TestRelayPolicyDustThreshold(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	for _, test := range []struct {
+		addr string
+		dust int64
+	}{
+		{"mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", 546},
+		{"2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc", 540},
+		{"tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 294},
+		{"tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 330},
+	} {
+		pkScript, err := AddrToPkScript(test.addr, network)
+		require.NoError(t, err)
+		assert.Equal(t, test.dust, BTCRelayPolicy.DustThreshold(pkScript), test.addr)
+		assert.Equal(t, DOGERelayPolicy.DustLimit, DOGERelayPolicy.DustThreshold(pkScript))
+	}
+	pkScript, err := AddrToPkScript("mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", network)
+	require.NoError(t, err)
+	assert.Equal(t, LTCRelayPolicy.DustLimit, LTCRelayPolicy.DustThreshold(pkScript))
+	assert.Equal(t, QTUMRelayPolicy.DustLimit, QTUMRelayPolicy.DustThreshold(pkScript))
+	assert.Equal(t, ZECRelayPolicy.DustLimit, ZECRelayPolicy.DustThreshold(pkScript))
+	assert.Equal(t, int64(0), BTCRelayPolicy.DustThreshold([]byte{0x6a, 0x01, 0x00}))
+}
+
+func TestRelayPolicyFee(t *testing.T) {
+	assert.Equal(t, int64(2250), BTCRelayPolicy.Fee(225, 10))
+	assert.Equal(t, int64(225), BTCRelayPolicy.Fee(225, 0))
+	// 1 DOGE/kB is far above 1 koinu/vB
+	assert.Equal(t, int64(22500), DOGERelayPolicy.Fee(225, 1))
+	assert.Equal(t, int64(45000), DOGERelayPolicy.Fee(225, 200))
+	assert.Equal(t, int64(226), DASHRelayPolicy.MinRelayFee(226))
+	assert.Equal(t, int64(100), DOGERelayPolicy.MinFeeRate())
+	assert.Equal(t, int64(1), BSVRelayPolicy.MinFeeRate())
+}
+
+func TestRelayPolicyCheckTx(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	p2wpkh, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.NoError(t, err)
+	p2tr, err := AddrToPkScript("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", network)
+	require.NoError(t, err)
+
+	tx := wire.NewMsgTx(2)
+	tx.AddTxOut(wire.NewTxOut(294, p2wpkh))
+	tx.AddTxOut(wire.NewTxOut(330, p2tr))
+	assert.NoError(t, BTCRelayPolicy.CheckTx(tx))
+	assert.ErrorIs(t, DOGERelayPolicy.CheckTx(tx), ErrSegWitUnsupported)
+	assert.ErrorIs(t, BTGRelayPolicy.CheckTx(tx), ErrTaprootUnsupported)
+	tx.TxOut[1].Value = 329
+	assert.ErrorIs(t, BTCRelayPolicy.CheckTx(tx), ErrDustOutput)
+
+	tx.TxOut = tx.TxOut[:1]
+	tx.TxOut[0].PkScript = make([]byte, 100001)
+	assert.ErrorIs(t, BTCRelayPolicy.CheckTx(tx), ErrTxWeight)
+}
+
+func TestRelayPolicyBuilders(t *testing.T) {
+	key, err := btcutil.DecodeWIF("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.NoError(t, err)
+	dogeAddr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.SerializePubKey()), GetDOGEMainNetParams())
+	require.NoError(t, err)
+	rvnAddr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(key.SerializePubKey()), GetRVNMainNetParams())
+	require.NoError(t, err)
+
+	// a DOGE builder refuses segwit outputs
+	builder := NewTxBuild(1, GetDOGEMainNetParams())
+	builder.AddInput2("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", 0,
+		"cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", dogeAddr.EncodeAddress(), 10000000)
+	builder.AddOutput2("", "00145c005c5532ce810ddf20f9d1d939631b47089ecd", 9000000)
+	_, err = builder.Build()
+	assert.ErrorIs(t, err, ErrSegWitUnsupported)
+
+	// and so does a BTC one set to the BSV policy
+	builder = NewTxBuild(1, &chaincfg.TestNet3Params)
+	builder.SetRelayPolicy(BSVRelayPolicy)
+	builder.AddInput2("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", 0,
+		"cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", 10000)
+	builder.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 9000)
+	_, err = builder.Build()
+	assert.ErrorIs(t, err, ErrSegWitUnsupported)
+
+	_, err = SelectCoins(&CoinSelectionRequest{
+		Utxos:         PrevOutputs{{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", Amount: 100000000, Address: rvnAddr.EncodeAddress()}},
+		Outputs:       []*TxOutput{{Address: rvnAddr.EncodeAddress(), Amount: 1000000}},
+		FeeRate:       10,
+		ChangeAddress: rvnAddr.EncodeAddress(),
+	}, GetRVNMainNetParams())
+	assert.ErrorIs(t, err, ErrFeeRateBelowMinimum)
+
+	_, err = Inscribe(GetDOGEMainNetParams(), &InscriptionRequest{
+		CommitTxPrevOutputList: PrevOutputs{{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", Amount: 100000000,
+			Address: dogeAddr.EncodeAddress(), PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22"}},
+		CommitFeeRate:       1,
+		RevealFeeRate:       1,
+		InscriptionDataList: []InscriptionData{{ContentType: "text/plain", Body: []byte("a"), RevealAddr: dogeAddr.EncodeAddress()}},
+		ChangeAddress:       dogeAddr.EncodeAddress(),
+	})
+	assert.ErrorIs(t, err, ErrTaprootUnsupported)
+}
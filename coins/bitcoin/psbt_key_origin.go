@@ -0,0 +1,256 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/okx/go-wallet-sdk/crypto/base58"
+	"github.com/okx/go-wallet-sdk/crypto/go-bip32"
+)
+
+// Signing devices such as Coldcard, Jade and Ledger only sign the inputs whose
+// key origin they can derive: the BIP-32 derivation of each key, the tap
+// derivation, internal key and merkle root of taproot inputs, and for
+// multisig the global xpubs of the cosigners. The helpers here fill them in
+// from TxInput and TxOutput for every PSBT builder of the package.
+
+var (
+	ErrInvalidXPub        = errors.New("invalid extended public key")
+	ErrTaprootKeyMismatch = errors.New("taproot internal key does not match the output key")
+)
+
+// PsbtXPub is a global xpub of a PSBT with the origin of the extended key,
+// DerivationPath having as many steps as the depth of XPub.
+type PsbtXPub struct {
+	XPub              string `json:"xpub"`
+	MasterFingerprint uint32 `json:"masterFingerprint"`
+	DerivationPath    string `json:"derivationPath"`
+}
+
+// keyValue returns the PSBT_GLOBAL_XPUB key and value of x.
+func (x *PsbtXPub) keyValue() ([]byte, []byte, error) {
+	key, err := bip32.B58Deserialize(x.XPub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidXPub, err)
+	}
+	if key.IsPrivate {
+		return nil, nil, fmt.Errorf("%w: private key", ErrInvalidXPub)
+	}
+	path, err := accounts.ParseDerivationPath(x.DerivationPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(path) != int(key.Depth) {
+		return nil, nil, fmt.Errorf("%w: depth %d, path %s", ErrInvalidXPub, key.Depth, x.DerivationPath)
+	}
+	serialized, err := key.Serialize()
+	if err != nil {
+		return nil, nil, err
+	}
+	value := binary.LittleEndian.AppendUint32(nil, x.MasterFingerprint)
+	for _, step := range path {
+		value = binary.LittleEndian.AppendUint32(value, step)
+	}
+	return append([]byte{byte(psbt.XpubType)}, serialized[:78]...), value, nil
+}
+
+// addGlobalXPubs adds xpubs to the global map of p, skipping those already in it.
+func addGlobalXPubs(p *psbt.Packet, xpubs []*PsbtXPub) error {
+	for _, xpub := range xpubs {
+		key, value, err := xpub.keyValue()
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, u := range p.Unknowns {
+			if bytes.Equal(u.Key, key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			p.Unknowns = append(p.Unknowns, &psbt.Unknown{Key: key, Value: value})
+		}
+	}
+	return nil
+}
+
+// AddGlobalXPubs adds the xpubs of the wallet, one per cosigner for a
+// multisig, to the global map of p.
+func (p *PsbtPacket) AddGlobalXPubs(xpubs ...*PsbtXPub) error {
+	return addGlobalXPubs(p.Packet, xpubs)
+}
+
+// GlobalXPubs returns the global xpubs of p.
+func (p *PsbtPacket) GlobalXPubs() ([]*PsbtXPub, error) {
+	var xpubs []*PsbtXPub
+	for _, u := range p.Packet.Unknowns {
+		if len(u.Key) != 79 || u.Key[0] != byte(psbt.XpubType) {
+			continue
+		}
+		if len(u.Value) < 4 || len(u.Value)%4 != 0 {
+			return nil, fmt.Errorf("%w: invalid key origin", ErrInvalidXPub)
+		}
+		path := make([]uint32, 0, len(u.Value)/4-1)
+		for i := 4; i < len(u.Value); i += 4 {
+			path = append(path, binary.LittleEndian.Uint32(u.Value[i:]))
+		}
+		xpubs = append(xpubs, &PsbtXPub{
+			XPub:              base58.CheckEncodeRaw(u.Key[1:]),
+			MasterFingerprint: binary.LittleEndian.Uint32(u.Value),
+			DerivationPath:    formatDerivationPath(path),
+		})
+	}
+	return xpubs, nil
+}
+
+// xOnlyKey returns the x-only form of a compressed or x-only public key.
+func xOnlyKey(publicKey []byte) []byte {
+	if len(publicKey) == btcec.PubKeyBytesLenCompressed {
+		return publicKey[1:]
+	}
+	return publicKey
+}
+
+// taprootInternalKey returns the x-only internal key publicKey is when it
+// tweaks with merkleRoot to the output key of pkScript, nil when publicKey is
+// the output key itself, and ErrTaprootKeyMismatch otherwise.
+func taprootInternalKey(publicKey, merkleRoot, pkScript []byte) ([]byte, error) {
+	xOnly := xOnlyKey(publicKey)
+	if len(merkleRoot) == 0 && bytes.Equal(xOnly, pkScript[2:]) {
+		return nil, nil
+	}
+	internalKey, err := schnorr.ParsePubKey(xOnly)
+	if err != nil {
+		return nil, err
+	}
+	outputKey := txscript.ComputeTaprootOutputKey(internalKey, merkleRoot)
+	if !bytes.Equal(schnorr.SerializePubKey(outputKey), pkScript[2:]) {
+		return nil, ErrTaprootKeyMismatch
+	}
+	return xOnly, nil
+}
+
+// appendTapDerivation appends the tap derivation of xOnly to derivations
+// unless it is already in them.
+func appendTapDerivation(derivations []*psbt.TaprootBip32Derivation, xOnly []byte, masterFingerprint uint32, path []uint32) []*psbt.TaprootBip32Derivation {
+	for _, d := range derivations {
+		if bytes.Equal(d.XOnlyPubKey, xOnly) {
+			return derivations
+		}
+	}
+	return append(derivations, &psbt.TaprootBip32Derivation{
+		XOnlyPubKey:          xOnly,
+		MasterKeyFingerprint: masterFingerprint,
+		Bip32Path:            path,
+	})
+}
+
+// addInputKeyOrigin adds the key origin of in to input i spending prevPkScript,
+// nothing when in has no public key or derivation path. A taproot input also
+// gets its internal key and, for a script tree, its merkle root; the internal
+// key has to tweak to the output key of prevPkScript. The output key itself is
+// still accepted without a merkle root and gets a plain BIP-32 derivation, as
+// callers knowing only the output key had before.
+func addInputKeyOrigin(p *psbt.Packet, i int, in *TxInput, prevPkScript []byte) error {
+	if in.PublicKey == "" || in.DerivationPath == "" {
+		return nil
+	}
+	publicKey, err := hex.DecodeString(in.PublicKey)
+	if err != nil {
+		return err
+	}
+	path, err := accounts.ParseDerivationPath(in.DerivationPath)
+	if err != nil {
+		return err
+	}
+	pIn := &p.Inputs[i]
+	var internalKey, merkleRoot []byte
+	if txscript.IsPayToTaproot(prevPkScript) {
+		if in.TapMerkleRoot != "" {
+			if merkleRoot, err = hex.DecodeString(in.TapMerkleRoot); err != nil {
+				return err
+			}
+		}
+		if internalKey, err = taprootInternalKey(publicKey, merkleRoot, prevPkScript); err != nil {
+			return fmt.Errorf("%w: input %d", err, i)
+		}
+	}
+	if internalKey == nil {
+		for _, d := range pIn.Bip32Derivation {
+			if bytes.Equal(d.PubKey, publicKey) {
+				return nil
+			}
+		}
+		updater, err := psbt.NewUpdater(p)
+		if err != nil {
+			return err
+		}
+		return updater.AddInBip32Derivation(in.MasterFingerprint, path, publicKey, i)
+	}
+
+	pIn.TaprootInternalKey = internalKey
+	pIn.TaprootMerkleRoot = merkleRoot
+	pIn.TaprootBip32Derivation = appendTapDerivation(pIn.TaprootBip32Derivation, internalKey, in.MasterFingerprint, path)
+	return nil
+}
+
+// addOutputKeyOrigin adds the key origin of the change output out to output
+// i, with the redeem script of a nested segwit change so that the device can
+// check the output pays its key. A taproot change key is the BIP-86 internal
+// key of the output or, as for inputs, the output key itself.
+func addOutputKeyOrigin(p *psbt.Packet, i int, out *TxOutput) error {
+	if out.PublicKey == "" || out.DerivationPath == "" {
+		return nil
+	}
+	publicKey, err := hex.DecodeString(out.PublicKey)
+	if err != nil {
+		return err
+	}
+	path, err := accounts.ParseDerivationPath(out.DerivationPath)
+	if err != nil {
+		return err
+	}
+	pkScript := p.UnsignedTx.TxOut[i].PkScript
+	pOut := &p.Outputs[i]
+	if txscript.IsPayToTaproot(pkScript) {
+		internalKey, err := taprootInternalKey(publicKey, nil, pkScript)
+		if err != nil {
+			return fmt.Errorf("%w: output %d", err, i)
+		}
+		if internalKey != nil {
+			pOut.TaprootInternalKey = internalKey
+			pOut.TaprootBip32Derivation = appendTapDerivation(pOut.TaprootBip32Derivation, internalKey, out.MasterFingerprint, path)
+			return nil
+		}
+	}
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		return err
+	}
+	if txscript.IsPayToScriptHash(pkScript) && pOut.RedeemScript == nil {
+		redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(publicKey))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(pkScript[2:22], btcutil.Hash160(redeemScript)) {
+			if err := updater.AddOutRedeemScript(redeemScript, i); err != nil {
+				return err
+			}
+		}
+	}
+	for _, d := range pOut.Bip32Derivation {
+		if bytes.Equal(d.PubKey, publicKey) {
+			return nil
+		}
+	}
+	return updater.AddOutBip32Derivation(out.MasterFingerprint, path, publicKey, i)
+}
@@ -0,0 +1,207 @@
+package bitcoin
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/okx/go-wallet-sdk/crypto/go-bip32"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func keyOriginTestXPub(t *testing.T) *PsbtXPub {
+	master, err := bip32.NewMasterKey(make([]byte, 32))
+	require.NoError(t, err)
+	account, err := master.NewChildKeyByPathString("m/86'/1'/0'")
+	require.NoError(t, err)
+	return &PsbtXPub{
+		XPub:              account.PublicKey().B58Serialize(),
+		MasterFingerprint: binary.LittleEndian.Uint32(btcutil.Hash160(master.PublicKey().Key)[:4]),
+		DerivationPath:    "m/86'/1'/0'",
+	}
+}
+
+func TestGenerateUnsignedPSBTKeyOrigin(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	pubKey := "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	inputs := []*TxInput{{
+		TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		VOut:              0,
+		Amount:            100000,
+		Address:           "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/0/0",
+		PublicKey:         pubKey,
+	}, {
+		TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		VOut:              1,
+		Amount:            100000,
+		Address:           "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/84'/1'/0'/0/0",
+		PublicKey:         pubKey,
+	}}
+	outputs := []*TxOutput{{
+		Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		Amount:  100000,
+	}, {
+		Address:           "2NF33rckfiQTiE5Guk5ufUdwms8PgmtnEdc",
+		Amount:            50000,
+		IsChange:          true,
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/49'/1'/0'/1/0",
+		PublicKey:         pubKey,
+	}, {
+		Address:           "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		Amount:            40000,
+		IsChange:          true,
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/1/0",
+		PublicKey:         pubKey,
+	}}
+	xpub := keyOriginTestXPub(t)
+	psbtHex, err := GenerateUnsignedPSBTHex(inputs, outputs, network, xpub)
+	require.NoError(t, err)
+	packet, err := ParsePsbtPacket(psbtHex)
+	require.NoError(t, err)
+	p := packet.Packet
+
+	require.Equal(t, 1, len(p.Inputs[0].TaprootBip32Derivation))
+	assert.Equal(t, mustDecodeHex(t, pubKey)[1:], p.Inputs[0].TaprootInternalKey)
+	assert.Equal(t, uint32(0xF23F9FD2), p.Inputs[0].TaprootBip32Derivation[0].MasterKeyFingerprint)
+	assert.Equal(t, []uint32{86 + 0x80000000, 1 + 0x80000000, 0x80000000, 0, 0}, p.Inputs[0].TaprootBip32Derivation[0].Bip32Path)
+	assert.Empty(t, p.Inputs[0].Bip32Derivation)
+	assert.Nil(t, p.Inputs[0].TaprootMerkleRoot)
+	require.Equal(t, 1, len(p.Inputs[1].Bip32Derivation))
+	assert.Equal(t, mustDecodeHex(t, pubKey), p.Inputs[1].Bip32Derivation[0].PubKey)
+
+	assert.Empty(t, p.Outputs[0].Bip32Derivation)
+	require.Equal(t, 1, len(p.Outputs[1].Bip32Derivation))
+	assert.Equal(t, "00145c005c5532ce810ddf20f9d1d939631b47089ecd", hex.EncodeToString(p.Outputs[1].RedeemScript))
+	require.Equal(t, 1, len(p.Outputs[2].TaprootBip32Derivation))
+	assert.Equal(t, mustDecodeHex(t, pubKey)[1:], p.Outputs[2].TaprootInternalKey)
+
+	xpubs, err := packet.GlobalXPubs()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(xpubs))
+	assert.Equal(t, xpub, xpubs[0])
+
+	// the global xpub survives a v2 round trip and is not added twice
+	require.NoError(t, packet.ConvertTo(2))
+	require.NoError(t, packet.AddGlobalXPubs(xpub))
+	b64, err := packet.B64Encode()
+	require.NoError(t, err)
+	packet, err = ParsePsbtPacket(b64)
+	require.NoError(t, err)
+	xpubs, err = packet.GlobalXPubs()
+	require.NoError(t, err)
+	assert.Equal(t, []*PsbtXPub{xpub}, xpubs)
+}
+
+func TestKeyOriginErrors(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	in := &TxInput{
+		TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		Amount:            100000,
+		Address:           "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/0/0",
+		PublicKey:         "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f",
+		// the key is a BIP-86 key, not the internal key of a script tree
+		TapMerkleRoot: "0000000000000000000000000000000000000000000000000000000000000001",
+	}
+	out := &TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 1000}
+	_, err := GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network)
+	assert.ErrorIs(t, err, ErrTaprootKeyMismatch)
+
+	in.TapMerkleRoot = ""
+	xpub := keyOriginTestXPub(t)
+	xpub.DerivationPath = "m/86'/1'"
+	_, err = GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network, xpub)
+	assert.ErrorIs(t, err, ErrInvalidXPub)
+
+	master, err := bip32.NewMasterKey(make([]byte, 32))
+	require.NoError(t, err)
+	_, err = GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network, &PsbtXPub{XPub: master.B58Serialize(), DerivationPath: "m"})
+	assert.ErrorIs(t, err, ErrInvalidXPub)
+}
+
+func TestTaprootOutputKeyOrigin(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	addr := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+	// callers which only know the output key pass it as a compressed key
+	outputKey := "02" + hex.EncodeToString(pkScript[2:])
+	in := &TxInput{
+		TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		Amount:            100000,
+		Address:           addr,
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/0/0",
+		PublicKey:         outputKey,
+	}
+	out := &TxOutput{
+		Address:           addr,
+		Amount:            90000,
+		IsChange:          true,
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/1/0",
+		PublicKey:         outputKey,
+	}
+	psbtHex, err := GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network)
+	require.NoError(t, err)
+	packet, err := ParsePsbtPacket(psbtHex)
+	require.NoError(t, err)
+	p := packet.Packet
+	require.Equal(t, 1, len(p.Inputs[0].Bip32Derivation))
+	assert.Equal(t, mustDecodeHex(t, outputKey), p.Inputs[0].Bip32Derivation[0].PubKey)
+	assert.Nil(t, p.Inputs[0].TaprootInternalKey)
+	assert.Empty(t, p.Inputs[0].TaprootBip32Derivation)
+	require.Equal(t, 1, len(p.Outputs[0].Bip32Derivation))
+	assert.Nil(t, p.Outputs[0].TaprootInternalKey)
+
+	// the change key is checked like the input key, the internal key is
+	// accepted and any other key refused
+	in.PublicKey = "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f"
+	out.PublicKey = in.PublicKey
+	_, err = GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network)
+	require.NoError(t, err)
+	out.PublicKey = "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	_, err = GenerateUnsignedPSBTHex([]*TxInput{in}, []*TxOutput{out}, network)
+	assert.ErrorIs(t, err, ErrTaprootKeyMismatch)
+}
+
+func TestMPCListingKeyOrigin(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	in := &TxInput{
+		TxId:              "46e3ce050474e6da80760a2a0b062836ff13e2a42962dc1c9b17b8f962444206",
+		VOut:              0,
+		Amount:            546,
+		Address:           "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/86'/1'/0'/0/0",
+		PublicKey:         "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f",
+	}
+	out := &TxOutput{
+		Address:           "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		Amount:            100000,
+		MasterFingerprint: 0xF23F9FD2,
+		DerivationPath:    "m/84'/1'/0'/0/0",
+		PublicKey:         "0357bbb2d4a9cb8a2357633f201b9c518c2795ded682b7913c6beef3fe23bd6d2f",
+	}
+	res, err := GenerateMPCUnsignedListingPSBT(in, out, network, keyOriginTestXPub(t))
+	require.NoError(t, err)
+	packet, err := ParsePsbtPacket(res.PsbtTx)
+	require.NoError(t, err)
+	pIn := packet.Packet.Inputs[SellerSignatureIndex]
+	require.Equal(t, 1, len(pIn.TaprootBip32Derivation))
+	assert.Equal(t, txscript.SigHashSingle|txscript.SigHashAnyOneCanPay, pIn.SighashType)
+	require.Equal(t, 1, len(packet.Packet.Outputs[SellerSignatureIndex].Bip32Derivation))
+	xpubs, err := packet.GlobalXPubs()
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(xpubs))
+}
@@ -0,0 +1,471 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+// The BIP-174 roles on PsbtPacket: NewPsbtPacket is the Creator, AddInput and
+// AddOutput the Constructor, UpdateInput, UpdateOutput and AddInputScripts the
+// Updater, Sign the Signer, CombinePsbtPackets the Combiner, Finalize the
+// Finalizer and Extract the Extractor. All of them work on v0 and v2 packets.
+
+var (
+	ErrPsbtNotModifiable = errors.New("psbt inputs or outputs are not modifiable")
+	ErrPsbtMismatch      = errors.New("psbts do not spend the same transaction")
+)
+
+// NewPsbtPacket creates an empty PSBT. For v2 packets lockTime is the fallback
+// lock time and inputs and outputs stay modifiable until they are signed.
+func NewPsbtPacket(version uint32, txVersion int32, lockTime uint32) (*PsbtPacket, error) {
+	if version != 0 && version != 2 {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedPsbtVersion, version)
+	}
+	tx := wire.NewMsgTx(txVersion)
+	tx.LockTime = lockTime
+	p, err := psbt.NewFromUnsignedTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	packet := &PsbtPacket{Packet: p, Version: version}
+	if version == 2 {
+		packet.FallbackLockTime = lockTime
+		packet.TxModifiable = PsbtInputsModifiable | PsbtOutputsModifiable
+	}
+	return packet, nil
+}
+
+func (p *PsbtPacket) hasSignatures() bool {
+	for _, in := range p.Packet.Inputs {
+		if len(in.PartialSigs) > 0 || len(in.TaprootKeySpendSig) > 0 || len(in.TaprootScriptSpendSig) > 0 ||
+			len(in.FinalScriptSig) > 0 || len(in.FinalScriptWitness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PsbtPacket) checkModifiable(flag uint8) error {
+	if p.Version == 2 {
+		if p.TxModifiable&flag == 0 {
+			return ErrPsbtNotModifiable
+		}
+		return nil
+	}
+	if p.hasSignatures() {
+		return ErrPsbtNotModifiable
+	}
+	return nil
+}
+
+// AddInput appends the outpoint of in with its sequence, the utxo details are
+// added by UpdateInput.
+func (p *PsbtPacket) AddInput(in *TxInput) error {
+	if err := p.checkModifiable(PsbtInputsModifiable); err != nil {
+		return err
+	}
+	txHash, err := chainhash.NewHashFromStr(in.TxId)
+	if err != nil {
+		return err
+	}
+	txIn := wire.NewTxIn(wire.NewOutPoint(txHash, in.VOut), nil, nil)
+	txIn.Sequence = in.Sequence | wire.SequenceLockTimeDisabled
+	p.Packet.UnsignedTx.AddTxIn(txIn)
+	p.Packet.Inputs = append(p.Packet.Inputs, psbt.PInput{})
+	if p.Version == 2 {
+		p.RequiredTimeLockTime = append(p.RequiredTimeLockTime, 0)
+		p.RequiredHeightLockTime = append(p.RequiredHeightLockTime, 0)
+	}
+	return nil
+}
+
+func (p *PsbtPacket) AddOutput(out *TxOutput, network *chaincfg.Params) error {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if err := p.checkModifiable(PsbtOutputsModifiable); err != nil {
+		return err
+	}
+	pkScript, err := AddrToPkScript(out.Address, network)
+	if err != nil {
+		return err
+	}
+	p.Packet.UnsignedTx.AddTxOut(wire.NewTxOut(out.Amount, pkScript))
+	p.Packet.Outputs = append(p.Packet.Outputs, psbt.POutput{})
+	if out.IsChange {
+		return p.UpdateOutput(len(p.Packet.Outputs)-1, out)
+	}
+	return nil
+}
+
+// UpdateInput adds the utxo of in to input i, the redeem script of a nested
+// segwit key and, when a derivation path is given, its key origin.
+func (p *PsbtPacket) UpdateInput(i int, in *TxInput, network *chaincfg.Params) error {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	updater, err := psbt.NewUpdater(p.Packet)
+	if err != nil {
+		return err
+	}
+	prevPkScript, err := AddrToPkScript(in.Address, network)
+	if err != nil {
+		return err
+	}
+	if in.NonWitnessUtxo != "" {
+		prevTx, err := NewTxFromHex(in.NonWitnessUtxo)
+		if err != nil {
+			return err
+		}
+		if prevTx.TxHash() != p.Packet.UnsignedTx.TxIn[i].PreviousOutPoint.Hash {
+			return psbt.ErrInvalidPrevOutNonWitnessTransaction
+		}
+		if err := updater.AddInNonWitnessUtxo(prevTx, i); err != nil {
+			return err
+		}
+	}
+	// legacy inputs only carry the full previous transaction, a P2SH input
+	// without one is taken to be nested segwit
+	legacy := txscript.IsPayToPubKeyHash(prevPkScript) || txscript.IsPayToScriptHash(prevPkScript) && in.NonWitnessUtxo != ""
+	if txscript.IsPayToPubKeyHash(prevPkScript) && in.NonWitnessUtxo == "" {
+		return errors.New("p2pkh input needs its previous transaction")
+	}
+	if !legacy {
+		if err := updater.AddInWitnessUtxo(wire.NewTxOut(in.Amount, prevPkScript), i); err != nil {
+			return err
+		}
+	}
+	if in.PublicKey == "" {
+		return nil
+	}
+	publicKey, err := hex.DecodeString(in.PublicKey)
+	if err != nil {
+		return err
+	}
+	if txscript.IsPayToScriptHash(prevPkScript) && p.Packet.Inputs[i].RedeemScript == nil {
+		redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(publicKey))
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(prevPkScript[2:22], btcutil.Hash160(redeemScript)) {
+			if err := updater.AddInRedeemScript(redeemScript, i); err != nil {
+				return err
+			}
+		}
+	}
+	return addInputKeyOrigin(p.Packet, i, in, prevPkScript)
+}
+
+// AddInputScripts sets the redeem and witness scripts of a script hash input,
+// either may be nil.
+func (p *PsbtPacket) AddInputScripts(i int, redeemScript, witnessScript []byte) error {
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	updater, err := psbt.NewUpdater(p.Packet)
+	if err != nil {
+		return err
+	}
+	if redeemScript != nil {
+		if err := updater.AddInRedeemScript(redeemScript, i); err != nil {
+			return err
+		}
+	}
+	if witnessScript != nil {
+		if err := updater.AddInWitnessScript(witnessScript, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateOutput adds the key origin of a change output.
+func (p *PsbtPacket) UpdateOutput(i int, out *TxOutput) error {
+	if i < 0 || i >= len(p.Packet.Outputs) {
+		return errors.New("psbt output index out of range")
+	}
+	return addOutputKeyOrigin(p.Packet, i, out)
+}
+
+// Sign adds the signature of privKey to every input it can sign: key hash
+// inputs of its key, taproot key spends of its key, tap leaves containing its
+// x-only key and script hash inputs whose redeem or witness script contains its
+// key or key hash. It returns the number of signed inputs and leaves
+// finalization to Finalize.
+func (p *PsbtPacket) Sign(privKey string) (int, error) {
+	wif, err := btcutil.DecodeWIF(privKey)
+	if err != nil {
+		return 0, err
+	}
+	key := wif.PrivKey
+	pubKey := key.PubKey().SerializeCompressed()
+	updater, err := psbt.NewUpdater(p.Packet)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkPsbtUtxos(p.Packet); err != nil {
+		return 0, err
+	}
+	fetcher := psbtPrevOutFetcher(p.Packet)
+	sigHashes := txscript.NewTxSigHashes(p.Packet.UnsignedTx, fetcher)
+	tx := p.Packet.UnsignedTx
+
+	signed := 0
+	for i := range p.Packet.Inputs {
+		in := &p.Packet.Inputs[i]
+		if len(in.FinalScriptSig) > 0 || len(in.FinalScriptWitness) > 0 {
+			continue
+		}
+		prevOut := fetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+		if prevOut == nil {
+			continue
+		}
+		hashType := in.SighashType
+		pkScript := prevOut.PkScript
+
+		if txscript.IsPayToTaproot(pkScript) {
+			// an explicit SIGHASH_ALL is signed as such, not as DEFAULT
+			outputKey := txscript.ComputeTaprootOutputKey(key.PubKey(), in.TaprootMerkleRoot)
+			if !bytes.Equal(pkScript[2:], schnorr.SerializePubKey(outputKey)) {
+				ok, err := p.signTapLeaves(i, key, sigHashes, fetcher, hashType)
+				if err != nil {
+					return signed, err
+				}
+				if ok {
+					p.afterSign(i, hashType)
+					signed++
+				}
+				continue
+			}
+			sig, err := txscript.RawTxInTaprootSignature(tx, sigHashes, i, prevOut.Value, pkScript, in.TaprootMerkleRoot, hashType, key)
+			if err != nil {
+				return signed, err
+			}
+			in.TaprootInternalKey = schnorr.SerializePubKey(key.PubKey())
+			in.TaprootKeySpendSig = sig
+			p.afterSign(i, hashType)
+			signed++
+			continue
+		}
+
+		if hashType == txscript.SigHashDefault {
+			hashType = txscript.SigHashAll
+		}
+		var sig []byte
+		script := pkScript
+		if txscript.IsPayToScriptHash(script) {
+			if in.RedeemScript == nil {
+				continue
+			}
+			script = in.RedeemScript
+		}
+		switch {
+		case txscript.IsPayToWitnessPubKeyHash(script):
+			if !bytes.Equal(script[2:], btcutil.Hash160(pubKey)) {
+				continue
+			}
+			signScript, err := PayToPubKeyHashScript(btcutil.Hash160(pubKey))
+			if err != nil {
+				return signed, err
+			}
+			sig, err = txscript.RawTxInWitnessSignature(tx, sigHashes, i, prevOut.Value, signScript, hashType, key)
+			if err != nil {
+				return signed, err
+			}
+		case txscript.IsPayToWitnessScriptHash(script):
+			if in.WitnessScript == nil || !containsKey(in.WitnessScript, pubKey) {
+				continue
+			}
+			sig, err = txscript.RawTxInWitnessSignature(tx, sigHashes, i, prevOut.Value, in.WitnessScript, hashType, key)
+			if err != nil {
+				return signed, err
+			}
+		case txscript.IsPayToPubKeyHash(script):
+			if !bytes.Equal(script[3:23], btcutil.Hash160(pubKey)) {
+				continue
+			}
+			sig, err = txscript.RawTxInSignature(tx, i, script, hashType, key)
+			if err != nil {
+				return signed, err
+			}
+		case containsKey(script, pubKey) && txscript.IsPayToScriptHash(pkScript):
+			sig, err = txscript.RawTxInSignature(tx, i, script, hashType, key)
+			if err != nil {
+				return signed, err
+			}
+		default:
+			continue
+		}
+		if in.SighashType == 0 {
+			if err := updater.AddInSighashType(hashType, i); err != nil {
+				return signed, err
+			}
+		}
+		if _, err := updater.Sign(i, sig, pubKey, nil, nil); err != nil {
+			return signed, err
+		}
+		p.afterSign(i, hashType)
+		signed++
+	}
+	return signed, nil
+}
+
+// containsKey reports whether script has pubKey or its hash160.
+func containsKey(script, pubKey []byte) bool {
+	return bytes.Contains(script, pubKey) || bytes.Contains(script, btcutil.Hash160(pubKey))
+}
+
+// signTapLeaves adds the script path signature of key for every leaf of input
+// i which contains its x-only key.
+func (p *PsbtPacket) signTapLeaves(i int, key *btcec.PrivateKey, sigHashes *txscript.TxSigHashes,
+	fetcher txscript.PrevOutputFetcher, hashType txscript.SigHashType) (bool, error) {
+	in := &p.Packet.Inputs[i]
+	xOnly := schnorr.SerializePubKey(key.PubKey())
+	prevOut := fetcher.FetchPrevOutput(p.Packet.UnsignedTx.TxIn[i].PreviousOutPoint)
+	signed := false
+	for _, l := range in.TaprootLeafScript {
+		if !bytes.Contains(l.Script, xOnly) {
+			continue
+		}
+		leaf := txscript.NewTapLeaf(l.LeafVersion, l.Script)
+		leafHash := leaf.TapHash()
+		sig, err := txscript.RawTxInTapscriptSignature(p.Packet.UnsignedTx, sigHashes, i, prevOut.Value,
+			prevOut.PkScript, leaf, hashType, key)
+		if err != nil {
+			return signed, err
+		}
+		if hashType != txscript.SigHashDefault {
+			sig = sig[:schnorr.SignatureSize]
+		}
+		in.TaprootScriptSpendSig = append(in.TaprootScriptSpendSig, &psbt.TaprootScriptSpendSig{
+			XOnlyPubKey: xOnly,
+			LeafHash:    leafHash[:],
+			Signature:   sig,
+			SigHash:     hashType,
+		})
+		signed = true
+	}
+	return signed, nil
+}
+
+// afterSign updates the v2 modifiable flags the way BIP-370 asks a Signer to.
+func (p *PsbtPacket) afterSign(i int, hashType txscript.SigHashType) {
+	if p.Version != 2 {
+		return
+	}
+	if hashType&txscript.SigHashAnyOneCanPay == 0 {
+		p.TxModifiable &^= PsbtInputsModifiable
+	}
+	switch hashType &^ txscript.SigHashAnyOneCanPay {
+	case txscript.SigHashNone:
+	case txscript.SigHashSingle:
+		p.TxModifiable |= PsbtHasSighashSingle
+		fallthrough
+	default:
+		p.TxModifiable &^= PsbtOutputsModifiable
+	}
+}
+
+// CombinePsbtPackets merges the fields of PSBTs of the same transaction, such
+// as the partial signatures of independent signers. The result has the version
+// of the first packet; where two packets set the same key the first one wins.
+func CombinePsbtPackets(packets ...*PsbtPacket) (*PsbtPacket, error) {
+	if len(packets) == 0 {
+		return nil, errors.New("no psbt to combine")
+	}
+	first := packets[0]
+	txHash := first.Packet.UnsignedTx.TxHash()
+	combined, err := splitV0Packet(first.Packet)
+	if err != nil {
+		return nil, err
+	}
+	res := &PsbtPacket{
+		Version:                first.Version,
+		FallbackLockTime:       first.FallbackLockTime,
+		TxModifiable:           first.TxModifiable,
+		RequiredTimeLockTime:   append([]uint32{}, first.RequiredTimeLockTime...),
+		RequiredHeightLockTime: append([]uint32{}, first.RequiredHeightLockTime...),
+	}
+	for _, packet := range packets[1:] {
+		if packet.Packet.UnsignedTx.TxHash() != txHash || packet.Packet.UnsignedTx.Version != first.Packet.UnsignedTx.Version {
+			return nil, ErrPsbtMismatch
+		}
+		raw, err := splitV0Packet(packet.Packet)
+		if err != nil {
+			return nil, err
+		}
+		combined.global = mergePsbtMap(combined.global, raw.global)
+		for i := range raw.inputs {
+			combined.inputs[i] = mergePsbtMap(combined.inputs[i], raw.inputs[i])
+		}
+		for i := range raw.outputs {
+			combined.outputs[i] = mergePsbtMap(combined.outputs[i], raw.outputs[i])
+		}
+		if res.Version == 2 && packet.Version == 2 {
+			res.TxModifiable = res.TxModifiable&packet.TxModifiable&(PsbtInputsModifiable|PsbtOutputsModifiable) |
+				(res.TxModifiable|packet.TxModifiable)&PsbtHasSighashSingle
+		}
+	}
+	b, err := combined.serialize()
+	if err != nil {
+		return nil, err
+	}
+	if res.Packet, err = psbt.NewFromRawBytes(bytes.NewReader(b), false); err != nil {
+		return nil, err
+	}
+	if res.Version == 2 && len(res.RequiredTimeLockTime) != len(res.Packet.Inputs) {
+		res.RequiredTimeLockTime = make([]uint32, len(res.Packet.Inputs))
+		res.RequiredHeightLockTime = make([]uint32, len(res.Packet.Inputs))
+	}
+	return res, nil
+}
+
+func mergePsbtMap(dst, src []psbtKV) []psbtKV {
+	for _, kv := range src {
+		found := false
+		for _, v := range dst {
+			if bytes.Equal(v.key, kv.key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dst = append(dst, kv)
+		}
+	}
+	return dst
+}
+
+// Finalize builds the final script sig and witness of every input, multisig
+// inputs with as many signatures as their threshold.
+func (p *PsbtPacket) Finalize() error {
+	for i := range p.Packet.Inputs {
+		if _, err := finalizeMultisigInput(p.Packet, i); err != nil {
+			return err
+		}
+	}
+	return psbt.MaybeFinalizeAll(p.Packet)
+}
+
+// Extract returns the hex of the network serialized transaction of a
+// finalized packet.
+func (p *PsbtPacket) Extract() (string, error) {
+	tx, err := psbt.Extract(p.Packet)
+	if err != nil {
+		return "", err
+	}
+	return GetTxHex(tx)
+}
@@ -0,0 +1,175 @@
+package bitcoin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestPsbtRolesMultiSigner(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 4, network)
+	witnessScript, err := GetRedeemScript(pubKeys[:3], 2)
+	require.NoError(t, err)
+	scriptHash := sha256.Sum256(witnessScript)
+	multiAddr, err := btcutil.NewAddressWitnessScriptHash(scriptHash[:], network)
+	require.NoError(t, err)
+	singleAddr, err := PubKeyToAddr(mustDecodeHex(t, pubKeys[3]), SEGWIT_NATIVE, network)
+	require.NoError(t, err)
+
+	ins := []*TxInput{
+		{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", VOut: 0, Amount: 60000, Address: multiAddr.EncodeAddress()},
+		{TxId: "a2bd8bfdd2f8b7f1d4ff3ab9fa65e7b5d5a0c5e2b8f0f7c27d3b6b1b3e6a2c11", VOut: 3, Amount: 40000, Address: singleAddr, PublicKey: pubKeys[3],
+			MasterFingerprint: 0x0adac573, DerivationPath: "m/84'/1'/0'/0/0"},
+	}
+	outs := []*TxOutput{
+		{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 70000},
+		{Address: singleAddr, Amount: 29000, IsChange: true, PublicKey: pubKeys[3], MasterFingerprint: 0x0adac573, DerivationPath: "m/84'/1'/0'/1/0"},
+	}
+
+	for _, version := range []uint32{0, 2} {
+		// creator, constructor and updater
+		p, err := NewPsbtPacket(version, 2, 0)
+		require.NoError(t, err)
+		for i, in := range ins {
+			require.NoError(t, p.AddInput(in))
+			require.NoError(t, p.UpdateInput(i, in, network))
+		}
+		require.NoError(t, p.AddInputScripts(0, nil, witnessScript))
+		for _, out := range outs {
+			require.NoError(t, p.AddOutput(out, network))
+		}
+		assert.Len(t, p.Packet.Inputs[1].Bip32Derivation, 1)
+		assert.Len(t, p.Packet.Outputs[1].Bip32Derivation, 1)
+		unsigned, err := p.B64Encode()
+		require.NoError(t, err)
+
+		// independent signers each sign their own copy
+		var signedCopies []*PsbtPacket
+		for i, wif := range []string{wifs[0], wifs[2], wifs[3]} {
+			own, err := ParsePsbtPacket(unsigned)
+			require.NoError(t, err)
+			assert.Equal(t, version, own.Version)
+			n, err := own.Sign(wif)
+			require.NoError(t, err)
+			assert.Equal(t, 1, n, "signer %d", i)
+			signedCopies = append(signedCopies, own)
+		}
+		n, err := signedCopies[0].Sign(wifs[1])
+		require.NoError(t, err)
+		assert.Equal(t, 1, n)
+		_, err = p.Sign(wifs[1])
+		require.NoError(t, err)
+
+		// combiner, finalizer and extractor
+		combined, err := CombinePsbtPackets(signedCopies[1], signedCopies[2], p)
+		require.NoError(t, err)
+		assert.Len(t, combined.Packet.Inputs[0].PartialSigs, 2)
+		assert.Len(t, combined.Packet.Inputs[1].PartialSigs, 1)
+		require.NoError(t, combined.Finalize())
+		txHex, err := combined.Extract()
+		require.NoError(t, err)
+		verifyTxScripts(t, txHex, PrevOutputs{
+			{TxId: ins[0].TxId, VOut: ins[0].VOut, Amount: ins[0].Amount, Address: ins[0].Address},
+			{TxId: ins[1].TxId, VOut: ins[1].VOut, Amount: ins[1].Amount, Address: ins[1].Address},
+		}, network)
+	}
+}
+
+func TestPsbtRolesModifiable(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 1, network)
+	addr, err := PubKeyToAddr(mustDecodeHex(t, pubKeys[0]), TAPROOT, network)
+	require.NoError(t, err)
+	in := &TxInput{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", VOut: 1, Amount: 50000, Address: addr}
+	out := &TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 49000}
+
+	for _, test := range []struct {
+		hashType   txscript.SigHashType
+		modifiable uint8
+	}{
+		{txscript.SigHashDefault, 0},
+		// BIP-370 only leaves the outputs modifiable after a NONE signature
+		{txscript.SigHashSingle | txscript.SigHashAnyOneCanPay, PsbtInputsModifiable | PsbtHasSighashSingle},
+		{txscript.SigHashSingle, PsbtHasSighashSingle},
+		{txscript.SigHashNone, PsbtOutputsModifiable},
+	} {
+		p, err := NewPsbtPacket(2, 2, 0)
+		require.NoError(t, err)
+		require.NoError(t, p.AddInput(in))
+		require.NoError(t, p.UpdateInput(0, in, network))
+		require.NoError(t, p.AddOutput(out, network))
+		p.Packet.Inputs[0].SighashType = test.hashType
+		n, err := p.Sign(wifs[0])
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+		assert.Equal(t, test.modifiable, p.TxModifiable)
+		if test.modifiable&PsbtInputsModifiable == 0 {
+			assert.ErrorIs(t, p.AddInput(in), ErrPsbtNotModifiable)
+		}
+		if test.modifiable&PsbtOutputsModifiable == 0 {
+			assert.ErrorIs(t, p.AddOutput(out, network), ErrPsbtNotModifiable)
+		}
+	}
+
+	// v0 packets are fixed once anything is signed
+	p, err := NewPsbtPacket(0, 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, p.AddInput(in))
+	require.NoError(t, p.UpdateInput(0, in, network))
+	require.NoError(t, p.AddOutput(out, network))
+	_, err = p.Sign(wifs[0])
+	require.NoError(t, err)
+	assert.ErrorIs(t, p.AddOutput(out, network), ErrPsbtNotModifiable)
+	require.NoError(t, p.Finalize())
+	txHex, err := p.Extract()
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: in.TxId, VOut: in.VOut, Amount: in.Amount, Address: addr}}, network)
+}
+
+func TestPsbtSignSigHashAll(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 1, network)
+	addr, err := PubKeyToAddr(mustDecodeHex(t, pubKeys[0]), TAPROOT, network)
+	require.NoError(t, err)
+	in := &TxInput{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", VOut: 1, Amount: 50000, Address: addr}
+	p, err := NewPsbtPacket(2, 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, p.AddInput(in))
+	require.NoError(t, p.UpdateInput(0, in, network))
+	require.NoError(t, p.AddOutput(&TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 49000}, network))
+	p.Packet.Inputs[0].SighashType = txscript.SigHashAll
+	_, err = p.Sign(wifs[0])
+	require.NoError(t, err)
+
+	// the key spend signature commits to SIGHASH_ALL, not DEFAULT
+	sig := p.Packet.Inputs[0].TaprootKeySpendSig
+	require.Len(t, sig, 65)
+	assert.Equal(t, byte(txscript.SigHashAll), sig[64])
+	require.NoError(t, p.Finalize())
+	txHex, err := p.Extract()
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: in.TxId, VOut: in.VOut, Amount: in.Amount, Address: addr}}, network)
+}
+
+func TestCombinePsbtPacketsMismatch(t *testing.T) {
+	a, err := NewPsbtPacket(2, 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, a.AddInput(&TxInput{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"}))
+	b, err := NewPsbtPacket(2, 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, b.AddInput(&TxInput{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", VOut: 1}))
+	_, err = CombinePsbtPackets(a, b)
+	assert.ErrorIs(t, err, ErrPsbtMismatch)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
@@ -16,7 +16,6 @@ import (
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/btcsuite/btcd/txscript"
 	"github.com/btcsuite/btcd/wire"
-	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/okx/go-wallet-sdk/coins/bitcoin/doginals"
 	"io"
 )
@@ -46,6 +45,9 @@ type TxInput struct {
 	MasterFingerprint uint32
 	DerivationPath    string
 	PublicKey         string
+	// TapMerkleRoot is the hex root of the script tree of a taproot input
+	// whose internal key is PublicKey, empty for a BIP-86 key.
+	TapMerkleRoot string
 }
 type TxInputs []*TxInput
 
@@ -81,6 +83,9 @@ type ToSignInput struct {
 	SigHashTypes       []int  `json:"sighashTypes"`
 	DisableTweakSigner bool   `json:"disableTweakSigner"`
 	UseTweakSigner     bool   `json:"useTweakSigner"`
+	// TapLeafHashToSign picks the leaf of a script path spend, by default the
+	// leaves whose script contains the signing key are signed.
+	TapLeafHashToSign string `json:"tapLeafHashToSign"`
 }
 
 type SignPsbtOption struct {
@@ -350,12 +355,17 @@ func CalcFee(ins TxInputs, outs []*TxOutput, sellerPsbt string, feeRate int64, n
 	}
 
 	view, _ := ins.UtxoViewpoint(network)
-	return GetTxVirtualSizeByView(btcutil.NewTx(tx), view) * feeRate, nil
+	return RelayPolicyFor(network).Fee(GetTxVirtualSizeByView(btcutil.NewTx(tx), view), feeRate), nil
 }
 
 func AddrToPkScript(addr string, network *chaincfg.Params) ([]byte, error) {
 	address, err := btcutil.DecodeAddress(addr, network)
 	if err != nil {
+		if CashAddrPrefix(network) != "" {
+			if pkScript, cashAddrErr := CashAddrToPkScript(addr, network); cashAddrErr == nil {
+				return pkScript, nil
+			}
+		}
 		return nil, err
 	}
 
@@ -372,7 +382,10 @@ func PayToWitnessPubKeyHashScript(pubKeyHash []byte) ([]byte, error) {
 	return txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(pubKeyHash).Script()
 }
 
-func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg.Params) (string, error) {
+// GenerateUnsignedPSBTHex creates the PSBT of ins and outs with the key
+// origin of the inputs and change outputs, and xpubs as global xpubs, for a
+// signing device to recognise them.
+func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg.Params, xpubs ...*PsbtXPub) (string, error) {
 	if network == nil {
 		network = &chaincfg.MainNetParams
 	}
@@ -444,30 +457,21 @@ func GenerateUnsignedPSBTHex(ins []*TxInput, outs []*TxOutput, network *chaincfg
 			}
 		}
 
-		derivationPath, err := accounts.ParseDerivationPath(in.DerivationPath)
-		if err != nil {
-			return "", err
-		}
-		if err := updater.AddInBip32Derivation(in.MasterFingerprint, derivationPath, publicKeyBytes, i); err != nil {
+		if err := addInputKeyOrigin(p, i, in, prevPkScript); err != nil {
 			return "", err
 		}
 	}
 
 	for i, out := range outs {
 		if out.IsChange {
-			derivationPath, err := accounts.ParseDerivationPath(out.DerivationPath)
-			if err != nil {
-				return "", err
-			}
-			publicKeyBytes, err := hex.DecodeString(out.PublicKey)
-			if err != nil {
-				return "", err
-			}
-			if err := updater.AddOutBip32Derivation(out.MasterFingerprint, derivationPath, publicKeyBytes, i); err != nil {
+			if err := addOutputKeyOrigin(p, i, out); err != nil {
 				return "", err
 			}
 		}
 	}
+	if err := addGlobalXPubs(p, xpubs); err != nil {
+		return "", err
+	}
 
 	var b bytes.Buffer
 	if err := p.Serialize(&b); err != nil {
@@ -1008,10 +1012,10 @@ func CalcFeeForBatchBuy(ins TxInputs, outs []*TxOutput, sellerPSBTList []string,
 		return 0, err
 	}
 	if network != nil && network.PubKeyHashAddrID == doginals.PubKeyHashAddrID && network.ScriptHashAddrID == doginals.ScriptHashAddrID {
-		return doginals.GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate, nil
+		return DOGERelayPolicy.Fee(doginals.GetTxVirtualSize(btcutil.NewTx(tx)), feeRate), nil
 	}
 	view, _ := ins.UtxoViewpoint(network)
-	return GetTxVirtualSizeByView(btcutil.NewTx(tx), view) * feeRate, nil
+	return RelayPolicyFor(network).Fee(GetTxVirtualSizeByView(btcutil.NewTx(tx), view), feeRate), nil
 }
 
 func CalcFeeForBatchBuyWithMPC(ins TxInputs, outs []*TxOutput, sellerPSBTList []string, feeRate int64, network *chaincfg.Params, ops ...string) (int64, error) {
@@ -1036,10 +1040,10 @@ func CalcFeeForBatchBuyWithMPC(ins TxInputs, outs []*TxOutput, sellerPSBTList []
 		return 0, err
 	}
 	if network != nil && network.PubKeyHashAddrID == doginals.PubKeyHashAddrID && network.ScriptHashAddrID == doginals.ScriptHashAddrID {
-		return doginals.GetTxVirtualSize(btcutil.NewTx(tx)) * feeRate, nil
+		return DOGERelayPolicy.Fee(doginals.GetTxVirtualSize(btcutil.NewTx(tx)), feeRate), nil
 	}
 	view, _ := ins.UtxoViewpoint(network)
-	return GetTxVirtualSizeByView(btcutil.NewTx(tx), view) * feeRate, nil
+	return RelayPolicyFor(network).Fee(GetTxVirtualSizeByView(btcutil.NewTx(tx), view), feeRate), nil
 }
 
 type GenerateMPCPSbtTxRes struct {
@@ -1048,7 +1052,10 @@ type GenerateMPCPSbtTxRes struct {
 	SignHashList []string `json:"signHashList"`
 }
 
-func GenerateMPCUnsignedListingPSBT(in *TxInput, out *TxOutput, network *chaincfg.Params) (*GenerateMPCPSbtTxRes, error) {
+// GenerateMPCUnsignedListingPSBT creates the listing PSBT of the seller and
+// the hash to sign for it. The key origins of in and out and the xpubs are
+// added for a signing device to sign it instead.
+func GenerateMPCUnsignedListingPSBT(in *TxInput, out *TxOutput, network *chaincfg.Params, xpubs ...*PsbtXPub) (*GenerateMPCPSbtTxRes, error) {
 	txHash, err := chainhash.NewHashFromStr(in.TxId)
 	if err != nil {
 		return nil, err
@@ -1104,6 +1111,15 @@ func GenerateMPCUnsignedListingPSBT(in *TxInput, out *TxOutput, network *chaincf
 	if err != nil {
 		return nil, err
 	}
+	if err := addInputKeyOrigin(p, SellerSignatureIndex, in, prevPkScript); err != nil {
+		return nil, err
+	}
+	if err := addOutputKeyOrigin(p, SellerSignatureIndex, out); err != nil {
+		return nil, err
+	}
+	if err := addGlobalXPubs(p, xpubs); err != nil {
+		return nil, err
+	}
 	psbtBase64, err := p.B64Encode()
 	if err != nil {
 		return nil, err
@@ -1142,7 +1158,10 @@ func GenerateMPCSignedListingPSBT(psbtBase64 string, signature string, pubKey st
 	return res, nil
 }
 
-func GenerateMPCUnsignedBuyingPSBT(ins []*TxInput, outs []*TxOutput, sellerPSBTList []string, network *chaincfg.Params) (*GenerateMPCPSbtTxRes, error) {
+// GenerateMPCUnsignedBuyingPSBT creates the buying PSBT of the seller PSBTs
+// and the hashes to sign for the buyer inputs, with the key origins of the
+// buyer inputs and change outputs and the xpubs.
+func GenerateMPCUnsignedBuyingPSBT(ins []*TxInput, outs []*TxOutput, sellerPSBTList []string, network *chaincfg.Params, xpubs ...*PsbtXPub) (*GenerateMPCPSbtTxRes, error) {
 	sellerIndex := len(sellerPSBTList) + 1
 	var spList []*psbt.Packet
 	for _, sellerPSBT := range sellerPSBTList {
@@ -1222,9 +1241,22 @@ func GenerateMPCUnsignedBuyingPSBT(ins []*TxInput, outs []*TxOutput, sellerPSBTL
 		if err != nil {
 			return nil, err
 		}
+		if err := addInputKeyOrigin(bp, i, in, prevOuts[bp.UnsignedTx.TxIn[i].PreviousOutPoint].PkScript); err != nil {
+			return nil, err
+		}
 
 		sigHashList = append(sigHashList, sigHash)
 	}
+	for i, out := range outs {
+		if out.IsChange && (i < sellerIndex || i >= sellerIndex+len(spList)) {
+			if err := addOutputKeyOrigin(bp, i, out); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := addGlobalXPubs(bp, xpubs); err != nil {
+		return nil, err
+	}
 
 	for i, sp := range spList {
 		bp.Inputs[sellerIndex+i] = sp.Inputs[SellerSignatureIndex]
@@ -1692,8 +1724,10 @@ func signPsbtWithKeyPathAndScriptPath(updater *psbt.Updater, priv string, i int,
 				if toSignInput != nil && toSignInput.UseTweakSigner {
 					privKey = txscript.TweakTaprootPrivKey(*privKey, []byte{})
 				}
-				// btcd only support one leaf till now
-				tapLeaves := updater.Upsbt.Inputs[i].TaprootLeafScript
+				tapLeaves, err := tapLeavesToSign(updater.Upsbt.Inputs[i].TaprootLeafScript, privKey, toSignInput)
+				if err != nil {
+					return err
+				}
 				taprootScriptSpendSignatures := make([]*psbt.TaprootScriptSpendSig, 0)
 				for _, leaf := range tapLeaves {
 					tapLeaf := txscript.TapLeaf{
@@ -1792,6 +1826,31 @@ func signPsbtWithKeyPathAndScriptPath(updater *psbt.Updater, priv string, i int,
 	return nil
 }
 
+// tapLeavesToSign picks the leaves of a script path spend: the requested one,
+// else those whose script contains the key, else all of them.
+func tapLeavesToSign(leaves []*psbt.TaprootTapLeafScript, privKey *btcec.PrivateKey, toSignInput *ToSignInput) ([]*psbt.TaprootTapLeafScript, error) {
+	if toSignInput != nil && toSignInput.TapLeafHashToSign != "" {
+		for _, leaf := range leaves {
+			tapHash := txscript.NewTapLeaf(leaf.LeafVersion, leaf.Script).TapHash()
+			if hex.EncodeToString(tapHash[:]) == toSignInput.TapLeafHashToSign {
+				return []*psbt.TaprootTapLeafScript{leaf}, nil
+			}
+		}
+		return nil, fmt.Errorf("tap leaf %s not found", toSignInput.TapLeafHashToSign)
+	}
+	xOnly := schnorr.SerializePubKey(privKey.PubKey())
+	var own []*psbt.TaprootTapLeafScript
+	for _, leaf := range leaves {
+		if bytes.Contains(leaf.Script, xOnly) {
+			own = append(own, leaf)
+		}
+	}
+	if len(own) > 0 {
+		return own, nil
+	}
+	return leaves, nil
+}
+
 func CheckDuplicateOfUpdater(updater *psbt.Updater, index int) {
 	signatures := updater.Upsbt.Inputs[index].TaprootScriptSpendSig
 	m := map[string]*psbt.TaprootScriptSpendSig{}
@@ -0,0 +1,531 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"io"
+	"sort"
+)
+
+// BIP-370 PSBT v2 field types.
+const (
+	PsbtGlobalTxVersion        psbt.GlobalType = 0x02
+	PsbtGlobalFallbackLockTime psbt.GlobalType = 0x03
+	PsbtGlobalInputCount       psbt.GlobalType = 0x04
+	PsbtGlobalOutputCount      psbt.GlobalType = 0x05
+	PsbtGlobalTxModifiable     psbt.GlobalType = 0x06
+
+	PsbtInPreviousTxId           psbt.InputType  = 0x0e
+	PsbtInOutputIndex            psbt.InputType  = 0x0f
+	PsbtInSequence               psbt.InputType  = 0x10
+	PsbtInRequiredTimeLockTime   psbt.InputType  = 0x11
+	PsbtInRequiredHeightLockTime psbt.InputType  = 0x12
+	PsbtOutAmount                psbt.OutputType = 0x03
+	PsbtOutScript                psbt.OutputType = 0x04
+)
+
+// PSBT_GLOBAL_TX_MODIFIABLE flags.
+const (
+	PsbtInputsModifiable  = 0x01
+	PsbtOutputsModifiable = 0x02
+	PsbtHasSighashSingle  = 0x04
+)
+
+var (
+	ErrUnsupportedPsbtVersion = errors.New("unsupported psbt version")
+	ErrPsbtLockTimeConflict   = errors.New("psbt inputs require both a time and a height lock time")
+	ErrPsbtInputIndex         = errors.New("psbt input index out of range")
+)
+
+var psbtMagicBytes = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+// PsbtPacket is a PSBT of either version. Both versions share the psbt.Packet
+// model, whose unsigned transaction is kept in sync with the v2 fields, and
+// differ only in serialization.
+type PsbtPacket struct {
+	Packet  *psbt.Packet
+	Version uint32
+
+	// v2 only fields
+	FallbackLockTime       uint32
+	TxModifiable           uint8
+	RequiredTimeLockTime   []uint32 // by input, 0 when not required
+	RequiredHeightLockTime []uint32 // by input, 0 when not required
+}
+
+type psbtKV struct {
+	key   []byte
+	value []byte
+}
+
+func readPsbtMap(r io.Reader) ([]psbtKV, error) {
+	var kvs []psbtKV
+	seen := make(map[string]bool)
+	for {
+		keyLen, err := wire.ReadVarInt(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		if keyLen == 0 {
+			return kvs, nil
+		}
+		if keyLen > psbt.MaxPsbtKeyLength {
+			return nil, psbt.ErrInvalidPsbtFormat
+		}
+		key := make([]byte, keyLen)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, err
+		}
+		value, err := wire.ReadVarBytes(r, 0, psbt.MaxPsbtValueLength, "PSBT value")
+		if err != nil {
+			return nil, err
+		}
+		if seen[string(key)] {
+			return nil, psbt.ErrDuplicateKey
+		}
+		seen[string(key)] = true
+		kvs = append(kvs, psbtKV{key: key, value: value})
+	}
+}
+
+func writePsbtMap(w io.Writer, kvs []psbtKV) error {
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return bytes.Compare(kvs[i].key, kvs[j].key) < 0
+	})
+	for _, kv := range kvs {
+		if err := wire.WriteVarBytes(w, 0, kv.key); err != nil {
+			return err
+		}
+		if err := wire.WriteVarBytes(w, 0, kv.value); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte{0x00})
+	return err
+}
+
+func findPsbtKV(kvs []psbtKV, keyType byte) ([]byte, bool) {
+	for _, kv := range kvs {
+		if len(kv.key) == 1 && kv.key[0] == keyType {
+			return kv.value, true
+		}
+	}
+	return nil, false
+}
+
+func filterPsbtKV(kvs []psbtKV, keyTypes ...byte) []psbtKV {
+	var res []psbtKV
+	for _, kv := range kvs {
+		drop := false
+		for _, t := range keyTypes {
+			if kv.key[0] == t {
+				drop = true
+			}
+		}
+		if !drop {
+			res = append(res, kv)
+		}
+	}
+	return res
+}
+
+func v2Uint32(v []byte) uint32 {
+	return binary.LittleEndian.Uint32(v)
+}
+
+func putUint32(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, n)
+	return b
+}
+
+func putVarInt(n uint64) []byte {
+	var buf bytes.Buffer
+	_ = wire.WriteVarInt(&buf, 0, n)
+	return buf.Bytes()
+}
+
+// rawPsbt is a PSBT split into its key-value maps.
+type rawPsbt struct {
+	global  []psbtKV
+	inputs  [][]psbtKV
+	outputs [][]psbtKV
+}
+
+func readRawPsbt(r io.Reader, inputCount, outputCount int) (*rawPsbt, error) {
+	raw := &rawPsbt{}
+	for i := 0; i < inputCount; i++ {
+		m, err := readPsbtMap(r)
+		if err != nil {
+			return nil, err
+		}
+		raw.inputs = append(raw.inputs, m)
+	}
+	for i := 0; i < outputCount; i++ {
+		m, err := readPsbtMap(r)
+		if err != nil {
+			return nil, err
+		}
+		raw.outputs = append(raw.outputs, m)
+	}
+	return raw, nil
+}
+
+func (raw *rawPsbt) serialize() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(psbtMagicBytes)
+	if err := writePsbtMap(&buf, raw.global); err != nil {
+		return nil, err
+	}
+	for _, m := range raw.inputs {
+		if err := writePsbtMap(&buf, m); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range raw.outputs {
+		if err := writePsbtMap(&buf, m); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// splitV0Packet serializes p and splits it into its maps.
+func splitV0Packet(p *psbt.Packet) (*rawPsbt, error) {
+	var buf bytes.Buffer
+	if err := p.Serialize(&buf); err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(buf.Bytes()[len(psbtMagicBytes):])
+	global, err := readPsbtMap(r)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := readRawPsbt(r, len(p.UnsignedTx.TxIn), len(p.UnsignedTx.TxOut))
+	if err != nil {
+		return nil, err
+	}
+	raw.global = global
+	return raw, nil
+}
+
+// ParsePsbtPacket parses a hex or base64 encoded PSBT of version 0 or 2.
+func ParsePsbtPacket(psbtStr string) (*PsbtPacket, error) {
+	var b []byte
+	var err error
+	if IsHexString(psbtStr) {
+		b, err = hex.DecodeString(psbtStr)
+	} else {
+		b, err = base64.StdEncoding.DecodeString(psbtStr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPsbtPacketFromBytes(b)
+}
+
+func NewPsbtPacketFromBytes(b []byte) (*PsbtPacket, error) {
+	if !bytes.HasPrefix(b, psbtMagicBytes) {
+		return nil, psbt.ErrInvalidMagicBytes
+	}
+	r := bytes.NewReader(b[len(psbtMagicBytes):])
+	global, err := readPsbtMap(r)
+	if err != nil {
+		return nil, err
+	}
+	version := uint32(0)
+	if v, ok := findPsbtKV(global, byte(psbt.VersionType)); ok {
+		if len(v) != 4 {
+			return nil, psbt.ErrInvalidPsbtFormat
+		}
+		version = v2Uint32(v)
+	}
+	switch version {
+	case 0:
+		p, err := psbt.NewFromRawBytes(bytes.NewReader(b), false)
+		if err != nil {
+			return nil, err
+		}
+		return &PsbtPacket{Packet: p}, nil
+	case 2:
+		return parsePsbtV2(global, r)
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedPsbtVersion, version)
+}
+
+func parsePsbtV2(global []psbtKV, r io.Reader) (*PsbtPacket, error) {
+	if _, ok := findPsbtKV(global, byte(psbt.UnsignedTxType)); ok {
+		return nil, fmt.Errorf("%w: v2 psbt with an unsigned transaction", psbt.ErrInvalidPsbtFormat)
+	}
+	readCount := func(t psbt.GlobalType) (int, error) {
+		v, ok := findPsbtKV(global, byte(t))
+		if !ok {
+			return 0, fmt.Errorf("%w: missing global field %#x", psbt.ErrInvalidPsbtFormat, byte(t))
+		}
+		n, err := wire.ReadVarInt(bytes.NewReader(v), 0)
+		if err != nil {
+			return 0, err
+		}
+		return int(n), nil
+	}
+	inputCount, err := readCount(PsbtGlobalInputCount)
+	if err != nil {
+		return nil, err
+	}
+	outputCount, err := readCount(PsbtGlobalOutputCount)
+	if err != nil {
+		return nil, err
+	}
+	txVersion, ok := findPsbtKV(global, byte(PsbtGlobalTxVersion))
+	if !ok || len(txVersion) != 4 {
+		return nil, fmt.Errorf("%w: missing tx version", psbt.ErrInvalidPsbtFormat)
+	}
+	raw, err := readRawPsbt(r, inputCount, outputCount)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := &PsbtPacket{
+		Version:                2,
+		RequiredTimeLockTime:   make([]uint32, inputCount),
+		RequiredHeightLockTime: make([]uint32, inputCount),
+	}
+	if v, ok := findPsbtKV(global, byte(PsbtGlobalFallbackLockTime)); ok && len(v) == 4 {
+		packet.FallbackLockTime = v2Uint32(v)
+	}
+	if v, ok := findPsbtKV(global, byte(PsbtGlobalTxModifiable)); ok && len(v) == 1 {
+		packet.TxModifiable = v[0]
+	}
+
+	tx := wire.NewMsgTx(int32(v2Uint32(txVersion)))
+	for i, m := range raw.inputs {
+		txId, ok := findPsbtKV(m, byte(PsbtInPreviousTxId))
+		if !ok || len(txId) != chainhash.HashSize {
+			return nil, fmt.Errorf("%w: input %d has no previous txid", psbt.ErrInvalidPsbtFormat, i)
+		}
+		index, ok := findPsbtKV(m, byte(PsbtInOutputIndex))
+		if !ok || len(index) != 4 {
+			return nil, fmt.Errorf("%w: input %d has no output index", psbt.ErrInvalidPsbtFormat, i)
+		}
+		hash, _ := chainhash.NewHash(txId)
+		in := wire.NewTxIn(wire.NewOutPoint(hash, v2Uint32(index)), nil, nil)
+		if v, ok := findPsbtKV(m, byte(PsbtInSequence)); ok && len(v) == 4 {
+			in.Sequence = v2Uint32(v)
+		}
+		if v, ok := findPsbtKV(m, byte(PsbtInRequiredTimeLockTime)); ok && len(v) == 4 {
+			packet.RequiredTimeLockTime[i] = v2Uint32(v)
+		}
+		if v, ok := findPsbtKV(m, byte(PsbtInRequiredHeightLockTime)); ok && len(v) == 4 {
+			packet.RequiredHeightLockTime[i] = v2Uint32(v)
+		}
+		tx.AddTxIn(in)
+		raw.inputs[i] = filterPsbtKV(m, byte(PsbtInPreviousTxId), byte(PsbtInOutputIndex), byte(PsbtInSequence),
+			byte(PsbtInRequiredTimeLockTime), byte(PsbtInRequiredHeightLockTime))
+	}
+	for i, m := range raw.outputs {
+		amount, ok := findPsbtKV(m, byte(PsbtOutAmount))
+		if !ok || len(amount) != 8 {
+			return nil, fmt.Errorf("%w: output %d has no amount", psbt.ErrInvalidPsbtFormat, i)
+		}
+		script, ok := findPsbtKV(m, byte(PsbtOutScript))
+		if !ok {
+			return nil, fmt.Errorf("%w: output %d has no script", psbt.ErrInvalidPsbtFormat, i)
+		}
+		tx.AddTxOut(wire.NewTxOut(int64(binary.LittleEndian.Uint64(amount)), script))
+		raw.outputs[i] = filterPsbtKV(m, byte(PsbtOutAmount), byte(PsbtOutScript))
+	}
+	lockTime, err := packet.lockTime()
+	if err != nil {
+		return nil, err
+	}
+	tx.LockTime = lockTime
+
+	// the remaining fields are those of v0, parse them as a v0 packet
+	var txBuf bytes.Buffer
+	if err := tx.SerializeNoWitness(&txBuf); err != nil {
+		return nil, err
+	}
+	raw.global = append(filterPsbtKV(global, byte(PsbtGlobalTxVersion), byte(PsbtGlobalFallbackLockTime),
+		byte(PsbtGlobalInputCount), byte(PsbtGlobalOutputCount), byte(PsbtGlobalTxModifiable), byte(psbt.VersionType)),
+		psbtKV{key: []byte{byte(psbt.UnsignedTxType)}, value: txBuf.Bytes()})
+	b, err := raw.serialize()
+	if err != nil {
+		return nil, err
+	}
+	if packet.Packet, err = psbt.NewFromRawBytes(bytes.NewReader(b), false); err != nil {
+		return nil, err
+	}
+	return packet, nil
+}
+
+// lockTime determines the transaction lock time following BIP-370: the
+// largest required height if every input with a requirement accepts a
+// height, else the largest required time, else the fallback.
+func (p *PsbtPacket) lockTime() (uint32, error) {
+	if p.Version != 2 {
+		return p.Packet.UnsignedTx.LockTime, nil
+	}
+	hasRequirement, allHeight, allTime := false, true, true
+	var maxHeight, maxTime uint32
+	for i := range p.RequiredTimeLockTime {
+		height, time := p.RequiredHeightLockTime[i], p.RequiredTimeLockTime[i]
+		if height == 0 && time == 0 {
+			continue
+		}
+		hasRequirement = true
+		if height == 0 {
+			allHeight = false
+		}
+		if time == 0 {
+			allTime = false
+		}
+		if height > maxHeight {
+			maxHeight = height
+		}
+		if time > maxTime {
+			maxTime = time
+		}
+	}
+	switch {
+	case !hasRequirement:
+		return p.FallbackLockTime, nil
+	case allHeight:
+		return maxHeight, nil
+	case allTime:
+		return maxTime, nil
+	}
+	return 0, ErrPsbtLockTimeConflict
+}
+
+// Serialize encodes the packet in its version's format.
+func (p *PsbtPacket) Serialize() ([]byte, error) {
+	switch p.Version {
+	case 0:
+		var buf bytes.Buffer
+		if err := p.Packet.Serialize(&buf); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case 2:
+		return p.serializeV2()
+	}
+	return nil, fmt.Errorf("%w: %d", ErrUnsupportedPsbtVersion, p.Version)
+}
+
+func (p *PsbtPacket) serializeV2() ([]byte, error) {
+	raw, err := splitV0Packet(p.Packet)
+	if err != nil {
+		return nil, err
+	}
+	tx := p.Packet.UnsignedTx
+	raw.global = append(filterPsbtKV(raw.global, byte(psbt.UnsignedTxType), byte(psbt.VersionType)),
+		psbtKV{key: []byte{byte(PsbtGlobalTxVersion)}, value: putUint32(uint32(tx.Version))},
+		psbtKV{key: []byte{byte(PsbtGlobalFallbackLockTime)}, value: putUint32(p.FallbackLockTime)},
+		psbtKV{key: []byte{byte(PsbtGlobalInputCount)}, value: putVarInt(uint64(len(tx.TxIn)))},
+		psbtKV{key: []byte{byte(PsbtGlobalOutputCount)}, value: putVarInt(uint64(len(tx.TxOut)))},
+		psbtKV{key: []byte{byte(PsbtGlobalTxModifiable)}, value: []byte{p.TxModifiable}},
+		psbtKV{key: []byte{byte(psbt.VersionType)}, value: putUint32(2)},
+	)
+	for i, in := range tx.TxIn {
+		m := append(raw.inputs[i],
+			psbtKV{key: []byte{byte(PsbtInPreviousTxId)}, value: in.PreviousOutPoint.Hash.CloneBytes()},
+			psbtKV{key: []byte{byte(PsbtInOutputIndex)}, value: putUint32(in.PreviousOutPoint.Index)},
+		)
+		if in.Sequence != wire.MaxTxInSequenceNum {
+			m = append(m, psbtKV{key: []byte{byte(PsbtInSequence)}, value: putUint32(in.Sequence)})
+		}
+		if p.RequiredTimeLockTime[i] != 0 {
+			m = append(m, psbtKV{key: []byte{byte(PsbtInRequiredTimeLockTime)}, value: putUint32(p.RequiredTimeLockTime[i])})
+		}
+		if p.RequiredHeightLockTime[i] != 0 {
+			m = append(m, psbtKV{key: []byte{byte(PsbtInRequiredHeightLockTime)}, value: putUint32(p.RequiredHeightLockTime[i])})
+		}
+		raw.inputs[i] = m
+	}
+	for i, out := range tx.TxOut {
+		amount := make([]byte, 8)
+		binary.LittleEndian.PutUint64(amount, uint64(out.Value))
+		raw.outputs[i] = append(raw.outputs[i],
+			psbtKV{key: []byte{byte(PsbtOutAmount)}, value: amount},
+			psbtKV{key: []byte{byte(PsbtOutScript)}, value: out.PkScript},
+		)
+	}
+	return raw.serialize()
+}
+
+func (p *PsbtPacket) B64Encode() (string, error) {
+	b, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (p *PsbtPacket) HexEncode() (string, error) {
+	b, err := p.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ConvertTo switches the packet to version 0 or 2. A v0 packet converted to v2
+// keeps its lock time as the fallback and is not modifiable.
+func (p *PsbtPacket) ConvertTo(version uint32) error {
+	if version == p.Version {
+		return nil
+	}
+	switch version {
+	case 0:
+		p.FallbackLockTime, p.TxModifiable = 0, 0
+		p.RequiredTimeLockTime, p.RequiredHeightLockTime = nil, nil
+	case 2:
+		p.FallbackLockTime = p.Packet.UnsignedTx.LockTime
+		p.TxModifiable = 0
+		p.RequiredTimeLockTime = make([]uint32, len(p.Packet.Inputs))
+		p.RequiredHeightLockTime = make([]uint32, len(p.Packet.Inputs))
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedPsbtVersion, version)
+	}
+	p.Version = version
+	return nil
+}
+
+// SetRequiredLockTime sets the lock time input i requires, a height below
+// 500000000 and a unix time otherwise. It only applies to v2 packets.
+func (p *PsbtPacket) SetRequiredLockTime(i int, lockTime uint32) error {
+	if p.Version != 2 {
+		return fmt.Errorf("%w: required lock times need a v2 psbt", ErrUnsupportedPsbtVersion)
+	}
+	if i < 0 || i >= len(p.Packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	height, time := p.RequiredHeightLockTime[i], p.RequiredTimeLockTime[i]
+	if lockTime < txscript.LockTimeThreshold {
+		p.RequiredHeightLockTime[i] = lockTime
+	} else {
+		p.RequiredTimeLockTime[i] = lockTime
+	}
+	if err := p.syncLockTime(); err != nil {
+		p.RequiredHeightLockTime[i], p.RequiredTimeLockTime[i] = height, time
+		return err
+	}
+	return nil
+}
+
+func (p *PsbtPacket) syncLockTime() error {
+	lockTime, err := p.lockTime()
+	if err != nil {
+		return err
+	}
+	p.Packet.UnsignedTx.LockTime = lockTime
+	return nil
+}
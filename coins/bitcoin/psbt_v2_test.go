@@ -0,0 +1,108 @@
+package bitcoin
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newTestPsbtPacket(t *testing.T, version uint32) *PsbtPacket {
+	network := &chaincfg.TestNet3Params
+	p, err := NewPsbtPacket(version, 2, 0)
+	require.NoError(t, err)
+	for i, in := range []*TxInput{
+		{TxId: "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", VOut: 1, Amount: 50000,
+			Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+		{TxId: "a2bd8bfdd2f8b7f1d4ff3ab9fa65e7b5d5a0c5e2b8f0f7c27d3b6b1b3e6a2c11", VOut: 0, Sequence: 0xfffffffd, Amount: 30000,
+			Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+	} {
+		require.NoError(t, p.AddInput(in))
+		require.NoError(t, p.UpdateInput(i, in, network))
+	}
+	require.NoError(t, p.AddOutput(&TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 79000}, network))
+	return p
+}
+
+func TestPsbtV2RoundTrip(t *testing.T) {
+	p := newTestPsbtPacket(t, 2)
+	b, err := p.Serialize()
+	require.NoError(t, err)
+
+	// a v2 packet has no unsigned transaction and is rejected by v0 parsers
+	_, err = psbt.NewFromRawBytes(bytes.NewReader(b), false)
+	assert.Error(t, err)
+
+	parsed, err := NewPsbtPacketFromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), parsed.Version)
+	assert.Equal(t, uint8(PsbtInputsModifiable|PsbtOutputsModifiable), parsed.TxModifiable)
+	assert.Equal(t, p.Packet.UnsignedTx.TxHash(), parsed.Packet.UnsignedTx.TxHash())
+	assert.Equal(t, uint32(0xfffffffd), parsed.Packet.UnsignedTx.TxIn[1].Sequence)
+	assert.Equal(t, p.Packet.Inputs[0].WitnessUtxo, parsed.Packet.Inputs[0].WitnessUtxo)
+	again, err := parsed.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, b, again)
+
+	b64, err := parsed.B64Encode()
+	require.NoError(t, err)
+	fromB64, err := ParsePsbtPacket(b64)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), fromB64.Version)
+}
+
+func TestPsbtConvertVersion(t *testing.T) {
+	p := newTestPsbtPacket(t, 0)
+	p.Packet.UnsignedTx.LockTime = 800000
+	v0, err := p.Serialize()
+	require.NoError(t, err)
+
+	require.NoError(t, p.ConvertTo(2))
+	v2, err := p.Serialize()
+	require.NoError(t, err)
+	parsed, err := NewPsbtPacketFromBytes(v2)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(800000), parsed.FallbackLockTime)
+	assert.Equal(t, uint32(800000), parsed.Packet.UnsignedTx.LockTime)
+	assert.Equal(t, uint8(0), parsed.TxModifiable)
+
+	require.NoError(t, parsed.ConvertTo(0))
+	back, err := parsed.Serialize()
+	require.NoError(t, err)
+	assert.Equal(t, v0, back)
+
+	assert.ErrorIs(t, parsed.ConvertTo(1), ErrUnsupportedPsbtVersion)
+}
+
+func TestPsbtV2RequiredLockTime(t *testing.T) {
+	p := newTestPsbtPacket(t, 2)
+	p.FallbackLockTime = 100
+	require.NoError(t, p.syncLockTime())
+	assert.Equal(t, uint32(100), p.Packet.UnsignedTx.LockTime)
+
+	require.NoError(t, p.SetRequiredLockTime(0, 800000))
+	require.NoError(t, p.SetRequiredLockTime(1, 800100))
+	assert.Equal(t, uint32(800100), p.Packet.UnsignedTx.LockTime)
+
+	b, err := p.Serialize()
+	require.NoError(t, err)
+	parsed, err := NewPsbtPacketFromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{800000, 800100}, parsed.RequiredHeightLockTime)
+	assert.Equal(t, uint32(800100), parsed.Packet.UnsignedTx.LockTime)
+
+	// an input taking only a time next to one taking only a height cannot be satisfied
+	p.RequiredHeightLockTime[1] = 0
+	assert.ErrorIs(t, p.SetRequiredLockTime(1, 1700000000), ErrPsbtLockTimeConflict)
+	assert.Equal(t, uint32(0), p.RequiredTimeLockTime[1])
+
+	// once the first input also accepts a time, the time is used
+	require.NoError(t, p.SetRequiredLockTime(0, 1700000100))
+	require.NoError(t, p.SetRequiredLockTime(1, 1700000000))
+	assert.Equal(t, uint32(1700000100), p.Packet.UnsignedTx.LockTime)
+
+	v0 := newTestPsbtPacket(t, 0)
+	assert.ErrorIs(t, v0.SetRequiredLockTime(0, 800000), ErrUnsupportedPsbtVersion)
+}
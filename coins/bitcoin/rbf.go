@@ -0,0 +1,337 @@
+package bitcoin
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"sort"
+)
+
+const (
+	// DefaultIncrementalRelayFeeRate is Bitcoin Core's -incrementalrelayfee in sat/vB.
+	DefaultIncrementalRelayFeeRate = int64(1)
+	// MaxBIP125RBFSequence is the highest sequence number that still signals replaceability.
+	MaxBIP125RBFSequence = wire.MaxTxInSequenceNum - 2
+)
+
+type RbfUtxo struct {
+	TxId          string `json:"txId"`
+	VOut          uint32 `json:"vOut"`
+	Amount        int64  `json:"amount"`
+	Address       string `json:"address"`
+	PrivateKey    string `json:"privateKey"`
+	Confirmations int64  `json:"confirmations"`
+}
+
+type RbfRequest struct {
+	RawTx                   string      `json:"rawTx"`
+	PrevOutputs             PrevOutputs `json:"prevOutputs"` // prevouts of every input of RawTx
+	ExtraUtxos              []*RbfUtxo  `json:"extraUtxos"`  // candidates pulled in only when the change cannot absorb the bump
+	FeeRate                 int64       `json:"feeRate"`
+	ChangeAddress           string      `json:"changeAddress"`
+	MinChangeValue          int64       `json:"minChangeValue"`
+	IncrementalRelayFeeRate int64       `json:"incrementalRelayFeeRate"`
+	AllowFullRbf            bool        `json:"allowFullRbf"` // replace even if the original does not signal
+	Unsigned                bool        `json:"unsigned"`
+}
+
+type RbfResult struct {
+	Tx          string   `json:"tx"`
+	TxId        string   `json:"txId"`
+	Fee         int64    `json:"fee"`
+	VSize       int64    `json:"vSize"`
+	OriginalFee int64    `json:"originalFee"`
+	Signed      bool     `json:"signed"`
+	AddedInputs []string `json:"addedInputs"` // txid:vout of inputs not present in the original
+}
+
+// SignalsRBF reports whether tx opts in to replacement as described by BIP-125.
+func SignalsRBF(tx *wire.MsgTx) bool {
+	for _, in := range tx.TxIn {
+		if in.Sequence <= MaxBIP125RBFSequence {
+			return true
+		}
+	}
+	return false
+}
+
+// BumpFee builds a BIP-125 replacement of request.RawTx paying request.FeeRate.
+// The change output is shrunk first; confirmed ExtraUtxos are added only if it
+// cannot cover the higher fee. The result is signed when every input has a key.
+func BumpFee(request *RbfRequest, network *chaincfg.Params) (*RbfResult, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if request == nil || request.FeeRate <= 0 {
+		return nil, errors.New("invalid fee rate")
+	}
+	original, err := NewTxFromHex(request.RawTx)
+	if err != nil {
+		return nil, err
+	}
+	if !request.AllowFullRbf && !SignalsRBF(original) {
+		return nil, errors.New("original transaction does not signal replaceability")
+	}
+	incrementalRelayFeeRate := request.IncrementalRelayFeeRate
+	if incrementalRelayFeeRate <= 0 {
+		incrementalRelayFeeRate = DefaultIncrementalRelayFeeRate
+	}
+	minChangeValue := request.MinChangeValue
+	if minChangeValue <= 0 {
+		minChangeValue = DefaultMinChangeValue
+	}
+
+	prevOutMap := make(map[wire.OutPoint]*PrevOutput, len(request.PrevOutputs))
+	for _, v := range request.PrevOutputs {
+		h, err := chainhash.NewHashFromStr(v.TxId)
+		if err != nil {
+			return nil, err
+		}
+		prevOutMap[wire.OutPoint{Hash: *h, Index: v.VOut}] = v
+	}
+
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	var privateKeys []*btcec.PrivateKey
+	canSign := !request.Unsigned
+	hasWitness := false
+	originalIn := int64(0)
+	inputWeight := int64(0)
+	tx := wire.NewMsgTx(original.Version)
+	tx.LockTime = original.LockTime
+	for _, in := range original.TxIn {
+		prevOutput, ok := prevOutMap[in.PreviousOutPoint]
+		if !ok {
+			return nil, fmt.Errorf("missing prevout %s", in.PreviousOutPoint.String())
+		}
+		pkScript, err := AddrToPkScript(prevOutput.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := signedInputWeight(in, pkScript)
+		if err != nil {
+			return nil, err
+		}
+		inputWeight += weight
+		hasWitness = hasWitness || !txscript.IsPayToPubKeyHash(pkScript)
+		originalIn += prevOutput.Amount
+		prevOutFetcher.AddPrevOut(in.PreviousOutPoint, wire.NewTxOut(prevOutput.Amount, pkScript))
+
+		txIn := wire.NewTxIn(&in.PreviousOutPoint, nil, nil)
+		txIn.Sequence = in.Sequence
+		if txIn.Sequence > MaxBIP125RBFSequence {
+			txIn.Sequence = DefaultSequenceNum
+		}
+		tx.AddTxIn(txIn)
+		canSign, privateKeys = appendSigningKey(canSign, privateKeys, prevOutput.PrivateKey)
+	}
+
+	var changePkScript []byte
+	if len(request.ChangeAddress) > 0 {
+		changePkScript, err = AddrToPkScript(request.ChangeAddress, network)
+		if err != nil {
+			return nil, err
+		}
+	}
+	changeIndex := -1
+	originalOut := int64(0)
+	outputWeight := int64(0)
+	for i, out := range original.TxOut {
+		originalOut += out.Value
+		if changePkScript != nil && changeIndex < 0 && bytes.Equal(out.PkScript, changePkScript) {
+			changeIndex = i
+			continue
+		}
+		outputWeight += OutputWeight(out.PkScript)
+		tx.AddTxOut(wire.NewTxOut(out.Value, out.PkScript))
+	}
+	originalFee := originalIn - originalOut
+	if originalFee < 0 {
+		return nil, errors.New("invalid prevouts, inputs less than outputs")
+	}
+	originalVSize := GetTxVirtualSize(btcutil.NewTx(original))
+	if !isSigned(original) {
+		originalVSize = (int64(TxOverheadWeight(len(original.TxIn), len(original.TxOut), hasWitness)) + inputWeight + outputWeight + changeOutputWeight(changeIndex, original) + WitnessScaleFactor - 1) / WitnessScaleFactor
+	}
+	if request.FeeRate*originalVSize <= originalFee {
+		return nil, fmt.Errorf("fee rate %d does not exceed the original fee rate", request.FeeRate)
+	}
+	if changeIndex < 0 && len(request.ExtraUtxos) == 0 {
+		return nil, errors.New("no change output to reduce and no extra utxos")
+	}
+
+	// everything except the change is now fixed, only inputs may be appended
+	nonChangeOut := originalOut
+	changeWeight := int64(0)
+	if changePkScript != nil {
+		changeWeight = OutputWeight(changePkScript)
+	}
+	if changeIndex >= 0 {
+		nonChangeOut -= original.TxOut[changeIndex].Value
+	}
+	requiredFee := func(weight int64) int64 {
+		vSize := (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+		fee := vSize * request.FeeRate
+		if minFee := originalFee + vSize*incrementalRelayFeeRate; fee < minFee {
+			fee = minFee
+		}
+		return fee
+	}
+
+	extras := make([]*RbfUtxo, 0, len(request.ExtraUtxos))
+	for _, v := range request.ExtraUtxos {
+		if v.Confirmations < 1 {
+			continue
+		}
+		extras = append(extras, v)
+	}
+	sort.SliceStable(extras, func(i, j int) bool {
+		return extras[i].Amount > extras[j].Amount
+	})
+
+	var addedInputs []string
+	totalIn := originalIn
+	for next := 0; ; next++ {
+		numOutputs := len(tx.TxOut)
+		overhead := func(outputs int) int64 {
+			return int64(TxOverheadWeight(len(tx.TxIn), outputs, hasWitness))
+		}
+		weightWithChange := overhead(numOutputs+1) + inputWeight + outputWeight + changeWeight
+		weightNoChange := overhead(numOutputs) + inputWeight + outputWeight
+		if changePkScript != nil {
+			change := totalIn - nonChangeOut - requiredFee(weightWithChange)
+			if change >= minChangeValue {
+				if changeIndex >= 0 && changeIndex <= len(tx.TxOut) {
+					tx.TxOut = append(tx.TxOut[:changeIndex], append([]*wire.TxOut{wire.NewTxOut(change, changePkScript)}, tx.TxOut[changeIndex:]...)...)
+				} else {
+					tx.AddTxOut(wire.NewTxOut(change, changePkScript))
+				}
+				break
+			}
+		}
+		if totalIn-nonChangeOut >= requiredFee(weightNoChange) && len(tx.TxOut) > 0 {
+			break
+		}
+		if next >= len(extras) {
+			return nil, ErrInsufficientBalance
+		}
+		extra := extras[next]
+		h, err := chainhash.NewHashFromStr(extra.TxId)
+		if err != nil {
+			return nil, err
+		}
+		outPoint := wire.NewOutPoint(h, extra.VOut)
+		if outPoint.Hash == original.TxHash() {
+			return nil, errors.New("extra utxo spends the transaction being replaced")
+		}
+		if _, ok := prevOutMap[*outPoint]; ok {
+			continue
+		}
+		pkScript, err := AddrToPkScript(extra.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := InputWeight(pkScript)
+		if err != nil {
+			return nil, err
+		}
+		inputWeight += weight
+		hasWitness = hasWitness || !txscript.IsPayToPubKeyHash(pkScript)
+		totalIn += extra.Amount
+		prevOutFetcher.AddPrevOut(*outPoint, wire.NewTxOut(extra.Amount, pkScript))
+		txIn := wire.NewTxIn(outPoint, nil, nil)
+		txIn.Sequence = DefaultSequenceNum
+		tx.AddTxIn(txIn)
+		canSign, privateKeys = appendSigningKey(canSign, privateKeys, extra.PrivateKey)
+		addedInputs = append(addedInputs, outPoint.String())
+	}
+
+	totalOut := int64(0)
+	for _, out := range tx.TxOut {
+		totalOut += out.Value
+	}
+	if canSign {
+		if err := Sign(tx, privateKeys, prevOutFetcher); err != nil {
+			return nil, err
+		}
+	}
+	vSize := (int64(TxOverheadWeight(len(tx.TxIn), len(tx.TxOut), hasWitness)) + inputWeight + outputWeight + outputWeightOfChange(tx, changePkScript) + WitnessScaleFactor - 1) / WitnessScaleFactor
+	if canSign {
+		vSize = GetTxVirtualSize(btcutil.NewTx(tx))
+	}
+	fee := totalIn - totalOut
+	if fee <= originalFee || fee-originalFee < vSize*incrementalRelayFeeRate {
+		return nil, errors.New("replacement does not pay for its own relay bandwidth")
+	}
+	txHex, err := GetTxHex(tx)
+	if err != nil {
+		return nil, err
+	}
+	return &RbfResult{
+		Tx:          txHex,
+		TxId:        tx.TxHash().String(),
+		Fee:         fee,
+		VSize:       vSize,
+		OriginalFee: originalFee,
+		Signed:      canSign,
+		AddedInputs: addedInputs,
+	}, nil
+}
+
+func appendSigningKey(canSign bool, privateKeys []*btcec.PrivateKey, wifStr string) (bool, []*btcec.PrivateKey) {
+	if !canSign || len(wifStr) == 0 {
+		return false, privateKeys
+	}
+	wif, err := btcutil.DecodeWIF(wifStr)
+	if err != nil {
+		return false, privateKeys
+	}
+	return true, append(privateKeys, wif.PrivKey)
+}
+
+func isSigned(tx *wire.MsgTx) bool {
+	for _, in := range tx.TxIn {
+		if len(in.SignatureScript) > 0 || len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// signedInputWeight measures in when it already carries signatures and
+// otherwise estimates it from the spent script.
+func signedInputWeight(in *wire.TxIn, pkScript []byte) (int64, error) {
+	if len(in.SignatureScript) == 0 && len(in.Witness) == 0 {
+		return InputWeight(pkScript)
+	}
+	base := 32 + 4 + 4 + wire.VarIntSerializeSize(uint64(len(in.SignatureScript))) + len(in.SignatureScript)
+	witness := 1
+	if len(in.Witness) > 0 {
+		witness = in.Witness.SerializeSize()
+	}
+	return int64(base*WitnessScaleFactor + witness), nil
+}
+
+func changeOutputWeight(changeIndex int, tx *wire.MsgTx) int64 {
+	if changeIndex < 0 {
+		return 0
+	}
+	return OutputWeight(tx.TxOut[changeIndex].PkScript)
+}
+
+func outputWeightOfChange(tx *wire.MsgTx, changePkScript []byte) int64 {
+	if changePkScript == nil {
+		return 0
+	}
+	for _, out := range tx.TxOut {
+		if bytes.Equal(out.PkScript, changePkScript) {
+			return OutputWeight(changePkScript)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func verifyTxScripts(t *testing.T, txHex string, prevOutputs PrevOutputs, network *chaincfg.Params) {
+	tx, err := NewTxFromHex(txHex)
+	require.NoError(t, err)
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for _, v := range prevOutputs {
+		pkScript, err := AddrToPkScript(v.Address, network)
+		require.NoError(t, err)
+		for _, in := range tx.TxIn {
+			if in.PreviousOutPoint.Hash.String() == v.TxId && in.PreviousOutPoint.Index == v.VOut {
+				fetcher.AddPrevOut(in.PreviousOutPoint, wire.NewTxOut(v.Amount, pkScript))
+			}
+		}
+	}
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i, in := range tx.TxIn {
+		prevOut := fetcher.FetchPrevOutput(in.PreviousOutPoint)
+		require.NotNil(t, prevOut)
+		vm, err := txscript.NewEngine(prevOut.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, prevOut.Value, fetcher)
+		require.NoError(t, err)
+		require.NoError(t, vm.Execute())
+	}
+}
+
+func TestBumpFeeShrinkChange(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	prevOutputs := PrevOutputs{{
+		TxId:       "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		VOut:       0,
+		Amount:     100000,
+		Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+	}}
+	txBuild := NewTxBuild(2, network)
+	txBuild.EnableRBF()
+	txBuild.AddInput2(prevOutputs[0].TxId, prevOutputs[0].VOut, prevOutputs[0].PrivateKey, prevOutputs[0].Address, prevOutputs[0].Amount)
+	txBuild.AddOutput("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 30000)
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 69800)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	assert.True(t, SignalsRBF(tx))
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+
+	res, err := BumpFee(&RbfRequest{
+		RawTx:         txHex,
+		PrevOutputs:   prevOutputs,
+		FeeRate:       20,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+	}, network)
+	require.NoError(t, err)
+	assert.True(t, res.Signed)
+	assert.Equal(t, int64(200), res.OriginalFee)
+	assert.True(t, res.Fee >= res.VSize*20)
+	assert.True(t, res.Fee-res.OriginalFee >= res.VSize*DefaultIncrementalRelayFeeRate)
+	assert.Equal(t, 0, len(res.AddedInputs))
+
+	replacement, err := NewTxFromHex(res.Tx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(replacement.TxOut))
+	assert.Equal(t, int64(30000), replacement.TxOut[0].Value)
+	assert.Equal(t, 69800-(res.Fee-res.OriginalFee), replacement.TxOut[1].Value)
+	assert.True(t, SignalsRBF(replacement))
+	verifyTxScripts(t, res.Tx, prevOutputs, network)
+}
+
+func TestBumpFeeAddInput(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	prevOutputs := PrevOutputs{{
+		TxId:       "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		VOut:       0,
+		Amount:     10000,
+		Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+	}}
+	txBuild := NewTxBuild(2, network)
+	txBuild.AddInput2(prevOutputs[0].TxId, prevOutputs[0].VOut, prevOutputs[0].PrivateKey, prevOutputs[0].Address, prevOutputs[0].Amount)
+	txBuild.AddOutput("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 9800)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+
+	request := &RbfRequest{
+		RawTx:         txHex,
+		PrevOutputs:   prevOutputs,
+		FeeRate:       30,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		ExtraUtxos: []*RbfUtxo{{
+			TxId:       "02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1",
+			VOut:       1,
+			Amount:     50000,
+			Address:    "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+			PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		}},
+	}
+	_, err = BumpFee(request, network)
+	assert.Error(t, err)
+
+	request.AllowFullRbf = true
+	_, err = BumpFee(request, network)
+	assert.Equal(t, ErrInsufficientBalance, err)
+
+	request.ExtraUtxos[0].Confirmations = 1
+	res, err := BumpFee(request, network)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1:1"}, res.AddedInputs)
+	replacement, err := NewTxFromHex(res.Tx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(replacement.TxIn))
+	assert.Equal(t, 2, len(replacement.TxOut))
+	assert.True(t, res.Fee >= res.VSize*30)
+
+	verifyTxScripts(t, res.Tx, append(prevOutputs, &PrevOutput{
+		TxId:    request.ExtraUtxos[0].TxId,
+		VOut:    request.ExtraUtxos[0].VOut,
+		Amount:  request.ExtraUtxos[0].Amount,
+		Address: request.ExtraUtxos[0].Address,
+	}), network)
+
+	request.Unsigned = true
+	res, err = BumpFee(request, network)
+	require.NoError(t, err)
+	assert.False(t, res.Signed)
+}
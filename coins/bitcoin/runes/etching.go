@@ -0,0 +1,578 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"math/big"
+	"unicode/utf8"
+)
+
+const (
+	// RuneCommitConfirmations is the number of confirmations the commit tx of
+	// an etching needs when its reveal is mined, the reveal input carries the
+	// matching relative lock time so it cannot be mined earlier.
+	RuneCommitConfirmations = 6
+
+	subsidyHalvingInterval = 210000
+	runeUnlockInterval     = subsidyHalvingInterval / 12
+	runeUnlockSteps        = 12
+
+	runeTagContentType = 1
+	runeTagCommitment  = 13
+)
+
+var (
+	ErrInvalidEtching      = errors.New("invalid etching")
+	ErrInvalidRune         = errors.New("invalid rune")
+	ErrRuneLocked          = errors.New("rune name is not unlocked at this height")
+	ErrRuneReserved        = errors.New("rune name is reserved")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+)
+
+// runeSteps[i] is the first rune with i+1 letters.
+var runeSteps = func() []*big.Int {
+	steps := make([]*big.Int, 28)
+	steps[0] = big.NewInt(0)
+	power := big.NewInt(1)
+	for i := 1; i < len(steps); i++ {
+		power = new(big.Int).Mul(power, big.NewInt(26))
+		steps[i] = new(big.Int).Add(steps[i-1], power)
+	}
+	return steps
+}()
+
+// reservedRune is the first rune ord assigns to etchings without a name.
+var reservedRune = runeSteps[26]
+
+// FirstRuneHeight is the height from which runes can be etched on network.
+func FirstRuneHeight(network *chaincfg.Params) uint32 {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	switch network.Net {
+	case chaincfg.MainNetParams.Net:
+		return subsidyHalvingInterval * 4
+	case chaincfg.TestNet3Params.Net:
+		return subsidyHalvingInterval * 12
+	}
+	return 0
+}
+
+// MinimumRuneAtHeight returns the smallest rune that can be etched in a block
+// at height. Names of thirteen letters are open from the first rune height,
+// and one more letter is unlocked every 17500 blocks.
+func MinimumRuneAtHeight(network *chaincfg.Params, height uint32) *big.Int {
+	offset := uint64(height) + 1
+	start := uint64(FirstRuneHeight(network))
+	end := start + subsidyHalvingInterval
+	if offset < start {
+		return new(big.Int).Set(runeSteps[runeUnlockSteps])
+	}
+	if offset >= end {
+		return big.NewInt(0)
+	}
+	progress := offset - start
+	length := runeUnlockSteps - progress/runeUnlockInterval
+	last, first := runeSteps[length-1], runeSteps[length]
+	remainder := big.NewInt(int64(progress % runeUnlockInterval))
+	step := new(big.Int).Mul(new(big.Int).Sub(first, last), remainder)
+	step.Div(step, big.NewInt(runeUnlockInterval))
+	return step.Sub(first, step)
+}
+
+// CheckRuneName reports whether the spaced rune name can be etched in a block
+// at height.
+func CheckRuneName(name string, network *chaincfg.Params, height uint32) error {
+	n, _, err := ParseSpacedRune(name)
+	if err != nil {
+		return err
+	}
+	if n.Cmp(reservedRune) >= 0 {
+		return ErrRuneReserved
+	}
+	if n.Cmp(MinimumRuneAtHeight(network, height)) < 0 {
+		return ErrRuneLocked
+	}
+	return nil
+}
+
+// RuneCommitment is the little-endian rune number without trailing zeros, the
+// push the etching input tapscript must contain.
+func RuneCommitment(n *big.Int) []byte {
+	b := n.Bytes()
+	commitment := make([]byte, len(b))
+	for i := range b {
+		commitment[i] = b[len(b)-1-i]
+	}
+	return commitment
+}
+
+func parseEtchingAmount(s string) (*big.Int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.Cmp(maxU128) > 0 {
+		return nil, ErrInvalidEtching
+	}
+	return n, nil
+}
+
+func optionalHeight(n uint64) *uint64 {
+	if n == 0 {
+		return nil
+	}
+	return &n
+}
+
+// RunestoneEtching checks e and returns the etching it encodes. Limit and Term
+// fill in Amount and OffsetEnd when those are not set.
+func (e *Etching) RunestoneEtching() (*RunestoneEtching, error) {
+	if e == nil {
+		return nil, ErrInvalidEtching
+	}
+	etching := &RunestoneEtching{Turbo: e.Turbo}
+	if e.Rune != "" {
+		n, spacers, err := ParseSpacedRune(e.Rune)
+		if err != nil {
+			return nil, ErrInvalidRune
+		}
+		etching.Rune = n
+		if spacers != 0 {
+			etching.Spacers = &spacers
+		}
+	}
+	if e.Divisibility < 0 || e.Divisibility > MaxDivisibility {
+		return nil, ErrInvalidEtching
+	}
+	if e.Divisibility > 0 {
+		d := uint8(e.Divisibility)
+		etching.Divisibility = &d
+	}
+	if e.Symbol != "" {
+		if utf8.RuneCountInString(e.Symbol) != 1 {
+			return nil, ErrInvalidEtching
+		}
+		symbol, _ := utf8.DecodeRuneInString(e.Symbol)
+		etching.Symbol = &symbol
+	}
+	var err error
+	if etching.Premine, err = parseEtchingAmount(e.Premine); err != nil {
+		return nil, err
+	}
+
+	amount := e.Amount
+	if amount == "" {
+		amount = e.Limit
+	}
+	offsetEnd := e.OffsetEnd
+	if offsetEnd == 0 && e.Term > 0 {
+		offsetEnd = uint64(e.Term)
+	}
+	terms := &RuneTerms{
+		HeightStart: optionalHeight(e.HeightStart),
+		HeightEnd:   optionalHeight(e.HeightEnd),
+		OffsetStart: optionalHeight(e.OffsetStart),
+		OffsetEnd:   optionalHeight(offsetEnd),
+	}
+	if terms.Amount, err = parseEtchingAmount(amount); err != nil {
+		return nil, err
+	}
+	if terms.Cap, err = parseEtchingAmount(e.Cap); err != nil {
+		return nil, err
+	}
+	if terms.Amount != nil || terms.Cap != nil || terms.HeightStart != nil || terms.HeightEnd != nil ||
+		terms.OffsetStart != nil || terms.OffsetEnd != nil {
+		etching.Terms = terms
+	}
+	if etching.supply() == nil {
+		return nil, ErrInvalidEtching
+	}
+	return etching, nil
+}
+
+func encodeRuneField(tag, value *big.Int, payload *bytes.Buffer) {
+	EncodeToVecV2(tag, payload)
+	EncodeToVecV2(value, payload)
+}
+
+func encodeOptionalU64(tag *big.Int, value *uint64, payload *bytes.Buffer) {
+	if value != nil {
+		encodeRuneField(tag, new(big.Int).SetUint64(*value), payload)
+	}
+}
+
+// BuildEtchingOpReturnData returns the runestone output script etching e, with
+// the fields in the order ord enciphers them.
+func BuildEtchingOpReturnData(e *Etching, isDefaultOutput bool, defaultOutput int64) ([]byte, error) {
+	etching, err := e.RunestoneEtching()
+	if err != nil {
+		return nil, err
+	}
+	flags := big.NewInt(1 << FlagEtching)
+	if etching.Terms != nil {
+		flags.SetBit(flags, FlagTerms, 1)
+	}
+	if etching.Turbo {
+		flags.SetBit(flags, FlagTurbo, 1)
+	}
+
+	payload := &bytes.Buffer{}
+	encodeRuneField(TAG_Flags, flags, payload)
+	if etching.Rune != nil {
+		encodeRuneField(TAG_Rune, etching.Rune, payload)
+	}
+	if etching.Divisibility != nil {
+		encodeRuneField(TAG_Divisibility, big.NewInt(int64(*etching.Divisibility)), payload)
+	}
+	if etching.Spacers != nil {
+		encodeRuneField(TAG_Spacers, big.NewInt(int64(*etching.Spacers)), payload)
+	}
+	if etching.Symbol != nil {
+		encodeRuneField(TAG_Symbol, big.NewInt(int64(*etching.Symbol)), payload)
+	}
+	if etching.Premine != nil {
+		encodeRuneField(TAG_Premine, etching.Premine, payload)
+	}
+	if terms := etching.Terms; terms != nil {
+		if terms.Amount != nil {
+			encodeRuneField(TAG_Amount, terms.Amount, payload)
+		}
+		if terms.Cap != nil {
+			encodeRuneField(TAG_Cap, terms.Cap, payload)
+		}
+		encodeOptionalU64(TAG_HeightStart, terms.HeightStart, payload)
+		encodeOptionalU64(TAG_HeightEnd, terms.HeightEnd, payload)
+		encodeOptionalU64(TAG_OffsetStart, terms.OffsetStart, payload)
+		encodeOptionalU64(TAG_OffsetEnd, terms.OffsetEnd, payload)
+	}
+	if isDefaultOutput {
+		encodeRuneField(TAG_Pointer, big.NewInt(defaultOutput), payload)
+	}
+
+	data := payload.Bytes()
+	if len(data) > 80 {
+		return nil, errors.New("The script is too long")
+	}
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddOp(txscript.OP_13).AddData(data).Script()
+}
+
+type RuneEtchingRequest struct {
+	CommitTxPrevOutputList PrevOutputs `json:"commitTxPrevOutputList"`
+	CommitFeeRate          int64       `json:"commitFeeRate"`
+	RevealFeeRate          int64       `json:"revealFeeRate"`
+	Etching                *Etching    `json:"etching"`
+	// RevealAddr receives the premine and the optional inscription.
+	RevealAddr     string `json:"revealAddr"`
+	RevealOutValue int64  `json:"revealOutValue"`
+	ChangeAddress  string `json:"changeAddress"`
+	MinChangeValue int64  `json:"minChangeValue"`
+	ContentType    string `json:"contentType,omitempty"`
+	Body           []byte `json:"body,omitempty"`
+	// RevealHeight is the height the reveal is expected to be mined at, when
+	// set the rune name is checked against the minimum length schedule.
+	RevealHeight uint32 `json:"revealHeight,omitempty"`
+}
+
+// RuneEtchingBuilder builds the commit tx paying to a tapscript that pushes the
+// rune commitment and the reveal tx that spends it with the runestone.
+type RuneEtchingBuilder struct {
+	Network                   *chaincfg.Params
+	PrivateKey                *btcec.PrivateKey
+	CommitTxPrevOutputFetcher *txscript.MultiPrevOutFetcher
+	CommitTxPrivateKeyList    []*btcec.PrivateKey
+	EtchingScript             []byte
+	ControlBlockWitness       []byte
+	CommitTxAddress           string
+	CommitTxAddressPkScript   []byte
+	RevealTxPrevOutput        *wire.TxOut
+	CommitTx                  *wire.MsgTx
+	RevealTx                  *wire.MsgTx
+	MustCommitTxFee           int64
+	MustRevealTxFee           int64
+}
+
+type RuneEtchingTxs struct {
+	CommitTx    string `json:"commitTx"`
+	RevealTx    string `json:"revealTx"`
+	CommitTxFee int64  `json:"commitTxFee"`
+	RevealTxFee int64  `json:"revealTxFee"`
+	CommitAddr  string `json:"commitAddr"`
+}
+
+func NewRuneEtchingTool(network *chaincfg.Params, request *RuneEtchingRequest) (*RuneEtchingBuilder, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if request.Etching == nil || request.Etching.Rune == "" {
+		return nil, ErrInvalidRune
+	}
+	if len(request.CommitTxPrevOutputList) == 0 {
+		return nil, errors.New("no commit tx inputs")
+	}
+	if request.RevealHeight > 0 {
+		if err := CheckRuneName(request.Etching.Rune, network, request.RevealHeight); err != nil {
+			return nil, err
+		}
+	}
+	builder := &RuneEtchingBuilder{
+		Network:                   network,
+		CommitTxPrevOutputFetcher: txscript.NewMultiPrevOutFetcher(nil),
+	}
+	for _, prevOutput := range request.CommitTxPrevOutputList {
+		privateKeyWif, err := btcutil.DecodeWIF(prevOutput.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		builder.CommitTxPrivateKeyList = append(builder.CommitTxPrivateKeyList, privateKeyWif.PrivKey)
+	}
+	builder.PrivateKey = builder.CommitTxPrivateKeyList[0]
+	return builder, builder.initTool(request)
+}
+
+func (builder *RuneEtchingBuilder) initTool(request *RuneEtchingRequest) error {
+	revealOutValue := DefaultRevealOutValue
+	if request.RevealOutValue > 0 {
+		revealOutValue = request.RevealOutValue
+	}
+	minChangeValue := DefaultMinChangeValue
+	if request.MinChangeValue > 0 {
+		minChangeValue = request.MinChangeValue
+	}
+	if err := builder.buildEtchingScript(request); err != nil {
+		return err
+	}
+	if err := builder.buildEmptyRevealTx(request, revealOutValue); err != nil {
+		return err
+	}
+	if err := builder.buildCommitTx(request.CommitTxPrevOutputList, request.ChangeAddress, request.CommitFeeRate, minChangeValue); err != nil {
+		return err
+	}
+	if err := Sign(builder.CommitTx, builder.CommitTxPrivateKeyList, builder.CommitTxPrevOutputFetcher); err != nil {
+		return errors.New("sign commit tx error")
+	}
+	return builder.completeRevealTx()
+}
+
+// buildEtchingScript builds <key> OP_CHECKSIG followed by an ord envelope with
+// the rune commitment and the optional inscription.
+func (builder *RuneEtchingBuilder) buildEtchingScript(request *RuneEtchingRequest) error {
+	n, _, err := ParseSpacedRune(request.Etching.Rune)
+	if err != nil {
+		return ErrInvalidRune
+	}
+	commitment := RuneCommitment(n)
+	// pushed as data even when a small integer opcode would be shorter, ord
+	// only counts data pushes as a commitment
+	scriptBuilder := txscript.NewScriptBuilder().
+		AddData(schnorr.SerializePubKey(builder.PrivateKey.PubKey())).
+		AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_FALSE).
+		AddOp(txscript.OP_IF).
+		AddData([]byte("ord")).
+		AddOps([]byte{txscript.OP_DATA_1, runeTagCommitment, byte(len(commitment))}).
+		AddOps(commitment)
+	if request.ContentType != "" {
+		scriptBuilder.AddOps([]byte{txscript.OP_DATA_1, runeTagContentType}).AddData([]byte(request.ContentType))
+	}
+	if len(request.Body) > 0 {
+		scriptBuilder.AddOp(txscript.OP_0)
+		for i := 0; i < len(request.Body); i += txscript.MaxScriptElementSize {
+			end := i + txscript.MaxScriptElementSize
+			if end > len(request.Body) {
+				end = len(request.Body)
+			}
+			scriptBuilder.AddFullData(request.Body[i:end])
+		}
+	}
+	script, err := scriptBuilder.AddOp(txscript.OP_ENDIF).Script()
+	if err != nil {
+		return err
+	}
+
+	pubKey := hex.EncodeToString(builder.PrivateKey.PubKey().SerializeCompressed())
+	tree, err := bitcoin.NewTaprootScriptTree(pubKey, []*bitcoin.TapLeafSpec{{Script: hex.EncodeToString(script)}})
+	if err != nil {
+		return err
+	}
+	builder.ControlBlockWitness, err = tree.ControlBlock(0)
+	if err != nil {
+		return err
+	}
+	builder.CommitTxAddress, err = tree.Address(builder.Network)
+	if err != nil {
+		return err
+	}
+	builder.CommitTxAddressPkScript, err = bitcoin.AddrToPkScript(builder.CommitTxAddress, builder.Network)
+	if err != nil {
+		return err
+	}
+	builder.EtchingScript = script
+	return nil
+}
+
+func (builder *RuneEtchingBuilder) buildEmptyRevealTx(request *RuneEtchingRequest, revealOutValue int64) error {
+	etching, err := request.Etching.RunestoneEtching()
+	if err != nil {
+		return err
+	}
+	runestone, err := BuildEtchingOpReturnData(request.Etching, etching.Premine != nil && etching.Premine.Sign() > 0, 0)
+	if err != nil {
+		return err
+	}
+	pkScript, err := bitcoin.AddrToPkScript(request.RevealAddr, builder.Network)
+	if err != nil {
+		return err
+	}
+
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	in := wire.NewTxIn(&wire.OutPoint{}, nil, nil)
+	// a relative lock time keeps the reveal out of the blocks before the
+	// commitment matures
+	in.Sequence = RuneCommitConfirmations - 1
+	tx.AddTxIn(in)
+	tx.AddTxOut(wire.NewTxOut(revealOutValue, pkScript))
+	tx.AddTxOut(wire.NewTxOut(0, runestone))
+
+	emptySignature := make([]byte, 64)
+	in.Witness = wire.TxWitness{emptySignature, builder.EtchingScript, builder.ControlBlockWitness}
+	fee := bitcoin.GetTxVirtualSize(btcutil.NewTx(tx)) * request.RevealFeeRate
+	in.Witness = nil
+
+	builder.MustRevealTxFee = fee
+	builder.RevealTxPrevOutput = wire.NewTxOut(revealOutValue+fee, builder.CommitTxAddressPkScript)
+	builder.RevealTx = tx
+	return nil
+}
+
+func (builder *RuneEtchingBuilder) buildCommitTx(commitTxPrevOutputList PrevOutputs, changeAddress string, commitFeeRate int64, minChangeValue int64) error {
+	totalSenderAmount := btcutil.Amount(0)
+	tx := wire.NewMsgTx(DefaultTxVersion)
+	changePkScript, err := bitcoin.AddrToPkScript(changeAddress, builder.Network)
+	if err != nil {
+		return err
+	}
+	for _, prevOutput := range commitTxPrevOutputList {
+		txHash, err := chainhash.NewHashFromStr(prevOutput.TxId)
+		if err != nil {
+			return err
+		}
+		outPoint := wire.NewOutPoint(txHash, prevOutput.VOut)
+		pkScript, err := bitcoin.AddrToPkScript(prevOutput.Address, builder.Network)
+		if err != nil {
+			return err
+		}
+		builder.CommitTxPrevOutputFetcher.AddPrevOut(*outPoint, wire.NewTxOut(prevOutput.Amount, pkScript))
+
+		in := wire.NewTxIn(outPoint, nil, nil)
+		in.Sequence = DefaultSequenceNum
+		tx.AddTxIn(in)
+		totalSenderAmount += btcutil.Amount(prevOutput.Amount)
+	}
+	tx.AddTxOut(builder.RevealTxPrevOutput)
+	tx.AddTxOut(wire.NewTxOut(0, changePkScript))
+
+	txForEstimate := wire.NewMsgTx(DefaultTxVersion)
+	txForEstimate.TxIn = tx.TxIn
+	txForEstimate.TxOut = tx.TxOut
+	if err = Sign(txForEstimate, builder.CommitTxPrivateKeyList, builder.CommitTxPrevOutputFetcher); err != nil {
+		return err
+	}
+
+	view, _ := commitTxPrevOutputList.UtxoViewpoint(builder.Network)
+	fee := btcutil.Amount(bitcoin.GetTxVirtualSizeByView(btcutil.NewTx(txForEstimate), view)) * btcutil.Amount(commitFeeRate)
+	changeAmount := totalSenderAmount - btcutil.Amount(builder.RevealTxPrevOutput.Value) - fee
+	if int64(changeAmount) >= minChangeValue {
+		tx.TxOut[len(tx.TxOut)-1].Value = int64(changeAmount)
+	} else {
+		tx.TxOut = tx.TxOut[:len(tx.TxOut)-1]
+		if changeAmount < 0 {
+			txForEstimate.TxOut = txForEstimate.TxOut[:len(txForEstimate.TxOut)-1]
+			feeWithoutChange := btcutil.Amount(bitcoin.GetTxVirtualSizeByView(btcutil.NewTx(txForEstimate), view)) * btcutil.Amount(commitFeeRate)
+			if totalSenderAmount-btcutil.Amount(builder.RevealTxPrevOutput.Value)-feeWithoutChange < 0 {
+				builder.MustCommitTxFee = int64(fee)
+				return ErrInsufficientBalance
+			}
+		}
+	}
+	builder.CommitTx = tx
+	return nil
+}
+
+func (builder *RuneEtchingBuilder) completeRevealTx() error {
+	revealTx := builder.RevealTx
+	revealTx.TxIn[0].PreviousOutPoint = wire.OutPoint{Hash: builder.CommitTx.TxHash(), Index: 0}
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(builder.RevealTxPrevOutput.PkScript, builder.RevealTxPrevOutput.Value)
+	sigHash, err := txscript.CalcTapscriptSignaturehash(txscript.NewTxSigHashes(revealTx, prevOutFetcher),
+		txscript.SigHashDefault, revealTx, 0, prevOutFetcher, txscript.NewBaseTapLeaf(builder.EtchingScript))
+	if err != nil {
+		return err
+	}
+	signature, err := schnorr.Sign(builder.PrivateKey, sigHash)
+	if err != nil {
+		return err
+	}
+	revealTx.TxIn[0].Witness = wire.TxWitness{signature.Serialize(), builder.EtchingScript, builder.ControlBlockWitness}
+	if weight := bitcoin.GetTransactionWeight(btcutil.NewTx(revealTx)); weight > MaxStandardTxWeight {
+		return fmt.Errorf("reveal transaction weight greater than %d (MAX_STANDARD_TX_WEIGHT): %d", MaxStandardTxWeight, weight)
+	}
+	return nil
+}
+
+func (builder *RuneEtchingBuilder) CalculateFee() (int64, int64) {
+	commitTxFee := int64(0)
+	for _, in := range builder.CommitTx.TxIn {
+		commitTxFee += builder.CommitTxPrevOutputFetcher.FetchPrevOutput(in.PreviousOutPoint).Value
+	}
+	for _, out := range builder.CommitTx.TxOut {
+		commitTxFee -= out.Value
+	}
+	revealTxFee := builder.RevealTxPrevOutput.Value
+	for _, out := range builder.RevealTx.TxOut {
+		revealTxFee -= out.Value
+	}
+	return commitTxFee, revealTxFee
+}
+
+// EtchRune builds and signs the commit and reveal txs of an etching. The reveal
+// can only be mined once the commit has RuneCommitConfirmations-1
+// confirmations. With insufficient balance only the fees are returned.
+func EtchRune(network *chaincfg.Params, request *RuneEtchingRequest) (*RuneEtchingTxs, error) {
+	tool, err := NewRuneEtchingTool(network, request)
+	if errors.Is(err, ErrInsufficientBalance) {
+		return &RuneEtchingTxs{
+			CommitTxFee: tool.MustCommitTxFee,
+			RevealTxFee: tool.MustRevealTxFee,
+			CommitAddr:  tool.CommitTxAddress,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	commitTx, err := bitcoin.GetTxHex(tool.CommitTx)
+	if err != nil {
+		return nil, err
+	}
+	revealTx, err := bitcoin.GetTxHex(tool.RevealTx)
+	if err != nil {
+		return nil, err
+	}
+	commitTxFee, revealTxFee := tool.CalculateFee()
+	return &RuneEtchingTxs{
+		CommitTx:    commitTx,
+		RevealTx:    revealTx,
+		CommitTxFee: commitTxFee,
+		RevealTxFee: revealTxFee,
+		CommitAddr:  tool.CommitTxAddress,
+	}, nil
+}
@@ -0,0 +1,165 @@
+package bitcoin
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestBuildEtchingOpReturnData(t *testing.T) {
+	etching := &Etching{
+		Rune:         "UNCOMMON•GOODS",
+		Divisibility: 2,
+		Symbol:       "⧉",
+		Premine:      "1000",
+		Amount:       "1",
+		Cap:          "340282366920938463463374607431768210455",
+		HeightStart:  840000,
+		HeightEnd:    1050000,
+		OffsetEnd:    10,
+		Turbo:        true,
+	}
+	script, err := BuildEtchingOpReturnData(etching, true, 0)
+	require.NoError(t, err)
+	tx := wire.NewMsgTx(2)
+	tx.AddTxOut(wire.NewTxOut(546, []byte{txscript.OP_1}))
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	runestone := DecipherRunestone(tx)
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph, runestone.Flaws)
+	decoded := runestone.Etching
+	assert.Equal(t, "UNCOMMON•GOODS", decoded.SpacedRune())
+	assert.Equal(t, uint8(2), *decoded.Divisibility)
+	assert.Equal(t, '⧉', *decoded.Symbol)
+	assert.Equal(t, "1000", decoded.Premine.String())
+	assert.Equal(t, etching.Cap, decoded.Terms.Cap.String())
+	assert.Equal(t, uint64(840000), *decoded.Terms.HeightStart)
+	assert.Equal(t, uint64(1050000), *decoded.Terms.HeightEnd)
+	assert.Equal(t, uint64(10), *decoded.Terms.OffsetEnd)
+	assert.True(t, decoded.Turbo)
+	assert.Equal(t, uint32(0), *runestone.Pointer)
+
+	// the legacy fields map onto amount and offset end
+	script, err = BuildEtchingOpReturnData(&Etching{Rune: "AAAAAAAAAAAAAB", Limit: "500", Term: 100}, false, 0)
+	require.NoError(t, err)
+	tx.TxOut[1].PkScript = script
+	runestone = DecipherRunestone(tx)
+	require.NotNil(t, runestone)
+	assert.Equal(t, "500", runestone.Etching.Terms.Amount.String())
+	assert.Equal(t, uint64(100), *runestone.Etching.Terms.OffsetEnd)
+	assert.Nil(t, runestone.Etching.Symbol)
+
+	for _, e := range []*Etching{
+		{Rune: "A•"},
+		{Rune: "ABC", Divisibility: 39},
+		{Rune: "ABC", Symbol: "AB"},
+		{Rune: "ABC", Premine: "1", Cap: "340282366920938463463374607431768211455", Amount: "1"},
+	} {
+		_, err := BuildEtchingOpReturnData(e, false, 0)
+		assert.Error(t, err, e.Rune)
+	}
+}
+
+func TestMinimumRuneAtHeight(t *testing.T) {
+	network := &chaincfg.MainNetParams
+	for _, test := range []struct {
+		height uint32
+		rune   string
+	}{
+		{0, "AAAAAAAAAAAAA"},
+		{839999, "AAAAAAAAAAAAA"},
+		{840000, "ZZYZXBRKWXVA"},
+		{840000 + 17500 - 1, "AAAAAAAAAAAA"},
+		{840000 + 17500*11 - 1, "AA"},
+		{840000 + 17500*12 - 2, "B"},
+		{840000 + 17500*12 - 1, "A"},
+		{2000000, "A"},
+	} {
+		assert.Equal(t, test.rune, RuneName(MinimumRuneAtHeight(network, test.height)), test.height)
+	}
+	assert.Equal(t, "AAAAAAAAAAAAA", RuneName(MinimumRuneAtHeight(&chaincfg.TestNet3Params, 2519998)))
+	assert.Equal(t, "A", RuneName(MinimumRuneAtHeight(&chaincfg.RegressionNetParams, 210000)))
+
+	assert.NoError(t, CheckRuneName("UNCOMMON•GOODS", network, 840000))
+	assert.ErrorIs(t, CheckRuneName("UNCOMMONGOOD", network, 840000), ErrRuneLocked)
+	assert.ErrorIs(t, CheckRuneName("AAAAAAAAAAAAAAAAAAAAAAAAAAA", network, 2000000), ErrRuneReserved)
+	assert.Equal(t, []byte{0x1f, 0x8e}, RuneCommitment(big.NewInt(0x8e1f)))
+}
+
+func TestEtchRune(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	request := &RuneEtchingRequest{
+		CommitTxPrevOutputList: PrevOutputs{{
+			TxId:       "453aa6dd39f31f06cd50b72a8683b8c0402ab36f889d96696317503a025a21b5",
+			VOut:       0,
+			Amount:     100000,
+			Address:    "tb1pmye4w4txqsrddyguc5x6z2h5qkms0u38r6y90m8us53h4ndkwprst34fnw",
+			PrivateKey: "cSWVEyJPTXLcNdEyAKzngz3diBXXEhAZHUyURzv2JsuUohopZkdE",
+		}},
+		CommitFeeRate: 2,
+		RevealFeeRate: 2,
+		Etching:       &Etching{Rune: "UNCOMMON•GOODS", Premine: "100", Amount: "1", Cap: "1000", Symbol: "⧉"},
+		RevealAddr:    "tb1pmye4w4txqsrddyguc5x6z2h5qkms0u38r6y90m8us53h4ndkwprst34fnw",
+		ChangeAddress: "tb1pmye4w4txqsrddyguc5x6z2h5qkms0u38r6y90m8us53h4ndkwprst34fnw",
+		ContentType:   "text/plain;charset=utf-8",
+		Body:          []byte("UNCOMMON•GOODS"),
+		RevealHeight:  2500000,
+	}
+	txs, err := EtchRune(network, request)
+	require.NoError(t, err)
+
+	commitTx, err := bitcoin.NewTxFromHex(txs.CommitTx)
+	require.NoError(t, err)
+	revealTx, err := bitcoin.NewTxFromHex(txs.RevealTx)
+	require.NoError(t, err)
+	assert.Equal(t, commitTx.TxHash(), revealTx.TxIn[0].PreviousOutPoint.Hash)
+	assert.Equal(t, uint32(RuneCommitConfirmations-1), revealTx.TxIn[0].Sequence)
+	assert.Equal(t, int64(100000), commitTx.TxOut[0].Value+commitTx.TxOut[1].Value+txs.CommitTxFee)
+	assert.Equal(t, commitTx.TxOut[0].Value, revealTx.TxOut[0].Value+txs.RevealTxFee)
+
+	verifyEtchingInput(t, commitTx, 0, request.CommitTxPrevOutputList[0].Amount, request.CommitTxPrevOutputList[0].Address, network)
+	verifyEtchingInput(t, revealTx, 0, commitTx.TxOut[0].Value, txs.CommitAddr, network)
+
+	// the tapscript pushes the commitment and the runestone etches the rune
+	n, _, err := ParseSpacedRune("UNCOMMONGOODS")
+	require.NoError(t, err)
+	tapscript := revealTx.TxIn[0].Witness[1]
+	tokenizer := txscript.MakeScriptTokenizer(0, tapscript)
+	committed := false
+	for tokenizer.Next() {
+		committed = committed || bytes.Equal(tokenizer.Data(), RuneCommitment(n))
+	}
+	assert.True(t, committed)
+	runestone := DecipherRunestone(revealTx)
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph)
+	assert.Equal(t, "UNCOMMON•GOODS", runestone.Etching.SpacedRune())
+	assert.Equal(t, uint32(0), *runestone.Pointer)
+
+	request.Etching.Rune = "SHORT"
+	_, err = EtchRune(network, request)
+	assert.ErrorIs(t, err, ErrRuneLocked)
+
+	request.Etching.Rune = "UNCOMMON•GOODS"
+	request.CommitTxPrevOutputList[0].Amount = 1000
+	txs, err = EtchRune(network, request)
+	require.NoError(t, err)
+	assert.Empty(t, txs.CommitTx)
+	assert.True(t, txs.CommitTxFee > 0)
+}
+
+func verifyEtchingInput(t *testing.T, tx *wire.MsgTx, i int, amount int64, address string, network *chaincfg.Params) {
+	pkScript, err := bitcoin.AddrToPkScript(address, network)
+	require.NoError(t, err)
+	prevOutFetcher := txscript.NewCannedPrevOutputFetcher(pkScript, amount)
+	vm, err := txscript.NewEngine(pkScript, tx, i, txscript.StandardVerifyFlags, nil,
+		txscript.NewTxSigHashes(tx, prevOutFetcher), amount, prevOutFetcher)
+	require.NoError(t, err)
+	require.NoError(t, vm.Execute())
+}
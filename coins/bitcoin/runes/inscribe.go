@@ -30,7 +30,7 @@ var (
 	TAG_HeightStart  = big.NewInt(12)
 	TAG_HeightEnd    = big.NewInt(14)
 	TAG_OffsetStart  = big.NewInt(16)
-	TAG_OffsetEnd    = big.NewInt(118)
+	TAG_OffsetEnd    = big.NewInt(18)
 	TAG_Mint         = big.NewInt(20)
 	TAG_Pointer      = big.NewInt(22)
 	TAG_Cenotaph     = big.NewInt(126)
@@ -153,11 +153,23 @@ func (s RealEdicts) Len() int {
 }
 
 type Etching struct {
-	Divisibility int64  `json:"divisibility"`
-	Limit        string `json:"limit"`
-	Rune         string `json:"rune"`
-	Symbol       string `json:"symbol"`
-	Term         int64  `json:"term"`
+	Divisibility int64 `json:"divisibility"`
+	// Limit is the amount of a mint under the first runes protocol, use Amount.
+	Limit string `json:"limit"`
+	// Rune is the rune name, letters may be separated by • or . spacers.
+	Rune   string `json:"rune"`
+	Symbol string `json:"symbol"`
+	// Term is the number of blocks minting is open under the first runes
+	// protocol, use OffsetEnd.
+	Term        int64  `json:"term"`
+	Premine     string `json:"premine,omitempty"`
+	Cap         string `json:"cap,omitempty"`
+	Amount      string `json:"amount,omitempty"`
+	HeightStart uint64 `json:"heightStart,omitempty"`
+	HeightEnd   uint64 `json:"heightEnd,omitempty"`
+	OffsetStart uint64 `json:"offsetStart,omitempty"`
+	OffsetEnd   uint64 `json:"offsetEnd,omitempty"`
+	Turbo       bool   `json:"turbo,omitempty"`
 }
 
 type PrevOutput struct {
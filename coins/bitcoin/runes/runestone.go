@@ -0,0 +1,505 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+)
+
+// Runestone flaws, a runestone with any of them is a cenotaph. The names are
+// those of ord.
+const (
+	FlawEdictOutput         = "EdictOutput"
+	FlawEdictRuneId         = "EdictRuneId"
+	FlawInvalidScript       = "InvalidScript"
+	FlawOpcode              = "Opcode"
+	FlawSupplyOverflow      = "SupplyOverflow"
+	FlawTrailingIntegers    = "TrailingIntegers"
+	FlawTruncatedField      = "TruncatedField"
+	FlawUnrecognizedEvenTag = "UnrecognizedEvenTag"
+	FlawUnrecognizedFlag    = "UnrecognizedFlag"
+	FlawVarint              = "Varint"
+)
+
+const (
+	FlagEtching = 0
+	FlagTerms   = 1
+	FlagTurbo   = 2
+
+	MaxDivisibility = 38
+	MaxSpacers      = 0b00000111_11111111_11111111_11111111
+)
+
+var (
+	ErrNoRunestone = errors.New("transaction has no runestone")
+
+	maxU64  = new(big.Int).SetUint64(^uint64(0))
+	maxU32  = big.NewInt(int64(^uint32(0)))
+	maxU128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+)
+
+type RuneId struct {
+	Block uint64 `json:"block"`
+	Tx    uint32 `json:"tx"`
+}
+
+func (id RuneId) String() string {
+	return fmt.Sprintf("%d:%d", id.Block, id.Tx)
+}
+
+// next applies the delta encoding of edict ids: the tx is relative only
+// within the same block.
+func (id RuneId) next(block, tx *big.Int) (RuneId, bool) {
+	if block.Cmp(maxU64) > 0 || tx.Cmp(maxU32) > 0 {
+		return RuneId{}, false
+	}
+	b, t := block.Uint64(), uint32(tx.Uint64())
+	if id.Block+b < id.Block {
+		return RuneId{}, false
+	}
+	next := RuneId{Block: id.Block + b, Tx: t}
+	if b == 0 {
+		if id.Tx+t < id.Tx {
+			return RuneId{}, false
+		}
+		next.Tx = id.Tx + t
+	}
+	if next.Block == 0 && next.Tx > 0 {
+		return RuneId{}, false
+	}
+	return next, true
+}
+
+type RunestoneEdict struct {
+	Id     RuneId   `json:"id"`
+	Amount *big.Int `json:"amount"`
+	Output uint32   `json:"output"`
+}
+
+type RuneTerms struct {
+	Amount      *big.Int `json:"amount,omitempty"`
+	Cap         *big.Int `json:"cap,omitempty"`
+	HeightStart *uint64  `json:"heightStart,omitempty"`
+	HeightEnd   *uint64  `json:"heightEnd,omitempty"`
+	OffsetStart *uint64  `json:"offsetStart,omitempty"`
+	OffsetEnd   *uint64  `json:"offsetEnd,omitempty"`
+}
+
+type RunestoneEtching struct {
+	Divisibility *uint8     `json:"divisibility,omitempty"`
+	Premine      *big.Int   `json:"premine,omitempty"`
+	Rune         *big.Int   `json:"rune,omitempty"`
+	Spacers      *uint32    `json:"spacers,omitempty"`
+	Symbol       *rune      `json:"symbol,omitempty"`
+	Terms        *RuneTerms `json:"terms,omitempty"`
+	Turbo        bool       `json:"turbo"`
+}
+
+// SpacedRune returns the etched rune name with its spacers, empty when the
+// etching does not name its rune.
+func (e *RunestoneEtching) SpacedRune() string {
+	if e == nil || e.Rune == nil {
+		return ""
+	}
+	spacers := uint32(0)
+	if e.Spacers != nil {
+		spacers = *e.Spacers
+	}
+	return SpacedRuneName(e.Rune, spacers)
+}
+
+// supply is premine + cap * amount, nil when it does not fit 128 bits.
+func (e *RunestoneEtching) supply() *big.Int {
+	supply := new(big.Int)
+	if e.Premine != nil {
+		supply.Set(e.Premine)
+	}
+	if e.Terms != nil && e.Terms.Cap != nil && e.Terms.Amount != nil {
+		supply.Add(supply, new(big.Int).Mul(e.Terms.Cap, e.Terms.Amount))
+	}
+	if supply.Cmp(maxU128) > 0 {
+		return nil
+	}
+	return supply
+}
+
+// Runestone is a deciphered runestone. A cenotaph keeps only the etched rune
+// and the mint, ord burns the runes of its inputs.
+type Runestone struct {
+	Edicts   []*RunestoneEdict `json:"edicts"`
+	Etching  *RunestoneEtching `json:"etching,omitempty"`
+	Mint     *RuneId           `json:"mint,omitempty"`
+	Pointer  *uint32           `json:"pointer,omitempty"`
+	Cenotaph bool              `json:"cenotaph"`
+	Flaws    []string          `json:"flaws,omitempty"`
+}
+
+func DecipherRunestoneFromHex(txHex string) (*Runestone, error) {
+	tx, err := bitcoin.NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	runestone := DecipherRunestone(tx)
+	if runestone == nil {
+		return nil, ErrNoRunestone
+	}
+	return runestone, nil
+}
+
+// DecipherRunestone decodes the runestone of tx following ord, it returns nil
+// when no output starts with OP_RETURN OP_13.
+func DecipherRunestone(tx *wire.MsgTx) *Runestone {
+	payload, flaw, ok := runestonePayload(tx)
+	if !ok {
+		return nil
+	}
+	if flaw != "" {
+		return &Runestone{Cenotaph: true, Flaws: []string{flaw}}
+	}
+	integers, err := decodeRuneVarints(payload)
+	if err != nil {
+		return &Runestone{Cenotaph: true, Flaws: []string{FlawVarint}}
+	}
+
+	runestone := &Runestone{}
+	var flaws []string
+	fields := make(map[uint64][]*big.Int)
+	var tags []*big.Int
+	for i := 0; i < len(integers); i += 2 {
+		tag := integers[i]
+		if tag.Sign() == 0 {
+			id := RuneId{}
+			for chunk := integers[i+1:]; len(chunk) > 0; chunk = chunk[4:] {
+				if len(chunk) < 4 {
+					flaws = append(flaws, FlawTrailingIntegers)
+					break
+				}
+				next, ok := id.next(chunk[0], chunk[1])
+				if !ok {
+					flaws = append(flaws, FlawEdictRuneId)
+					break
+				}
+				if chunk[3].Cmp(maxU32) > 0 || chunk[3].Uint64() > uint64(len(tx.TxOut)) {
+					flaws = append(flaws, FlawEdictOutput)
+					break
+				}
+				id = next
+				runestone.Edicts = append(runestone.Edicts, &RunestoneEdict{Id: next, Amount: chunk[2], Output: uint32(chunk[3].Uint64())})
+			}
+			break
+		}
+		if i+1 >= len(integers) {
+			flaws = append(flaws, FlawTruncatedField)
+			break
+		}
+		if !tag.IsUint64() {
+			// a tag this large can never be recognised, only its parity matters
+			tags = append(tags, tag)
+			continue
+		}
+		fields[tag.Uint64()] = append(fields[tag.Uint64()], integers[i+1])
+	}
+
+	take := func(tag *big.Int, n int, with func([]*big.Int) bool) bool {
+		values := fields[tag.Uint64()]
+		if len(values) < n || !with(values[:n]) {
+			return false
+		}
+		if fields[tag.Uint64()] = values[n:]; len(fields[tag.Uint64()]) == 0 {
+			delete(fields, tag.Uint64())
+		}
+		return true
+	}
+	toU64 := func(v *big.Int) (*uint64, bool) {
+		if !v.IsUint64() {
+			return nil, false
+		}
+		n := v.Uint64()
+		return &n, true
+	}
+
+	flags := new(big.Int)
+	take(TAG_Flags, 1, func(v []*big.Int) bool {
+		flags.Set(v[0])
+		return true
+	})
+	takeFlag := func(flag int) bool {
+		set := flags.Bit(flag) == 1
+		flags.SetBit(flags, flag, 0)
+		return set
+	}
+
+	if takeFlag(FlagEtching) {
+		etching := &RunestoneEtching{}
+		take(TAG_Divisibility, 1, func(v []*big.Int) bool {
+			if v[0].Cmp(big.NewInt(MaxDivisibility)) > 0 {
+				return false
+			}
+			d := uint8(v[0].Uint64())
+			etching.Divisibility = &d
+			return true
+		})
+		take(TAG_Premine, 1, func(v []*big.Int) bool {
+			etching.Premine = v[0]
+			return true
+		})
+		take(TAG_Rune, 1, func(v []*big.Int) bool {
+			etching.Rune = v[0]
+			return true
+		})
+		take(TAG_Spacers, 1, func(v []*big.Int) bool {
+			if v[0].Cmp(big.NewInt(MaxSpacers)) > 0 {
+				return false
+			}
+			s := uint32(v[0].Uint64())
+			etching.Spacers = &s
+			return true
+		})
+		take(TAG_Symbol, 1, func(v []*big.Int) bool {
+			if v[0].Cmp(maxU32) > 0 || !isRuneChar(v[0].Uint64()) {
+				return false
+			}
+			s := rune(v[0].Uint64())
+			etching.Symbol = &s
+			return true
+		})
+		if takeFlag(FlagTerms) {
+			terms := &RuneTerms{}
+			take(TAG_Cap, 1, func(v []*big.Int) bool {
+				terms.Cap = v[0]
+				return true
+			})
+			take(TAG_HeightStart, 1, func(v []*big.Int) (ok bool) {
+				terms.HeightStart, ok = toU64(v[0])
+				return
+			})
+			take(TAG_HeightEnd, 1, func(v []*big.Int) (ok bool) {
+				terms.HeightEnd, ok = toU64(v[0])
+				return
+			})
+			take(TAG_Amount, 1, func(v []*big.Int) bool {
+				terms.Amount = v[0]
+				return true
+			})
+			take(TAG_OffsetStart, 1, func(v []*big.Int) (ok bool) {
+				terms.OffsetStart, ok = toU64(v[0])
+				return
+			})
+			take(TAG_OffsetEnd, 1, func(v []*big.Int) (ok bool) {
+				terms.OffsetEnd, ok = toU64(v[0])
+				return
+			})
+			etching.Terms = terms
+		}
+		etching.Turbo = takeFlag(FlagTurbo)
+		runestone.Etching = etching
+	}
+
+	take(TAG_Mint, 2, func(v []*big.Int) bool {
+		if v[0].Cmp(maxU64) > 0 || v[1].Cmp(maxU32) > 0 {
+			return false
+		}
+		id := RuneId{Block: v[0].Uint64(), Tx: uint32(v[1].Uint64())}
+		if id.Block == 0 && id.Tx > 0 {
+			return false
+		}
+		runestone.Mint = &id
+		return true
+	})
+	take(TAG_Pointer, 1, func(v []*big.Int) bool {
+		if v[0].Cmp(maxU32) > 0 || v[0].Uint64() >= uint64(len(tx.TxOut)) {
+			return false
+		}
+		p := uint32(v[0].Uint64())
+		runestone.Pointer = &p
+		return true
+	})
+
+	if runestone.Etching != nil && runestone.Etching.supply() == nil {
+		flaws = append(flaws, FlawSupplyOverflow)
+	}
+	if flags.Sign() != 0 {
+		flaws = append(flaws, FlawUnrecognizedFlag)
+	}
+	for tag := range fields {
+		if tag%2 == 0 {
+			flaws = append(flaws, FlawUnrecognizedEvenTag)
+			break
+		}
+	}
+	for _, tag := range tags {
+		if tag.Bit(0) == 0 && !containsFlaw(flaws, FlawUnrecognizedEvenTag) {
+			flaws = append(flaws, FlawUnrecognizedEvenTag)
+		}
+	}
+	if len(flaws) > 0 {
+		cenotaph := &Runestone{Cenotaph: true, Flaws: flaws, Mint: runestone.Mint}
+		if runestone.Etching != nil && runestone.Etching.Rune != nil {
+			cenotaph.Etching = &RunestoneEtching{Rune: runestone.Etching.Rune}
+		}
+		return cenotaph
+	}
+	return runestone
+}
+
+func containsFlaw(flaws []string, flaw string) bool {
+	for _, f := range flaws {
+		if f == flaw {
+			return true
+		}
+	}
+	return false
+}
+
+// isRuneChar reports whether n is a unicode scalar value, a rust char.
+func isRuneChar(n uint64) bool {
+	return n <= utf8.MaxRune && (n < 0xd800 || n > 0xdfff)
+}
+
+// runestonePayload concatenates the pushes of the first OP_RETURN OP_13
+// output. Anything but data pushes after the magic number is a flaw.
+func runestonePayload(tx *wire.MsgTx) ([]byte, string, bool) {
+	for _, out := range tx.TxOut {
+		tokenizer := txscript.MakeScriptTokenizer(0, out.PkScript)
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_RETURN {
+			continue
+		}
+		if !tokenizer.Next() || tokenizer.Opcode() != txscript.OP_13 {
+			continue
+		}
+		var payload []byte
+		for tokenizer.Next() {
+			if tokenizer.Opcode() > txscript.OP_PUSHDATA4 {
+				return nil, FlawOpcode, true
+			}
+			payload = append(payload, tokenizer.Data()...)
+		}
+		if tokenizer.Err() != nil {
+			return nil, FlawInvalidScript, true
+		}
+		return payload, "", true
+	}
+	return nil, "", false
+}
+
+// decodeRuneVarints decodes the LEB128 u128 integers of a runestone payload.
+func decodeRuneVarints(payload []byte) ([]*big.Int, error) {
+	var integers []*big.Int
+	for len(payload) > 0 {
+		n, size, err := decodeRuneVarint(payload)
+		if err != nil {
+			return nil, err
+		}
+		integers = append(integers, n)
+		payload = payload[size:]
+	}
+	return integers, nil
+}
+
+func decodeRuneVarint(b []byte) (*big.Int, int, error) {
+	n := new(big.Int)
+	for i, v := range b {
+		if i > 18 {
+			return nil, 0, errors.New("overlong varint")
+		}
+		value := uint64(v & 0x7f)
+		if i == 18 && value&0b0111_1100 != 0 {
+			return nil, 0, errors.New("varint overflow")
+		}
+		n.Or(n, new(big.Int).Lsh(new(big.Int).SetUint64(value), uint(7*i)))
+		if v&0x80 == 0 {
+			return n, i + 1, nil
+		}
+	}
+	return nil, 0, errors.New("unterminated varint")
+}
+
+// RuneName returns the base-26 name of a rune number, 0 is A and 26 is AA.
+func RuneName(n *big.Int) string {
+	var name []byte
+	v := new(big.Int).Add(n, big.NewInt(1))
+	twentySix := big.NewInt(26)
+	for v.Sign() > 0 {
+		v.Sub(v, big.NewInt(1))
+		mod := new(big.Int)
+		v.DivMod(v, twentySix, mod)
+		name = append(name, byte('A'+mod.Int64()))
+	}
+	for i, j := 0, len(name)-1; i < j; i, j = i+1, j-1 {
+		name[i], name[j] = name[j], name[i]
+	}
+	return string(name)
+}
+
+// SpacedRuneName inserts a • after every letter whose spacer bit is set.
+func SpacedRuneName(n *big.Int, spacers uint32) string {
+	name := RuneName(n)
+	var b strings.Builder
+	for i, c := range name {
+		b.WriteRune(c)
+		if i < len(name)-1 && spacers&(1<<uint(i)) != 0 {
+			b.WriteRune('•')
+		}
+	}
+	return b.String()
+}
+
+// ParseSpacedRune is the inverse of SpacedRuneName, spacers are • or .
+func ParseSpacedRune(s string) (*big.Int, uint32, error) {
+	n := new(big.Int)
+	var spacers uint32
+	letters := 0
+	for _, c := range s {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			if letters > 0 {
+				n.Add(n, big.NewInt(1))
+			}
+			n.Mul(n, big.NewInt(26))
+			n.Add(n, big.NewInt(int64(c-'A')))
+			letters++
+			if n.Cmp(maxU128) > 0 {
+				return nil, 0, fmt.Errorf("rune %s out of range", s)
+			}
+		case c == '•' || c == '.':
+			if letters == 0 || spacers&(1<<uint(letters-1)) != 0 {
+				return nil, 0, fmt.Errorf("invalid spacer in rune %s", s)
+			}
+			spacers |= 1 << uint(letters-1)
+		default:
+			return nil, 0, fmt.Errorf("invalid character %q in rune %s", c, s)
+		}
+	}
+	if letters == 0 || spacers>>uint(letters-1) != 0 {
+		return nil, 0, fmt.Errorf("invalid rune %s", s)
+	}
+	return n, spacers, nil
+}
+
+func (r *Runestone) String() string {
+	if r == nil {
+		return ""
+	}
+	var b strings.Builder
+	if r.Cenotaph {
+		b.WriteString("cenotaph " + strings.Join(r.Flaws, ",") + " ")
+	}
+	for _, e := range r.Edicts {
+		b.WriteString(fmt.Sprintf("edict %s %s -> %d ", e.Id, e.Amount, e.Output))
+	}
+	if r.Etching != nil {
+		b.WriteString("etching " + r.Etching.SpacedRune() + " ")
+	}
+	if r.Mint != nil {
+		b.WriteString("mint " + r.Mint.String() + " ")
+	}
+	if r.Pointer != nil {
+		b.WriteString(fmt.Sprintf("pointer %d ", *r.Pointer))
+	}
+	return strings.TrimSpace(b.String())
+}
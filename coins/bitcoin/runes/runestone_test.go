@@ -0,0 +1,183 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func runestoneTx(t *testing.T, script []byte, outputs int) *wire.MsgTx {
+	tx := wire.NewMsgTx(2)
+	tx.AddTxOut(wire.NewTxOut(0, script))
+	for i := 1; i < outputs; i++ {
+		tx.AddTxOut(wire.NewTxOut(546, []byte{txscript.OP_1}))
+	}
+	return tx
+}
+
+func runestoneIntegers(t *testing.T, integers ...int64) []byte {
+	var bigIntegers []*big.Int
+	for _, n := range integers {
+		bigIntegers = append(bigIntegers, big.NewInt(n))
+	}
+	return runestoneBigIntegers(t, bigIntegers...)
+}
+
+func runestoneBigIntegers(t *testing.T, integers ...*big.Int) []byte {
+	var payload []byte
+	for _, n := range integers {
+		payload = append(payload, runeVarint(n)...)
+	}
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddOp(txscript.OP_13).AddData(payload).Script()
+	require.NoError(t, err)
+	return script
+}
+
+func TestDecipherRunestoneEdicts(t *testing.T) {
+	script, err := BuildOpReturnData([]*Edict{{Block: "837557", Id: "1234", Amount: "21000", Output: 0}}, true, false, 1)
+	require.NoError(t, err)
+	runestone := DecipherRunestone(runestoneTx(t, script, 2))
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph)
+	require.Len(t, runestone.Edicts, 1)
+	assert.Equal(t, RuneId{Block: 837557, Tx: 1234}, runestone.Edicts[0].Id)
+	assert.Equal(t, "21000", runestone.Edicts[0].Amount.String())
+	assert.Equal(t, uint32(1), *runestone.Pointer)
+
+	script, err = BuildOpReturnDataJson([]byte(`{"edicts":[{"block":"837557","id":"1234","amount":"1","output":0}],"isDefaultOutput":true,"defaultOutput":1,"mint":true}`))
+	require.NoError(t, err)
+	runestone = DecipherRunestone(runestoneTx(t, script, 2))
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph)
+	assert.Equal(t, "837557:1234", runestone.Mint.String())
+
+	tx := runestoneTx(t, script, 2)
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	txHex, err := bitcoin.GetTxHex(tx)
+	require.NoError(t, err)
+	fromHex, err := DecipherRunestoneFromHex(txHex)
+	require.NoError(t, err)
+	assert.Equal(t, runestone, fromHex)
+
+	_, err = DecipherRunestoneFromHex(hex.EncodeToString([]byte{}))
+	assert.Error(t, err)
+	assert.Nil(t, DecipherRunestone(runestoneTx(t, []byte{txscript.OP_RETURN, txscript.OP_14}, 1)))
+}
+
+func TestDecipherRunestoneEtching(t *testing.T) {
+	// flags etching|terms, rune UNCOMMONGOODS with a spacer, premine and terms
+	n, spacers, err := ParseSpacedRune("UNCOMMON•GOODS")
+	require.NoError(t, err)
+	var integers []byte
+	for _, kv := range [][2]*big.Int{
+		{TAG_Flags, big.NewInt(0b11)},
+		{TAG_Rune, n},
+		{TAG_Spacers, big.NewInt(int64(spacers))},
+		{TAG_Divisibility, big.NewInt(2)},
+		{TAG_Symbol, big.NewInt('⧉')},
+		{TAG_Premine, big.NewInt(1000)},
+		{TAG_Amount, big.NewInt(1)},
+		{TAG_Cap, big.NewInt(100)},
+		{TAG_HeightStart, big.NewInt(840000)},
+		{TAG_OffsetEnd, big.NewInt(1050000)},
+	} {
+		integers = append(integers, runeVarint(kv[0])...)
+		integers = append(integers, runeVarint(kv[1])...)
+	}
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_RETURN).AddOp(txscript.OP_13).AddData(integers).Script()
+	require.NoError(t, err)
+
+	runestone := DecipherRunestone(runestoneTx(t, script, 1))
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph, runestone.Flaws)
+	etching := runestone.Etching
+	require.NotNil(t, etching)
+	assert.Equal(t, "UNCOMMON•GOODS", etching.SpacedRune())
+	assert.Equal(t, uint8(2), *etching.Divisibility)
+	assert.Equal(t, '⧉', *etching.Symbol)
+	assert.Equal(t, "1000", etching.Premine.String())
+	assert.Equal(t, "100", etching.Terms.Cap.String())
+	assert.Equal(t, uint64(840000), *etching.Terms.HeightStart)
+	assert.Nil(t, etching.Terms.HeightEnd)
+	assert.Equal(t, uint64(1050000), *etching.Terms.OffsetEnd)
+	assert.False(t, etching.Turbo)
+
+	// the tags as ord numbers them: flags 2, offset end 18
+	runestone = DecipherRunestone(runestoneTx(t, runestoneIntegers(t, 2, 0b11, 18, 100), 1))
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph, runestone.Flaws)
+	require.NotNil(t, runestone.Etching.Terms)
+	assert.Equal(t, uint64(100), *runestone.Etching.Terms.OffsetEnd)
+	assert.Equal(t, int64(18), TAG_OffsetEnd.Int64())
+}
+
+func TestDecipherRunestoneCenotaph(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		script  []byte
+		outputs int
+		flaw    string
+	}{
+		{"opcode", []byte{txscript.OP_RETURN, txscript.OP_13, txscript.OP_VERIFY}, 1, FlawOpcode},
+		{"invalid script", []byte{txscript.OP_RETURN, txscript.OP_13, txscript.OP_DATA_4, 0}, 1, FlawInvalidScript},
+		{"unterminated varint", []byte{txscript.OP_RETURN, txscript.OP_13, txscript.OP_DATA_1, 0x80}, 1, FlawVarint},
+		{"overlong varint", append([]byte{txscript.OP_RETURN, txscript.OP_13, 20}, append(bytes.Repeat([]byte{0x80}, 19), 0)...), 1, FlawVarint},
+		{"overflowing varint", append([]byte{txscript.OP_RETURN, txscript.OP_13, 19}, append(bytes.Repeat([]byte{0xff}, 18), 0x04)...), 1, FlawVarint},
+		{"trailing integers", runestoneIntegers(t, 0, 1, 1, 1), 1, FlawTrailingIntegers},
+		{"edict output", runestoneIntegers(t, 0, 1, 1, 1, 2), 1, FlawEdictOutput},
+		{"edict rune id", runestoneIntegers(t, 0, 0, 1, 1, 0), 1, FlawEdictRuneId},
+		{"truncated field", runestoneIntegers(t, 22), 1, FlawTruncatedField},
+		{"even tag", runestoneIntegers(t, 126, 1), 1, FlawUnrecognizedEvenTag},
+		{"unrecognized flag", runestoneIntegers(t, 2, 8), 1, FlawUnrecognizedFlag},
+		{"pointer", runestoneIntegers(t, 22, 1), 1, FlawUnrecognizedEvenTag},
+		{"supply overflow", runestoneBigIntegers(t, TAG_Flags, big.NewInt(0b11), TAG_Premine, maxU128,
+			TAG_Cap, big.NewInt(1), TAG_Amount, big.NewInt(1)), 1, FlawSupplyOverflow},
+	} {
+		runestone := DecipherRunestone(runestoneTx(t, test.script, test.outputs))
+		require.NotNil(t, runestone, test.name)
+		assert.True(t, runestone.Cenotaph, test.name)
+		assert.Contains(t, runestone.Flaws, test.flaw, test.name)
+		assert.Empty(t, runestone.Edicts, test.name)
+	}
+
+	// a cenotaph still etches its rune and counts its mint
+	runestone := DecipherRunestone(runestoneTx(t, runestoneIntegers(t, 2, 1, 4, 5, 20, 840000, 20, 1, 126, 0), 1))
+	require.NotNil(t, runestone)
+	assert.True(t, runestone.Cenotaph)
+	assert.Equal(t, "F", RuneName(runestone.Etching.Rune))
+	assert.Equal(t, RuneId{Block: 840000, Tx: 1}, *runestone.Mint)
+
+	// odd tags are ignored
+	runestone = DecipherRunestone(runestoneTx(t, runestoneIntegers(t, 127, 1, 22, 1), 2))
+	require.NotNil(t, runestone)
+	assert.False(t, runestone.Cenotaph)
+	assert.Equal(t, uint32(1), *runestone.Pointer)
+}
+
+func TestRuneName(t *testing.T) {
+	for n, name := range map[int64]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, name, RuneName(big.NewInt(n)))
+		parsed, spacers, err := ParseSpacedRune(name)
+		require.NoError(t, err)
+		assert.Equal(t, n, parsed.Int64())
+		assert.Equal(t, uint32(0), spacers)
+	}
+	assert.Equal(t, "BCGDENLQRQWDSLRUGSNLBTMFIJAV", RuneName(maxU128))
+	assert.Equal(t, "A•B•C", SpacedRuneName(big.NewInt(730), 0b11))
+	for _, s := range []string{"", "•A", "A•", "A••B", "Ab"} {
+		_, _, err := ParseSpacedRune(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func runeVarint(n *big.Int) []byte {
+	var buf bytes.Buffer
+	EncodeToVecV2(n, &buf)
+	return buf.Bytes()
+}
@@ -0,0 +1,628 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"strings"
+)
+
+// BIP-352 silent payments: the sender derives a fresh taproot output for a
+// reusable sp1 address from the private keys of its inputs, the receiver finds
+// it by scanning transactions with its scan key.
+
+const (
+	SilentPaymentHRPMainNet = "sp"
+	SilentPaymentHRPTestNet = "tsp"
+	// SilentPaymentChangeLabel is the label reserved for change.
+	SilentPaymentChangeLabel = 0
+
+	silentPaymentVersion = 0
+	silentPaymentMaxK    = 2323
+)
+
+var (
+	silentPaymentTagInputs       = []byte("BIP0352/Inputs")
+	silentPaymentTagSharedSecret = []byte("BIP0352/SharedSecret")
+	silentPaymentTagLabel        = []byte("BIP0352/Label")
+)
+
+var (
+	ErrInvalidSilentPaymentAddress = errors.New("invalid silent payment address")
+	ErrNoSilentPaymentInputs       = errors.New("no inputs eligible for silent payments")
+	ErrSilentPaymentInputKey       = errors.New("invalid silent payment input key")
+)
+
+// SilentPaymentAddress is the scan and spend public keys of an sp1 address,
+// the spend key including the label tweak of a labeled address.
+type SilentPaymentAddress struct {
+	ScanPubKey  *btcec.PublicKey
+	SpendPubKey *btcec.PublicKey
+}
+
+func silentPaymentHRP(network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	switch network.Net {
+	case wire.MainNet:
+		return SilentPaymentHRPMainNet, nil
+	case wire.TestNet3, wire.TestNet, chaincfg.SigNetParams.Net:
+		return SilentPaymentHRPTestNet, nil
+	}
+	return "", fmt.Errorf("silent payments are not supported on %s", network.Name)
+}
+
+// Encode returns the bech32m sp1 address, tsp1 on the test networks.
+func (a *SilentPaymentAddress) Encode(network *chaincfg.Params) (string, error) {
+	hrp, err := silentPaymentHRP(network)
+	if err != nil {
+		return "", err
+	}
+	payload := append(a.ScanPubKey.SerializeCompressed(), a.SpendPubKey.SerializeCompressed()...)
+	data, err := bech32.ConvertBits(payload, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return bech32.EncodeM(hrp, append([]byte{silentPaymentVersion}, data...))
+}
+
+// DecodeSilentPaymentAddress decodes an sp1 address of network. Addresses of a
+// later version are read for their first 66 bytes, as BIP-352 asks.
+func DecodeSilentPaymentAddress(addr string, network *chaincfg.Params) (*SilentPaymentAddress, error) {
+	expectedHRP, err := silentPaymentHRP(network)
+	if err != nil {
+		return nil, err
+	}
+	hrp, data, err := bech32.DecodeNoLimit(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSilentPaymentAddress, err)
+	}
+	if hrp != expectedHRP || len(data) == 0 {
+		return nil, ErrInvalidSilentPaymentAddress
+	}
+	// DecodeNoLimit accepts both checksums, only bech32m re-encodes to addr
+	if encoded, err := bech32.EncodeM(hrp, data); err != nil || encoded != strings.ToLower(addr) {
+		return nil, fmt.Errorf("%w: not bech32m", ErrInvalidSilentPaymentAddress)
+	}
+	version := data[0]
+	if version == 31 {
+		return nil, fmt.Errorf("%w: version %d", ErrInvalidSilentPaymentAddress, version)
+	}
+	payload, err := bech32.ConvertBits(data[1:], 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSilentPaymentAddress, err)
+	}
+	if len(payload) < 66 || version == silentPaymentVersion && len(payload) != 66 {
+		return nil, fmt.Errorf("%w: payload of %d bytes", ErrInvalidSilentPaymentAddress, len(payload))
+	}
+	scan, err := btcec.ParsePubKey(payload[:33])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSilentPaymentAddress, err)
+	}
+	spend, err := btcec.ParsePubKey(payload[33:66])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSilentPaymentAddress, err)
+	}
+	return &SilentPaymentAddress{ScanPubKey: scan, SpendPubKey: spend}, nil
+}
+
+// IsSilentPaymentAddress reports whether addr is an sp1 address of network.
+func IsSilentPaymentAddress(addr string, network *chaincfg.Params) bool {
+	_, err := DecodeSilentPaymentAddress(addr, network)
+	return err == nil
+}
+
+func scalarFromBytes(b []byte) (*btcec.ModNScalar, error) {
+	var s btcec.ModNScalar
+	if overflow := s.SetByteSlice(b); overflow {
+		return nil, errors.New("scalar out of range")
+	}
+	return &s, nil
+}
+
+func scalarBaseMult(k *btcec.ModNScalar) *btcec.PublicKey {
+	var p btcec.JacobianPoint
+	btcec.ScalarBaseMultNonConst(k, &p)
+	p.ToAffine()
+	return btcec.NewPublicKey(&p.X, &p.Y)
+}
+
+func scalarMult(k *btcec.ModNScalar, pubKey *btcec.PublicKey) *btcec.PublicKey {
+	var p, res btcec.JacobianPoint
+	pubKey.AsJacobian(&p)
+	btcec.ScalarMultNonConst(k, &p, &res)
+	res.ToAffine()
+	return btcec.NewPublicKey(&res.X, &res.Y)
+}
+
+// pointAdd returns a+b, nil for the point at infinity.
+func pointAdd(a, b *btcec.PublicKey) *btcec.PublicKey {
+	var p, q, res btcec.JacobianPoint
+	a.AsJacobian(&p)
+	b.AsJacobian(&q)
+	btcec.AddNonConst(&p, &q, &res)
+	if (res.X.IsZero() && res.Y.IsZero()) || res.Z.IsZero() {
+		return nil
+	}
+	res.ToAffine()
+	return btcec.NewPublicKey(&res.X, &res.Y)
+}
+
+func negatePoint(pubKey *btcec.PublicKey) *btcec.PublicKey {
+	var p btcec.JacobianPoint
+	pubKey.AsJacobian(&p)
+	p.Y.Negate(1).Normalize()
+	return btcec.NewPublicKey(&p.X, &p.Y)
+}
+
+// silentPaymentLabelTweak is hash_BIP0352/Label(ser256(b_scan) || ser32(m)).
+func silentPaymentLabelTweak(scanPrivKey *btcec.PrivateKey, m uint32) (*btcec.ModNScalar, error) {
+	var buf [36]byte
+	scanPrivKey.Key.PutBytesUnchecked(buf[:32])
+	binary.BigEndian.PutUint32(buf[32:], m)
+	return scalarFromBytes(chainhash.TaggedHash(silentPaymentTagLabel, buf[:])[:])
+}
+
+// silentPaymentInputHash is hash_BIP0352/Inputs(outpoint_L || A), outpoint_L
+// the smallest serialized outpoint of the transaction.
+func silentPaymentInputHash(outPoints []wire.OutPoint, sumPubKey *btcec.PublicKey) (*btcec.ModNScalar, error) {
+	var smallest []byte
+	for _, op := range outPoints {
+		var b [36]byte
+		copy(b[:32], op.Hash[:])
+		binary.LittleEndian.PutUint32(b[32:], op.Index)
+		if smallest == nil || bytes.Compare(b[:], smallest) < 0 {
+			smallest = b[:]
+		}
+	}
+	if smallest == nil {
+		return nil, ErrNoSilentPaymentInputs
+	}
+	return scalarFromBytes(chainhash.TaggedHash(silentPaymentTagInputs, smallest, sumPubKey.SerializeCompressed())[:])
+}
+
+// silentPaymentTweak is t_k = hash_BIP0352/SharedSecret(serP(ecdh) || ser32(k)).
+func silentPaymentTweak(sharedSecret *btcec.PublicKey, k uint32) (*btcec.ModNScalar, error) {
+	var ser [4]byte
+	binary.BigEndian.PutUint32(ser[:], k)
+	return scalarFromBytes(chainhash.TaggedHash(silentPaymentTagSharedSecret, sharedSecret.SerializeCompressed(), ser[:])[:])
+}
+
+// silentPaymentInputKey returns the key an input spending pkScript
+// contributes, nil when the input is not eligible, counting the inputs
+// silentPaymentInputPubKey counts. Taproot keys are the output key, negated to
+// even y: the key itself when it is the output key, else its BIP-86 tweak.
+// P2PKH and P2SH inputs need their key too: the receiver counts compressed
+// P2PKH and P2SH-P2WPKH inputs, and only the key tells them from the
+// uncompressed P2PKH and other P2SH inputs it does not count.
+func silentPaymentInputKey(privKey *btcec.PrivateKey, pkScript []byte) (*btcec.ModNScalar, error) {
+	switch {
+	case txscript.IsPayToTaproot(pkScript):
+		if privKey == nil {
+			return nil, fmt.Errorf("%w: missing taproot key", ErrSilentPaymentInputKey)
+		}
+		key := privKey
+		if !bytes.Equal(pkScript[2:], schnorr.SerializePubKey(key.PubKey())) {
+			key = txscript.TweakTaprootPrivKey(*privKey, nil)
+			if !bytes.Equal(pkScript[2:], schnorr.SerializePubKey(key.PubKey())) {
+				return nil, fmt.Errorf("%w: not the taproot output key", ErrSilentPaymentInputKey)
+			}
+		}
+		k := key.Key
+		if key.PubKey().SerializeCompressed()[0] == 0x03 {
+			k.Negate()
+		}
+		return &k, nil
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		if privKey == nil {
+			return nil, fmt.Errorf("%w: missing P2WPKH key", ErrSilentPaymentInputKey)
+		}
+		if !bytes.Equal(btcutil.Hash160(privKey.PubKey().SerializeCompressed()), pkScript[2:22]) {
+			return nil, fmt.Errorf("%w: not the P2WPKH key", ErrSilentPaymentInputKey)
+		}
+	case txscript.IsPayToPubKeyHash(pkScript):
+		if privKey == nil {
+			return nil, fmt.Errorf("%w: missing P2PKH key", ErrSilentPaymentInputKey)
+		}
+		if !bytes.Equal(btcutil.Hash160(privKey.PubKey().SerializeCompressed()), pkScript[3:23]) {
+			if bytes.Equal(btcutil.Hash160(privKey.PubKey().SerializeUncompressed()), pkScript[3:23]) {
+				// uncompressed keys are not eligible
+				return nil, nil
+			}
+			return nil, fmt.Errorf("%w: not the P2PKH key", ErrSilentPaymentInputKey)
+		}
+	case txscript.IsPayToScriptHash(pkScript):
+		if privKey == nil {
+			return nil, fmt.Errorf("%w: missing P2SH key", ErrSilentPaymentInputKey)
+		}
+		redeemScript, err := PayToWitnessPubKeyHashScript(btcutil.Hash160(privKey.PubKey().SerializeCompressed()))
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(btcutil.Hash160(redeemScript), pkScript[2:22]) {
+			// a P2SH other than P2SH-P2WPKH of the key
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	k := privKey.Key
+	return &k, nil
+}
+
+// silentPaymentScripts returns the taproot pkScripts paying recipients from
+// inputs spending prevPkScripts at outPoints with privateKeys. Keys of
+// ineligible inputs may be nil, and so may the pkScripts of inputs known not
+// to be eligible such as script path spends under the NUMS key, their
+// outpoints still count.
+func silentPaymentScripts(privateKeys []*btcec.PrivateKey, outPoints []wire.OutPoint, prevPkScripts [][]byte,
+	recipients []string, network *chaincfg.Params) ([][]byte, error) {
+	var sum btcec.ModNScalar
+	eligible := false
+	for i := range outPoints {
+		k, err := silentPaymentInputKey(privateKeys[i], prevPkScripts[i])
+		if err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+		if k != nil {
+			sum.Add(k)
+			eligible = true
+		}
+	}
+	if !eligible || sum.IsZero() {
+		return nil, ErrNoSilentPaymentInputs
+	}
+	inputHash, err := silentPaymentInputHash(outPoints, scalarBaseMult(&sum))
+	if err != nil {
+		return nil, err
+	}
+	sum.Mul(inputHash)
+
+	scripts := make([][]byte, len(recipients))
+	counts := make(map[string]uint32)
+	for i, recipient := range recipients {
+		addr, err := DecodeSilentPaymentAddress(recipient, network)
+		if err != nil {
+			return nil, err
+		}
+		scanKey := string(addr.ScanPubKey.SerializeCompressed())
+		k := counts[scanKey]
+		counts[scanKey]++
+		tweak, err := silentPaymentTweak(scalarMult(&sum, addr.ScanPubKey), k)
+		if err != nil {
+			return nil, err
+		}
+		outputKey := pointAdd(addr.SpendPubKey, scalarBaseMult(tweak))
+		if outputKey == nil {
+			return nil, errors.New("silent payment output is the point at infinity")
+		}
+		scripts[i], err = txscript.NewScriptBuilder().AddOp(txscript.OP_1).AddData(schnorr.SerializePubKey(outputKey)).Script()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return scripts, nil
+}
+
+// DeriveSilentPaymentOutputs returns the taproot addresses paying recipients,
+// sp1 addresses, from the transaction spending inputs. Every input of the
+// transaction must be given, the private key and address for those eligible:
+// taproot, P2WPKH, P2SH-P2WPKH and compressed P2PKH inputs, and P2SH or P2PKH
+// inputs which might be. Inputs without an address are taken as not eligible,
+// taproot ones should only be script path spends under the NUMS key and P2SH
+// ones only scripts other than P2SH-P2WPKH.
+func DeriveSilentPaymentOutputs(inputs []*TxInput, recipients []string, network *chaincfg.Params) ([]string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	privateKeys := make([]*btcec.PrivateKey, len(inputs))
+	outPoints := make([]wire.OutPoint, len(inputs))
+	prevPkScripts := make([][]byte, len(inputs))
+	for i, in := range inputs {
+		hash, err := chainhash.NewHashFromStr(in.TxId)
+		if err != nil {
+			return nil, err
+		}
+		outPoints[i] = wire.OutPoint{Hash: *hash, Index: in.VOut}
+		if in.Address != "" {
+			if prevPkScripts[i], err = AddrToPkScript(in.Address, network); err != nil {
+				return nil, err
+			}
+		}
+		if in.PrivateKey == "" {
+			continue
+		}
+		wif, err := btcutil.DecodeWIF(in.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		privateKeys[i] = wif.PrivKey
+	}
+	scripts, err := silentPaymentScripts(privateKeys, outPoints, prevPkScripts, recipients, network)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, len(scripts))
+	for i, script := range scripts {
+		addr, err := btcutil.NewAddressTaproot(script[2:], network)
+		if err != nil {
+			return nil, err
+		}
+		addresses[i] = addr.EncodeAddress()
+	}
+	return addresses, nil
+}
+
+// SilentPaymentReceiver scans for the outputs of an sp1 address.
+type SilentPaymentReceiver struct {
+	ScanPrivKey *btcec.PrivateKey
+	SpendPubKey *btcec.PublicKey
+	// Labels are the labels scanned for, the change label included when wanted.
+	Labels []uint32
+}
+
+// NewSilentPaymentReceiver creates a receiver from the WIF scan key and the hex
+// spend public key.
+func NewSilentPaymentReceiver(scanPrivKey string, spendPubKey string, labels ...uint32) (*SilentPaymentReceiver, error) {
+	wif, err := btcutil.DecodeWIF(scanPrivKey)
+	if err != nil {
+		return nil, err
+	}
+	spend, err := parseHexPubKey(spendPubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &SilentPaymentReceiver{ScanPrivKey: wif.PrivKey, SpendPubKey: spend, Labels: labels}, nil
+}
+
+// Address returns the sp1 address of the receiver.
+func (r *SilentPaymentReceiver) Address(network *chaincfg.Params) (string, error) {
+	addr := &SilentPaymentAddress{ScanPubKey: r.ScanPrivKey.PubKey(), SpendPubKey: r.SpendPubKey}
+	return addr.Encode(network)
+}
+
+// LabeledAddress returns the address of label m, B_spend + hash(b_scan || m)·G.
+// Scan must be given m in Labels to find its payments.
+func (r *SilentPaymentReceiver) LabeledAddress(m uint32, network *chaincfg.Params) (string, error) {
+	tweak, err := silentPaymentLabelTweak(r.ScanPrivKey, m)
+	if err != nil {
+		return "", err
+	}
+	spend := pointAdd(r.SpendPubKey, scalarBaseMult(tweak))
+	if spend == nil {
+		return "", errors.New("labeled spend key is the point at infinity")
+	}
+	addr := &SilentPaymentAddress{ScanPubKey: r.ScanPrivKey.PubKey(), SpendPubKey: spend}
+	return addr.Encode(network)
+}
+
+// SilentPaymentOutput is an output found by Scan.
+type SilentPaymentOutput struct {
+	TxId   string `json:"txId"`
+	VOut   uint32 `json:"vOut"`
+	Amount int64  `json:"amount"`
+	// Address is the taproot address of the output, spent with the key of
+	// SilentPaymentSpendKey and no taproot tweak.
+	Address string `json:"address"`
+	// Tweak is the hex scalar added to the spend private key, the label tweak
+	// included.
+	Tweak string  `json:"tweak"`
+	Label *uint32 `json:"label,omitempty"`
+}
+
+// silentPaymentInputPubKey returns the public key input in contributes, nil
+// when it is not eligible.
+func silentPaymentInputPubKey(in *wire.TxIn, pkScript []byte) *btcec.PublicKey {
+	switch {
+	case txscript.IsPayToTaproot(pkScript):
+		witness := in.Witness
+		if len(witness) > 1 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+			witness = witness[:len(witness)-1]
+		}
+		if len(witness) > 1 {
+			controlBlock := witness[len(witness)-1]
+			nums, _ := hex.DecodeString(TaprootNUMSKey)
+			if len(controlBlock) >= 33 && bytes.Equal(controlBlock[1:33], nums[1:]) {
+				return nil
+			}
+		}
+		pubKey, err := schnorr.ParsePubKey(pkScript[2:])
+		if err != nil {
+			return nil
+		}
+		return pubKey
+	case txscript.IsPayToWitnessPubKeyHash(pkScript):
+		return compressedWitnessPubKey(in.Witness)
+	case txscript.IsPayToScriptHash(pkScript):
+		pushes, err := txscript.PushedData(in.SignatureScript)
+		if err != nil || len(pushes) != 1 || !txscript.IsPayToWitnessPubKeyHash(pushes[0]) {
+			return nil
+		}
+		return compressedWitnessPubKey(in.Witness)
+	case txscript.IsPayToPubKeyHash(pkScript):
+		pushes, err := txscript.PushedData(in.SignatureScript)
+		if err != nil {
+			return nil
+		}
+		for i := len(pushes) - 1; i >= 0; i-- {
+			if len(pushes[i]) == btcec.PubKeyBytesLenCompressed && bytes.Equal(btcutil.Hash160(pushes[i]), pkScript[3:23]) {
+				pubKey, err := btcec.ParsePubKey(pushes[i])
+				if err != nil {
+					return nil
+				}
+				return pubKey
+			}
+		}
+	}
+	return nil
+}
+
+func compressedWitnessPubKey(witness wire.TxWitness) *btcec.PublicKey {
+	if len(witness) != 2 || len(witness[1]) != btcec.PubKeyBytesLenCompressed {
+		return nil
+	}
+	pubKey, err := btcec.ParsePubKey(witness[1])
+	if err != nil {
+		return nil
+	}
+	return pubKey
+}
+
+// Scan returns the outputs of tx paying the receiver, prevOuts being the
+// outputs spent by each input of tx. Transactions spending a witness version
+// above 1 are not silent payments.
+func (r *SilentPaymentReceiver) Scan(tx *wire.MsgTx, prevOuts []*wire.TxOut, network *chaincfg.Params) ([]*SilentPaymentOutput, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if len(prevOuts) != len(tx.TxIn) {
+		return nil, errors.New("one prevout per input is needed")
+	}
+	var sum *btcec.PublicKey
+	outPoints := make([]wire.OutPoint, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		outPoints[i] = in.PreviousOutPoint
+		pkScript := prevOuts[i].PkScript
+		if version, _, err := txscript.ExtractWitnessProgramInfo(pkScript); err == nil && version > 1 {
+			return nil, nil
+		}
+		pubKey := silentPaymentInputPubKey(in, pkScript)
+		if pubKey == nil {
+			continue
+		}
+		if sum == nil {
+			sum = pubKey
+		} else if sum = pointAdd(sum, pubKey); sum == nil {
+			return nil, nil
+		}
+	}
+	if sum == nil {
+		return nil, nil
+	}
+	inputHash, err := silentPaymentInputHash(outPoints, sum)
+	if err != nil {
+		return nil, err
+	}
+	scalar := r.ScanPrivKey.Key
+	scalar.Mul(inputHash)
+	sharedSecret := scalarMult(&scalar, sum)
+
+	labels := make(map[string]uint32)
+	labelTweaks := make(map[uint32]*btcec.ModNScalar)
+	for _, m := range r.Labels {
+		tweak, err := silentPaymentLabelTweak(r.ScanPrivKey, m)
+		if err != nil {
+			return nil, err
+		}
+		labels[string(scalarBaseMult(tweak).SerializeCompressed())] = m
+		labelTweaks[m] = tweak
+	}
+
+	txId := tx.TxHash().String()
+	found := make(map[int]bool)
+	var res []*SilentPaymentOutput
+	for k := uint32(0); k < silentPaymentMaxK; k++ {
+		tweak, err := silentPaymentTweak(sharedSecret, k)
+		if err != nil {
+			return nil, err
+		}
+		base := pointAdd(r.SpendPubKey, scalarBaseMult(tweak))
+		if base == nil {
+			return nil, errors.New("silent payment output is the point at infinity")
+		}
+		match := -1
+		var label *uint32
+		for i, out := range tx.TxOut {
+			if found[i] || !txscript.IsPayToTaproot(out.PkScript) {
+				continue
+			}
+			if bytes.Equal(out.PkScript[2:], schnorr.SerializePubKey(base)) {
+				match = i
+				break
+			}
+			if len(labels) == 0 {
+				continue
+			}
+			outputKey, err := schnorr.ParsePubKey(out.PkScript[2:])
+			if err != nil {
+				continue
+			}
+			// output - base is a label point, for either parity of the output
+			for _, candidate := range []*btcec.PublicKey{outputKey, negatePoint(outputKey)} {
+				diff := pointAdd(candidate, negatePoint(base))
+				if diff == nil {
+					continue
+				}
+				if m, ok := labels[string(diff.SerializeCompressed())]; ok {
+					match, label = i, &m
+					break
+				}
+			}
+			if match >= 0 {
+				break
+			}
+		}
+		if match < 0 {
+			break
+		}
+		found[match] = true
+		if label != nil {
+			tweak.Add(labelTweaks[*label])
+		}
+		addr, err := btcutil.NewAddressTaproot(tx.TxOut[match].PkScript[2:], network)
+		if err != nil {
+			return nil, err
+		}
+		tweakBytes := tweak.Bytes()
+		res = append(res, &SilentPaymentOutput{
+			TxId:    txId,
+			VOut:    uint32(match),
+			Amount:  tx.TxOut[match].Value,
+			Address: addr.EncodeAddress(),
+			Tweak:   hex.EncodeToString(tweakBytes[:]),
+			Label:   label,
+		})
+	}
+	return res, nil
+}
+
+// SilentPaymentSpendKey returns the WIF key spending an output found by Scan,
+// the spend private key plus the output tweak.
+func SilentPaymentSpendKey(spendPrivKey string, tweak string, network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	wif, err := btcutil.DecodeWIF(spendPrivKey)
+	if err != nil {
+		return "", err
+	}
+	tweakBytes, err := hex.DecodeString(tweak)
+	if err != nil {
+		return "", err
+	}
+	t, err := scalarFromBytes(tweakBytes)
+	if err != nil {
+		return "", err
+	}
+	k := wif.PrivKey.Key
+	k.Add(t)
+	if k.IsZero() {
+		return "", errors.New("silent payment spend key is zero")
+	}
+	res, err := btcutil.NewWIF(&btcec.PrivateKey{Key: k}, network, true)
+	if err != nil {
+		return "", err
+	}
+	return res.String(), nil
+}
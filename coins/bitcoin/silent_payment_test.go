@@ -0,0 +1,277 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSilentPaymentAddress(t *testing.T) {
+	// BIP-352 test vectors
+	addr, err := DecodeSilentPaymentAddress("sp1qqgste7k9hx0qftg6qmwlkqtwuy6cycyavzmzj85c6qdfhjdpdjtdgqjuexzk6murw56suy3e0rd2cgqvycxttddwsvgxe2usfpxumr70xc9pkqwv", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0220bcfac5b99e04ad1a06ddfb016ee13582609d60b6291e98d01a9bc9a16c96d4", hex.EncodeToString(addr.ScanPubKey.SerializeCompressed()))
+	assert.Equal(t, "025cc9856d6f8375350e123978daac200c260cb5b5ae83106cab90484dcd8fcf36", hex.EncodeToString(addr.SpendPubKey.SerializeCompressed()))
+}
+
+func silentPaymentTestWIF(t *testing.T, privKey string) string {
+	b, err := hex.DecodeString(privKey)
+	require.NoError(t, err)
+	key, _ := btcec.PrivKeyFromBytes(b)
+	wif, err := btcutil.NewWIF(key, &chaincfg.MainNetParams, true)
+	require.NoError(t, err)
+	return wif.String()
+}
+
+func TestSilentPaymentSendAndScan(t *testing.T) {
+	network := &chaincfg.MainNetParams
+	// BIP-352 test vector "Simple send: two inputs"
+	recipient := "sp1qqgste7k9hx0qftg6qmwlkqtwuy6cycyavzmzj85c6qdfhjdpdjtdgqjuexzk6murw56suy3e0rd2cgqvycxttddwsvgxe2usfpxumr70xc9pkqwv"
+	inputs := []*TxInput{
+		{TxId: "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16", VOut: 0, PrivateKey: silentPaymentTestWIF(t, "eadc78165ff1f8ea94ad7cfdc54990738a4c53f6e0507b42154201b8e5dff3b1")},
+		{TxId: "a1075db55d416d3ca199f55b6084e2115b9345e16c5cf302fc80e9d5fbf5d48d", VOut: 0, PrivateKey: silentPaymentTestWIF(t, "0378e95685b74565fa56751b84a32dfd18545d10d691641b8372e32164fad66a")},
+	}
+	for _, in := range inputs {
+		wif, err := btcutil.DecodeWIF(in.PrivateKey)
+		require.NoError(t, err)
+		in.Address, err = PubKeyToAddr(wif.PrivKey.PubKey().SerializeCompressed(), LEGACY, network)
+		require.NoError(t, err)
+		in.Amount = 100000
+	}
+	outputs, err := DeriveSilentPaymentOutputs(inputs, []string{recipient}, network)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(outputs[0], network)
+	require.NoError(t, err)
+	assert.Equal(t, "f207162b1a7abc51c42017bef055e9ec1efc3d3567cb720357e2b84325db33ac", hex.EncodeToString(pkScript[2:]))
+
+	// the same payment through the builder, then found by the receiver
+	txBuild := NewTxBuild(2, network)
+	for _, in := range inputs {
+		txBuild.AddInput2(in.TxId, in.VOut, in.PrivateKey, in.Address, in.Amount)
+	}
+	require.NoError(t, txBuild.AddSilentPaymentOutput(recipient, 150000))
+	txBuild.AddOutput(inputs[0].Address, 40000)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	assert.Equal(t, pkScript, tx.TxOut[0].PkScript)
+
+	scanKey := silentPaymentTestWIF(t, "0f694e068028a717f8af6b9411f9a133dd3565258714cc226594b34db90c1f2c")
+	spendKey := silentPaymentTestWIF(t, "9d6ad855ce3417ef84e836892e5a56392bfba05fa5d97ccea30e266f540e08b3")
+	spendWIF, err := btcutil.DecodeWIF(spendKey)
+	require.NoError(t, err)
+	receiver, err := NewSilentPaymentReceiver(scanKey, hex.EncodeToString(spendWIF.PrivKey.PubKey().SerializeCompressed()))
+	require.NoError(t, err)
+	addr, err := receiver.Address(network)
+	require.NoError(t, err)
+	assert.Equal(t, recipient, addr)
+
+	var prevOuts []*wire.TxOut
+	for _, in := range inputs {
+		script, err := AddrToPkScript(in.Address, network)
+		require.NoError(t, err)
+		prevOuts = append(prevOuts, wire.NewTxOut(in.Amount, script))
+	}
+	found, err := receiver.Scan(tx, prevOuts, network)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(found))
+	assert.Equal(t, uint32(0), found[0].VOut)
+	assert.Equal(t, outputs[0], found[0].Address)
+	assert.Nil(t, found[0].Label)
+
+	// the output is spent with the tweaked spend key
+	key, err := SilentPaymentSpendKey(spendKey, found[0].Tweak, network)
+	require.NoError(t, err)
+	spend := NewTxBuild(2, network)
+	spend.AddInput2(found[0].TxId, found[0].VOut, key, found[0].Address, found[0].Amount)
+	spend.AddOutput(inputs[0].Address, 149000)
+	spendTx, err := spend.Build()
+	require.NoError(t, err)
+	txHex, err := GetTxHex(spendTx)
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: found[0].TxId, VOut: found[0].VOut, Amount: found[0].Amount, Address: found[0].Address}}, network)
+}
+
+func TestSilentPaymentIneligibleInputs(t *testing.T) {
+	network := &chaincfg.MainNetParams
+	scanKey := silentPaymentTestWIF(t, "0f694e068028a717f8af6b9411f9a133dd3565258714cc226594b34db90c1f2c")
+	spendWIF, err := btcutil.DecodeWIF(silentPaymentTestWIF(t, "9d6ad855ce3417ef84e836892e5a56392bfba05fa5d97ccea30e266f540e08b3"))
+	require.NoError(t, err)
+	receiver, err := NewSilentPaymentReceiver(scanKey, hex.EncodeToString(spendWIF.PrivKey.PubKey().SerializeCompressed()))
+	require.NoError(t, err)
+	recipient, err := receiver.Address(network)
+	require.NoError(t, err)
+
+	// a P2WPKH input, an uncompressed P2PKH input and a P2SH 1 of 1 multisig
+	var keys []*btcec.PrivateKey
+	for _, b := range []byte{1, 2, 3} {
+		key, _ := btcec.PrivKeyFromBytes(bytes.Repeat([]byte{b}, 32))
+		keys = append(keys, key)
+	}
+	p2wpkh, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(keys[0].PubKey().SerializeCompressed()), network)
+	require.NoError(t, err)
+	p2pkh, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(keys[1].PubKey().SerializeUncompressed()), network)
+	require.NoError(t, err)
+	multisigPubKey, err := btcutil.NewAddressPubKey(keys[2].PubKey().SerializeCompressed(), network)
+	require.NoError(t, err)
+	redeemScript, err := txscript.MultiSigScript([]*btcutil.AddressPubKey{multisigPubKey}, 1)
+	require.NoError(t, err)
+	p2sh, err := btcutil.NewAddressScriptHash(redeemScript, network)
+	require.NoError(t, err)
+
+	var inputs []*TxInput
+	for i, addr := range []btcutil.Address{p2wpkh, p2pkh, p2sh} {
+		wif, err := btcutil.NewWIF(keys[i], network, addr != p2pkh)
+		require.NoError(t, err)
+		inputs = append(inputs, &TxInput{TxId: chainhash.HashH([]byte{byte(i)}).String(), PrivateKey: wif.String(), Address: addr.EncodeAddress(), Amount: 100000})
+	}
+	outputs, err := DeriveSilentPaymentOutputs(inputs, []string{recipient}, network)
+	require.NoError(t, err)
+	// only the P2WPKH key counts
+	only, err := DeriveSilentPaymentOutputs([]*TxInput{inputs[0], {TxId: inputs[1].TxId}, {TxId: inputs[2].TxId}}, []string{recipient}, network)
+	require.NoError(t, err)
+	assert.Equal(t, only, outputs)
+
+	pkScript, err := AddrToPkScript(outputs[0], network)
+	require.NoError(t, err)
+	tx := wire.NewMsgTx(2)
+	var prevOuts []*wire.TxOut
+	for _, in := range inputs {
+		hash, err := chainhash.NewHashFromStr(in.TxId)
+		require.NoError(t, err)
+		tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, 0), nil, nil))
+		script, err := AddrToPkScript(in.Address, network)
+		require.NoError(t, err)
+		prevOuts = append(prevOuts, wire.NewTxOut(in.Amount, script))
+	}
+	tx.AddTxOut(wire.NewTxOut(290000, pkScript))
+	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
+	for i, in := range tx.TxIn {
+		prevOutFetcher.AddPrevOut(in.PreviousOutPoint, prevOuts[i])
+	}
+	witness, err := txscript.WitnessSignature(tx, txscript.NewTxSigHashes(tx, prevOutFetcher), 0, 100000, prevOuts[0].PkScript, txscript.SigHashAll, keys[0], true)
+	require.NoError(t, err)
+	tx.TxIn[0].Witness = witness
+	tx.TxIn[1].SignatureScript, err = txscript.SignatureScript(tx, 1, prevOuts[1].PkScript, txscript.SigHashAll, keys[1], false)
+	require.NoError(t, err)
+	sig, err := txscript.RawTxInSignature(tx, 2, redeemScript, txscript.SigHashAll, keys[2])
+	require.NoError(t, err)
+	tx.TxIn[2].SignatureScript, err = txscript.NewScriptBuilder().AddOp(txscript.OP_0).AddData(sig).AddData(redeemScript).Script()
+	require.NoError(t, err)
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+	var prevOutputs PrevOutputs
+	for _, in := range inputs {
+		prevOutputs = append(prevOutputs, &PrevOutput{TxId: in.TxId, Amount: in.Amount, Address: in.Address})
+	}
+	verifyTxScripts(t, txHex, prevOutputs, network)
+
+	found, err := receiver.Scan(tx, prevOuts, network)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(found))
+	assert.Equal(t, outputs[0], found[0].Address)
+
+	// a key that is not the one of an eligible input, or no key at all
+	other, err := btcutil.NewWIF(keys[1], network, true)
+	require.NoError(t, err)
+	for _, key := range []string{other.String(), ""} {
+		_, err = DeriveSilentPaymentOutputs([]*TxInput{{TxId: inputs[0].TxId, PrivateKey: key, Address: inputs[0].Address}}, []string{recipient}, network)
+		assert.ErrorIs(t, err, ErrSilentPaymentInputKey)
+	}
+	// a P2PKH or P2SH input signed elsewhere, without its key, may count for
+	// the receiver
+	compressed, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(keys[1].PubKey().SerializeCompressed()), network)
+	require.NoError(t, err)
+	for _, addr := range []btcutil.Address{compressed, p2sh} {
+		_, err = DeriveSilentPaymentOutputs([]*TxInput{inputs[0], {TxId: inputs[1].TxId, Address: addr.EncodeAddress()}}, []string{recipient}, network)
+		assert.ErrorIs(t, err, ErrSilentPaymentInputKey)
+	}
+	_, err = DeriveSilentPaymentOutputs([]*TxInput{inputs[0], {TxId: inputs[1].TxId, PrivateKey: other.String(), Address: p2sh.EncodeAddress()},
+		{TxId: inputs[2].TxId, PrivateKey: inputs[0].PrivateKey, Address: p2pkh.EncodeAddress()}}, []string{recipient}, network)
+	assert.ErrorIs(t, err, ErrSilentPaymentInputKey)
+}
+
+func TestSilentPaymentLabels(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 3, &chaincfg.TestNet3Params)
+	receiver, err := NewSilentPaymentReceiver(wifs[1], pubKeys[2])
+	require.NoError(t, err)
+	addr, err := receiver.Address(network)
+	require.NoError(t, err)
+	assert.Equal(t, "tsp1", addr[:4])
+	change, err := receiver.LabeledAddress(SilentPaymentChangeLabel, network)
+	require.NoError(t, err)
+	labeled, err := receiver.LabeledAddress(7, network)
+	require.NoError(t, err)
+
+	// a taproot input, the sender pays the plain, labeled and change addresses
+	senderAddr, err := PubKeyToAddr(mustDecodeHex(t, pubKeys[0]), TAPROOT, network)
+	require.NoError(t, err)
+	txBuild := NewTxBuild(2, network)
+	txBuild.AddInput2("0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", 1, wifs[0], senderAddr, 100000)
+	require.NoError(t, txBuild.AddSilentPaymentOutput(addr, 30000))
+	require.NoError(t, txBuild.AddSilentPaymentOutput(labeled, 30000))
+	require.NoError(t, txBuild.AddSilentPaymentOutput(change, 30000))
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	senderScript, err := AddrToPkScript(senderAddr, network)
+	require.NoError(t, err)
+	prevOuts := []*wire.TxOut{wire.NewTxOut(100000, senderScript)}
+
+	found, err := receiver.Scan(tx, prevOuts, network)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(found))
+
+	receiver.Labels = []uint32{SilentPaymentChangeLabel, 7}
+	found, err = receiver.Scan(tx, prevOuts, network)
+	require.NoError(t, err)
+	require.Equal(t, 3, len(found))
+	labels := map[uint32]bool{}
+	for _, out := range found {
+		if out.Label != nil {
+			labels[*out.Label] = true
+		}
+		key, err := SilentPaymentSpendKey(wifs[2], out.Tweak, network)
+		require.NoError(t, err)
+		wif, err := btcutil.DecodeWIF(key)
+		require.NoError(t, err)
+		outAddr, err := btcutil.NewAddressTaproot(tx.TxOut[out.VOut].PkScript[2:], network)
+		require.NoError(t, err)
+		assert.Equal(t, outAddr.EncodeAddress(), out.Address)
+		assert.Equal(t, tx.TxOut[out.VOut].PkScript[2:], wif.PrivKey.PubKey().SerializeCompressed()[1:])
+	}
+	assert.True(t, labels[SilentPaymentChangeLabel])
+	assert.True(t, labels[7])
+}
+
+func TestSilentPaymentErrors(t *testing.T) {
+	_, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	scan, err := btcec.ParsePubKey(mustDecodeHex(t, pubKeys[0]))
+	require.NoError(t, err)
+	spend, err := btcec.ParsePubKey(mustDecodeHex(t, pubKeys[1]))
+	require.NoError(t, err)
+	addr, err := (&SilentPaymentAddress{ScanPubKey: scan, SpendPubKey: spend}).Encode(&chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	assert.True(t, IsSilentPaymentAddress(addr, &chaincfg.RegressionNetParams))
+	assert.False(t, IsSilentPaymentAddress(addr, &chaincfg.MainNetParams))
+	assert.False(t, IsSilentPaymentAddress(addr, GetLTCMainNetParams()))
+	assert.False(t, IsSilentPaymentAddress(addr[:len(addr)-1]+"q", &chaincfg.TestNet3Params))
+
+	txBuild := NewTxBuild(2, &chaincfg.TestNet3Params)
+	assert.ErrorIs(t, txBuild.AddSilentPaymentOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 1000), ErrInvalidSilentPaymentAddress)
+
+	// P2WSH inputs are not eligible
+	_, err = DeriveSilentPaymentOutputs([]*TxInput{{
+		TxId:       "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		PrivateKey: "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22",
+		Address:    "tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7",
+	}}, []string{addr}, &chaincfg.TestNet3Params)
+	assert.ErrorIs(t, err, ErrNoSilentPaymentInputs)
+}
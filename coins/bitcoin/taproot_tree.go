@@ -0,0 +1,195 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"sort"
+)
+
+// TaprootNUMSKey is the BIP-341 point with no known discrete logarithm, an
+// internal key that disables the key path.
+const TaprootNUMSKey = "0250929b74c1a04954b78b4b6035e97a5e078a5a0f28ec96d547bfee9ace803ac0"
+
+var ErrTapLeafIndex = errors.New("tap leaf index out of range")
+
+type TapLeafSpec struct {
+	Script string `json:"script"`
+	// Weight is the relative likelihood of the leaf being spent, likelier
+	// leaves get shorter merkle proofs. Zero counts as one.
+	Weight uint32 `json:"weight"`
+	// LeafVersion defaults to the tapscript version 0xc0.
+	LeafVersion uint8 `json:"leafVersion"`
+}
+
+// TaprootScriptTree is a Huffman-weighted tree of tap leaves under an internal key.
+type TaprootScriptTree struct {
+	InternalKey *btcec.PublicKey
+	Leaves      []txscript.TapLeaf
+	root        txscript.TapNode
+	proofs      [][]byte
+}
+
+type tapHuffmanNode struct {
+	weight uint64
+	seq    int
+	node   txscript.TapNode
+	leaves []int
+}
+
+// NewTaprootScriptTree builds the tree of leaves with internalPubKey, a
+// compressed or x-only hex key. Leaves are combined two at a time, lightest
+// first, with ties broken by their order in leaves.
+func NewTaprootScriptTree(internalPubKey string, leaves []*TapLeafSpec) (*TaprootScriptTree, error) {
+	if len(leaves) == 0 {
+		return nil, errors.New("taproot script tree needs at least one leaf")
+	}
+	keyBytes, err := hex.DecodeString(internalPubKey)
+	if err != nil {
+		return nil, err
+	}
+	var key *btcec.PublicKey
+	if len(keyBytes) == schnorr.PubKeyBytesLen {
+		key, err = schnorr.ParsePubKey(keyBytes)
+	} else {
+		key, err = btcec.ParsePubKey(keyBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &TaprootScriptTree{InternalKey: key, proofs: make([][]byte, len(leaves))}
+	queue := make([]*tapHuffmanNode, 0, len(leaves))
+	for i, spec := range leaves {
+		script, err := hex.DecodeString(spec.Script)
+		if err != nil {
+			return nil, err
+		}
+		version := txscript.TapscriptLeafVersion(spec.LeafVersion)
+		if version == 0 {
+			version = txscript.BaseLeafVersion
+		}
+		leaf := txscript.NewTapLeaf(version, script)
+		tree.Leaves = append(tree.Leaves, leaf)
+		weight := uint64(spec.Weight)
+		if weight == 0 {
+			weight = 1
+		}
+		queue = append(queue, &tapHuffmanNode{weight: weight, seq: i, node: leaf, leaves: []int{i}})
+	}
+
+	seq := len(leaves)
+	for len(queue) > 1 {
+		sort.SliceStable(queue, func(i, j int) bool {
+			if queue[i].weight != queue[j].weight {
+				return queue[i].weight < queue[j].weight
+			}
+			return queue[i].seq < queue[j].seq
+		})
+		a, b := queue[0], queue[1]
+		aHash, bHash := a.node.TapHash(), b.node.TapHash()
+		for _, i := range a.leaves {
+			tree.proofs[i] = append(tree.proofs[i], bHash[:]...)
+		}
+		for _, i := range b.leaves {
+			tree.proofs[i] = append(tree.proofs[i], aHash[:]...)
+		}
+		branch := txscript.NewTapBranch(a.node, b.node)
+		queue = append(queue[2:], &tapHuffmanNode{
+			weight: a.weight + b.weight,
+			seq:    seq,
+			node:   branch,
+			leaves: append(append([]int{}, a.leaves...), b.leaves...),
+		})
+		seq++
+	}
+	tree.root = queue[0].node
+	return tree, nil
+}
+
+func (t *TaprootScriptTree) MerkleRoot() []byte {
+	h := t.root.TapHash()
+	return h[:]
+}
+
+func (t *TaprootScriptTree) OutputKey() *btcec.PublicKey {
+	return txscript.ComputeTaprootOutputKey(t.InternalKey, t.MerkleRoot())
+}
+
+func (t *TaprootScriptTree) Address(network *chaincfg.Params) (string, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	addr, err := btcutil.NewAddressTaproot(schnorr.SerializePubKey(t.OutputKey()), network)
+	if err != nil {
+		return "", err
+	}
+	return addr.EncodeAddress(), nil
+}
+
+func (t *TaprootScriptTree) LeafHash(index int) (chainhash.Hash, error) {
+	if index < 0 || index >= len(t.Leaves) {
+		return chainhash.Hash{}, ErrTapLeafIndex
+	}
+	return t.Leaves[index].TapHash(), nil
+}
+
+// ControlBlock returns the serialized control block spending leaf index.
+func (t *TaprootScriptTree) ControlBlock(index int) ([]byte, error) {
+	if index < 0 || index >= len(t.Leaves) {
+		return nil, ErrTapLeafIndex
+	}
+	controlBlock := txscript.ControlBlock{
+		InternalKey:     t.InternalKey,
+		OutputKeyYIsOdd: t.OutputKey().SerializeCompressed()[0] == 0x03,
+		LeafVersion:     t.Leaves[index].LeafVersion,
+		InclusionProof:  t.proofs[index],
+	}
+	return controlBlock.ToBytes()
+}
+
+// Info returns the address and the control block of leaf index in the form
+// NewTaprootAddress does for single leaf trees.
+func (t *TaprootScriptTree) Info(index int, network *chaincfg.Params) (*TaprootInfo, error) {
+	controlBlock, err := t.ControlBlock(index)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := t.Address(network)
+	if err != nil {
+		return nil, err
+	}
+	return &TaprootInfo{ControlBlockWitness: hex.EncodeToString(controlBlock), TaprootAddress: addr}, nil
+}
+
+// AddLeafToPsbtInput makes input i of packet spendable through leaf index:
+// SignPsbtWithKeyPathAndScriptPath signs the leaf scripts of an input, and the
+// finalizer uses the script and control block added here.
+func (t *TaprootScriptTree) AddLeafToPsbtInput(packet *psbt.Packet, i int, index int) error {
+	if i < 0 || i >= len(packet.Inputs) {
+		return ErrPsbtInputIndex
+	}
+	controlBlock, err := t.ControlBlock(index)
+	if err != nil {
+		return err
+	}
+	leaf := t.Leaves[index]
+	for _, l := range packet.Inputs[i].TaprootLeafScript {
+		if l.LeafVersion == leaf.LeafVersion && bytes.Equal(l.Script, leaf.Script) {
+			return nil
+		}
+	}
+	packet.Inputs[i].TaprootLeafScript = append(packet.Inputs[i].TaprootLeafScript, &psbt.TaprootTapLeafScript{
+		ControlBlock: controlBlock,
+		Script:       leaf.Script,
+		LeafVersion:  leaf.LeafVersion,
+	})
+	return nil
+}
@@ -0,0 +1,126 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func checkSigLeaf(t *testing.T, pubKey string) string {
+	script, err := txscript.NewScriptBuilder().
+		AddData(mustDecodeHex(t, pubKey)[1:]).
+		AddOp(txscript.OP_CHECKSIG).Script()
+	require.NoError(t, err)
+	return hex.EncodeToString(script)
+}
+
+func TestTaprootScriptTreeSingleLeaf(t *testing.T) {
+	_, pubKeys := testKeys(t, 2, &chaincfg.MainNetParams)
+	script := checkSigLeaf(t, pubKeys[1])
+	tree, err := NewTaprootScriptTree(pubKeys[0], []*TapLeafSpec{{Script: script}})
+	require.NoError(t, err)
+	info, err := tree.Info(0, nil)
+	require.NoError(t, err)
+	expected, err := NewTaprootAddress(script, nil, pubKeys[0])
+	require.NoError(t, err)
+	assert.Equal(t, expected, info)
+}
+
+func TestTaprootScriptTreeHuffman(t *testing.T) {
+	_, pubKeys := testKeys(t, 5, &chaincfg.MainNetParams)
+	var leaves []*TapLeafSpec
+	for i, weight := range []uint32{10, 1, 1, 2} {
+		leaves = append(leaves, &TapLeafSpec{Script: checkSigLeaf(t, pubKeys[i+1]), Weight: weight})
+	}
+	tree, err := NewTaprootScriptTree(pubKeys[0], leaves)
+	require.NoError(t, err)
+
+	// ((1,1),2) then 10: the heavy leaf sits next to the root
+	depths := []int{1, 3, 3, 2}
+	for i := range leaves {
+		controlBlockBytes, err := tree.ControlBlock(i)
+		require.NoError(t, err)
+		controlBlock, err := txscript.ParseControlBlock(controlBlockBytes)
+		require.NoError(t, err)
+		assert.Len(t, controlBlock.InclusionProof, depths[i]*chainhash.HashSize)
+		assert.Equal(t, tree.MerkleRoot(), controlBlock.RootHash(tree.Leaves[i].Script))
+		require.NoError(t, txscript.VerifyTaprootLeafCommitment(controlBlock,
+			schnorr.SerializePubKey(tree.OutputKey()), tree.Leaves[i].Script))
+	}
+
+	// with equal weights the tree is the balanced one btcd assembles
+	equal, err := NewTaprootScriptTree(pubKeys[0], []*TapLeafSpec{{Script: leaves[0].Script}, {Script: leaves[1].Script}})
+	require.NoError(t, err)
+	assembled := txscript.AssembleTaprootScriptTree(equal.Leaves...)
+	root := assembled.RootNode.TapHash()
+	assert.Equal(t, root[:], equal.MerkleRoot())
+
+	_, err = tree.ControlBlock(4)
+	assert.ErrorIs(t, err, ErrTapLeafIndex)
+	_, err = NewTaprootScriptTree(pubKeys[0], nil)
+	assert.Error(t, err)
+}
+
+func TestTaprootScriptTreeScriptPathSpend(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	wifs, pubKeys := testKeys(t, 3, network)
+	var leaves []*TapLeafSpec
+	for i := range pubKeys {
+		leaves = append(leaves, &TapLeafSpec{Script: checkSigLeaf(t, pubKeys[i])})
+	}
+	tree, err := NewTaprootScriptTree(TaprootNUMSKey, leaves)
+	require.NoError(t, err)
+	addr, err := tree.Address(network)
+	require.NoError(t, err)
+	pkScript, err := AddrToPkScript(addr, network)
+	require.NoError(t, err)
+	toScript, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.NoError(t, err)
+	prevTxId := "0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822"
+	prevHash, err := chainhash.NewHashFromStr(prevTxId)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		signer      int
+		leafHashArg bool
+	}{
+		{signer: 2},
+		{signer: 1, leafHashArg: true},
+	} {
+		packet, err := psbt.New([]*wire.OutPoint{wire.NewOutPoint(prevHash, 0)}, []*wire.TxOut{wire.NewTxOut(90000, toScript)},
+			2, 0, []uint32{wire.MaxTxInSequenceNum})
+		require.NoError(t, err)
+		packet.Inputs[0].WitnessUtxo = wire.NewTxOut(100000, pkScript)
+		for i := range leaves {
+			require.NoError(t, tree.AddLeafToPsbtInput(packet, 0, i))
+		}
+		var buf bytes.Buffer
+		require.NoError(t, packet.Serialize(&buf))
+
+		option := &SignPsbtOption{AutoFinalized: true, ToSignInputs: []*ToSignInput{{Index: 0}}}
+		if test.leafHashArg {
+			leafHash, err := tree.LeafHash(test.signer)
+			require.NoError(t, err)
+			option.ToSignInputs[0].TapLeafHashToSign = hex.EncodeToString(leafHash[:])
+		}
+		signed, err := SignPsbtWithKeyPathAndScriptPath(hex.EncodeToString(buf.Bytes()), wifs[test.signer], network, option)
+		require.NoError(t, err)
+		txHex, err := ExtractTxFromSignedPSBT(signed)
+		require.NoError(t, err)
+		verifyTxScripts(t, txHex, PrevOutputs{{TxId: prevTxId, VOut: 0, Amount: 100000, Address: addr}}, network)
+
+		tx, err := NewTxFromHex(txHex)
+		require.NoError(t, err)
+		witness := tx.TxIn[0].Witness
+		require.Len(t, witness, 3)
+		assert.Equal(t, tree.Leaves[test.signer].Script, []byte(witness[1]))
+	}
+}
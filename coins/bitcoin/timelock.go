@@ -0,0 +1,275 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/okx/go-wallet-sdk/coins/bitcoin/miniscript"
+	"strings"
+)
+
+// LockTimeThreshold separates block heights from unix timestamps in nLockTime
+// and in CHECKLOCKTIMEVERIFY.
+const LockTimeThreshold = 500000000
+
+var ErrInvalidTimelock = errors.New("invalid timelock")
+
+// TimelockScript is a timelocked spending policy, as a P2WSH witness script
+// and as a tapscript leaf.
+type TimelockScript struct {
+	// Policy is the miniscript, keys as given.
+	Policy string `json:"policy"`
+	// Older is the relative timelock of the timelocked path, a BIP-68 sequence.
+	Older uint32 `json:"older"`
+	// After is the absolute timelock of the timelocked path.
+	After uint32 `json:"after"`
+
+	witnessScript *miniscript.Miniscript
+	leafScript    *miniscript.Miniscript
+}
+
+func newTimelockScript(policy string, older, after uint32) (*TimelockScript, error) {
+	witnessScript, err := miniscript.Parse(policy, miniscript.P2WSH)
+	if err != nil {
+		return nil, err
+	}
+	leafScript, err := miniscript.Parse(strings.ReplaceAll(policy, "multi(", "multi_a("), miniscript.Tapscript)
+	if err != nil {
+		return nil, err
+	}
+	return &TimelockScript{Policy: policy, Older: older, After: after, witnessScript: witnessScript, leafScript: leafScript}, nil
+}
+
+// BlocksSequence is the BIP-68 relative timelock of n blocks.
+func BlocksSequence(n uint16) uint32 {
+	return uint32(n)
+}
+
+// SecondsSequence is the BIP-68 relative timelock of at least seconds, in
+// units of 512 seconds rounded up.
+func SecondsSequence(seconds uint32) uint32 {
+	return wire.SequenceLockTimeIsSeconds | (seconds+511)>>wire.SequenceLockTimeGranularity&wire.SequenceLockTimeMask
+}
+
+func checkOlder(sequence uint32) error {
+	if sequence&wire.SequenceLockTimeMask == 0 || sequence&^(wire.SequenceLockTimeIsSeconds|wire.SequenceLockTimeMask) != 0 {
+		return fmt.Errorf("%w: sequence %#x", ErrInvalidTimelock, sequence)
+	}
+	return nil
+}
+
+// NewRecoveryVault is spendable by primaryPubKey at any time and by
+// recoveryPubKey once the output is delay old, a BIP-68 sequence.
+func NewRecoveryVault(primaryPubKey, recoveryPubKey string, delay uint32) (*TimelockScript, error) {
+	if err := checkOlder(delay); err != nil {
+		return nil, err
+	}
+	return newTimelockScript(fmt.Sprintf("or_d(pk(%s),and_v(v:pk(%s),older(%d)))", primaryPubKey, recoveryPubKey, delay), delay, 0)
+}
+
+// NewVestingLock is spendable by pubKey from lockTime on, a block height or,
+// from LockTimeThreshold on, a unix timestamp.
+func NewVestingLock(pubKey string, lockTime uint32) (*TimelockScript, error) {
+	if lockTime == 0 {
+		return nil, ErrInvalidTimelock
+	}
+	return newTimelockScript(fmt.Sprintf("and_v(v:pk(%s),after(%d))", pubKey, lockTime), 0, lockTime)
+}
+
+// NewDecayingMultisig is a threshold-of-pubKeys multisig which only needs
+// decayedThreshold signatures once the output is delay old. It is a multi in
+// P2WSH and a multi_a in tapscript.
+func NewDecayingMultisig(pubKeys []string, threshold, decayedThreshold int, delay uint32) (*TimelockScript, error) {
+	if err := checkOlder(delay); err != nil {
+		return nil, err
+	}
+	if decayedThreshold < 1 || decayedThreshold >= threshold || threshold > len(pubKeys) {
+		return nil, errors.New("invalid multisig thresholds")
+	}
+	keys := strings.Join(pubKeys, ",")
+	return newTimelockScript(fmt.Sprintf("or_d(multi(%d,%s),and_v(v:multi(%d,%s),older(%d)))",
+		threshold, keys, decayedThreshold, keys, delay), delay, 0)
+}
+
+// WitnessScript returns the P2WSH witness script.
+func (s *TimelockScript) WitnessScript() ([]byte, error) {
+	return s.witnessScript.Script()
+}
+
+// P2WSHAddress returns the P2WSH address of the witness script.
+func (s *TimelockScript) P2WSHAddress(network *chaincfg.Params) (string, error) {
+	return s.witnessScript.P2WSHAddress(network)
+}
+
+// LeafScript returns the tapscript leaf.
+func (s *TimelockScript) LeafScript() ([]byte, error) {
+	return s.leafScript.Script()
+}
+
+// TaprootTree returns the tree whose single leaf is the script under
+// internalPubKey, TaprootNUMSKey disabling the key path when empty.
+func (s *TimelockScript) TaprootTree(internalPubKey string) (*TaprootScriptTree, error) {
+	if internalPubKey == "" {
+		internalPubKey = TaprootNUMSKey
+	}
+	script, err := s.LeafScript()
+	if err != nil {
+		return nil, err
+	}
+	return NewTaprootScriptTree(internalPubKey, []*TapLeafSpec{{Script: fmt.Sprintf("%x", script)}})
+}
+
+// TaprootAddress returns the address of TaprootTree.
+func (s *TimelockScript) TaprootAddress(internalPubKey string, network *chaincfg.Params) (string, error) {
+	tree, err := s.TaprootTree(internalPubKey)
+	if err != nil {
+		return "", err
+	}
+	return tree.Address(network)
+}
+
+// TimelockSpend is how TransactionBuilder spends a TimelockScript output.
+type TimelockSpend struct {
+	Script *TimelockScript
+	// Taproot spends the leaf of TaprootTree(InternalPubKey) instead of the
+	// P2WSH output.
+	Taproot        bool
+	InternalPubKey string
+	// PrivateKeys are the WIF keys signing the input.
+	PrivateKeys []string
+	// Timelocked takes the timelocked path: the input sequence is set to
+	// Script.Older. Script.After always raises the lock time.
+	Timelocked bool
+}
+
+func (s *TimelockSpend) pkScript(network *chaincfg.Params) ([]byte, error) {
+	if s.Script == nil {
+		return nil, errors.New("missing timelock script")
+	}
+	var addr string
+	var err error
+	if s.Taproot {
+		addr, err = s.Script.TaprootAddress(s.InternalPubKey, network)
+	} else {
+		addr, err = s.Script.P2WSHAddress(network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AddrToPkScript(addr, network)
+}
+
+// keySatisfier satisfies a miniscript with signatures made on the fly and the
+// timelocks of the transaction.
+type keySatisfier struct {
+	tx        *wire.MsgTx
+	index     int
+	keys      []*btcec.PrivateKey
+	sign      func(key *btcec.PrivateKey) ([]byte, error)
+	tapscript bool
+}
+
+func (s *keySatisfier) Signature(pubKey []byte) ([]byte, bool) {
+	for _, key := range s.keys {
+		var serialized []byte
+		if s.tapscript {
+			serialized = schnorr.SerializePubKey(key.PubKey())
+		} else {
+			serialized = key.PubKey().SerializeCompressed()
+		}
+		if string(serialized) != string(pubKey) {
+			continue
+		}
+		sig, err := s.sign(key)
+		return sig, err == nil
+	}
+	return nil, false
+}
+
+func (s *keySatisfier) Preimage(string, []byte) ([]byte, bool) {
+	return nil, false
+}
+
+func (s *keySatisfier) CheckOlder(n uint32) bool {
+	sequence := s.tx.TxIn[s.index].Sequence
+	if s.tx.Version < 2 || sequence&wire.SequenceLockTimeDisabled != 0 ||
+		(sequence&wire.SequenceLockTimeIsSeconds != 0) != (n&wire.SequenceLockTimeIsSeconds != 0) {
+		return false
+	}
+	return sequence&wire.SequenceLockTimeMask >= n&wire.SequenceLockTimeMask
+}
+
+func (s *keySatisfier) CheckAfter(n uint32) bool {
+	if s.tx.TxIn[s.index].Sequence == wire.MaxTxInSequenceNum ||
+		(s.tx.LockTime >= LockTimeThreshold) != (n >= LockTimeThreshold) {
+		return false
+	}
+	return s.tx.LockTime >= n
+}
+
+// signTimelockInput signs input i with the keys of spend and sets its witness.
+func signTimelockInput(tx *wire.MsgTx, i int, spend *TimelockSpend, prevOutFetcher txscript.PrevOutputFetcher) error {
+	var keys []*btcec.PrivateKey
+	for _, privateKey := range spend.PrivateKeys {
+		wif, err := btcutil.DecodeWIF(privateKey)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, wif.PrivKey)
+	}
+	prevOut := prevOutFetcher.FetchPrevOutput(tx.TxIn[i].PreviousOutPoint)
+	sigHashes := txscript.NewTxSigHashes(tx, prevOutFetcher)
+	satisfier := &keySatisfier{tx: tx, index: i, keys: keys, tapscript: spend.Taproot}
+	var m *miniscript.Miniscript
+	var tail [][]byte
+	if spend.Taproot {
+		m = spend.Script.leafScript
+		tree, err := spend.Script.TaprootTree(spend.InternalPubKey)
+		if err != nil {
+			return err
+		}
+		controlBlock, err := tree.ControlBlock(0)
+		if err != nil {
+			return err
+		}
+		leaf := tree.Leaves[0]
+		tail = [][]byte{leaf.Script, controlBlock}
+		satisfier.sign = func(key *btcec.PrivateKey) ([]byte, error) {
+			hash, err := txscript.CalcTapscriptSignaturehash(sigHashes, txscript.SigHashDefault, tx, i, prevOutFetcher, leaf)
+			if err != nil {
+				return nil, err
+			}
+			sig, err := schnorr.Sign(key, hash)
+			if err != nil {
+				return nil, err
+			}
+			return sig.Serialize(), nil
+		}
+	} else {
+		m = spend.Script.witnessScript
+		script, err := m.Script()
+		if err != nil {
+			return err
+		}
+		tail = [][]byte{script}
+		satisfier.sign = func(key *btcec.PrivateKey) ([]byte, error) {
+			hash, err := txscript.CalcWitnessSigHash(script, sigHashes, txscript.SigHashAll, tx, i, prevOut.Value)
+			if err != nil {
+				return nil, err
+			}
+			return append(ecdsa.Sign(key, hash).Serialize(), byte(txscript.SigHashAll)), nil
+		}
+	}
+	stack, err := m.Satisfy(satisfier)
+	if err != nil {
+		return fmt.Errorf("input %d: %w", i, err)
+	}
+	tx.TxIn[i].Witness = append(stack, tail...)
+	return nil
+}
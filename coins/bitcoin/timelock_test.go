@@ -0,0 +1,142 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func buildTimelockSpend(t *testing.T, spend *TimelockSpend, configure func(*TransactionBuilder)) (*wire.MsgTx, error) {
+	network := &chaincfg.TestNet3Params
+	var addr string
+	var err error
+	if spend.Taproot {
+		addr, err = spend.Script.TaprootAddress(spend.InternalPubKey, network)
+	} else {
+		addr, err = spend.Script.P2WSHAddress(network)
+	}
+	require.NoError(t, err)
+
+	txId := "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c"
+	txBuild := NewTxBuild(2, network)
+	txBuild.AddTimelockInput(txId, 0, 100000, spend)
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 99000)
+	if configure != nil {
+		configure(txBuild)
+	}
+	tx, err := txBuild.Build()
+	if err != nil {
+		return nil, err
+	}
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, PrevOutputs{{TxId: txId, VOut: 0, Amount: 100000, Address: addr}}, network)
+	return tx, nil
+}
+
+func TestRecoveryVault(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	vault, err := NewRecoveryVault(pubKeys[0], pubKeys[1], BlocksSequence(144))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(144), vault.Older)
+
+	for _, taproot := range []bool{false, true} {
+		tx, err := buildTimelockSpend(t, &TimelockSpend{Script: vault, Taproot: taproot, PrivateKeys: wifs[:1]}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, wire.MaxTxInSequenceNum, tx.TxIn[0].Sequence)
+
+		tx, err = buildTimelockSpend(t, &TimelockSpend{Script: vault, Taproot: taproot, PrivateKeys: wifs[1:], Timelocked: true}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(144), tx.TxIn[0].Sequence)
+
+		_, err = buildTimelockSpend(t, &TimelockSpend{Script: vault, Taproot: taproot, PrivateKeys: wifs[1:]}, nil)
+		assert.Error(t, err)
+	}
+
+	_, err = NewRecoveryVault(pubKeys[0], pubKeys[1], 0)
+	assert.ErrorIs(t, err, ErrInvalidTimelock)
+	assert.Equal(t, uint32(wire.SequenceLockTimeIsSeconds|2), SecondsSequence(1000))
+}
+
+func TestVestingLock(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 1, &chaincfg.TestNet3Params)
+	vesting, err := NewVestingLock(pubKeys[0], 2500000)
+	require.NoError(t, err)
+
+	for _, taproot := range []bool{false, true} {
+		spend := &TimelockSpend{Script: vesting, Taproot: taproot, PrivateKeys: wifs, Timelocked: true}
+		tx, err := buildTimelockSpend(t, spend, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2500000), tx.LockTime)
+		assert.Equal(t, wire.MaxTxInSequenceNum-1, tx.TxIn[0].Sequence)
+
+		// a later lock time is kept
+		tx, err = buildTimelockSpend(t, spend, func(build *TransactionBuilder) {
+			build.SetLockTime(2600000)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, uint32(2600000), tx.LockTime)
+
+		_, err = buildTimelockSpend(t, spend, func(build *TransactionBuilder) {
+			build.SetLockTime(1700000000)
+		})
+		assert.ErrorIs(t, err, ErrInvalidTimelock)
+	}
+}
+
+func TestDecayingMultisig(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 3, &chaincfg.TestNet3Params)
+	decaying, err := NewDecayingMultisig(pubKeys, 2, 1, BlocksSequence(1000))
+	require.NoError(t, err)
+
+	for _, taproot := range []bool{false, true} {
+		_, err := buildTimelockSpend(t, &TimelockSpend{Script: decaying, Taproot: taproot, PrivateKeys: wifs[:2]}, nil)
+		require.NoError(t, err)
+
+		_, err = buildTimelockSpend(t, &TimelockSpend{Script: decaying, Taproot: taproot, PrivateKeys: wifs[2:]}, nil)
+		assert.Error(t, err)
+
+		tx, err := buildTimelockSpend(t, &TimelockSpend{Script: decaying, Taproot: taproot, PrivateKeys: wifs[2:], Timelocked: true}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(1000), tx.TxIn[0].Sequence)
+	}
+
+	_, err = NewDecayingMultisig(pubKeys, 2, 2, BlocksSequence(1000))
+	assert.Error(t, err)
+}
+
+func TestTimelockBuilderErrors(t *testing.T) {
+	wifs, pubKeys := testKeys(t, 2, &chaincfg.TestNet3Params)
+	vault, err := NewRecoveryVault(pubKeys[0], pubKeys[1], BlocksSequence(144))
+	require.NoError(t, err)
+	addr, err := vault.P2WSHAddress(&chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	assert.Equal(t, 62, len(addr))
+
+	txBuild := NewTxBuild(1, &chaincfg.TestNet3Params)
+	txBuild.AddTimelockInput("0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", 0, 100000,
+		&TimelockSpend{Script: vault, PrivateKeys: wifs[1:], Timelocked: true})
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 99000)
+	_, err = txBuild.Build()
+	assert.ErrorIs(t, err, ErrInvalidTimelock)
+}
+
+func TestSetInputSequence(t *testing.T) {
+	txBuild := NewTxBuild(2, &chaincfg.TestNet3Params)
+	txBuild.AddInput("0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", 0,
+		"cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", "", "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 100000)
+	txBuild.AddInput("02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1", 1,
+		"cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", "", "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 100000)
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 199000)
+	txBuild.SetLockTime(2500000)
+	require.NoError(t, txBuild.SetInputSequence(1, 10))
+	assert.Error(t, txBuild.SetInputSequence(2, 10))
+
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2500000), tx.LockTime)
+	assert.Equal(t, wire.MaxTxInSequenceNum-1, tx.TxIn[0].Sequence)
+	assert.Equal(t, uint32(10), tx.TxIn[1].Sequence)
+}
@@ -20,6 +20,9 @@ type TransactionBuilder struct {
 	outputs   []Output
 	netParams *chaincfg.Params
 	tx        *wire.MsgTx
+	rbf       bool
+	forkId    bool
+	policy    *RelayPolicy
 }
 
 func (t *TransactionBuilder) TotalInputAmount() int64 {
@@ -45,12 +48,20 @@ type Input struct {
 	redeemScript  string
 	address       string
 	amount        int64
+	assets        []*UtxoAsset
+	token         *CashToken
+	sequence      *uint32
+	timelock      *TimelockSpend
 }
 
 type Output struct {
 	address string
 	script  string
 	amount  int64
+	token   *CashToken
+	// silentPayment outputs pay the sp1 address, their script is derived
+	// from the input keys when signing
+	silentPayment bool
 }
 
 func NewTxBuild(version int32, netParams *chaincfg.Params) *TransactionBuilder {
@@ -62,10 +73,148 @@ func NewTxBuild(version int32, netParams *chaincfg.Params) *TransactionBuilder {
 		outputs:   nil,
 		netParams: netParams,
 		tx:        &wire.MsgTx{Version: version, LockTime: 0},
+		forkId:    IsBCHNetwork(netParams),
+		policy:    RelayPolicyFor(netParams),
 	}
 	return builder
 }
 
+// SetRelayPolicy replaces the policy of the network, BSVRelayPolicy for
+// instance. The builder refuses the output types the policy lacks and
+// transactions above its standard weight, dust is left to the caller.
+func (build *TransactionBuilder) SetRelayPolicy(policy *RelayPolicy) {
+	build.policy = policy
+}
+
+// EnableForkID signs with the replay protected SIGHASH_ALL|FORKID digest of
+// BCH and BSV. It is on by default for the BCH params, BSV shares the BTC
+// params and must enable it.
+func (build *TransactionBuilder) EnableForkID() {
+	build.forkId = true
+}
+
+// sigHashType is the hash type appended to the signatures.
+func (build *TransactionBuilder) sigHashType() txscript.SigHashType {
+	if build.forkId {
+		return SigHashAllForkID
+	}
+	return txscript.SigHashAll
+}
+
+func (build *TransactionBuilder) signatureHash(script []byte, tx *wire.MsgTx, i int) ([]byte, error) {
+	if build.forkId {
+		return CalcForkIDSignatureHash(tx, i, script, build.inputs[i].amount, build.inputs[i].token, SigHashAllForkID)
+	}
+	return txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, i)
+}
+
+// outputScript returns the pkScript of output, its token prefix included.
+func (build *TransactionBuilder) outputScript(output Output) ([]byte, error) {
+	if output.silentPayment {
+		return nil, errors.New("silent payment outputs need the input private keys")
+	}
+	var pkScript []byte
+	var err error
+	if len(output.script) != 0 && len(output.address) == 0 {
+		pkScript, err = hex.DecodeString(output.script)
+	} else {
+		pkScript, err = AddrToPkScript(output.address, build.netParams)
+	}
+	if err != nil || output.token == nil {
+		return pkScript, err
+	}
+	if !build.forkId {
+		return nil, errors.New("cashtokens need fork id signing")
+	}
+	if !IsTokenAwareCashAddr(output.address, build.netParams) {
+		return nil, ErrNotTokenAware
+	}
+	prefix, err := output.token.Prefix()
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, pkScript...), nil
+}
+
+// checkCashTokens makes sure the fungible tokens of the inputs are all sent.
+func (build *TransactionBuilder) checkCashTokens(tx *wire.MsgTx) error {
+	if !build.forkId {
+		return nil
+	}
+	tokens := make([]*CashToken, len(build.inputs))
+	for i, input := range build.inputs {
+		tokens[i] = input.token
+	}
+	return checkCashTokenBalance(tx, tokens)
+}
+
+// EnableRBF makes every input signal BIP-125 replaceability.
+func (build *TransactionBuilder) EnableRBF() {
+	build.rbf = true
+}
+
+func (build *TransactionBuilder) sequence() uint32 {
+	if build.rbf {
+		return DefaultSequenceNum
+	}
+	return wire.MaxTxInSequenceNum
+}
+
+// SetLockTime sets nLockTime, a block height or, from LockTimeThreshold on, a
+// unix timestamp. Final inputs are then given the sequence 0xfffffffe so that
+// the lock time is enforced.
+func (build *TransactionBuilder) SetLockTime(lockTime uint32) {
+	build.tx.LockTime = lockTime
+}
+
+// SetInputSequence sets the nSequence of input index, overriding RBF and the
+// timelock of the input.
+func (build *TransactionBuilder) SetInputSequence(index int, sequence uint32) error {
+	if index < 0 || index >= len(build.inputs) {
+		return fmt.Errorf("invalid input index %d", index)
+	}
+	build.inputs[index].sequence = &sequence
+	return nil
+}
+
+func (build *TransactionBuilder) inputSequence(i int) uint32 {
+	input := build.inputs[i]
+	sequence := build.sequence()
+	if input.sequence != nil {
+		sequence = *input.sequence
+	} else if input.timelock != nil && input.timelock.Timelocked && input.timelock.Script.Older > 0 {
+		sequence = input.timelock.Script.Older
+	}
+	if sequence == wire.MaxTxInSequenceNum && build.tx.LockTime > 0 {
+		sequence = wire.MaxTxInSequenceNum - 1
+	}
+	return sequence
+}
+
+// applyTimelocks raises the lock time to the After of the timelock inputs.
+func (build *TransactionBuilder) applyTimelocks() error {
+	for _, input := range build.inputs {
+		if input.timelock == nil || input.timelock.Script == nil {
+			continue
+		}
+		script := input.timelock.Script
+		if input.timelock.Timelocked && script.Older > 0 && build.tx.Version < 2 {
+			return fmt.Errorf("%w: relative timelocks need version 2", ErrInvalidTimelock)
+		}
+		if script.After == 0 {
+			continue
+		}
+		lockTime := build.tx.LockTime
+		if lockTime > 0 && (lockTime >= LockTimeThreshold) != (script.After >= LockTimeThreshold) {
+			return fmt.Errorf("%w: height and time lock times mixed", ErrInvalidTimelock)
+		}
+		if script.After > lockTime {
+			build.tx.LockTime = script.After
+		}
+	}
+	return nil
+}
+
 func (build *TransactionBuilder) AppendInput(input Input) {
 	build.inputs = append(build.inputs, input)
 }
@@ -81,7 +230,12 @@ func (build *TransactionBuilder) UtxoViewpoint() (UtxoViewpoint, error) {
 			return nil, err
 		}
 		var script []byte
-		if len(v.redeemScript) > 0 {
+		if v.timelock != nil {
+			script, err = v.timelock.pkScript(build.netParams)
+			if err != nil {
+				return nil, err
+			}
+		} else if len(v.redeemScript) > 0 {
 			script, err = hex.DecodeString(v.redeemScript)
 			if err != nil {
 				return nil, err
@@ -113,6 +267,41 @@ func (build *TransactionBuilder) AddInput2(txId string, vOut uint32, privateKey
 	build.inputs = append(build.inputs, input)
 }
 
+// AddPrevOutput adds utxo as an input together with its assets, the build
+// then fails if an asset would be burned as fee or split across outputs.
+func (build *TransactionBuilder) AddPrevOutput(utxo *PrevOutput) {
+	build.inputs = append(build.inputs, Input{txId: utxo.TxId, vOut: utxo.VOut, privateKeyHex: utxo.PrivateKey,
+		address: utxo.Address, amount: utxo.Amount, assets: utxo.Assets})
+}
+
+// checkAssetFlow runs CheckAssetFlow on the inputs added with their assets.
+func (build *TransactionBuilder) checkAssetFlow(outputs []*wire.TxOut) error {
+	inputs := make(PrevOutputs, 0, len(build.inputs))
+	for _, input := range build.inputs {
+		inputs = append(inputs, &PrevOutput{Amount: input.amount, Assets: input.assets})
+	}
+	return CheckAssetFlow(inputs, outputs)
+}
+
+// AddTimelockInput adds an input spending an output of spend.Script.
+func (build *TransactionBuilder) AddTimelockInput(txId string, vOut uint32, amount int64, spend *TimelockSpend) {
+	input := Input{txId: txId, vOut: vOut, amount: amount, timelock: spend}
+	build.inputs = append(build.inputs, input)
+}
+
+// AddTokenInput adds a BCH input whose output carries token.
+func (build *TransactionBuilder) AddTokenInput(txId string, vOut uint32, privateKey string, address string, amount int64, token *CashToken) {
+	input := Input{txId: txId, vOut: vOut, privateKeyHex: privateKey, address: address, amount: amount, token: token}
+	build.inputs = append(build.inputs, input)
+}
+
+// AddTokenOutput adds a BCH output carrying token, address must be a
+// token-aware CashAddr.
+func (build *TransactionBuilder) AddTokenOutput(address string, amount int64, token *CashToken) {
+	output := Output{address: address, amount: amount, token: token}
+	build.outputs = append(build.outputs, output)
+}
+
 func (build *TransactionBuilder) AddOutput(address string, amount int64) {
 	output := Output{address: address, amount: amount}
 	build.outputs = append(build.outputs, output)
@@ -123,14 +312,65 @@ func (build *TransactionBuilder) AddOutput2(address string, script string, amoun
 	build.outputs = append(build.outputs, output)
 }
 
+// AddSilentPaymentOutput pays amount to the sp1 address. Its taproot output
+// is derived from the input keys by Build, so only Build supports it.
+func (build *TransactionBuilder) AddSilentPaymentOutput(address string, amount int64) error {
+	if !IsSilentPaymentAddress(address, build.netParams) {
+		return ErrInvalidSilentPaymentAddress
+	}
+	build.outputs = append(build.outputs, Output{address: address, amount: amount, silentPayment: true})
+	return nil
+}
+
+// silentPaymentScripts returns the pkScripts of the silent payment outputs by
+// output index.
+func (build *TransactionBuilder) silentPaymentScripts(tx *wire.MsgTx, privateKeys []*btcec.PrivateKey,
+	prevOutFetcher txscript.PrevOutputFetcher) (map[int][]byte, error) {
+	var indexes []int
+	var recipients []string
+	for i, output := range build.outputs {
+		if output.silentPayment {
+			indexes = append(indexes, i)
+			recipients = append(recipients, output.address)
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, nil
+	}
+	outPoints := make([]wire.OutPoint, len(tx.TxIn))
+	prevPkScripts := make([][]byte, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		outPoints[i] = in.PreviousOutPoint
+		if timelock := build.inputs[i].timelock; timelock != nil && timelock.Taproot &&
+			(timelock.InternalPubKey == "" || timelock.InternalPubKey == TaprootNUMSKey) {
+			// a script path spend under the NUMS key is not eligible
+			continue
+		}
+		prevPkScripts[i] = prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint).PkScript
+	}
+	scripts, err := silentPaymentScripts(privateKeys, outPoints, prevPkScripts, recipients, build.netParams)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[int][]byte, len(scripts))
+	for i, script := range scripts {
+		res[indexes[i]] = script
+	}
+	return res, nil
+}
+
 func (build *TransactionBuilder) Build() (*wire.MsgTx, error) {
 	if len(build.inputs) == 0 || len(build.outputs) == 0 {
 		return nil, errors.New("invalid inputs or outputs")
 	}
 
+	if err := build.applyTimelocks(); err != nil {
+		return nil, err
+	}
 	tx := build.tx
 	prevOutFetcher := txscript.NewMultiPrevOutFetcher(nil)
 	var privateKeys []*btcec.PrivateKey
+	hasTimelock := false
 	for i := 0; i < len(build.inputs); i++ {
 		input := build.inputs[i]
 		txHash, err := chainhash.NewHashFromStr(input.txId)
@@ -138,15 +378,27 @@ func (build *TransactionBuilder) Build() (*wire.MsgTx, error) {
 			return nil, err
 		}
 		outPoint := wire.NewOutPoint(txHash, input.vOut)
-		pkScript, err := AddrToPkScript(input.address, build.netParams)
+		var pkScript []byte
+		if input.timelock != nil {
+			pkScript, err = input.timelock.pkScript(build.netParams)
+		} else {
+			pkScript, err = AddrToPkScript(input.address, build.netParams)
+		}
 		if err != nil {
 			return nil, err
 		}
 		txOut := wire.NewTxOut(input.amount, pkScript)
 		prevOutFetcher.AddPrevOut(*outPoint, txOut)
 		txIn := wire.NewTxIn(outPoint, nil, nil)
+		txIn.Sequence = build.inputSequence(i)
 		tx.TxIn = append(tx.TxIn, txIn)
 
+		if input.timelock != nil {
+			// signed once the other inputs are
+			hasTimelock = true
+			privateKeys = append(privateKeys, nil)
+			continue
+		}
 		wif, err := btcutil.DecodeWIF(input.privateKeyHex)
 		if err != nil {
 			return nil, err
@@ -154,26 +406,54 @@ func (build *TransactionBuilder) Build() (*wire.MsgTx, error) {
 		privateKeys = append(privateKeys, wif.PrivKey)
 	}
 
+	silentPaymentScripts, err := build.silentPaymentScripts(tx, privateKeys, prevOutFetcher)
+	if err != nil {
+		return nil, err
+	}
 	for i := 0; i < len(build.outputs); i++ {
-		output := build.outputs[i]
-
-		var pkScript []byte
-		var err error
-		if len(output.script) != 0 && len(output.address) == 0 {
-			pkScript, err = hex.DecodeString(output.script)
+		pkScript, ok := silentPaymentScripts[i]
+		if !ok {
+			pkScript, err = build.outputScript(build.outputs[i])
 			if err != nil {
 				return nil, err
 			}
-		} else {
-			pkScript, err = AddrToPkScript(output.address, build.netParams)
+		}
+		txOut := wire.NewTxOut(build.outputs[i].amount, pkScript)
+		tx.TxOut = append(tx.TxOut, txOut)
+	}
+	if err := build.checkAssetFlow(tx.TxOut); err != nil {
+		return nil, err
+	}
+	if err := build.policy.CheckOutputTypes(tx.TxOut); err != nil {
+		return nil, err
+	}
+	if build.forkId && hasTimelock {
+		return nil, errors.New("timelock inputs cannot be signed with fork id")
+	}
+	if build.forkId {
+		if err := build.checkCashTokens(tx); err != nil {
+			return nil, err
+		}
+		for i, in := range tx.TxIn {
+			prevOut := prevOutFetcher.FetchPrevOutput(in.PreviousOutPoint)
+			sigScript, err := forkIDSignatureScript(tx, i, prevOut.PkScript, prevOut.Value, build.inputs[i].token, privateKeys[i])
 			if err != nil {
 				return nil, err
 			}
+			in.SignatureScript = sigScript
+		}
+	} else if err := Sign(tx, privateKeys, prevOutFetcher); err != nil {
+		return nil, err
+	}
+	for i, input := range build.inputs {
+		if input.timelock == nil {
+			continue
+		}
+		if err := signTimelockInput(tx, i, input.timelock, prevOutFetcher); err != nil {
+			return nil, err
 		}
-		txOut := wire.NewTxOut(output.amount, pkScript)
-		tx.TxOut = append(tx.TxOut, txOut)
 	}
-	if err := Sign(tx, privateKeys, prevOutFetcher); err != nil {
+	if err := build.policy.checkWeight(tx); err != nil {
 		return nil, err
 	}
 	return tx, nil
@@ -223,27 +503,32 @@ func (build *TransactionBuilder) SingleBuild() (string, error) {
 		}
 		outPoint := wire.NewOutPoint(hash, input.vOut)
 		txIn := wire.NewTxIn(outPoint, signatureScript, nil)
+		txIn.Sequence = build.inputSequence(i)
 		tx.TxIn = append(tx.TxIn, txIn)
 	}
 
 	for i := 0; i < len(build.outputs); i++ {
-		output := build.outputs[i]
-		address, err := btcutil.DecodeAddress(output.address, build.netParams)
+		script, err := build.outputScript(build.outputs[i])
 		if err != nil {
 			return "", err
 		}
-		script, err := txscript.PayToAddrScript(address)
-		if err != nil {
-			return "", err
-		}
-		txOut := wire.NewTxOut(output.amount, script)
+		txOut := wire.NewTxOut(build.outputs[i].amount, script)
 		tx.TxOut = append(tx.TxOut, txOut)
 	}
+	if err := build.checkAssetFlow(tx.TxOut); err != nil {
+		return "", err
+	}
+	if err := build.policy.CheckOutputTypes(tx.TxOut); err != nil {
+		return "", err
+	}
+	if err := build.checkCashTokens(tx); err != nil {
+		return "", err
+	}
 
 	for i := 0; i < len(build.inputs); i++ {
 		ecKey := ecKeyArray[i]
 		redeemScript := scriptArray[i]
-		sigHash, err := txscript.CalcSignatureHash(redeemScript, txscript.SigHashAll, tx, i)
+		sigHash, err := build.signatureHash(redeemScript, tx, i)
 		if err != nil {
 			return "", err
 		}
@@ -254,7 +539,7 @@ func (build *TransactionBuilder) SingleBuild() (string, error) {
 		} else {
 			redeemScript = ecKey.PubKey().SerializeCompressed()
 		}
-		sig1 := append(sign.Serialize(), byte(txscript.SigHashAll))
+		sig1 := append(sign.Serialize(), byte(build.sigHashType()))
 		scriptBuilder, err := builder.AddData(sig1).AddData(redeemScript).Script()
 		if err != nil {
 			return "", err
@@ -354,34 +639,39 @@ func (build *TransactionBuilder) UnSignedTx(pubKeyMap map[int]string) (string, m
 		}
 		outPoint := wire.NewOutPoint(hash, input.vOut)
 		txIn := wire.NewTxIn(outPoint, signatureScript, nil)
+		txIn.Sequence = build.inputSequence(i)
 		tx.TxIn = append(tx.TxIn, txIn)
 	}
 
 	for i := 0; i < len(build.outputs); i++ {
-		output := build.outputs[i]
-		address, err := btcutil.DecodeAddress(output.address, build.netParams)
-		if err != nil {
-			return "", nil, err
-		}
-		script, err := txscript.PayToAddrScript(address)
+		script, err := build.outputScript(build.outputs[i])
 		if err != nil {
 			return "", nil, err
 		}
-		txOut := wire.NewTxOut(output.amount, script)
+		txOut := wire.NewTxOut(build.outputs[i].amount, script)
 		tx.TxOut = append(tx.TxOut, txOut)
 	}
+	if err := build.checkAssetFlow(tx.TxOut); err != nil {
+		return "", nil, err
+	}
+	if err := build.policy.CheckOutputTypes(tx.TxOut); err != nil {
+		return "", nil, err
+	}
+	if err := build.checkCashTokens(tx); err != nil {
+		return "", nil, err
+	}
 
 	hashes := make(map[int]string)
 	for i := 0; i < len(build.inputs); i++ {
 		redeemScript := scriptArray[i]
-		sigHash, err := txscript.CalcSignatureHash(redeemScript, txscript.SigHashAll, tx, i)
+		sigHash, err := build.signatureHash(redeemScript, tx, i)
 		if err != nil {
 			return "", nil, err
 		}
 		hashes[i] = hex.EncodeToString(sigHash)
 
 		builder := txscript.NewScriptBuilder()
-		sig1 := append(make([]byte, 70), byte(txscript.SigHashAll))
+		sig1 := append(make([]byte, 70), byte(build.sigHashType()))
 		scriptBuilder, err := builder.AddData(sig1).AddData(redeemScript).Script()
 		if err != nil {
 			return "", nil, err
@@ -397,6 +687,18 @@ func (build *TransactionBuilder) UnSignedTx(pubKeyMap map[int]string) (string, m
 }
 
 func SignTx(raw string, pubKeyMap map[int]string, signatureMap map[int]string) (string, error) {
+	return SignTxWithSigHashType(raw, pubKeyMap, signatureMap, txscript.SigHashAll)
+}
+
+// SignTx is SignTx for the hashes UnSignedTx of the builder returned, the
+// signatures get SigHashAllForkID when fork id is enabled.
+func (build *TransactionBuilder) SignTx(raw string, pubKeyMap map[int]string, signatureMap map[int]string) (string, error) {
+	return SignTxWithSigHashType(raw, pubKeyMap, signatureMap, build.sigHashType())
+}
+
+// SignTxWithSigHashType is SignTx for signatures over another hash type, such
+// as SigHashAllForkID for the hashes UnSignedTx returns on BCH and BSV.
+func SignTxWithSigHashType(raw string, pubKeyMap map[int]string, signatureMap map[int]string, hashType txscript.SigHashType) (string, error) {
 	txBytes, err := hex.DecodeString(raw)
 	if err != nil {
 		return "", err
@@ -419,7 +721,7 @@ func SignTx(raw string, pubKeyMap map[int]string, signatureMap map[int]string) (
 			return "", err
 		}
 		redeemScript := publicKey.SerializeCompressed()
-		sig1 := append(util.RemoveZeroHex(signatureMap[i]), byte(txscript.SigHashAll))
+		sig1 := append(util.RemoveZeroHex(signatureMap[i]), byte(hashType))
 		scriptBuilder, err := builder.AddData(sig1).AddData(redeemScript).Script()
 		if err != nil {
 			return "", err
@@ -452,7 +754,7 @@ func CalcTxVirtualSize(inputs TxInputs, outputs []*TxOutput, changeAddress strin
 	}
 
 	if minChangeValue == 0 {
-		minChangeValue = DefaultMinChangeValue
+		minChangeValue = RelayPolicyFor(network).ChangeThreshold()
 	}
 	if inAmount-outAmount > minChangeValue {
 		txBuild.AddOutput(changeAddress, inAmount-outAmount)
@@ -0,0 +1,484 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Lock time kinds of DecodedTx.LockTimeType.
+const (
+	LockTimeNone      = "none"
+	LockTimeHeight    = "height"
+	LockTimeTimestamp = "timestamp"
+)
+
+// Payload kinds of an OP_RETURN output.
+const (
+	OpReturnRunestone = "runestone"
+	OpReturnAtomicals = "atomicals"
+	OpReturnText      = "text"
+	OpReturnData      = "data"
+)
+
+// sigHashMask selects the base sighash type, as txscript does.
+const sigHashMask = 0x1f
+
+var atomicalsMarker = []byte("atom")
+
+// DecodedTx explains a transaction or PSBT for a signing confirmation.
+type DecodedTx struct {
+	TxId     string `json:"txId"`
+	Version  int32  `json:"version"`
+	IsPsbt   bool   `json:"isPsbt"`
+	Complete bool   `json:"complete"`
+	// Weight and VSize are estimated for PSBTs which are not finalized.
+	Weight    int64 `json:"weight"`
+	VSize     int64 `json:"vSize"`
+	Estimated bool  `json:"estimated"`
+	// RBF is set when an input signals BIP-125 replaceability.
+	RBF          bool   `json:"rbf"`
+	LockTime     uint32 `json:"lockTime"`
+	LockTimeType string `json:"lockTimeType"`
+	// LockTimeDate is the UTC date of a timestamp lock time.
+	LockTimeDate string `json:"lockTimeDate,omitempty"`
+	// LockTimeEnforced is false when every input is final.
+	LockTimeEnforced bool             `json:"lockTimeEnforced"`
+	Inputs           []*DecodedInput  `json:"inputs"`
+	Outputs          []*DecodedOutput `json:"outputs"`
+	InputAmount      int64            `json:"inputAmount"`
+	OutputAmount     int64            `json:"outputAmount"`
+	// FeeKnown is set when the amount of every input is known.
+	FeeKnown bool    `json:"feeKnown"`
+	Fee      int64   `json:"fee"`
+	FeeRate  float64 `json:"feeRate"`
+}
+
+type DecodedInput struct {
+	TxId       string `json:"txId"`
+	VOut       uint32 `json:"vOut"`
+	Sequence   uint32 `json:"sequence"`
+	ScriptType string `json:"scriptType"`
+	Address    string `json:"address,omitempty"`
+	// Amount is only meaningful when AmountKnown is set.
+	Amount      int64 `json:"amount"`
+	AmountKnown bool  `json:"amountKnown"`
+	// RelativeLockBlocks and RelativeLockSeconds are the BIP-68 lock of the input.
+	RelativeLockBlocks  uint32              `json:"relativeLockBlocks,omitempty"`
+	RelativeLockSeconds uint32              `json:"relativeLockSeconds,omitempty"`
+	Signatures          []*DecodedSignature `json:"signatures,omitempty"`
+
+	pkScript []byte
+}
+
+type DecodedSignature struct {
+	PubKey      string `json:"pubKey,omitempty"`
+	SigHashType uint32 `json:"sigHashType"`
+	SigHash     string `json:"sigHash"`
+	Schnorr     bool   `json:"schnorr"`
+}
+
+type DecodedOutput struct {
+	VOut       uint32           `json:"vOut"`
+	Amount     int64            `json:"amount"`
+	ScriptType string           `json:"scriptType"`
+	Address    string           `json:"address,omitempty"`
+	PkScript   string           `json:"pkScript"`
+	OpReturn   *OpReturnPayload `json:"opReturn,omitempty"`
+}
+
+type OpReturnPayload struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+	Text string `json:"text,omitempty"`
+}
+
+// SigHashName returns the name of a sighash type, such as ALL|ANYONECANPAY.
+func SigHashName(hashType txscript.SigHashType) string {
+	if hashType == txscript.SigHashDefault {
+		return "DEFAULT"
+	}
+	var name string
+	switch hashType & sigHashMask {
+	case txscript.SigHashAll:
+		name = "ALL"
+	case txscript.SigHashNone:
+		name = "NONE"
+	case txscript.SigHashSingle:
+		name = "SINGLE"
+	default:
+		name = fmt.Sprintf("UNKNOWN(%#x)", uint32(hashType))
+	}
+	if hashType&SigHashForkID != 0 {
+		name += "|FORKID"
+	}
+	if hashType&txscript.SigHashAnyOneCanPay != 0 {
+		name += "|ANYONECANPAY"
+	}
+	return name
+}
+
+// ClassifyOpReturn classifies the payload of an OP_RETURN pkScript, nil when
+// pkScript is not one.
+func ClassifyOpReturn(pkScript []byte) *OpReturnPayload {
+	if len(pkScript) == 0 || pkScript[0] != txscript.OP_RETURN {
+		return nil
+	}
+	if len(pkScript) > 1 && pkScript[1] == txscript.OP_13 {
+		return &OpReturnPayload{Type: OpReturnRunestone, Data: hex.EncodeToString(pkScript[2:])}
+	}
+	pushes, err := txscript.PushedData(pkScript[1:])
+	if err != nil {
+		return &OpReturnPayload{Type: OpReturnData, Data: hex.EncodeToString(pkScript[1:])}
+	}
+	data := bytes.Join(pushes, nil)
+	res := &OpReturnPayload{Type: OpReturnData, Data: hex.EncodeToString(data)}
+	if len(pushes) > 0 && bytes.Equal(pushes[0], atomicalsMarker) {
+		res.Type = OpReturnAtomicals
+	} else if len(data) > 0 && isPrintableText(data) {
+		res.Type = OpReturnText
+		res.Text = string(data)
+	}
+	return res
+}
+
+func isPrintableText(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func scriptTypeName(pkScript []byte) string {
+	return txscript.GetScriptClass(pkScript).String()
+}
+
+func scriptAddress(pkScript []byte, network *chaincfg.Params) string {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, network)
+	if err != nil || len(addrs) != 1 {
+		return ""
+	}
+	return addrs[0].EncodeAddress()
+}
+
+// inferInputScriptType guesses the type of a spent output from its signed
+// input, for inputs without a known prevout.
+func inferInputScriptType(in *wire.TxIn) string {
+	pushes, _ := txscript.PushedData(in.SignatureScript)
+	switch {
+	case len(in.SignatureScript) == 0 && len(in.Witness) == 2 && len(in.Witness[1]) == 33:
+		return txscript.WitnessV0PubKeyHashTy.String()
+	case len(in.SignatureScript) == 0 && len(in.Witness) == 1 && (len(in.Witness[0]) == 64 || len(in.Witness[0]) == 65):
+		return txscript.WitnessV1TaprootTy.String()
+	case len(in.Witness) == 0 && len(pushes) == 2 && (len(pushes[1]) == 33 || len(pushes[1]) == 65):
+		return txscript.PubKeyHashTy.String()
+	case len(pushes) == 1 && len(in.Witness) > 0:
+		return txscript.ScriptHashTy.String()
+	}
+	return ""
+}
+
+// ecdsaSignature reports whether b is a DER signature followed by its sighash byte.
+func ecdsaSignature(b []byte) bool {
+	if len(b) < 9 || len(b) > 73 || b[0] != 0x30 {
+		return false
+	}
+	_, err := ecdsa.ParseDERSignature(b[:len(b)-1])
+	return err == nil
+}
+
+func schnorrSignature(b []byte) (*DecodedSignature, bool) {
+	if len(b) != schnorr.SignatureSize && len(b) != schnorr.SignatureSize+1 {
+		return nil, false
+	}
+	if _, err := schnorr.ParseSignature(b[:schnorr.SignatureSize]); err != nil {
+		return nil, false
+	}
+	hashType := txscript.SigHashDefault
+	if len(b) == schnorr.SignatureSize+1 {
+		hashType = txscript.SigHashType(b[schnorr.SignatureSize])
+	}
+	return &DecodedSignature{SigHashType: uint32(hashType), SigHash: SigHashName(hashType), Schnorr: true}, true
+}
+
+func ecdsaDecodedSignature(sig, pubKey []byte) *DecodedSignature {
+	hashType := txscript.SigHashType(sig[len(sig)-1])
+	res := &DecodedSignature{SigHashType: uint32(hashType), SigHash: SigHashName(hashType)}
+	if len(pubKey) == 33 || len(pubKey) == 65 {
+		res.PubKey = hex.EncodeToString(pubKey)
+	}
+	return res
+}
+
+// inputSignatures finds the signatures in the script sig and witness of in.
+func inputSignatures(in *wire.TxIn, taproot bool) []*DecodedSignature {
+	var res []*DecodedSignature
+	if taproot {
+		witness := in.Witness
+		if len(witness) > 1 && len(witness[len(witness)-1]) > 0 && witness[len(witness)-1][0] == txscript.TaprootAnnexTag {
+			witness = witness[:len(witness)-1]
+		}
+		if len(witness) > 1 {
+			// script path: the script and control block are not signatures
+			witness = witness[:len(witness)-2]
+		}
+		for _, item := range witness {
+			if sig, ok := schnorrSignature(item); ok {
+				res = append(res, sig)
+			}
+		}
+		return res
+	}
+	pushes, _ := txscript.PushedData(in.SignatureScript)
+	for _, stack := range [][][]byte{pushes, in.Witness} {
+		for i, item := range stack {
+			if !ecdsaSignature(item) {
+				continue
+			}
+			var pubKey []byte
+			if i+1 < len(stack) {
+				pubKey = stack[i+1]
+			}
+			res = append(res, ecdsaDecodedSignature(item, pubKey))
+		}
+	}
+	return res
+}
+
+// psbtInputSignatures returns the partial signatures of a PSBT input.
+func psbtInputSignatures(in *psbt.PInput) []*DecodedSignature {
+	var res []*DecodedSignature
+	for _, sig := range in.PartialSigs {
+		if len(sig.Signature) > 0 {
+			res = append(res, ecdsaDecodedSignature(sig.Signature, sig.PubKey))
+		}
+	}
+	if len(in.TaprootKeySpendSig) > 0 {
+		if sig, ok := schnorrSignature(in.TaprootKeySpendSig); ok {
+			res = append(res, sig)
+		}
+	}
+	for _, s := range in.TaprootScriptSpendSig {
+		hashType := s.SigHash
+		res = append(res, &DecodedSignature{
+			PubKey:      hex.EncodeToString(s.XOnlyPubKey),
+			SigHashType: uint32(hashType),
+			SigHash:     SigHashName(hashType),
+			Schnorr:     true,
+		})
+	}
+	return res
+}
+
+// DecodeTransaction explains raw, a hex transaction or a hex or base64 PSBT.
+// prevOuts gives the amounts and addresses of spent outputs the PSBT does not
+// carry; without them the fee of a raw transaction is unknown.
+func DecodeTransaction(raw string, prevOuts PrevOutputs, network *chaincfg.Params) (*DecodedTx, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	raw = strings.TrimSpace(raw)
+	if !strings.HasPrefix(raw, "70736274ff") {
+		if tx, err := NewTxFromHex(raw); err == nil {
+			return decodeTx(tx, nil, prevOuts, network)
+		}
+	}
+	packet, err := ParsePsbtPacket(raw)
+	if err != nil {
+		return nil, fmt.Errorf("neither a transaction nor a psbt: %w", err)
+	}
+	return decodePsbt(packet, prevOuts, network)
+}
+
+// DecodeTransactionJSON is DecodeTransaction encoded as JSON.
+func DecodeTransactionJSON(raw string, prevOuts PrevOutputs, network *chaincfg.Params) (string, error) {
+	decoded, err := DecodeTransaction(raw, prevOuts, network)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(decoded)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodePsbt(packet *PsbtPacket, prevOuts PrevOutputs, network *chaincfg.Params) (*DecodedTx, error) {
+	p := packet.Packet
+	complete := p.IsComplete()
+	tx := p.UnsignedTx.Copy()
+	if lockTime, err := packet.lockTime(); err == nil {
+		tx.LockTime = lockTime
+	}
+	if complete {
+		// the finalized transaction, for its real size
+		finalPacket := *p
+		finalPacket.UnsignedTx = tx
+		if extracted, err := psbt.Extract(&finalPacket); err == nil {
+			tx = extracted
+		}
+	}
+	if err := checkPsbtUtxos(p); err != nil {
+		return nil, err
+	}
+	fetcher := psbtPrevOutFetcher(p)
+	utxos := make([]*wire.TxOut, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		utxos[i] = fetcher.FetchPrevOutput(in.PreviousOutPoint)
+	}
+	decoded, err := decodeTx(tx, utxos, prevOuts, network)
+	if err != nil {
+		return nil, err
+	}
+	decoded.IsPsbt = true
+	decoded.Complete = complete
+	for i, in := range decoded.Inputs {
+		if len(p.Inputs[i].FinalScriptSig) == 0 && len(p.Inputs[i].FinalScriptWitness) == 0 {
+			in.Signatures = psbtInputSignatures(&p.Inputs[i])
+		}
+	}
+	if !complete {
+		decoded.estimateSize(tx)
+	}
+	return decoded, nil
+}
+
+// decodeTx explains tx, utxos being the known spent outputs by input.
+func decodeTx(tx *wire.MsgTx, utxos []*wire.TxOut, prevOuts PrevOutputs, network *chaincfg.Params) (*DecodedTx, error) {
+	weight := int64(tx.SerializeSizeStripped()*(WitnessScaleFactor-1) + tx.SerializeSize())
+	res := &DecodedTx{
+		TxId:     tx.TxHash().String(),
+		Version:  tx.Version,
+		Complete: true,
+		Weight:   weight,
+		VSize:    (weight + WitnessScaleFactor - 1) / WitnessScaleFactor,
+		LockTime: tx.LockTime,
+		FeeKnown: true,
+	}
+	switch {
+	case tx.LockTime == 0:
+		res.LockTimeType = LockTimeNone
+	case tx.LockTime < LockTimeThreshold:
+		res.LockTimeType = LockTimeHeight
+	default:
+		res.LockTimeType = LockTimeTimestamp
+		res.LockTimeDate = time.Unix(int64(tx.LockTime), 0).UTC().Format(time.RFC3339)
+	}
+
+	for i, in := range tx.TxIn {
+		input := &DecodedInput{
+			TxId:     in.PreviousOutPoint.Hash.String(),
+			VOut:     in.PreviousOutPoint.Index,
+			Sequence: in.Sequence,
+		}
+		if in.Sequence < wire.MaxTxInSequenceNum-1 {
+			res.RBF = true
+		}
+		if in.Sequence != wire.MaxTxInSequenceNum && tx.LockTime > 0 {
+			res.LockTimeEnforced = true
+		}
+		if tx.Version >= 2 && in.Sequence&wire.SequenceLockTimeDisabled == 0 {
+			value := in.Sequence & wire.SequenceLockTimeMask
+			if in.Sequence&wire.SequenceLockTimeIsSeconds != 0 {
+				input.RelativeLockSeconds = value << wire.SequenceLockTimeGranularity
+			} else {
+				input.RelativeLockBlocks = value
+			}
+		}
+
+		var pkScript []byte
+		if i < len(utxos) && utxos[i] != nil {
+			pkScript = utxos[i].PkScript
+			input.Amount, input.AmountKnown = utxos[i].Value, true
+		}
+		for _, prevOut := range prevOuts {
+			if prevOut.TxId != input.TxId || prevOut.VOut != input.VOut {
+				continue
+			}
+			if !input.AmountKnown {
+				input.Amount, input.AmountKnown = prevOut.Amount, true
+			}
+			if pkScript == nil && prevOut.Address != "" {
+				pkScript, _ = AddrToPkScript(prevOut.Address, network)
+			}
+		}
+		input.pkScript = pkScript
+		if pkScript != nil {
+			input.ScriptType = scriptTypeName(pkScript)
+			input.Address = scriptAddress(pkScript, network)
+		} else {
+			input.ScriptType = inferInputScriptType(in)
+		}
+		input.Signatures = inputSignatures(in, input.ScriptType == txscript.WitnessV1TaprootTy.String())
+		if input.AmountKnown {
+			res.InputAmount += input.Amount
+		} else {
+			res.FeeKnown = false
+		}
+		res.Inputs = append(res.Inputs, input)
+	}
+
+	for i, out := range tx.TxOut {
+		res.Outputs = append(res.Outputs, &DecodedOutput{
+			VOut:       uint32(i),
+			Amount:     out.Value,
+			ScriptType: scriptTypeName(out.PkScript),
+			Address:    scriptAddress(out.PkScript, network),
+			PkScript:   hex.EncodeToString(out.PkScript),
+			OpReturn:   ClassifyOpReturn(out.PkScript),
+		})
+		res.OutputAmount += out.Value
+	}
+	res.updateFee()
+	return res, nil
+}
+
+// estimateSize replaces the size of the unsigned tx by an estimate of the
+// signed one, inputs of a type InputWeight does not know count as they are.
+func (d *DecodedTx) estimateSize(tx *wire.MsgTx) {
+	hasWitness := false
+	weight := int64(0)
+	for i, in := range tx.TxIn {
+		pkScript := d.Inputs[i].pkScript
+		if w, err := InputWeight(pkScript); err == nil {
+			weight += w
+			hasWitness = hasWitness || !txscript.IsPayToPubKeyHash(pkScript)
+			continue
+		}
+		weight += int64(in.SerializeSize() * WitnessScaleFactor)
+	}
+	for _, out := range tx.TxOut {
+		weight += OutputWeight(out.PkScript)
+	}
+	weight += int64(TxOverheadWeight(len(tx.TxIn), len(tx.TxOut), hasWitness))
+	d.Weight = weight
+	d.VSize = (weight + WitnessScaleFactor - 1) / WitnessScaleFactor
+	d.Estimated = true
+	d.updateFee()
+}
+
+func (d *DecodedTx) updateFee() {
+	if !d.FeeKnown {
+		return
+	}
+	d.Fee = d.InputAmount - d.OutputAmount
+	if d.VSize > 0 {
+		d.FeeRate = float64(d.Fee) / float64(d.VSize)
+	}
+}
@@ -0,0 +1,185 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/btcutil/psbt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDecodeTransaction(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	prevOutputs := PrevOutputs{
+		{TxId: "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", VOut: 0, Amount: 100000, Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+		{TxId: "02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1", VOut: 1, Amount: 50000, Address: "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE"},
+	}
+	txBuild := NewTxBuild(2, network)
+	for _, v := range prevOutputs {
+		txBuild.AddInput2(v.TxId, v.VOut, "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", v.Address, v.Amount)
+	}
+	txBuild.AddOutput("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 140000)
+	txBuild.AddOutput2("", "6a0b68656c6c6f20776f726c64", 0)
+	txBuild.EnableRBF()
+	txBuild.SetLockTime(2500000)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+
+	decoded, err := DecodeTransaction(txHex, prevOutputs, network)
+	require.NoError(t, err)
+	assert.Equal(t, tx.TxHash().String(), decoded.TxId)
+	assert.False(t, decoded.IsPsbt)
+	assert.True(t, decoded.RBF)
+	assert.Equal(t, LockTimeHeight, decoded.LockTimeType)
+	assert.True(t, decoded.LockTimeEnforced)
+	require.Equal(t, 2, len(decoded.Inputs))
+	assert.Equal(t, "witness_v0_keyhash", decoded.Inputs[0].ScriptType)
+	assert.Equal(t, "pubkeyhash", decoded.Inputs[1].ScriptType)
+	assert.Equal(t, "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE", decoded.Inputs[1].Address)
+	for _, in := range decoded.Inputs {
+		require.Equal(t, 1, len(in.Signatures))
+		assert.Equal(t, "ALL", in.Signatures[0].SigHash)
+		assert.Equal(t, 66, len(in.Signatures[0].PubKey))
+	}
+	assert.Equal(t, "witness_v1_taproot", decoded.Outputs[0].ScriptType)
+	assert.Equal(t, "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", decoded.Outputs[0].Address)
+	require.NotNil(t, decoded.Outputs[1].OpReturn)
+	assert.Equal(t, OpReturnText, decoded.Outputs[1].OpReturn.Type)
+	assert.Equal(t, "hello world", decoded.Outputs[1].OpReturn.Text)
+	assert.True(t, decoded.FeeKnown)
+	assert.Equal(t, int64(10000), decoded.Fee)
+	assert.Equal(t, GetTxVirtualSize(btcutil.NewTx(tx)), decoded.VSize)
+	assert.InDelta(t, float64(10000)/float64(decoded.VSize), decoded.FeeRate, 1e-9)
+
+	// without prevouts the input types are inferred and the fee is unknown
+	decoded, err = DecodeTransaction(txHex, nil, network)
+	require.NoError(t, err)
+	assert.False(t, decoded.FeeKnown)
+	assert.Equal(t, "witness_v0_keyhash", decoded.Inputs[0].ScriptType)
+	assert.Equal(t, "pubkeyhash", decoded.Inputs[1].ScriptType)
+
+	res, err := DecodeTransactionJSON(txHex, prevOutputs, network)
+	require.NoError(t, err)
+	var m map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(res), &m))
+	assert.Equal(t, float64(10000), m["fee"])
+
+	_, err = DecodeTransaction("00", nil, network)
+	assert.Error(t, err)
+}
+
+func TestDecodePsbt(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	in := &TxInput{
+		TxId:    "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c",
+		VOut:    0,
+		Amount:  100000,
+		Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr",
+	}
+	packet, err := NewPsbtPacket(2, 2, 1700000000)
+	require.NoError(t, err)
+	require.NoError(t, packet.AddInput(in))
+	require.NoError(t, packet.UpdateInput(0, in, network))
+	require.NoError(t, packet.AddOutput(&TxOutput{Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", Amount: 99000}, network))
+	psbtStr, err := packet.B64Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodeTransaction(psbtStr, nil, network)
+	require.NoError(t, err)
+	assert.True(t, decoded.IsPsbt)
+	assert.False(t, decoded.Complete)
+	assert.True(t, decoded.Estimated)
+	// a zero sequence with the disable flag still signals replaceability
+	assert.True(t, decoded.RBF)
+	assert.Equal(t, LockTimeTimestamp, decoded.LockTimeType)
+	assert.Equal(t, "2023-11-14T22:13:20Z", decoded.LockTimeDate)
+	assert.Equal(t, int64(1000), decoded.Fee)
+	// one key path input and one p2wpkh output
+	assert.Equal(t, int64(99), decoded.VSize)
+
+	packet.Packet.Inputs[0].SighashType = txscript.SigHashSingle | txscript.SigHashAnyOneCanPay
+	signed, err := packet.Sign("cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22")
+	require.NoError(t, err)
+	require.Equal(t, 1, signed)
+	psbtStr, err = packet.B64Encode()
+	require.NoError(t, err)
+	decoded, err = DecodeTransaction(psbtStr, nil, network)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(decoded.Inputs[0].Signatures))
+	assert.Equal(t, "SINGLE|ANYONECANPAY", decoded.Inputs[0].Signatures[0].SigHash)
+	assert.True(t, decoded.Inputs[0].Signatures[0].Schnorr)
+
+	require.NoError(t, packet.Finalize())
+	psbtHex, err := packet.HexEncode()
+	require.NoError(t, err)
+	decoded, err = DecodeTransaction(psbtHex, nil, network)
+	require.NoError(t, err)
+	assert.True(t, decoded.Complete)
+	assert.False(t, decoded.Estimated)
+	// the explicit sighash byte makes the signature 65 bytes
+	assert.Equal(t, int64(100), decoded.VSize)
+	assert.Equal(t, "SINGLE|ANYONECANPAY", decoded.Inputs[0].Signatures[0].SigHash)
+}
+
+func TestClassifyOpReturn(t *testing.T) {
+	tests := []struct {
+		script string
+		kind   string
+	}{
+		{"6a5d0914c0a23314b50c1601", OpReturnRunestone},
+		{"6a0461746f6d0179", OpReturnAtomicals},
+		{"6a0568656c6c6f", OpReturnText},
+		{"6a0400ff0102", OpReturnData},
+	}
+	for _, test := range tests {
+		script, err := hex.DecodeString(test.script)
+		require.NoError(t, err)
+		payload := ClassifyOpReturn(script)
+		require.NotNil(t, payload, test.script)
+		assert.Equal(t, test.kind, payload.Type, test.script)
+	}
+	assert.Nil(t, ClassifyOpReturn([]byte{txscript.OP_1}))
+
+	assert.Equal(t, "DEFAULT", SigHashName(txscript.SigHashDefault))
+	assert.Equal(t, "NONE", SigHashName(txscript.SigHashNone))
+	assert.Equal(t, "ALL|FORKID", SigHashName(SigHashAllForkID))
+}
+
+func TestDecodePsbtInvalidUtxo(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	pkScript, err := AddrToPkScript("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", network)
+	require.NoError(t, err)
+	prevTx := wire.NewMsgTx(2)
+	prevTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(100000, pkScript))
+
+	for _, test := range []struct {
+		name     string
+		outPoint wire.OutPoint
+	}{
+		// the non-witness utxo has a single output
+		{"short utxo", wire.OutPoint{Hash: prevTx.TxHash(), Index: 5}},
+		{"wrong txid", wire.OutPoint{Hash: chainhash.HashH([]byte("other")), Index: 0}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tx := wire.NewMsgTx(2)
+			tx.AddTxIn(wire.NewTxIn(&test.outPoint, nil, nil))
+			tx.AddTxOut(wire.NewTxOut(1000, pkScript))
+			p, err := psbt.NewFromUnsignedTx(tx)
+			require.NoError(t, err)
+			p.Inputs[0].NonWitnessUtxo = prevTx
+			psbtStr, err := (&PsbtPacket{Packet: p}).B64Encode()
+			require.NoError(t, err)
+			_, err = DecodeTransaction(psbtStr, nil, network)
+			assert.ErrorIs(t, err, ErrInvalidPsbtUtxo)
+		})
+	}
+}
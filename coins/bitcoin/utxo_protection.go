@@ -0,0 +1,331 @@
+package bitcoin
+
+import (
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+type UtxoAssetKind string
+
+const (
+	UtxoAssetInscription UtxoAssetKind = "inscription"
+	UtxoAssetRune        UtxoAssetKind = "rune"
+	UtxoAssetArc20       UtxoAssetKind = "arc20"
+
+	// DefaultPostage is the value of the output an inscription is sent to.
+	DefaultPostage = int64(546)
+)
+
+var (
+	ErrAssetBearingInput = errors.New("input carries assets")
+	ErrAssetBurned       = errors.New("asset would be spent as fee")
+	ErrAssetSplit        = errors.New("asset would be split across outputs")
+	ErrAssetMisrouted    = errors.New("asset would be sent to the recipient")
+	ErrAssetNotFound     = errors.New("asset not found in utxo")
+)
+
+// UtxoAsset is an asset known to be carried by a utxo.
+type UtxoAsset struct {
+	Kind UtxoAssetKind `json:"kind"`
+	Id   string        `json:"id"`
+	// Offset is the first sat of the utxo carrying the asset and Size the number
+	// of sats it covers, 1 when unset. An ARC-20 covers as many sats as it has
+	// units. Runes are not bound to sats and ignore both.
+	Offset int64 `json:"offset"`
+	Size   int64 `json:"size,omitempty"`
+	// Amount is the rune balance, informational only.
+	Amount string `json:"amount,omitempty"`
+}
+
+func (a *UtxoAsset) satBound() bool {
+	return a.Kind != UtxoAssetRune
+}
+
+func (a *UtxoAsset) size() int64 {
+	if a.Size <= 0 {
+		return 1
+	}
+	return a.Size
+}
+
+// HasAssets reports whether the utxo is tagged with any asset.
+func (p *PrevOutput) HasAssets() bool {
+	return len(p.Assets) > 0
+}
+
+// SplitSpendableUtxos isolates the asset bearing utxos from those that can fund
+// plain payments and fees.
+func SplitSpendableUtxos(utxos PrevOutputs) (spendable PrevOutputs, protected PrevOutputs) {
+	for _, utxo := range utxos {
+		if utxo.HasAssets() {
+			protected = append(protected, utxo)
+		} else {
+			spendable = append(spendable, utxo)
+		}
+	}
+	return spendable, protected
+}
+
+// CheckUtxosSpendable refuses utxos tagged with assets.
+func CheckUtxosSpendable(utxos PrevOutputs) error {
+	for _, utxo := range utxos {
+		if utxo.HasAssets() {
+			return fmt.Errorf("%w: %s:%d", ErrAssetBearingInput, utxo.TxId, utxo.VOut)
+		}
+	}
+	return nil
+}
+
+// AssetLocation is where a sat bound asset ends up once a tx is mined.
+type AssetLocation struct {
+	Asset      *UtxoAsset `json:"asset"`
+	InputIndex int        `json:"inputIndex"`
+	// OutputIndex is -1 when the asset goes to the miner.
+	OutputIndex int   `json:"outputIndex"`
+	Offset      int64 `json:"offset"`
+	// Split is set when the sats of the asset land in more than one output.
+	Split bool `json:"split"`
+}
+
+// TraceAssets follows the sat bound assets of inputs through outputs, sats
+// being assigned first-in-first-out.
+func TraceAssets(inputs PrevOutputs, outputAmounts []int64) []*AssetLocation {
+	var locations []*AssetLocation
+	var inputStart int64
+	for i, input := range inputs {
+		for _, asset := range input.Assets {
+			if !asset.satBound() {
+				continue
+			}
+			first := inputStart + asset.Offset
+			last := first + asset.size() - 1
+			location := &AssetLocation{Asset: asset, InputIndex: i, OutputIndex: -1}
+			outputIndex, offset := satOutput(first, outputAmounts)
+			lastIndex, _ := satOutput(last, outputAmounts)
+			if outputIndex >= 0 {
+				location.OutputIndex, location.Offset = outputIndex, offset
+			}
+			location.Split = outputIndex != lastIndex
+			locations = append(locations, location)
+		}
+		inputStart += input.Amount
+	}
+	return locations
+}
+
+// satOutput returns the output holding the sat at position pos of the inputs,
+// -1 for the fee.
+func satOutput(pos int64, outputAmounts []int64) (int, int64) {
+	var start int64
+	for i, amount := range outputAmounts {
+		if pos < start+amount {
+			return i, pos - start
+		}
+		start += amount
+	}
+	return -1, 0
+}
+
+// CheckAssetFlow makes sure no sat bound asset of inputs is burned as fee or
+// split across outputs. Rune balances are only allowed to move when a
+// runestone routes them.
+func CheckAssetFlow(inputs PrevOutputs, outputs []*wire.TxOut) error {
+	amounts := make([]int64, len(outputs))
+	hasRunestone := false
+	for i, out := range outputs {
+		amounts[i] = out.Value
+		if len(out.PkScript) > 1 && out.PkScript[0] == txscript.OP_RETURN && out.PkScript[1] == txscript.OP_13 {
+			hasRunestone = true
+		}
+	}
+	for _, input := range inputs {
+		for _, asset := range input.Assets {
+			if !asset.satBound() && !hasRunestone {
+				return fmt.Errorf("%w: rune %s without runestone", ErrAssetBearingInput, asset.Id)
+			}
+		}
+	}
+	for _, location := range TraceAssets(inputs, amounts) {
+		if location.OutputIndex < 0 {
+			return fmt.Errorf("%w: %s", ErrAssetBurned, location.Asset.Id)
+		}
+		if location.Split {
+			return fmt.Errorf("%w: %s", ErrAssetSplit, location.Asset.Id)
+		}
+	}
+	return nil
+}
+
+type AssetTransferRequest struct {
+	AssetUtxo *PrevOutput `json:"assetUtxo"`
+	// AssetId selects the asset of AssetUtxo to send, the first sat bound one
+	// when empty.
+	AssetId   string `json:"assetId"`
+	ToAddress string `json:"toAddress"`
+	// Postage is the value of the recipient output, DefaultPostage when unset.
+	// An ARC-20 is always sent with exactly its size.
+	Postage       int64       `json:"postage"`
+	FundingUtxos  PrevOutputs `json:"fundingUtxos"`
+	FeeRate       int64       `json:"feeRate"` // sat/vB
+	ChangeAddress string      `json:"changeAddress"`
+	TxVersion     int32       `json:"txVersion"`
+}
+
+type AssetTransferResult struct {
+	Inputs    PrevOutputs         `json:"inputs"`
+	Outputs   []*TxOutput         `json:"outputs"`
+	Fee       int64               `json:"fee"`
+	Locations []*AssetLocation    `json:"locations"`
+	Builder   *TransactionBuilder `json:"-"`
+}
+
+// BuildAssetTransfer sends one sat bound asset to request.ToAddress in an
+// output of exact postage. The sats of the asset utxo before the asset, when
+// not dust, and those after it return to request.ChangeAddress with the
+// change, so other assets of the utxo stay with the sender. Asset bearing
+// funding utxos are skipped.
+func BuildAssetTransfer(request *AssetTransferRequest, network *chaincfg.Params) (*AssetTransferResult, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	if request == nil || request.AssetUtxo == nil {
+		return nil, errors.New("invalid asset utxo")
+	}
+	if request.FeeRate <= 0 {
+		return nil, errors.New("invalid fee rate")
+	}
+	var asset *UtxoAsset
+	for _, a := range request.AssetUtxo.Assets {
+		if !a.satBound() {
+			// the balance would follow the first output
+			return nil, fmt.Errorf("%w: rune %s", ErrAssetBearingInput, a.Id)
+		}
+		if asset == nil && (request.AssetId == "" || a.Id == request.AssetId) {
+			asset = a
+		}
+	}
+	if asset == nil {
+		return nil, ErrAssetNotFound
+	}
+	if asset.Offset < 0 || asset.Offset+asset.size() > request.AssetUtxo.Amount {
+		return nil, errors.New("asset offset out of utxo")
+	}
+	postage := request.Postage
+	if postage <= 0 {
+		postage = DefaultPostage
+	}
+	if asset.Kind == UtxoAssetArc20 {
+		postage = asset.size()
+	} else if postage < asset.size() {
+		return nil, errors.New("postage below asset size")
+	}
+
+	var outputs []*TxOutput
+	if asset.Offset >= DefaultMinChangeValue {
+		outputs = append(outputs, &TxOutput{Address: request.ChangeAddress, Amount: asset.Offset, IsChange: true})
+	} else if asset.Offset > 0 {
+		if asset.Kind == UtxoAssetArc20 {
+			return nil, fmt.Errorf("%w: %s", ErrAssetSplit, asset.Id)
+		}
+		// sats before the asset are dust, they travel with it
+		postage += asset.Offset
+	}
+	recipientIndex := len(outputs)
+	outputs = append(outputs, &TxOutput{Address: request.ToAddress, Amount: postage})
+
+	pkScripts := make([][]byte, 0, len(outputs)+1)
+	for _, out := range append(outputs, &TxOutput{Address: request.ChangeAddress}) {
+		pkScript, err := AddrToPkScript(out.Address, network)
+		if err != nil {
+			return nil, err
+		}
+		pkScripts = append(pkScripts, pkScript)
+	}
+
+	inputs := PrevOutputs{request.AssetUtxo}
+	funding, _ := SplitSpendableUtxos(request.FundingUtxos)
+	var inputAmount, outputAmount int64
+	for _, out := range outputs {
+		outputAmount += out.Amount
+	}
+	inputAmount = request.AssetUtxo.Amount
+	// no sat of the asset utxo pays the fee, its untagged sats may still
+	// carry assets the caller does not know about
+	minChange := request.AssetUtxo.Amount - outputAmount
+	if minChange < DefaultMinChangeValue {
+		minChange = DefaultMinChangeValue
+	}
+	var change int64
+	for {
+		weight := int64(TxOverheadWeight(len(inputs), len(outputs)+1, false))
+		hasWitness := false
+		for _, input := range inputs {
+			pkScript, err := AddrToPkScript(input.Address, network)
+			if err != nil {
+				return nil, err
+			}
+			inputWeight, err := InputWeight(pkScript)
+			if err != nil {
+				return nil, err
+			}
+			weight += inputWeight
+			hasWitness = hasWitness || !txscript.IsPayToPubKeyHash(pkScript)
+		}
+		if hasWitness {
+			weight += 2
+		}
+		for _, pkScript := range pkScripts {
+			weight += OutputWeight(pkScript)
+		}
+		// the change output is always kept, it holds the rest of the asset utxo
+		change = inputAmount - outputAmount - FeeForWeight(weight, request.FeeRate)
+		if change >= minChange {
+			break
+		}
+		if len(funding) == 0 {
+			return nil, ErrInsufficientBalance
+		}
+		inputs = append(inputs, funding[0])
+		inputAmount += funding[0].Amount
+		funding = funding[1:]
+	}
+	outputs = append(outputs, &TxOutput{Address: request.ChangeAddress, Amount: change, IsChange: true})
+
+	amounts := make([]int64, len(outputs))
+	for i, out := range outputs {
+		amounts[i] = out.Amount
+	}
+	locations := TraceAssets(inputs, amounts)
+	for _, location := range locations {
+		switch {
+		case location.OutputIndex < 0:
+			return nil, fmt.Errorf("%w: %s", ErrAssetBurned, location.Asset.Id)
+		case location.Split:
+			return nil, fmt.Errorf("%w: %s", ErrAssetSplit, location.Asset.Id)
+		case location.Asset != asset && location.OutputIndex == recipientIndex:
+			return nil, fmt.Errorf("%w: %s", ErrAssetMisrouted, location.Asset.Id)
+		}
+	}
+
+	version := request.TxVersion
+	if version == 0 {
+		version = DefaultTxVersion
+	}
+	builder := NewTxBuild(version, network)
+	for _, in := range inputs {
+		builder.AddPrevOutput(in)
+	}
+	for _, out := range outputs {
+		builder.AddOutput(out.Address, out.Amount)
+	}
+	return &AssetTransferResult{
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Fee:       inputAmount - outputAmount - change,
+		Locations: locations,
+		Builder:   builder,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTraceAssets(t *testing.T) {
+	inputs := PrevOutputs{
+		{Amount: 1000, Assets: []*UtxoAsset{
+			{Kind: UtxoAssetInscription, Id: "a", Offset: 0},
+			{Kind: UtxoAssetInscription, Id: "b", Offset: 600},
+			{Kind: UtxoAssetRune, Id: "840000:1"},
+		}},
+		{Amount: 2000, Assets: []*UtxoAsset{{Kind: UtxoAssetArc20, Id: "c", Offset: 500, Size: 1000}}},
+	}
+	locations := TraceAssets(inputs, []int64{546, 1454, 900})
+	require.Len(t, locations, 3)
+	assert.Equal(t, &AssetLocation{Asset: inputs[0].Assets[0], InputIndex: 0, OutputIndex: 0, Offset: 0}, locations[0])
+	assert.Equal(t, &AssetLocation{Asset: inputs[0].Assets[1], InputIndex: 0, OutputIndex: 1, Offset: 54}, locations[1])
+	// sats 1500..2499 straddle outputs 1 and 2
+	assert.Equal(t, 1, locations[2].OutputIndex)
+	assert.Equal(t, int64(954), locations[2].Offset)
+	assert.True(t, locations[2].Split)
+
+	locations = TraceAssets(inputs, []int64{546})
+	assert.Equal(t, -1, locations[1].OutputIndex)
+}
+
+func TestCheckAssetFlow(t *testing.T) {
+	inscription := PrevOutputs{{Amount: 10000, Assets: []*UtxoAsset{{Kind: UtxoAssetInscription, Id: "a", Offset: 9000}}}}
+	assert.NoError(t, CheckAssetFlow(inscription, []*wire.TxOut{{Value: 5000}, {Value: 4500}}))
+	assert.ErrorIs(t, CheckAssetFlow(inscription, []*wire.TxOut{{Value: 5000}, {Value: 3000}}), ErrAssetBurned)
+
+	arc20 := PrevOutputs{{Amount: 10000, Assets: []*UtxoAsset{{Kind: UtxoAssetArc20, Id: "c", Size: 1000}}}}
+	assert.NoError(t, CheckAssetFlow(arc20, []*wire.TxOut{{Value: 1000}, {Value: 8000}}))
+	assert.ErrorIs(t, CheckAssetFlow(arc20, []*wire.TxOut{{Value: 600}, {Value: 8000}}), ErrAssetSplit)
+
+	runes := PrevOutputs{{Amount: 10000, Assets: []*UtxoAsset{{Kind: UtxoAssetRune, Id: "840000:1", Amount: "100"}}}}
+	assert.ErrorIs(t, CheckAssetFlow(runes, []*wire.TxOut{{Value: 9000}}), ErrAssetBearingInput)
+	runestone := []byte{0x6a, 0x5d, 0x00}
+	assert.NoError(t, CheckAssetFlow(runes, []*wire.TxOut{{Value: 0, PkScript: runestone}, {Value: 9000}}))
+}
+
+func TestAssetProtectedSpends(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	utxos := coinSelectionUtxos(100000, 50000, 32250)
+	utxos[0].Assets = []*UtxoAsset{{Kind: UtxoAssetInscription, Id: "a", Offset: 30000}}
+	spendable, protected := SplitSpendableUtxos(utxos)
+	assert.Equal(t, utxos[1:], spendable)
+	assert.Equal(t, utxos[:1], protected)
+	assert.ErrorIs(t, CheckUtxosSpendable(utxos), ErrAssetBearingInput)
+
+	// coin selection never picks the inscribed utxo
+	res, err := SelectCoins(&CoinSelectionRequest{
+		Utxos:         utxos,
+		Outputs:       []*TxOutput{{Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", Amount: 60000}},
+		FeeRate:       10,
+		ChangeAddress: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc",
+		Strategy:      CoinSelectionLargestFirst,
+	}, network)
+	require.NoError(t, err)
+	for _, in := range res.Inputs {
+		assert.False(t, in.HasAssets())
+	}
+
+	// a plain spend sending every sat of the inscribed utxo as fee is refused
+	builder := NewTxBuild(2, network)
+	builder.AddPrevOutput(utxos[0])
+	builder.AddPrevOutput(utxos[1])
+	builder.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 20000)
+	_, err = builder.Build()
+	assert.ErrorIs(t, err, ErrAssetBurned)
+
+	_, err = Inscribe(network, &InscriptionRequest{
+		CommitTxPrevOutputList: utxos[:1],
+		CommitFeeRate:          2,
+		RevealFeeRate:          2,
+		InscriptionDataList:    []InscriptionData{{ContentType: "text/plain", Body: []byte("a"), RevealAddr: utxos[0].Address}},
+		ChangeAddress:          utxos[0].Address,
+	})
+	assert.ErrorIs(t, err, ErrAssetBearingInput)
+}
+
+func TestBuildAssetTransfer(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	utxos := coinSelectionUtxos(10000, 20000, 30000)
+	inscription := &UtxoAsset{Kind: UtxoAssetInscription, Id: "a", Offset: 3000}
+	other := &UtxoAsset{Kind: UtxoAssetInscription, Id: "b", Offset: 9000}
+	utxos[0].Assets = []*UtxoAsset{inscription, other}
+	utxos[1].Assets = []*UtxoAsset{{Kind: UtxoAssetInscription, Id: "d"}}
+	to := "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"
+	change := "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"
+
+	res, err := BuildAssetTransfer(&AssetTransferRequest{
+		AssetUtxo:     utxos[0],
+		AssetId:       "a",
+		ToAddress:     to,
+		FundingUtxos:  utxos[1:],
+		FeeRate:       10,
+		ChangeAddress: change,
+	}, network)
+	require.NoError(t, err)
+	// the inscribed funding utxo is skipped, the plain one pays the fee
+	require.Len(t, res.Inputs, 2)
+	assert.Equal(t, utxos[2], res.Inputs[1])
+	require.Len(t, res.Outputs, 3)
+	assert.Equal(t, &TxOutput{Address: change, Amount: 3000, IsChange: true}, res.Outputs[0])
+	assert.Equal(t, &TxOutput{Address: to, Amount: DefaultPostage}, res.Outputs[1])
+	assert.Equal(t, 1, res.Locations[0].OutputIndex)
+	assert.Equal(t, int64(0), res.Locations[0].Offset)
+	assert.Equal(t, 2, res.Locations[1].OutputIndex)
+
+	tx, err := res.Builder.Build()
+	require.NoError(t, err)
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+	verifyTxScripts(t, txHex, res.Inputs, network)
+	vSize := GetTxVirtualSize(btcutil.NewTx(tx))
+	assert.True(t, res.Fee >= vSize*10)
+
+	// the dust before the asset travels with it, the other inscription then
+	// would follow it to the recipient
+	inscription.Offset = 100
+	other.Offset = 50
+	_, err = BuildAssetTransfer(&AssetTransferRequest{
+		AssetUtxo:     utxos[0],
+		AssetId:       "a",
+		ToAddress:     to,
+		FundingUtxos:  utxos[2:],
+		FeeRate:       10,
+		ChangeAddress: change,
+	}, network)
+	assert.ErrorIs(t, err, ErrAssetMisrouted)
+
+	arc20 := &PrevOutput{TxId: utxos[0].TxId, VOut: 7, Amount: 5000, Address: change, PrivateKey: utxos[0].PrivateKey,
+		Assets: []*UtxoAsset{{Kind: UtxoAssetArc20, Id: "atom", Size: 5000}}}
+	res, err = BuildAssetTransfer(&AssetTransferRequest{
+		AssetUtxo:     arc20,
+		ToAddress:     to,
+		FundingUtxos:  utxos[2:],
+		FeeRate:       10,
+		ChangeAddress: change,
+	}, network)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5000), res.Outputs[0].Amount)
+	assert.False(t, res.Locations[0].Split)
+
+	_, err = BuildAssetTransfer(&AssetTransferRequest{AssetUtxo: utxos[0], AssetId: "x", FeeRate: 1}, network)
+	assert.ErrorIs(t, err, ErrAssetNotFound)
+}
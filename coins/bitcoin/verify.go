@@ -0,0 +1,274 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+)
+
+const (
+	// MaxStandardTxSigOpsCost is the sigop cost limit of a standard tx, a
+	// fifth of the block limit.
+	MaxStandardTxSigOpsCost = 80000 / 5
+	// MaxStandardScriptSigSize is the largest standard script sig, enough for
+	// a 15 of 15 bare multisig redeem script.
+	MaxStandardScriptSigSize = 1650
+	// MaxOpReturnRelay is the largest standard OP_RETURN pkScript, 80 bytes of
+	// data plus the opcodes.
+	MaxOpReturnRelay = 83
+)
+
+var (
+	ErrMissingPrevOut          = errors.New("missing prevout")
+	ErrScriptVerify            = errors.New("script verification failed")
+	ErrSigOpCost               = errors.New("sigop cost above the standard limit")
+	ErrOpReturnSize            = errors.New("OP_RETURN output above the standard size")
+	ErrMultipleOpReturn        = errors.New("more than one OP_RETURN output")
+	ErrScriptSigSize           = errors.New("script sig above the standard size")
+	ErrScriptSigNotPushOnly    = errors.New("script sig is not push only")
+	ErrForkIDScriptUnsupported = errors.New("fork id signatures are only verified for p2pkh inputs")
+)
+
+// InputVerification is the result of running the scripts of one input.
+type InputVerification struct {
+	Index      int    `json:"index"`
+	ScriptType string `json:"scriptType"`
+	Valid      bool   `json:"valid"`
+	Error      string `json:"error,omitempty"`
+}
+
+// TxVerification is what VerifyTx found about a signed transaction before it
+// is broadcast. Valid covers the consensus scripts of every input, Standard
+// the relay policy of the chain.
+type TxVerification struct {
+	TxId       string               `json:"txId"`
+	Valid      bool                 `json:"valid"`
+	Standard   bool                 `json:"standard"`
+	Inputs     []*InputVerification `json:"inputs"`
+	Violations []string             `json:"violations,omitempty"`
+	Weight     int64                `json:"weight"`
+	SigOpCost  int64                `json:"sigOpCost"`
+
+	errs []error
+}
+
+// Err returns the first failure, a script error before a policy one, nil when
+// the transaction is valid and standard.
+func (v *TxVerification) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs[0]
+}
+
+// VerifyTransaction is VerifyTx for a hex transaction whose spent outputs are
+// given by prevOuts, matched by outpoint.
+func VerifyTransaction(txHex string, prevOuts PrevOutputs, network *chaincfg.Params) (*TxVerification, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	tx, err := NewTxFromHex(txHex)
+	if err != nil {
+		return nil, err
+	}
+	view, err := prevOuts.UtxoViewpoint(network)
+	if err != nil {
+		return nil, err
+	}
+	utxos := make([]*wire.TxOut, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		for _, prevOut := range prevOuts {
+			if prevOut.TxId == in.PreviousOutPoint.Hash.String() && prevOut.VOut == in.PreviousOutPoint.Index {
+				utxos[i] = wire.NewTxOut(prevOut.Amount, view[in.PreviousOutPoint])
+				break
+			}
+		}
+	}
+	return VerifyTx(tx, utxos, network)
+}
+
+// VerifyTransactionJSON is VerifyTransaction encoded as JSON.
+func VerifyTransactionJSON(txHex string, prevOuts PrevOutputs, network *chaincfg.Params) (string, error) {
+	res, err := VerifyTransaction(txHex, prevOuts, network)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// VerifyTx runs the script interpreter on every input of tx, utxos being the
+// spent outputs by input, then checks the standardness rules of the chain:
+// dust, weight, sigop cost, script sig size and OP_RETURN outputs. Taproot
+// inputs need every utxo to compute their sighash. On the BCH params fork id
+// signatures are checked against their digest, the interpreter not knowing
+// them; on any other chain they fail like any unknown hash type.
+func VerifyTx(tx *wire.MsgTx, utxos []*wire.TxOut, network *chaincfg.Params) (*TxVerification, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	return verifyTx(tx, utxos, network, IsBCHNetwork(network))
+}
+
+// VerifyForkIDTx is VerifyTx with fork id signatures enabled on network, for
+// BSV which shares the BTC params.
+func VerifyForkIDTx(tx *wire.MsgTx, utxos []*wire.TxOut, network *chaincfg.Params) (*TxVerification, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	return verifyTx(tx, utxos, network, true)
+}
+
+func verifyTx(tx *wire.MsgTx, utxos []*wire.TxOut, network *chaincfg.Params, forkID bool) (*TxVerification, error) {
+	if len(utxos) != len(tx.TxIn) {
+		return nil, errors.New("one prevout per input is needed")
+	}
+	fetcher := txscript.NewMultiPrevOutFetcher(nil)
+	view := make(UtxoViewpoint, len(utxos))
+	for i, in := range tx.TxIn {
+		if utxos[i] == nil {
+			return nil, fmt.Errorf("%w: input %d", ErrMissingPrevOut, i)
+		}
+		fetcher.AddPrevOut(in.PreviousOutPoint, utxos[i])
+		view[in.PreviousOutPoint] = utxos[i].PkScript
+	}
+
+	res := &TxVerification{TxId: tx.TxHash().String(), Valid: true}
+	sigHashes := txscript.NewTxSigHashes(tx, fetcher)
+	for i := range tx.TxIn {
+		input := &InputVerification{Index: i, ScriptType: scriptTypeName(utxos[i].PkScript), Valid: true}
+		if err := verifyInput(tx, i, utxos[i], sigHashes, fetcher, forkID); err != nil {
+			input.Valid, input.Error = false, err.Error()
+			res.Valid = false
+			res.errs = append(res.errs, fmt.Errorf("%w: input %d: %v", ErrScriptVerify, i, err))
+		}
+		res.Inputs = append(res.Inputs, input)
+	}
+
+	res.Weight = GetTransactionWeight(btcutil.NewTx(tx))
+	for _, err := range checkStandard(tx, view, RelayPolicyFor(network), res) {
+		res.Violations = append(res.Violations, err.Error())
+		res.errs = append(res.errs, err)
+	}
+	res.Standard = len(res.Violations) == 0
+	return res, nil
+}
+
+// verifyInput runs the scripts of input i, fork id signatures being checked
+// against their digest only when forkID is set.
+func verifyInput(tx *wire.MsgTx, i int, utxo *wire.TxOut, sigHashes *txscript.TxSigHashes, fetcher txscript.PrevOutputFetcher, forkID bool) error {
+	if forkID && forkIDSigned(tx.TxIn[i]) {
+		return verifyForkIDInput(tx, i, utxo)
+	}
+	vm, err := txscript.NewEngine(utxo.PkScript, tx, i, txscript.StandardVerifyFlags, nil, sigHashes, utxo.Value, fetcher)
+	if err != nil {
+		return err
+	}
+	return vm.Execute()
+}
+
+// forkIDSigned reports whether the first push of the script sig of in is a
+// signature with the fork id flag.
+func forkIDSigned(in *wire.TxIn) bool {
+	if len(in.Witness) > 0 {
+		return false
+	}
+	pushes, err := txscript.PushedData(in.SignatureScript)
+	if err != nil || len(pushes) == 0 || !ecdsaSignature(pushes[0]) {
+		return false
+	}
+	return txscript.SigHashType(pushes[0][len(pushes[0])-1])&SigHashForkID != 0
+}
+
+// verifyForkIDInput checks a p2pkh input signed with a fork id digest.
+func verifyForkIDInput(tx *wire.MsgTx, i int, utxo *wire.TxOut) error {
+	if txscript.GetScriptClass(utxo.PkScript) != txscript.PubKeyHashTy {
+		return ErrForkIDScriptUnsupported
+	}
+	pushes, err := txscript.PushedData(tx.TxIn[i].SignatureScript)
+	if err != nil {
+		return err
+	}
+	if len(pushes) != 2 {
+		return errors.New("p2pkh script sig needs a signature and a public key")
+	}
+	sigBytes, pubKeyBytes := pushes[0], pushes[1]
+	if !bytes.Equal(btcutil.Hash160(pubKeyBytes), utxo.PkScript[3:23]) {
+		return errors.New("public key does not match the pkScript")
+	}
+	pubKey, err := btcec.ParsePubKey(pubKeyBytes)
+	if err != nil {
+		return err
+	}
+	sig, err := ecdsa.ParseDERSignature(sigBytes[:len(sigBytes)-1])
+	if err != nil {
+		return err
+	}
+	hashType := txscript.SigHashType(sigBytes[len(sigBytes)-1])
+	hash, err := CalcForkIDSignatureHash(tx, i, utxo.PkScript, utxo.Value, nil, hashType)
+	if err != nil {
+		return err
+	}
+	if !sig.Verify(hash, pubKey) {
+		return errors.New("signature does not verify")
+	}
+	return nil
+}
+
+// checkStandard returns the relay policy rules tx breaks and fills in the
+// sigop cost of res.
+func checkStandard(tx *wire.MsgTx, view UtxoViewpoint, policy *RelayPolicy, res *TxVerification) []error {
+	var errs []error
+	if err := policy.checkWeight(tx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := policy.CheckOutputTypes(tx.TxOut); err != nil {
+		errs = append(errs, err)
+	}
+
+	sigOpCost, err := GetSigOpCost(btcutil.NewTx(tx), false, view, true, policy.SegWit)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	res.SigOpCost = int64(sigOpCost)
+	if sigOpCost > MaxStandardTxSigOpsCost {
+		errs = append(errs, fmt.Errorf("%w: %d", ErrSigOpCost, sigOpCost))
+	}
+
+	for i, in := range tx.TxIn {
+		if len(in.SignatureScript) > MaxStandardScriptSigSize {
+			errs = append(errs, fmt.Errorf("%w: input %d", ErrScriptSigSize, i))
+		}
+		if !txscript.IsPushOnlyScript(in.SignatureScript) {
+			errs = append(errs, fmt.Errorf("%w: input %d", ErrScriptSigNotPushOnly, i))
+		}
+	}
+
+	opReturns := 0
+	for i, out := range tx.TxOut {
+		if len(out.PkScript) > 0 && out.PkScript[0] == txscript.OP_RETURN {
+			opReturns++
+			if len(out.PkScript) > MaxOpReturnRelay {
+				errs = append(errs, fmt.Errorf("%w: output %d", ErrOpReturnSize, i))
+			}
+			continue
+		}
+		if out.Value < policy.DustThreshold(out.PkScript) {
+			errs = append(errs, fmt.Errorf("%w: output %d", ErrDustOutput, i))
+		}
+	}
+	if opReturns > 1 {
+		errs = append(errs, ErrMultipleOpReturn)
+	}
+	return errs
+}
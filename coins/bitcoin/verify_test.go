@@ -0,0 +1,148 @@
+package bitcoin
+
+import (
+	"bytes"
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func verifyTestTx(t *testing.T, network *chaincfg.Params) (*wire.MsgTx, PrevOutputs) {
+	prevOutputs := PrevOutputs{
+		{TxId: "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", VOut: 0, Amount: 100000, Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"},
+		{TxId: "02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1", VOut: 1, Amount: 50000, Address: "mouQtmBWDS7JnT65Grj2tPzdSmGKJgRMhE"},
+		{TxId: "02133b22fdd190519ef9b49aca9a8dfdcbab0197c77109bb829cd51e17debed1", VOut: 2, Amount: 50000, Address: "tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr"},
+	}
+	txBuild := NewTxBuild(2, network)
+	for _, v := range prevOutputs {
+		txBuild.AddInput2(v.TxId, v.VOut, "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", v.Address, v.Amount)
+	}
+	txBuild.AddOutput("tb1pklh8lqax5l7m2ycypptv2emc4gata2dy28svnwcp9u32wlkenvsspcvhsr", 190000)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	return tx, prevOutputs
+}
+
+func TestVerifyTransaction(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	tx, prevOutputs := verifyTestTx(t, network)
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+
+	res, err := VerifyTransaction(txHex, prevOutputs, network)
+	require.NoError(t, err)
+	assert.NoError(t, res.Err())
+	assert.True(t, res.Valid)
+	assert.True(t, res.Standard)
+	require.Equal(t, 3, len(res.Inputs))
+	assert.Equal(t, "witness_v0_keyhash", res.Inputs[0].ScriptType)
+	assert.Equal(t, "pubkeyhash", res.Inputs[1].ScriptType)
+	assert.Equal(t, "witness_v1_taproot", res.Inputs[2].ScriptType)
+	// only the p2wpkh input counts, unscaled; spent p2pkh scripts are not counted
+	assert.Equal(t, int64(1), res.SigOpCost)
+
+	// a malformed witness, as a bad signature injection would produce
+	tx.TxIn[0].Witness = wire.TxWitness{tx.TxIn[0].Witness[1]}
+	res, err = VerifyTx(tx, verifyTestUtxos(t, tx, prevOutputs, network), network)
+	require.NoError(t, err)
+	assert.False(t, res.Valid)
+	assert.False(t, res.Inputs[0].Valid)
+	assert.NotEmpty(t, res.Inputs[0].Error)
+	assert.True(t, res.Inputs[1].Valid)
+	assert.True(t, res.Inputs[2].Valid)
+	assert.ErrorIs(t, res.Err(), ErrScriptVerify)
+
+	// a wrong amount breaks the segwit and taproot sighashes, not the legacy one
+	utxos := verifyTestUtxos(t, tx, prevOutputs, network)
+	utxos[2].Value++
+	res, err = VerifyTx(tx, utxos, network)
+	require.NoError(t, err)
+	assert.True(t, res.Inputs[1].Valid)
+	assert.False(t, res.Inputs[2].Valid)
+
+	_, err = VerifyTransaction(txHex, prevOutputs[:2], network)
+	assert.ErrorIs(t, err, ErrMissingPrevOut)
+
+	js, err := VerifyTransactionJSON(txHex, prevOutputs, network)
+	require.NoError(t, err)
+	assert.Contains(t, js, `"valid":true`)
+}
+
+func verifyTestUtxos(t *testing.T, tx *wire.MsgTx, prevOutputs PrevOutputs, network *chaincfg.Params) []*wire.TxOut {
+	var utxos []*wire.TxOut
+	for i := range tx.TxIn {
+		pkScript, err := AddrToPkScript(prevOutputs[i].Address, network)
+		require.NoError(t, err)
+		utxos = append(utxos, wire.NewTxOut(prevOutputs[i].Amount, pkScript))
+	}
+	return utxos
+}
+
+func TestVerifyStandardness(t *testing.T) {
+	network := &chaincfg.TestNet3Params
+	prevOutputs := PrevOutputs{{TxId: "0bc66f18fd95ca00b6569471aa2dcd47fe45d3446fbaeec9ced228b00713fe8c", VOut: 0, Amount: 100000, Address: "tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc"}}
+	txBuild := NewTxBuild(2, network)
+	txBuild.AddInput2(prevOutputs[0].TxId, prevOutputs[0].VOut, "cPnvkvUYyHcSSS26iD1dkrJdV7k1RoUqJLhn3CYxpo398PdLVE22", prevOutputs[0].Address, prevOutputs[0].Amount)
+	txBuild.AddOutput("tb1qtsq9c4fje6qsmheql8gajwtrrdrs38kdzeersc", 90000)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+	// outputs appended after signing break the sighash, the policy checks
+	// still run on their own
+	big := append([]byte{0x6a, 0x4c, 81}, bytes.Repeat([]byte{1}, 81)...)
+	tx.AddTxOut(wire.NewTxOut(0, big))
+	tx.AddTxOut(wire.NewTxOut(0, []byte{0x6a, 0x01, 0x01}))
+	tx.AddTxOut(wire.NewTxOut(100, tx.TxOut[0].PkScript))
+	txHex, err := GetTxHex(tx)
+	require.NoError(t, err)
+
+	res, err := VerifyTransaction(txHex, prevOutputs, network)
+	require.NoError(t, err)
+	assert.False(t, res.Valid)
+	assert.False(t, res.Standard)
+	assert.Equal(t, 3, len(res.Violations))
+	assert.ErrorIs(t, res.Err(), ErrScriptVerify)
+	assert.ErrorIs(t, res.errs[1], ErrOpReturnSize)
+	assert.ErrorIs(t, res.errs[2], ErrDustOutput)
+	assert.ErrorIs(t, res.errs[3], ErrMultipleOpReturn)
+}
+
+func TestVerifyForkID(t *testing.T) {
+	network := GetBCHmainNetParams()
+	addr, pkScript := forkIDTestAddress(t, false)
+	txBuild := NewTxBuild(1, network)
+	txBuild.AddInput("0b2c23f5c2e6326c90cfa1d3925b0d83f4b08035ca6af8fd8f606385dfbc5822", 1, forkIDTestWif, "", addr, 30000)
+	txBuild.AddOutput("1BpEi6DfDAUFd7GtittLSdBeYJvcoaVggu", 29000)
+	tx, err := txBuild.Build()
+	require.NoError(t, err)
+
+	res, err := VerifyTx(tx, []*wire.TxOut{wire.NewTxOut(30000, pkScript)}, network)
+	require.NoError(t, err)
+	assert.True(t, res.Valid)
+	assert.True(t, res.Standard)
+
+	res, err = VerifyTx(tx, []*wire.TxOut{wire.NewTxOut(30001, pkScript)}, network)
+	require.NoError(t, err)
+	assert.False(t, res.Valid)
+
+	wif, err := btcutil.DecodeWIF(forkIDTestWif)
+	require.NoError(t, err)
+	other, err := PayToPubKeyHashScript(btcutil.Hash160(wif.PrivKey.PubKey().SerializeUncompressed()))
+	require.NoError(t, err)
+	res, err = VerifyTx(tx, []*wire.TxOut{wire.NewTxOut(30000, other)}, network)
+	require.NoError(t, err)
+	assert.False(t, res.Valid)
+
+	// on the BTC params SIGHASH_ALL|FORKID is not a valid hash type, BSV
+	// enables fork id explicitly
+	res, err = VerifyTx(tx, []*wire.TxOut{wire.NewTxOut(30000, pkScript)}, &chaincfg.MainNetParams)
+	require.NoError(t, err)
+	assert.False(t, res.Valid)
+	assert.False(t, res.Inputs[0].Valid)
+	assert.ErrorIs(t, res.Err(), ErrScriptVerify)
+	res, err = VerifyForkIDTx(tx, []*wire.TxOut{wire.NewTxOut(30000, pkScript)}, GetBSVMainNetParams())
+	require.NoError(t, err)
+	assert.True(t, res.Valid)
+}
@@ -0,0 +1,344 @@
+package bitcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/okx/go-wallet-sdk/crypto/go-bip32"
+	"strconv"
+)
+
+// DefaultGapLimit is the BIP-44 gap limit, the number of consecutive unused
+// addresses after which a wallet stops looking for more.
+const DefaultGapLimit = 20
+
+// Purposes of the BIP-44 family of account structures.
+const (
+	PurposeBIP44 uint32 = 44
+	PurposeBIP49 uint32 = 49
+	PurposeBIP84 uint32 = 84
+	PurposeBIP86 uint32 = 86
+)
+
+var (
+	ErrInvalidExtendedKey = errors.New("invalid extended key")
+	ErrExtendedKeyNetwork = errors.New("extended key is not for this network")
+	ErrExtendedKeyPurpose = errors.New("extended key version does not match the purpose")
+	ErrAddressNotFound    = errors.New("address not found in the derived window")
+)
+
+// slip132Version is a SLIP-132 extended public key version.
+type slip132Version struct {
+	name    string
+	version [4]byte
+	purpose uint32
+	testNet bool
+	// multisig versions, Ypub and Zpub, have no single key address type
+	multisig bool
+}
+
+var slip132Versions = []*slip132Version{
+	{name: "xpub", version: [4]byte{0x04, 0x88, 0xb2, 0x1e}, purpose: PurposeBIP44},
+	{name: "ypub", version: [4]byte{0x04, 0x9d, 0x7c, 0xb2}, purpose: PurposeBIP49},
+	{name: "zpub", version: [4]byte{0x04, 0xb2, 0x47, 0x46}, purpose: PurposeBIP84},
+	{name: "Ypub", version: [4]byte{0x02, 0x95, 0xb4, 0x3f}, purpose: PurposeBIP49, multisig: true},
+	{name: "Zpub", version: [4]byte{0x02, 0xaa, 0x7e, 0xd3}, purpose: PurposeBIP84, multisig: true},
+	{name: "tpub", version: [4]byte{0x04, 0x35, 0x87, 0xcf}, purpose: PurposeBIP44, testNet: true},
+	{name: "upub", version: [4]byte{0x04, 0x4a, 0x52, 0x62}, purpose: PurposeBIP49, testNet: true},
+	{name: "vpub", version: [4]byte{0x04, 0x5f, 0x1c, 0xd6}, purpose: PurposeBIP84, testNet: true},
+	{name: "Upub", version: [4]byte{0x02, 0x42, 0x89, 0xef}, purpose: PurposeBIP49, testNet: true, multisig: true},
+	{name: "Vpub", version: [4]byte{0x02, 0x57, 0x54, 0x83}, purpose: PurposeBIP84, testNet: true, multisig: true},
+}
+
+func slip132ByName(name string) *slip132Version {
+	for _, v := range slip132Versions {
+		if v.name == name {
+			return v
+		}
+	}
+	return nil
+}
+
+func slip132ByVersion(version []byte) *slip132Version {
+	for _, v := range slip132Versions {
+		if bytes.Equal(v.version[:], version) {
+			return v
+		}
+	}
+	return nil
+}
+
+// ConvertExtendedPubKey re-encodes an extended public key with the SLIP-132
+// version named by prefix, such as xpub, zpub or vpub.
+func ConvertExtendedPubKey(extKey string, prefix string) (string, error) {
+	target := slip132ByName(prefix)
+	if target == nil {
+		return "", fmt.Errorf("%w: unknown version %s", ErrInvalidExtendedKey, prefix)
+	}
+	key, err := parseExtendedPubKey(extKey)
+	if err != nil {
+		return "", err
+	}
+	key.Version = target.version[:]
+	return key.B58Serialize(), nil
+}
+
+func parseExtendedPubKey(extKey string) (*bip32.Key, error) {
+	key, err := bip32.B58Deserialize(extKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidExtendedKey, err)
+	}
+	if key.IsPrivate {
+		return nil, fmt.Errorf("%w: private key", ErrInvalidExtendedKey)
+	}
+	return key, nil
+}
+
+// AccountAddress is an address of an XPubAccount with the key origin
+// TxInput and TxOutput take for signing devices.
+type AccountAddress struct {
+	Address           string `json:"address"`
+	Change            bool   `json:"change"`
+	Index             uint32 `json:"index"`
+	PublicKey         string `json:"publicKey"`
+	MasterFingerprint uint32 `json:"masterFingerprint"`
+	DerivationPath    string `json:"derivationPath"`
+}
+
+// XPubAccount is a watch-only BIP-44, 49, 84 or 86 account: the receive and
+// change chains of an account level extended public key. Addresses are
+// derived up to GapLimit past the last used one of each chain and can be
+// looked up back to their path. It is not safe for concurrent use.
+type XPubAccount struct {
+	Purpose uint32
+	// AddressType is the type of the addresses, one of LEGACY,
+	// SEGWIT_NESTED, SEGWIT_NATIVE and TAPROOT.
+	AddressType string
+	GapLimit    uint32
+	// MasterFingerprint and AccountPath are the origin of the account key,
+	// set by SetKeyOrigin. AccountPath defaults to m/purpose'/coin'/account'
+	// of the network.
+	MasterFingerprint uint32
+	AccountPath       string
+
+	network *chaincfg.Params
+	chains  [2]string
+	// derived addresses and the number of used ones by chain
+	derived [2][]*AccountAddress
+	used    [2]uint32
+	index   map[string]*AccountAddress
+}
+
+// NewXPubAccount creates the account of an account level extended public key
+// in any SLIP-132 version. purpose 0 takes it from the version, xpub and tpub
+// being BIP-44; a ypub or zpub given for another purpose is refused.
+func NewXPubAccount(extKey string, purpose uint32, network *chaincfg.Params) (*XPubAccount, error) {
+	if network == nil {
+		network = &chaincfg.MainNetParams
+	}
+	key, err := parseExtendedPubKey(extKey)
+	if err != nil {
+		return nil, err
+	}
+	if v := slip132ByVersion(key.Version); v != nil {
+		if v.testNet != (network.HDPublicKeyID == chaincfg.TestNet3Params.HDPublicKeyID) {
+			return nil, fmt.Errorf("%w: %s", ErrExtendedKeyNetwork, v.name)
+		}
+		if v.multisig {
+			return nil, fmt.Errorf("%w: %s is a multisig key", ErrExtendedKeyPurpose, v.name)
+		}
+		if purpose == 0 {
+			purpose = v.purpose
+		}
+		// xpub and tpub are also the version of BIP-84 and BIP-86 keys
+		if v.purpose != PurposeBIP44 && v.purpose != purpose {
+			return nil, fmt.Errorf("%w: %s for purpose %d", ErrExtendedKeyPurpose, v.name, purpose)
+		}
+	} else if !bytes.Equal(key.Version, network.HDPublicKeyID[:]) {
+		return nil, fmt.Errorf("%w: version %x", ErrExtendedKeyNetwork, key.Version)
+	}
+
+	account := &XPubAccount{
+		Purpose:  purpose,
+		GapLimit: DefaultGapLimit,
+		network:  network,
+		index:    make(map[string]*AccountAddress),
+	}
+	switch purpose {
+	case PurposeBIP44:
+		account.AddressType = LEGACY
+	case PurposeBIP49:
+		account.AddressType = SEGWIT_NESTED
+	case PurposeBIP84:
+		account.AddressType = SEGWIT_NATIVE
+	case PurposeBIP86:
+		account.AddressType = TAPROOT
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrExtendedKeyPurpose, purpose)
+	}
+	accountIndex := binary.BigEndian.Uint32(key.ChildNumber)
+	account.AccountPath = formatDerivationPath([]uint32{
+		purpose + bip32.FirstHardenedChild,
+		network.HDCoinType + bip32.FirstHardenedChild,
+		accountIndex | bip32.FirstHardenedChild,
+	})
+
+	// the chain keys are derived once, addresses from them
+	for change := uint32(0); change < 2; change++ {
+		chainKey, err := key.NewChildKey(change)
+		if err != nil {
+			return nil, err
+		}
+		account.chains[change] = chainKey.B58Serialize()
+	}
+	return account, nil
+}
+
+// SetKeyOrigin sets the master fingerprint and the path of the account key,
+// for the key origin of the addresses.
+func (a *XPubAccount) SetKeyOrigin(masterFingerprint uint32, accountPath string) error {
+	path, err := bip32.ParseDerivationPath(accountPath)
+	if err != nil {
+		return err
+	}
+	a.MasterFingerprint = masterFingerprint
+	a.AccountPath = formatDerivationPath(path)
+	for chain := range a.derived {
+		for _, addr := range a.derived[chain] {
+			addr.MasterFingerprint = masterFingerprint
+			addr.DerivationPath = fmt.Sprintf("%s/%d/%d", a.AccountPath, chain, addr.Index)
+		}
+	}
+	return nil
+}
+
+func (a *XPubAccount) gapLimit() uint32 {
+	if a.GapLimit == 0 {
+		return DefaultGapLimit
+	}
+	return a.GapLimit
+}
+
+func chainIndex(change bool) int {
+	if change {
+		return 1
+	}
+	return 0
+}
+
+// Derive returns the address at index of the receive or change chain.
+func (a *XPubAccount) Derive(change bool, index uint32) (*AccountAddress, error) {
+	chain := chainIndex(change)
+	if int(index) < len(a.derived[chain]) {
+		return a.derived[chain][index], nil
+	}
+	pubKey, err := bip32.DerivePubKeyFromExtendedKey(a.chains[chain], "m/"+strconv.FormatUint(uint64(index), 10))
+	if err != nil {
+		return nil, err
+	}
+	address, err := PubKeyToAddr(pubKey, a.AddressType, a.network)
+	if err != nil {
+		return nil, err
+	}
+	return &AccountAddress{
+		Address:           address,
+		Change:            change,
+		Index:             index,
+		PublicKey:         hex.EncodeToString(pubKey),
+		MasterFingerprint: a.MasterFingerprint,
+		DerivationPath:    fmt.Sprintf("%s/%d/%d", a.AccountPath, chain, index),
+	}, nil
+}
+
+// Addresses derives count addresses of a chain from start.
+func (a *XPubAccount) Addresses(change bool, start, count uint32) ([]*AccountAddress, error) {
+	res := make([]*AccountAddress, 0, count)
+	for i := start; i < start+count; i++ {
+		addr, err := a.Derive(change, i)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, addr)
+	}
+	return res, nil
+}
+
+// Window returns the addresses of a chain up to GapLimit past the last used
+// one, deriving those not derived yet.
+func (a *XPubAccount) Window(change bool) ([]*AccountAddress, error) {
+	chain := chainIndex(change)
+	end := a.used[chain] + a.gapLimit()
+	for i := uint32(len(a.derived[chain])); i < end; i++ {
+		addr, err := a.Derive(change, i)
+		if err != nil {
+			return nil, err
+		}
+		a.derived[chain] = append(a.derived[chain], addr)
+		a.index[addr.Address] = addr
+	}
+	return a.derived[chain][:end], nil
+}
+
+// Lookup finds the path of address in the windows of both chains.
+func (a *XPubAccount) Lookup(address string) (*AccountAddress, error) {
+	for _, change := range []bool{false, true} {
+		if _, err := a.Window(change); err != nil {
+			return nil, err
+		}
+	}
+	if addr, ok := a.index[address]; ok {
+		return addr, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrAddressNotFound, address)
+}
+
+// MarkUsed records that address received funds, moving the window of its
+// chain so that GapLimit unused addresses follow it.
+func (a *XPubAccount) MarkUsed(address string) (*AccountAddress, error) {
+	addr, err := a.Lookup(address)
+	if err != nil {
+		return nil, err
+	}
+	chain := chainIndex(addr.Change)
+	if addr.Index >= a.used[chain] {
+		a.used[chain] = addr.Index + 1
+	}
+	return addr, nil
+}
+
+// NextUnused returns the first address of a chain after the last used one.
+func (a *XPubAccount) NextUnused(change bool) (*AccountAddress, error) {
+	window, err := a.Window(change)
+	if err != nil {
+		return nil, err
+	}
+	return window[a.used[chainIndex(change)]], nil
+}
+
+// Discover runs BIP-44 account discovery on a chain: addresses are checked
+// with isUsed until GapLimit consecutive ones are unused. It returns the used
+// addresses.
+func (a *XPubAccount) Discover(change bool, isUsed func(address string) (bool, error)) ([]*AccountAddress, error) {
+	var used []*AccountAddress
+	chain := chainIndex(change)
+	for i := uint32(0); i < a.used[chain]+a.gapLimit(); i++ {
+		window, err := a.Window(change)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := isUsed(window[i].Address)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			used = append(used, window[i])
+			if i >= a.used[chain] {
+				a.used[chain] = i + 1
+			}
+		}
+	}
+	return used, nil
+}
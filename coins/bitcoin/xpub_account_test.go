@@ -0,0 +1,128 @@
+package bitcoin
+
+import (
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// account keys of the "abandon ... about" mnemonic, from the BIP-49, BIP-84
+// and BIP-86 test vectors
+const (
+	testZpub = "zpub6rFR7y4Q2AijBEqTUquhVz398htDFrtymD9xYYfG1m4wAcvPhXNfE3EfH1r1ADqtfSdVCToUG868RvUUkgDKf31mGDtKsAYz2oz2AGutZYs"
+	testXpub = "xpub6CatWdiZiodmUeTDp8LT5or8nmbKNcuyvz7WyksVFkKB4RHwCD3XyuvPEbvqAQY3rAPshWcMLoP2fMFMKHPJ4ZeZXYVUhLv1VMrjPC7PW6V"
+	testUpub = "upub5EFU65HtV5TeiSHmZZm7FUffBGy8UKeqp7vw43jYbvZPpoVsgU93oac7Wk3u6moKegAEWtGNF8DehrnHtv21XXEMYRUocHqguyjknFHYfgY"
+	testP2TR = "xpub6BgBgsespWvERF3LHQu6CnqdvfEvtMcQjYrcRzx53QJjSxarj2afYWcLteoGVky7D3UKDP9QyrLprQ3VCECoY49yfdDEHGCtMMj92pReUsQ"
+)
+
+func TestXPubAccountDerive(t *testing.T) {
+	account, err := NewXPubAccount(testZpub, 0, nil)
+	require.NoError(t, err)
+	assert.Equal(t, PurposeBIP84, account.Purpose)
+	assert.Equal(t, SEGWIT_NATIVE, account.AddressType)
+	addrs, err := account.Addresses(false, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", addrs[0].Address)
+	assert.Equal(t, "bc1qnjg0jd8228aq7egyzacy8cys3knf9xvrerkf9g", addrs[1].Address)
+	assert.Equal(t, "m/84'/0'/0'/0/1", addrs[1].DerivationPath)
+	change, err := account.Derive(true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el", change.Address)
+	assert.Equal(t, "m/84'/0'/0'/1/0", change.DerivationPath)
+
+	// SLIP-132 conversion both ways, the xpub form needs the purpose
+	xpub, err := ConvertExtendedPubKey(testZpub, "xpub")
+	require.NoError(t, err)
+	assert.Equal(t, testXpub, xpub)
+	zpub, err := ConvertExtendedPubKey(testXpub, "zpub")
+	require.NoError(t, err)
+	assert.Equal(t, testZpub, zpub)
+	account, err = NewXPubAccount(xpub, PurposeBIP84, nil)
+	require.NoError(t, err)
+	addr, err := account.Derive(false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1qcr8te4kr609gcawutmrza0j4xv80jy8z306fyu", addr.Address)
+
+	account, err = NewXPubAccount(testP2TR, PurposeBIP86, nil)
+	require.NoError(t, err)
+	addr, err = account.Derive(false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr", addr.Address)
+	addr, err = account.Derive(true, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7", addr.Address)
+
+	account, err = NewXPubAccount(testUpub, 0, &chaincfg.TestNet3Params)
+	require.NoError(t, err)
+	addr, err = account.Derive(false, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "2Mww8dCYPUpKHofjgcXcBCEGmniw9CoaiD2", addr.Address)
+	assert.Equal(t, "m/49'/1'/0'/0/0", addr.DerivationPath)
+}
+
+func TestXPubAccountGapLimit(t *testing.T) {
+	account, err := NewXPubAccount(testZpub, 0, nil)
+	require.NoError(t, err)
+	account.GapLimit = 5
+	window, err := account.Window(false)
+	require.NoError(t, err)
+	require.Equal(t, 5, len(window))
+
+	used, err := account.MarkUsed(window[3].Address)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), used.Index)
+	window, err = account.Window(false)
+	require.NoError(t, err)
+	assert.Equal(t, 9, len(window))
+	next, err := account.NextUnused(false)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), next.Index)
+
+	found, err := account.Lookup("bc1q8c6fshw2dlwun7ekn9qwf37cu2rn755upcp6el")
+	require.NoError(t, err)
+	assert.True(t, found.Change)
+	assert.Equal(t, uint32(0), found.Index)
+	_, err = account.Lookup("bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr")
+	assert.ErrorIs(t, err, ErrAddressNotFound)
+
+	require.NoError(t, account.SetKeyOrigin(0x0adac573, "m/84'/0'/0'"))
+	assert.Equal(t, "m/84'/0'/0'/1/0", found.DerivationPath)
+	assert.Equal(t, uint32(0x0adac573), found.MasterFingerprint)
+
+	// discovery stops after a gap of 5 unused addresses
+	addrs, err := account.Addresses(false, 0, 20)
+	require.NoError(t, err)
+	history := map[string]bool{addrs[0].Address: true, addrs[5].Address: true, addrs[10].Address: true, addrs[16].Address: true}
+	account, err = NewXPubAccount(testZpub, 0, nil)
+	require.NoError(t, err)
+	account.GapLimit = 5
+	discovered, err := account.Discover(false, func(address string) (bool, error) {
+		return history[address], nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, len(discovered))
+	assert.Equal(t, uint32(10), discovered[2].Index)
+	next, err = account.NextUnused(false)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(11), next.Index)
+}
+
+func TestXPubAccountErrors(t *testing.T) {
+	_, err := NewXPubAccount(testZpub, PurposeBIP44, nil)
+	assert.ErrorIs(t, err, ErrExtendedKeyPurpose)
+	_, err = NewXPubAccount(testZpub, 0, &chaincfg.TestNet3Params)
+	assert.ErrorIs(t, err, ErrExtendedKeyNetwork)
+	_, err = NewXPubAccount(testUpub, 0, nil)
+	assert.ErrorIs(t, err, ErrExtendedKeyNetwork)
+	_, err = NewXPubAccount(testXpub, 45, nil)
+	assert.ErrorIs(t, err, ErrExtendedKeyPurpose)
+	multisig, err := ConvertExtendedPubKey(testZpub, "Zpub")
+	require.NoError(t, err)
+	_, err = NewXPubAccount(multisig, 0, nil)
+	assert.ErrorIs(t, err, ErrExtendedKeyPurpose)
+	_, err = ConvertExtendedPubKey(testZpub, "wpub")
+	assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+	_, err = NewXPubAccount(testZpub[:len(testZpub)-1]+"t", 0, nil)
+	assert.ErrorIs(t, err, ErrInvalidExtendedKey)
+}